@@ -19,10 +19,13 @@ package apihttp
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/json"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/bbva/qed/balloon"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/raftwal"
@@ -70,14 +73,23 @@ func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 //   POST /events
 //
 // The following statuses are expected:
-// If everything is alright, the HTTP status is 201 and the body contains:
+// If everything is alright, the HTTP status is 201 and the body contains
+// the snapshot along with the signature of its canonical encoding:
 //   {
-//     "HyperDigest": "mHzXvSE/j7eFmNObvC7PdtQTmd4W0q/FPHmiYEjL0eM=",
-//     "HistoryDigest": "Kpbn+7P4XrZi2hKpdhA7freUicZdUsU6GqmUk0vDJ8A=",
-//     "Version": 1,
-//     "Event": "VGhpcyBpcyBteSBmaXJzdCBldmVudA=="
+//     "Snapshot": {
+//       "HyperDigest": "mHzXvSE/j7eFmNObvC7PdtQTmd4W0q/FPHmiYEjL0eM=",
+//       "HistoryDigest": "Kpbn+7P4XrZi2hKpdhA7freUicZdUsU6GqmUk0vDJ8A=",
+//       "Version": 1,
+//       "EventDigest": "VGhpcyBpcyBteSBmaXJzdCBldmVudA=="
+//     },
+//     "Signature": "<truncated for clarity in docs>"
 //   }
-func Add(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+// If the event has already been added, the body contains the snapshot that
+// was published for it when duplicates are idempotent, or the HTTP
+// status is 409 when they are rejected.
+// If the node is not the leader of the cluster, the HTTP status is 503 so
+// the client can try another node.
+func Add(balloon raftwal.RaftBalloonApi, signer protocol.SnapshotSigner) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		// Make sure we can only be called with an HTTP POST request.
@@ -101,19 +113,30 @@ func Add(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 
 		// Wait for the response
 		response, err := balloon.Add(event.Event)
+		if isNotLeader(err) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if isDuplicateEvent(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if isWrongMode(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		snapshot := &protocol.Snapshot{
-			response.HistoryDigest,
-			response.HyperDigest,
-			response.Version,
-			response.EventDigest,
+		signed, err := signer.SignSnapshot(protocol.ToSnapshot(response, balloon.HashAlgorithm()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		out, err := json.Marshal(snapshot)
+		out, err := json.Marshal(signed)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -127,11 +150,121 @@ func Add(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 	}
 }
 
+// AddBulk posts a bulk of events into the system. All of them are applied
+// through a single Raft entry, but each one gets its own version:
+// The http post url is:
+//   POST /events/bulk
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 201 and the body contains
+// one signed snapshot per event, in the same order they were sent:
+//   [
+//     {
+//       "Snapshot": {
+//         "HyperDigest": "mHzXvSE/j7eFmNObvC7PdtQTmd4W0q/FPHmiYEjL0eM=",
+//         "HistoryDigest": "Kpbn+7P4XrZi2hKpdhA7freUicZdUsU6GqmUk0vDJ8A=",
+//         "Version": 1,
+//         "EventDigest": "VGhpcyBpcyBteSBmaXJzdCBldmVudA=="
+//       },
+//       "Signature": "<truncated for clarity in docs>"
+//     },
+//     ...
+//   ]
+// If any event has already been added and duplicates are rejected, none is
+// added and the HTTP status is 409.
+// If the node is not the leader of the cluster, the HTTP status is 503.
+func AddBulk(balloon raftwal.RaftBalloonApi, signer protocol.SnapshotSigner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.Body == nil {
+			http.Error(w, "Please send a request body", http.StatusBadRequest)
+			return
+		}
+
+		var bulk protocol.EventsBulk
+		err := json.NewDecoder(r.Body).Decode(&bulk)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if len(bulk.Events) == 0 {
+			http.Error(w, "Please send at least one event", http.StatusBadRequest)
+			return
+		}
+
+		// Wait for the response
+		response, err := balloon.AddBulk(bulk.Events)
+		if isNotLeader(err) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if isDuplicateEvent(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if isWrongMode(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		snapshots := make([]*protocol.SignedSnapshot, len(response))
+		for i, s := range response {
+			snapshots[i], err = signer.SignSnapshot(protocol.ToSnapshot(s, balloon.HashAlgorithm()))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		out, err := json.Marshal(snapshots)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write(out)
+
+		return
+
+	}
+}
+
+func isWrongMode(err error) bool {
+	return err == balloon.ErrWrongMode
+}
+
+func isInvalidDigest(err error) bool {
+	return err == balloon.ErrInvalidDigest
+}
+
+func isDuplicateEvent(err error) bool {
+	return err == balloon.ErrDuplicateEvent
+}
+
+func isNotLeader(err error) bool {
+	return err == raftwal.ErrNotLeader
+}
+
 // Membership returns a membershipProof from the system
 // The http post url is:
 //   POST /proofs/membership
 //
 // The following statuses are expected:
+// If the length of the digest does not match the hash algorithm, the HTTP
+// status is 400.
 // If everything is alright, the HTTP status is 201 and the body contains:
 //   {
 //     "key": "TG9yZW0gaXBzdW0gZGF0dW0gbm9uIGNvcnJ1cHR1bSBlc3QK",
@@ -141,6 +274,9 @@ func Add(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 //     "queryVersion": "1",
 //     "actualVersion": "2",
 //   }
+//
+// Clients sending the header "Accept: application/x-qed-proof" receive the
+// proof in the compact binary encoding of the protocol package instead.
 func Membership(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -161,16 +297,22 @@ func Membership(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 		// Wait for the response
 		proof, err := balloon.QueryMembership(query.Key, query.Version)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			status := http.StatusInternalServerError
+			if isWrongMode(err) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
 			return
 		}
 
-		out, err := json.Marshal(protocol.ToMembershipResult(query.Key, proof))
+		out, contentType, err := marshalProof(r, protocol.ToMembershipResult(query.Key, proof, balloon.HashAlgorithm()))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		setVersionHeader(w, proof.CurrentVersion)
+		w.Header().Set("Content-Type", contentType)
 		w.WriteHeader(http.StatusOK)
 		w.Write(out)
 		return
@@ -195,6 +337,9 @@ func Membership(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 //     "queryVersion": "1",
 //     "actualVersion": "2",
 //   }
+//
+// Clients sending the header "Accept: application/x-qed-proof" receive the
+// proof in the compact binary encoding of the protocol package instead.
 func DigestMembership(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -214,17 +359,84 @@ func DigestMembership(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 
 		// Wait for the response
 		proof, err := balloon.QueryDigestMembership(query.KeyDigest, query.Version)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isInvalidDigest(err) || isWrongMode(err) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		out, contentType, err := marshalProof(r, protocol.ToMembershipResult([]byte(nil), proof, balloon.HashAlgorithm()))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		out, err := json.Marshal(protocol.ToMembershipResult([]byte(nil), proof))
+		setVersionHeader(w, proof.CurrentVersion)
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(out)
+		return
+
+	}
+}
+
+// BatchMembership returns a single membershipProof for several event
+// digests at once, where the nodes shared by their audit paths are
+// included only once.
+// The http post url is:
+//   POST /proofs/batch-membership
+//
+// The following statuses are expected:
+// If the batch is empty, has more than balloon.MaxBatchSize digests, has
+// duplicated digests or digests of the wrong length, the HTTP status is 400.
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "keyDigests": ["NDRkMmY3MjEzYjlhMTI4ZWRhZjQzNWFhNjcyMzUxMGE0YTRhOGY5OWEzOWNiYTVhN2FhMWI5OWEwYTlkYzE2NCAgLQo=", ...],
+//     "exists": [true, ...],
+//     "actualVersions": [2, ...],
+//     "hyper": ["<truncated for clarity in docs>"],
+//     "history": ["<truncated for clarity in docs>"],
+//     "currentVersion": "3",
+//     "queryVersion": "3",
+//   }
+func BatchMembership(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var query protocol.BatchMembershipDigests
+		err := json.NewDecoder(r.Body).Decode(&query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Wait for the response
+		proof, err := balloon.QueryBatchMembership(query.KeyDigests, query.Version)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isInvalidBatch(err) || isWrongMode(err) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		out, err := json.Marshal(protocol.ToBatchMembershipResult(proof, balloon.HashAlgorithm()))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		setVersionHeader(w, proof.CurrentVersion)
 		w.WriteHeader(http.StatusOK)
 		w.Write(out)
 		return
@@ -232,6 +444,14 @@ func DigestMembership(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 	}
 }
 
+func isInvalidBatch(err error) bool {
+	switch err {
+	case balloon.ErrEmptyBatch, balloon.ErrDuplicatedInBatch, balloon.ErrBatchTooLarge, balloon.ErrInvalidDigest:
+		return true
+	}
+	return false
+}
+
 // Incremental returns an incrementalProof from the system
 // The http post url is:
 //   POST /proofs/incremental
@@ -243,6 +463,9 @@ func DigestMembership(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 //     "end": "8",
 //     "auditPath": ["<truncated for clarity in docs>"]
 //   }
+//
+// Clients sending the header "Accept: application/x-qed-proof" receive the
+// proof in the compact binary encoding of the protocol package instead.
 func Incremental(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Make sure we can only be called with an HTTP POST request.
@@ -266,12 +489,13 @@ func Incremental(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 			return
 		}
 
-		out, err := json.Marshal(protocol.ToIncrementalResponse(proof))
+		out, contentType, err := marshalProof(r, protocol.ToIncrementalResponse(proof, balloon.HashAlgorithm()))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		w.Header().Set("Content-Type", contentType)
 		w.WriteHeader(http.StatusOK)
 		w.Write(out)
 		return
@@ -279,6 +503,414 @@ func Incremental(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
 	}
 }
 
+// Range returns the event digests added between two versions, both
+// included, along with a single history audit path that proves all of them
+// at the query version.
+// The http post url is:
+//   POST /proofs/range
+//
+// The following statuses are expected:
+// If the range is invalid or too large, the HTTP status is 400.
+// If the event digests of the range were added before the server stored
+// them, the range can not be proven and the HTTP status is 404.
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "start": "2",
+//     "end": "8",
+//     "version": "10",
+//     "eventDigests": ["<truncated for clarity in docs>"],
+//     "history": ["<truncated for clarity in docs>"]
+//   }
+func Range(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var query protocol.RangeQuery
+		err := json.NewDecoder(r.Body).Decode(&query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Wait for the response
+		proof, err := balloon.QueryRange(query.Start, query.End, query.Version)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isInvalidRange(err) {
+				status = http.StatusBadRequest
+			}
+			if isRangeNotStored(err) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		out, err := json.Marshal(protocol.ToRangeResult(proof, balloon.HashAlgorithm()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(out)
+		return
+
+	}
+}
+
+func isRangeNotStored(err error) bool {
+	return err == balloon.ErrRangeNotStored
+}
+
+func isInvalidRange(err error) bool {
+	return err == balloon.ErrInvalidRange || err == balloon.ErrRangeTooLarge
+}
+
+func isVersionNotFound(err error) bool {
+	return err == balloon.ErrVersionNotFound
+}
+
+// VersionAt returns the last version committed at or before a given time,
+// along with the time it was committed. Timestamps are in nanoseconds since
+// the Unix epoch.
+// The http post url is:
+//   POST /versions/at
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "version": "8",
+//     "timestamp": "1540000000000000000"
+//   }
+//
+// If there is no version committed at or before that time, the HTTP status
+// is 404.
+func VersionAt(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var query protocol.VersionAtQuery
+		err := json.NewDecoder(r.Body).Decode(&query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Wait for the response
+		version, timestamp, err := balloon.QueryVersionAt(query.Timestamp)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isVersionNotFound(err) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		out, err := json.Marshal(&protocol.VersionAtResult{version, timestamp})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(out)
+		return
+
+	}
+}
+
+// marshalProof encodes a proof with the compact binary encoding when the
+// client accepts it, and as JSON otherwise. It returns the encoded proof and
+// its content type.
+func marshalProof(r *http.Request, proof encoding.BinaryMarshaler) ([]byte, string, error) {
+	if strings.Contains(r.Header.Get("Accept"), protocol.ProofContentType) {
+		out, err := proof.MarshalBinary()
+		return out, protocol.ProofContentType, err
+	}
+	out, err := json.Marshal(proof)
+	return out, "application/json", err
+}
+
+// AddKeyValue posts a new value for a key into the system. The update is
+// chained with the previous one of the same key:
+// The http post url is:
+//   POST /kv
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 201 and the body contains
+// the signed snapshot:
+//   {
+//     "Snapshot": {
+//       "HyperDigest": "mHzXvSE/j7eFmNObvC7PdtQTmd4W0q/FPHmiYEjL0eM=",
+//       "HistoryDigest": "Kpbn+7P4XrZi2hKpdhA7freUicZdUsU6GqmUk0vDJ8A=",
+//       "Version": 1,
+//       "EventDigest": "VGhpcyBpcyBteSBmaXJzdCBldmVudA=="
+//     },
+//     "Signature": "<truncated for clarity in docs>"
+//   }
+// If the node is not the leader of the cluster, the HTTP status is 503.
+func AddKeyValue(balloon raftwal.RaftBalloonApi, signer protocol.SnapshotSigner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.Body == nil {
+			http.Error(w, "Please send a request body", http.StatusBadRequest)
+			return
+		}
+
+		var kv protocol.KeyValue
+		err := json.NewDecoder(r.Body).Decode(&kv)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if len(kv.Key) == 0 {
+			http.Error(w, "Please send a key", http.StatusBadRequest)
+			return
+		}
+
+		// Wait for the response
+		response, err := balloon.AddKeyValue(kv.Key, kv.Value)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isWrongMode(err) {
+				status = http.StatusBadRequest
+			}
+			if isNotLeader(err) {
+				status = http.StatusServiceUnavailable
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		signed, err := signer.SignSnapshot(protocol.ToSnapshot(response, balloon.HashAlgorithm()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		out, err := json.Marshal(signed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write(out)
+
+		return
+
+	}
+}
+
+// KeyValue returns the current value of a key along with its proof
+// The http post url is:
+//   POST /proofs/kv
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "Exists": true,
+//     "Key": "YWxpY2U=",
+//     "KeyDigest": "KDLAsOWsfrkpAEk8a4a9Mj5ypw89sVHCwA0PzHP7Hn4=",
+//     "Entry": {
+//       "Version": 8,
+//       "Previous": 3,
+//       "Value": "a2V5LTI=",
+//       "History": ["<truncated for clarity in docs>"]
+//     },
+//     "Hyper": ["<truncated for clarity in docs>"],
+//     "CurrentVersion": 10
+//   }
+func KeyValue(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var query protocol.KeyValueQuery
+		err := json.NewDecoder(r.Body).Decode(&query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Wait for the response
+		proof, err := balloon.QueryKeyValue(query.Key)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isWrongMode(err) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		out, err := json.Marshal(protocol.ToKeyValueResult(proof, balloon.HashAlgorithm()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		setVersionHeader(w, proof.CurrentVersion)
+		w.WriteHeader(http.StatusOK)
+		w.Write(out)
+		return
+
+	}
+}
+
+// KeyHistory returns all the values of a key between two versions along
+// with the proofs to check that none of them has been left out
+// The http post url is:
+//   POST /proofs/kv-history
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   {
+//     "Exists": true,
+//     "Key": "YWxpY2U=",
+//     "KeyDigest": "KDLAsOWsfrkpAEk8a4a9Mj5ypw89sVHCwA0PzHP7Hn4=",
+//     "Start": 2,
+//     "End": 6,
+//     "LatestVersion": 8,
+//     "Entries": ["<truncated for clarity in docs>"],
+//     "Next": {"<truncated for clarity in docs>"},
+//     "Hyper": ["<truncated for clarity in docs>"],
+//     "CurrentVersion": 10
+//   }
+func KeyHistory(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Make sure we can only be called with an HTTP POST request.
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var query protocol.KeyHistoryQuery
+		err := json.NewDecoder(r.Body).Decode(&query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if query.Start > query.End {
+			http.Error(w, "Start version must not be greater than end version", http.StatusBadRequest)
+			return
+		}
+
+		// Wait for the response
+		proof, err := balloon.QueryKeyHistory(query.Key, query.Start, query.End)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isWrongMode(err) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		out, err := json.Marshal(protocol.ToKeyHistoryResult(proof, balloon.HashAlgorithm()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		setVersionHeader(w, proof.CurrentVersion)
+		w.WriteHeader(http.StatusOK)
+		w.Write(out)
+		return
+
+	}
+}
+
+// Keys returns the keys that signed the snapshots of the log, in the order
+// they were rotated, with the versions each one signs. Only the last one is
+// active, and it signs every version from its start on.
+// The http get url is:
+//   GET /keys
+//
+// The following statuses are expected:
+// If everything is alright, the HTTP status is 200 and the body contains:
+//   [
+//     {
+//       "KeyID": "8e4d0a1c9b7f3e52",
+//       "PublicKey": "<base64>",
+//       "Start": 0,
+//       "End": 120,
+//       "Active": false
+//     },
+//     {
+//       "KeyID": "03f1b2c4d5e6a798",
+//       "PublicKey": "<base64>",
+//       "Start": 120,
+//       "Active": true
+//     }
+//   ]
+func Keys(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		keys, err := balloon.SigningKeys()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		result := make([]*protocol.SigningKey, len(keys))
+		for i, k := range keys {
+			result[i] = &protocol.SigningKey{
+				KeyID:     k.ID,
+				Algorithm: k.Algorithm,
+				PublicKey: k.PublicKey,
+				Start:     k.Start,
+				End:       k.End,
+				Active:    k.Active,
+			}
+		}
+
+		out, err := json.Marshal(result)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(out)
+	}
+}
+
 // AuthHandlerMiddleware function is an HTTP handler wrapper that performs
 // simple authorization tasks. Currently only checks that Api-Key it's present.
 //
@@ -296,18 +928,120 @@ func AuthHandlerMiddleware(handler http.HandlerFunc) http.HandlerFunc {
 	})
 }
 
+// balloonHandlers are the handlers of the api of a balloon, which are served
+// for the default namespace and under the path of every other one. Events
+// are only added and proven in the events mode, and keys in the keyvalue
+// one, so the requests that do not match the mode get the HTTP status 400.
+// They are answered with the read consistency the query asks for.
+var balloonHandlers = map[string]func(raftwal.RaftBalloonApi) http.HandlerFunc{
+	"/proofs/membership":         Membership,
+	"/proofs/digest-membership":  DigestMembership,
+	"/proofs/batch-membership":   BatchMembership,
+	"/proofs/incremental":        Incremental,
+	"/proofs/range":              Range,
+	"/versions/at":               VersionAt,
+	"/proofs/kv":                 KeyValue,
+	"/proofs/kv-history":         KeyHistory,
+	"/ct/v1/get-sth-consistency": CTGetSTHConsistency,
+	"/ct/v1/get-proof-by-hash":   CTGetProofByHash,
+	"/ct/v1/get-entries":         CTGetEntries,
+	"/keys":                      Keys,
+}
+
+// writeHandlers are the handlers of the api of a balloon that add to it.
+// They return the snapshots of the additions signed by the server.
+var writeHandlers = map[string]func(raftwal.RaftBalloonApi, protocol.SnapshotSigner) http.HandlerFunc{
+	"/events":      Add,
+	"/events/bulk": AddBulk,
+	"/kv":          AddKeyValue,
+}
+
+// signedHandlers are the handlers of the api of a balloon that query it
+// and sign the response with the key of the server. They are answered with
+// the read consistency the query asks for.
+var signedHandlers = map[string]func(raftwal.RaftBalloonApi, protocol.SnapshotSigner) http.HandlerFunc{
+	"/ct/v1/get-sth": CTGetSTH,
+}
+
+// handlerFor returns the constructor of the handler of the given path of
+// the api of a balloon, if any.
+func handlerFor(path string) (func(raftwal.RaftBalloonApi, protocol.SnapshotSigner) http.HandlerFunc, bool) {
+	if handler, ok := writeHandlers[path]; ok {
+		return handler, true
+	}
+	if handler, ok := balloonHandlers[path]; ok {
+		return func(balloon raftwal.RaftBalloonApi, _ protocol.SnapshotSigner) http.HandlerFunc {
+			return ConsistencyMiddleware(balloon, handler(balloon))
+		}, true
+	}
+	if handler, ok := signedHandlers[path]; ok {
+		return func(balloon raftwal.RaftBalloonApi, signer protocol.SnapshotSigner) http.HandlerFunc {
+			return ConsistencyMiddleware(balloon, handler(balloon, signer))
+		}, true
+	}
+	return nil, false
+}
+
+// Namespace serves the api of a namespace, routing its requests to the
+// same handlers of the default one:
+//
+//	/ns/{name}/events -> Add
+//	/ns/{name}/proofs/membership -> Membership
+//	...
+//
+// If the namespace does not exist, the HTTP status is 404.
+func Namespace(balloon raftwal.RaftBalloonApi, signer protocol.SnapshotSigner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/ns/"), "/", 2)
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		handler, ok := handlerFor("/" + parts[1])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		ns, err := balloon.Namespace(parts[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		handler(ns, signer).ServeHTTP(w, r)
+	}
+}
+
 // NewApiHttp returns a new *http.ServeMux containing the current API handlers.
+// The snapshots of the additions are signed with the key of their version,
+// and the additions that reach a follower are sent to the leader as the
+// policy of the follower writes says, and so the queries with the leader or
+// linearizable read consistency.
 //	/health-check -> HealthCheckHandler
 //	/events -> Add
+//	/events/bulk -> AddBulk
+//	/kv -> AddKeyValue
 //	/proofs/membership -> Membership
-func NewApiHttp(balloon raftwal.RaftBalloonApi) *http.ServeMux {
+//	/proofs/kv -> KeyValue
+//	/proofs/kv-history -> KeyHistory
+//	/keys -> Keys
+//	/ct/v1/... -> Certificate Transparency api
+//	/ns/{name}/... -> Namespace
+func NewApiHttp(balloon raftwal.RaftBalloonApi, signer protocol.SnapshotSigner, writes FollowerWrites) *http.ServeMux {
 
 	api := http.NewServeMux()
 	api.HandleFunc("/health-check", AuthHandlerMiddleware(HealthCheckHandler))
-	api.HandleFunc("/events", AuthHandlerMiddleware(Add(balloon)))
-	api.HandleFunc("/proofs/membership", AuthHandlerMiddleware(Membership(balloon)))
-	api.HandleFunc("/proofs/digest-membership", AuthHandlerMiddleware(DigestMembership(balloon)))
-	api.HandleFunc("/proofs/incremental", AuthHandlerMiddleware(Incremental(balloon)))
+	for path, handler := range writeHandlers {
+		api.HandleFunc(path, AuthHandlerMiddleware(LeaderMiddleware(balloon, writes, handler(balloon, signer))))
+	}
+	for path, handler := range balloonHandlers {
+		api.HandleFunc(path, AuthHandlerMiddleware(LeaderMiddleware(balloon, writes, ConsistencyMiddleware(balloon, handler(balloon)))))
+	}
+	for path, handler := range signedHandlers {
+		api.HandleFunc(path, AuthHandlerMiddleware(LeaderMiddleware(balloon, writes, ConsistencyMiddleware(balloon, handler(balloon, signer)))))
+	}
+	api.HandleFunc("/ns/", AuthHandlerMiddleware(LeaderMiddleware(balloon, writes, Namespace(balloon, signer))))
 
 	return api
 }
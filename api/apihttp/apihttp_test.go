@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -27,32 +28,131 @@ import (
 	"time"
 
 	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/balloon/ct"
+	"github.com/bbva/qed/balloon/history"
+	"github.com/bbva/qed/balloon/hyper"
 	"github.com/bbva/qed/balloon/visitor"
 	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/sign"
 	"github.com/bbva/qed/storage/badger"
 	"github.com/bbva/qed/testutils/rand"
 	assert "github.com/stretchr/testify/require"
 )
 
+var (
+	testKey    = sign.NewEd25519Signer()
+	testKeys   = sign.NewKeySet(testKey)
+	testRanges = protocol.NewKeyRanges(&protocol.SigningKey{KeyID: sign.KeyID(testKey.PublicKey()), Start: 0, Active: true})
+	testSigner = protocol.NewSnapshotSigner(testKey)
+)
+
 type fakeRaftBalloon struct {
 	dbPath       string
 	raftDir      string
 	raftBindAddr string
 	raftID       string
+	namespace    string
+	follower     bool
+	leader       string
 }
 
 func (b fakeRaftBalloon) Add(event []byte) (*balloon.Snapshot, error) {
-	return &balloon.Snapshot{hashing.Digest{0x02}, hashing.Digest{0x00}, hashing.Digest{0x01}, 0}, nil
+	if string(event) == "a duplicated event" {
+		return nil, balloon.ErrDuplicateEvent
+	}
+	if string(event) == "an event for a key/value namespace" {
+		return nil, balloon.ErrWrongMode
+	}
+	if string(event) == "an event for a follower" {
+		return nil, raftwal.ErrNotLeader
+	}
+	return &balloon.Snapshot{EventDigest: hashing.Digest{0x02}, HistoryDigest: hashing.Digest{0x00}, HyperDigest: hashing.Digest{0x01}, Namespace: b.namespace}, nil
+}
+
+func (b fakeRaftBalloon) AddBulk(events [][]byte) ([]*balloon.Snapshot, error) {
+	snapshots := make([]*balloon.Snapshot, len(events))
+	for i := range events {
+		snapshots[i] = &balloon.Snapshot{EventDigest: hashing.Digest{0x02}, HistoryDigest: hashing.Digest{0x00}, HyperDigest: hashing.Digest{0x01}, Version: uint64(i)}
+	}
+	return snapshots, nil
+}
+
+func (b fakeRaftBalloon) AddKeyValue(key, value []byte) (*balloon.Snapshot, error) {
+	return &balloon.Snapshot{EventDigest: hashing.Digest{0x02}, HistoryDigest: hashing.Digest{0x00}, HyperDigest: hashing.Digest{0x01}}, nil
+}
+
+func (b fakeRaftBalloon) ReadVersion(consistency protocol.ReadConsistency) (uint64, error) {
+	if b.follower && consistency != protocol.StaleReads {
+		return 0, raftwal.ErrNotLeader
+	}
+	return 3, nil
+}
+
+func (b fakeRaftBalloon) HashAlgorithm() string {
+	return hashing.SHA256
+}
+
+func (b fakeRaftBalloon) Join(nodeID, addr string, metadata map[string]string) error {
+	return nil
+}
+
+func (b fakeRaftBalloon) IsLeader() bool {
+	return !b.follower
+}
+
+func (b fakeRaftBalloon) LeaderEndpoint() string {
+	return b.leader
+}
+
+func (b fakeRaftBalloon) Members() ([]*raftwal.Member, error) {
+	return nil, nil
 }
 
-func (b fakeRaftBalloon) Join(nodeID, addr string) error {
+func (b fakeRaftBalloon) Remove(id string) error {
+	return nil
+}
+
+func (b fakeRaftBalloon) Status() (*raftwal.Status, error) {
+	return &raftwal.Status{}, nil
+}
+
+func (b fakeRaftBalloon) TakeSnapshot() error {
+	return nil
+}
+
+func (b fakeRaftBalloon) Backup(w io.Writer) (*raftwal.BackupHeader, error) {
+	return &raftwal.BackupHeader{}, nil
+}
+
+func (b fakeRaftBalloon) Namespace(name string) (raftwal.RaftBalloonApi, error) {
+	if name != "ns" {
+		return nil, raftwal.ErrNamespaceNotFound
+	}
+	return fakeRaftBalloon{namespace: name}, nil
+}
+
+func (b fakeRaftBalloon) CreateNamespace(name string, mode balloon.Mode, duplicates balloon.DuplicatePolicy) error {
+	return nil
+}
+
+func (b fakeRaftBalloon) SigningKeys() ([]*raftwal.SigningKey, error) {
+	publicKey := testKey.PublicKey()
+	return []*raftwal.SigningKey{
+		{ID: sign.KeyID(publicKey), PublicKey: publicKey, Start: 0, Active: true},
+	}, nil
+}
+
+func (b fakeRaftBalloon) RotateSigningKey(algorithm string, publicKey []byte) error {
 	return nil
 }
 
 func (b fakeRaftBalloon) QueryDigestMembership(keyDigest hashing.Digest, version uint64) (*balloon.MembershipProof, error) {
-	return &balloon.MembershipProof{
+	if len(keyDigest) != 32 {
+		return nil, balloon.ErrInvalidDigest
+	}
+	return balloon.NewMembershipProof(
 		true,
 		visitor.NewFakeVerifiable(true),
 		visitor.NewFakeVerifiable(true),
@@ -61,12 +161,12 @@ func (b fakeRaftBalloon) QueryDigestMembership(keyDigest hashing.Digest, version
 		2,
 		keyDigest,
 		hashing.NewFakeXorHasher(),
-	}, nil
+	), nil
 }
 
 func (b fakeRaftBalloon) QueryMembership(event []byte, version uint64) (*balloon.MembershipProof, error) {
 	hasher := hashing.NewFakeXorHasher()
-	return &balloon.MembershipProof{
+	return balloon.NewMembershipProof(
 		true,
 		visitor.NewFakeVerifiable(true),
 		visitor.NewFakeVerifiable(true),
@@ -75,9 +175,60 @@ func (b fakeRaftBalloon) QueryMembership(event []byte, version uint64) (*balloon
 		2,
 		hasher.Do(event),
 		hasher,
+	), nil
+}
+
+func (b fakeRaftBalloon) QueryBatchMembership(keyDigests []hashing.Digest, version uint64) (*balloon.BatchMembershipProof, error) {
+	if len(keyDigests) == 0 {
+		return nil, balloon.ErrEmptyBatch
+	}
+	hasher := hashing.NewFakeXorHasher()
+	keys := make([][]byte, len(keyDigests))
+	values := make([][]byte, len(keyDigests))
+	for i, keyDigest := range keyDigests {
+		keys[i] = keyDigest
+		values[i] = []byte{0x1}
+	}
+	return &balloon.BatchMembershipProof{
+		KeyDigests:     keyDigests,
+		Exists:         make([]bool, len(keyDigests)),
+		ActualVersions: make([]uint64, len(keyDigests)),
+		HyperProof:     hyper.NewBatchQueryProof(keys, values, visitor.AuditPath{"0|0": hashing.Digest{0x00}}, hasher),
+		CurrentVersion: 1,
+		QueryVersion:   version,
+		Hasher:         hasher,
 	}, nil
 }
 
+func (b fakeRaftBalloon) QueryRange(start, end, version uint64) (*balloon.RangeProof, error) {
+	if start > end || end > version {
+		return nil, balloon.ErrInvalidRange
+	}
+	if start == 0 {
+		// the first version was added before the leaves were stored
+		return nil, balloon.ErrRangeNotStored
+	}
+	eventDigests := make([]hashing.Digest, end-start+1)
+	for i := range eventDigests {
+		eventDigests[i] = hashing.Digest{byte(start) + byte(i)}
+	}
+	return balloon.NewRangeProof(
+		start,
+		end,
+		version,
+		eventDigests,
+		visitor.AuditPath{"0|0": hashing.Digest{0x00}},
+		hashing.NewFakeXorHasher(),
+	), nil
+}
+
+func (b fakeRaftBalloon) QueryVersionAt(timestamp int64) (uint64, int64, error) {
+	if timestamp < 100 {
+		return 0, 0, balloon.ErrVersionNotFound
+	}
+	return 1, 100, nil
+}
+
 func (b fakeRaftBalloon) QueryConsistency(start, end uint64) (*balloon.IncrementalProof, error) {
 	ip := balloon.IncrementalProof{
 		2,
@@ -88,6 +239,96 @@ func (b fakeRaftBalloon) QueryConsistency(start, end uint64) (*balloon.Increment
 	return &ip, nil
 }
 
+func (b fakeRaftBalloon) QueryKeyValue(key []byte) (*balloon.KeyValueProof, error) {
+	hasher := hashing.NewFakeXorHasher()
+	return &balloon.KeyValueProof{
+		Exists:    true,
+		Key:       key,
+		KeyDigest: hasher.Do(key),
+		Entry: &balloon.KeyValueEntry{
+			Version:      2,
+			Previous:     1,
+			Value:        []byte("value"),
+			HistoryProof: history.NewMembershipProof(2, 3, visitor.AuditPath{"0|0": hashing.Digest{0x00}}, hasher),
+		},
+		HyperProof:     hyper.NewQueryProof(hasher.Do(key), []byte{0x2}, visitor.AuditPath{}, hasher),
+		CurrentVersion: 3,
+		Hasher:         hasher,
+	}, nil
+}
+
+func (b fakeRaftBalloon) QueryKeyHistory(key []byte, start, end uint64) (*balloon.KeyHistoryProof, error) {
+	hasher := hashing.NewFakeXorHasher()
+	return &balloon.KeyHistoryProof{
+		Exists:         true,
+		Key:            key,
+		KeyDigest:      hasher.Do(key),
+		Start:          start,
+		End:            end,
+		LatestVersion:  start,
+		Entries:        []*balloon.KeyValueEntry{},
+		HyperProof:     hyper.NewQueryProof(hasher.Do(key), []byte{0x2}, visitor.AuditPath{}, hasher),
+		CurrentVersion: end,
+		Hasher:         hasher,
+	}, nil
+}
+
+// The fake Certificate Transparency tree has a leaf for each of its two
+// versions, whose event digests are their versions.
+var (
+	fakeCTTimestamp = int64(1540000000123000000)
+	fakeCTRootHash  = hashing.Digest(bytes.Repeat([]byte{0x01}, 32))
+)
+
+func fakeCTLeafInput(version uint64) []byte {
+	return ct.LeafInput(hashing.Digest{byte(version)}, fakeCTTimestamp)
+}
+
+func (b fakeRaftBalloon) QueryCTTreeHead() (*balloon.TreeHead, error) {
+	return &balloon.TreeHead{
+		Size:      2,
+		Timestamp: fakeCTTimestamp,
+		RootHash:  fakeCTRootHash,
+		Namespace: b.namespace,
+	}, nil
+}
+
+func (b fakeRaftBalloon) QueryCTConsistency(first, second uint64) ([]hashing.Digest, error) {
+	if first == 0 || first > second || second > 2 {
+		return nil, ct.ErrInvalidSize
+	}
+	if first == second {
+		return []hashing.Digest{}, nil
+	}
+	return []hashing.Digest{{0x00}}, nil
+}
+
+func (b fakeRaftBalloon) QueryCTMembership(leafHash hashing.Digest, size uint64) (uint64, []hashing.Digest, error) {
+	if size == 0 || size > 2 {
+		return 0, nil, ct.ErrInvalidSize
+	}
+	for version := uint64(0); version < size; version++ {
+		if bytes.Equal(leafHash, ct.LeafHash(fakeCTLeafInput(version))) {
+			return version, []hashing.Digest{{0x00}}, nil
+		}
+	}
+	return 0, nil, ct.ErrLeafNotFound
+}
+
+func (b fakeRaftBalloon) QueryCTEntries(start, end uint64) ([][]byte, error) {
+	if start > end || start >= 2 {
+		return nil, balloon.ErrInvalidRange
+	}
+	if end > 1 {
+		end = 1
+	}
+	var entries [][]byte
+	for version := start; version <= end; version++ {
+		entries = append(entries, fakeCTLeafInput(version))
+	}
+	return entries, nil
+}
+
 func TestHealthCheckHandler(t *testing.T) {
 	// Create a request to pass to our handler. We don't have any query parameters for now, so we'll
 	// pass 'nil' as the third parameter.
@@ -130,7 +371,7 @@ func TestAdd(t *testing.T) {
 
 	// We create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
 	rr := httptest.NewRecorder()
-	handler := Add(fakeRaftBalloon{})
+	handler := Add(fakeRaftBalloon{}, testSigner)
 
 	// Our handlers satisfy http.Handler, so we can call their ServeHTTP method
 	// directly and pass in our Request and ResponseRecorder.
@@ -143,9 +384,15 @@ func TestAdd(t *testing.T) {
 	}
 
 	// Check the body response
-	snapshot := &protocol.Snapshot{}
+	signed := &protocol.SignedSnapshot{}
+
+	json.Unmarshal([]byte(rr.Body.String()), signed)
+
+	if err := signed.Verify(testKeys, testRanges); err != nil {
+		t.Fatalf("Signature is not valid: %v", err)
+	}
 
-	json.Unmarshal([]byte(rr.Body.String()), snapshot)
+	snapshot := signed.Snapshot
 
 	if !bytes.Equal(snapshot.HyperDigest, []byte{0x1}) {
 		t.Errorf("HyperDigest is not consistent: %s", snapshot.HyperDigest)
@@ -160,6 +407,258 @@ func TestAdd(t *testing.T) {
 	}
 }
 
+func TestAddDuplicate(t *testing.T) {
+	data, _ := json.Marshal(&protocol.Event{[]byte("a duplicated event")})
+
+	req, err := http.NewRequest("POST", "/events", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := Add(fakeRaftBalloon{}, testSigner)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusConflict)
+	}
+}
+
+func TestAddNotLeader(t *testing.T) {
+	data, _ := json.Marshal(&protocol.Event{[]byte("an event for a follower")})
+
+	req, err := http.NewRequest("POST", "/events", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := Add(fakeRaftBalloon{}, testSigner)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusServiceUnavailable)
+	}
+}
+
+func TestAddWrongMode(t *testing.T) {
+	data, _ := json.Marshal(&protocol.Event{[]byte("an event for a key/value namespace")})
+
+	req, err := http.NewRequest("POST", "/events", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := Add(fakeRaftBalloon{}, testSigner)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestAddBulk(t *testing.T) {
+	data, _ := json.Marshal(&protocol.EventsBulk{
+		[][]byte{
+			[]byte("this is a sample event"),
+			[]byte("this is another sample event"),
+		},
+	})
+
+	req, err := http.NewRequest("POST", "/events/bulk", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := AddBulk(fakeRaftBalloon{}, testSigner)
+
+	handler.ServeHTTP(rr, req)
+
+	// Check the status code is what we expect.
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusCreated)
+	}
+
+	// Check the body response
+	var snapshots []*protocol.SignedSnapshot
+	json.Unmarshal([]byte(rr.Body.String()), &snapshots)
+
+	if len(snapshots) != 2 {
+		t.Fatalf("Wrong number of snapshots: got %d want 2", len(snapshots))
+	}
+
+	for i, signed := range snapshots {
+		if err := signed.Verify(testKeys, testRanges); err != nil {
+			t.Errorf("Signature is not valid for snapshot %d: %v", i, err)
+		}
+		if signed.Snapshot.Version != uint64(i) {
+			t.Errorf("Version is not consistent for snapshot %d", i)
+		}
+	}
+}
+
+func TestAddBulkWithoutEvents(t *testing.T) {
+	data, _ := json.Marshal(&protocol.EventsBulk{})
+
+	req, err := http.NewRequest("POST", "/events/bulk", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := AddBulk(fakeRaftBalloon{}, testSigner)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestAddKeyValue(t *testing.T) {
+	data, _ := json.Marshal(&protocol.KeyValue{[]byte("key"), []byte("value")})
+
+	req, err := http.NewRequest("POST", "/kv", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := AddKeyValue(fakeRaftBalloon{}, testSigner)
+
+	handler.ServeHTTP(rr, req)
+
+	// Check the status code is what we expect.
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusCreated)
+	}
+
+	// Check the body response
+	signed := &protocol.SignedSnapshot{}
+	json.Unmarshal([]byte(rr.Body.String()), signed)
+
+	if err := signed.Verify(testKeys, testRanges); err != nil {
+		t.Fatalf("Signature is not valid: %v", err)
+	}
+
+	if !bytes.Equal(signed.Snapshot.HyperDigest, []byte{0x1}) {
+		t.Errorf("HyperDigest is not consistent: %s", signed.Snapshot.HyperDigest)
+	}
+}
+
+func TestAddKeyValueWithoutKey(t *testing.T) {
+	data, _ := json.Marshal(&protocol.KeyValue{nil, []byte("value")})
+
+	req, err := http.NewRequest("POST", "/kv", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := AddKeyValue(fakeRaftBalloon{}, testSigner)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestKeyValue(t *testing.T) {
+	data, _ := json.Marshal(&protocol.KeyValueQuery{[]byte("key")})
+
+	req, err := http.NewRequest("POST", "/proofs/kv", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := KeyValue(fakeRaftBalloon{})
+
+	handler.ServeHTTP(rr, req)
+
+	// Check the status code is what we expect.
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	// Check the body response
+	result := &protocol.KeyValueResult{}
+	json.Unmarshal([]byte(rr.Body.String()), result)
+
+	if !result.Exists || result.Entry == nil {
+		t.Fatalf("The key should exist")
+	}
+
+	if !bytes.Equal(result.Entry.Value, []byte("value")) || result.Entry.Version != 2 || result.Entry.Previous != 1 {
+		t.Errorf("Entry is not consistent: %+v", result.Entry)
+	}
+
+	if result.CurrentVersion != 3 {
+		t.Errorf("CurrentVersion is not consistent")
+	}
+}
+
+func TestKeyHistory(t *testing.T) {
+	data, _ := json.Marshal(&protocol.KeyHistoryQuery{[]byte("key"), 2, 5})
+
+	req, err := http.NewRequest("POST", "/proofs/kv-history", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := KeyHistory(fakeRaftBalloon{})
+
+	handler.ServeHTTP(rr, req)
+
+	// Check the status code is what we expect.
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	// Check the body response
+	result := &protocol.KeyHistoryResult{}
+	json.Unmarshal([]byte(rr.Body.String()), result)
+
+	if result.Start != 2 || result.End != 5 {
+		t.Errorf("Range is not consistent: got [%d, %d] want [2, 5]", result.Start, result.End)
+	}
+}
+
+func TestKeyHistoryWithInvalidRange(t *testing.T) {
+	data, _ := json.Marshal(&protocol.KeyHistoryQuery{[]byte("key"), 5, 2})
+
+	req, err := http.NewRequest("POST", "/proofs/kv-history", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := KeyHistory(fakeRaftBalloon{})
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
 func TestMembership(t *testing.T) {
 	var version uint64 = 1
 	key := []byte("this is a sample event")
@@ -185,6 +684,7 @@ func TestMembership(t *testing.T) {
 		ActualVersion:  0x2,
 		KeyDigest:      []uint8{0x17},
 		Key:            key,
+		HashAlgorithm:  hashing.SHA256,
 	}
 
 	// Our handlers satisfy http.Handler, so we can call their ServeHTTP method
@@ -205,6 +705,41 @@ func TestMembership(t *testing.T) {
 
 }
 
+func TestMembershipCompactProof(t *testing.T) {
+	key := []byte("this is a sample event")
+	query, _ := json.Marshal(protocol.MembershipQuery{
+		Key:     key,
+		Version: 1,
+	})
+
+	req, err := http.NewRequest("POST", "/proofs/membership", bytes.NewBuffer(query))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", protocol.ProofContentType)
+
+	rr := httptest.NewRecorder()
+	handler := Membership(fakeRaftBalloon{})
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != protocol.ProofContentType {
+		t.Fatalf("handler returned wrong content type: got %v want %v",
+			contentType, protocol.ProofContentType)
+	}
+
+	actualResult := new(protocol.MembershipResult)
+	err = actualResult.UnmarshalBinary(rr.Body.Bytes())
+	assert.NoError(t, err, "Error decoding the compact proof")
+	assert.True(t, actualResult.Exists, "Incorrect proof")
+	assert.Equal(t, key, actualResult.Key, "Incorrect key")
+	assert.Equal(t, uint64(0x2), actualResult.ActualVersion, "Incorrect actual version")
+	assert.Equal(t, hashing.SHA256, actualResult.HashAlgorithm, "Incorrect hash algorithm")
+}
+
 func TestDigestMembership(t *testing.T) {
 
 	version := uint64(1)
@@ -233,6 +768,7 @@ func TestDigestMembership(t *testing.T) {
 		ActualVersion:  0x2,
 		KeyDigest:      eventDigest,
 		Key:            []byte(nil),
+		HashAlgorithm:  hashing.SHA256,
 	}
 
 	// Our handlers satisfy http.Handler, so we can call their ServeHTTP method
@@ -253,6 +789,200 @@ func TestDigestMembership(t *testing.T) {
 
 }
 
+func TestDigestMembershipWithInvalidDigest(t *testing.T) {
+	query, _ := json.Marshal(protocol.MembershipDigest{
+		KeyDigest: hashing.Digest{0x1},
+		Version:   1,
+	})
+
+	req, err := http.NewRequest("POST", "/proofs/digest-membership", bytes.NewBuffer(query))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := DigestMembership(fakeRaftBalloon{})
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestBatchMembership(t *testing.T) {
+	keyDigests := []hashing.Digest{{0x1}, {0x2}}
+	query, _ := json.Marshal(protocol.BatchMembershipDigests{
+		KeyDigests: keyDigests,
+		Version:    1,
+	})
+
+	req, err := http.NewRequest("POST", "/proofs/batch-membership", bytes.NewBuffer(query))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := BatchMembership(fakeRaftBalloon{})
+	expectedResult := &protocol.BatchMembershipResult{
+		KeyDigests:     keyDigests,
+		Exists:         []bool{false, false},
+		ActualVersions: []uint64{0, 0},
+		Hyper:          visitor.AuditPath{"0|0": hashing.Digest{0x00}},
+		History:        nil,
+		CurrentVersion: 1,
+		QueryVersion:   1,
+		HashAlgorithm:  hashing.SHA256,
+	}
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	actualResult := new(protocol.BatchMembershipResult)
+	json.Unmarshal([]byte(rr.Body.String()), actualResult)
+
+	assert.Equal(t, expectedResult, actualResult, "Incorrect proof")
+}
+
+func TestBatchMembershipWithEmptyBatch(t *testing.T) {
+	query, _ := json.Marshal(protocol.BatchMembershipDigests{
+		KeyDigests: []hashing.Digest{},
+		Version:    1,
+	})
+
+	req, err := http.NewRequest("POST", "/proofs/batch-membership", bytes.NewBuffer(query))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := BatchMembership(fakeRaftBalloon{})
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestRange(t *testing.T) {
+	query, _ := json.Marshal(protocol.RangeQuery{
+		Start:   2,
+		End:     4,
+		Version: 5,
+	})
+
+	req, err := http.NewRequest("POST", "/proofs/range", bytes.NewBuffer(query))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := Range(fakeRaftBalloon{})
+	expectedResult := &protocol.RangeResult{
+		Start:         2,
+		End:           4,
+		Version:       5,
+		EventDigests:  []hashing.Digest{{0x2}, {0x3}, {0x4}},
+		History:       visitor.AuditPath{"0|0": hashing.Digest{0x00}},
+		HashAlgorithm: hashing.SHA256,
+	}
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	actualResult := new(protocol.RangeResult)
+	json.Unmarshal([]byte(rr.Body.String()), actualResult)
+
+	assert.Equal(t, expectedResult, actualResult, "Incorrect proof")
+}
+
+func TestRangeWithInvalidRange(t *testing.T) {
+	query, _ := json.Marshal(protocol.RangeQuery{
+		Start:   4,
+		End:     2,
+		Version: 5,
+	})
+
+	req, err := http.NewRequest("POST", "/proofs/range", bytes.NewBuffer(query))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := Range(fakeRaftBalloon{})
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestRangeNotStored(t *testing.T) {
+	query, _ := json.Marshal(protocol.RangeQuery{
+		Start:   0,
+		End:     2,
+		Version: 5,
+	})
+
+	req, err := http.NewRequest("POST", "/proofs/range", bytes.NewBuffer(query))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := Range(fakeRaftBalloon{})
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
+
+func TestVersionAt(t *testing.T) {
+	testCases := []struct {
+		timestamp      int64
+		expectedStatus int
+	}{
+		{150, http.StatusOK},
+		{50, http.StatusNotFound},
+	}
+
+	for i, c := range testCases {
+		query, _ := json.Marshal(protocol.VersionAtQuery{c.timestamp})
+		req, err := http.NewRequest("POST", "/versions/at", bytes.NewBuffer(query))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := VersionAt(fakeRaftBalloon{})
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != c.expectedStatus {
+			t.Fatalf("handler returned wrong status code in test case %d: got %v want %v",
+				i, status, c.expectedStatus)
+		}
+		if c.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		result := new(protocol.VersionAtResult)
+		json.Unmarshal([]byte(rr.Body.String()), result)
+		assert.Equal(t, &protocol.VersionAtResult{1, 100}, result, "Incorrect version")
+	}
+}
+
 func TestIncremental(t *testing.T) {
 	start := uint64(2)
 	end := uint64(8)
@@ -271,6 +1001,7 @@ func TestIncremental(t *testing.T) {
 		start,
 		end,
 		visitor.AuditPath{"0|0": []uint8{0x0}},
+		hashing.SHA256,
 	}
 
 	// Our handlers satisfy http.Handler, so we can call their ServeHTTP method
@@ -288,6 +1019,94 @@ func TestIncremental(t *testing.T) {
 	assert.Equal(t, expectedResult, actualResult, "Incorrect proof")
 }
 
+func TestIncrementalCompactProof(t *testing.T) {
+	query, _ := json.Marshal(protocol.IncrementalRequest{
+		Start: 2,
+		End:   8,
+	})
+
+	req, err := http.NewRequest("POST", "/proofs/incremental", bytes.NewBuffer(query))
+	assert.NoError(t, err, "Error querying for incremental proof")
+	req.Header.Set("Accept", protocol.ProofContentType)
+
+	rr := httptest.NewRecorder()
+	handler := Incremental(fakeRaftBalloon{})
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "handler returned wrong status code")
+	assert.Equal(t, protocol.ProofContentType, rr.Header().Get("Content-Type"), "Wrong content type")
+
+	expectedResult := &protocol.IncrementalResponse{
+		2,
+		8,
+		visitor.AuditPath{"0|0": []uint8{0x0}},
+		hashing.SHA256,
+	}
+	actualResult := new(protocol.IncrementalResponse)
+	assert.NoError(t, actualResult.UnmarshalBinary(rr.Body.Bytes()), "Error decoding the compact proof")
+	assert.Equal(t, expectedResult, actualResult, "Incorrect proof")
+}
+
+func TestNamespace(t *testing.T) {
+	event := protocol.Event{Event: []byte("this is a sample event")}
+	data, _ := json.Marshal(event)
+
+	testCases := []struct {
+		path           string
+		expectedStatus int
+	}{
+		{"/ns/ns/events", http.StatusCreated},
+		{"/ns/unknown/events", http.StatusNotFound},
+		{"/ns/ns/unknown", http.StatusNotFound},
+		{"/ns/ns", http.StatusNotFound},
+	}
+
+	for _, c := range testCases {
+		req, err := http.NewRequest("POST", c.path, bytes.NewBuffer(data))
+		assert.NoError(t, err)
+		rr := httptest.NewRecorder()
+		Namespace(fakeRaftBalloon{}, testSigner).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code for path %s", c.path)
+
+		if c.expectedStatus == http.StatusCreated {
+			signed := &protocol.SignedSnapshot{}
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), signed))
+			assert.NoError(t, signed.Verify(testKeys, testRanges), "The snapshot should be signed")
+			assert.Equal(t, "ns", signed.Snapshot.Namespace, "The snapshot should carry the namespace")
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	req, err := http.NewRequest("GET", "/keys", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	Keys(fakeRaftBalloon{}).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "Wrong status code")
+
+	var keys []*protocol.SigningKey
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &keys))
+	assert.Equal(t, []*protocol.SigningKey{
+		{KeyID: sign.KeyID(testKey.PublicKey()), PublicKey: testKey.PublicKey(), Start: 0, Active: true},
+	}, keys)
+
+	// the snapshots are signed under the key id of the listed keys
+	data, _ := json.Marshal(&protocol.Event{[]byte("this is a sample event")})
+	req, err = http.NewRequest("POST", "/events", bytes.NewBuffer(data))
+	assert.NoError(t, err)
+	rr = httptest.NewRecorder()
+	Add(fakeRaftBalloon{}, testSigner).ServeHTTP(rr, req)
+
+	signed := &protocol.SignedSnapshot{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), signed))
+	assert.Equal(t, keys[0].KeyID, signed.KeyID)
+	assert.True(t, keys[0].Signs(signed.Snapshot.Version))
+}
+
 func TestAuthHandlerMiddleware(t *testing.T) {
 
 	req, err := http.NewRequest("GET", "/health-check", nil)
@@ -371,7 +1190,7 @@ func newNodeBench(b *testing.B, id int) (*raftwal.RaftBalloon, func()) {
 
 	raftPath := fmt.Sprintf("/var/tmp/raft-test/node%d/raft", id)
 	os.MkdirAll(raftPath, os.FileMode(0755))
-	r, err := raftwal.NewRaftBalloon(raftPath, ":8301", fmt.Sprintf("%d", id), badger, make(chan *protocol.Snapshot))
+	r, err := raftwal.NewRaftBalloon(raftPath, ":8301", fmt.Sprintf("%d", id), badger, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, raftwal.DefaultHyperCheckpointInterval, make(chan *protocol.Snapshot))
 	assert.NoError(b, err)
 
 	return r, func() {
@@ -388,7 +1207,7 @@ func BenchmarkApiAdd(b *testing.B) {
 	err := r.Open(true)
 	assert.NoError(b, err)
 
-	handler := Add(r)
+	handler := Add(r, testSigner)
 
 	time.Sleep(2 * time.Second)
 	b.ResetTimer()
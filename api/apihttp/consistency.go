@@ -0,0 +1,71 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal"
+)
+
+// ConsistencyMiddleware serves a query with the read consistency given in
+// the protocol.ConsistencyParam parameter of its url, stale by default.
+// The last version applied by the node is given in the
+// protocol.VersionHeader header of the response. The node may apply more
+// versions while the query runs, so the handlers of the proofs of the
+// current version replace it with the version of their proof.
+//
+// The following statuses are expected:
+// If the read consistency is unknown, the HTTP status is 400.
+// If the node is not the leader and the read consistency is leader or
+// linearizable, the HTTP status is 503, with the endpoint of the leader in
+// the protocol.LeaderHeader header if it is known.
+func ConsistencyMiddleware(balloon raftwal.RaftBalloonApi, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		consistency, err := protocol.ParseReadConsistency(r.URL.Query().Get(protocol.ConsistencyParam))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		version, err := balloon.ReadVersion(consistency)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isNotLeader(err) {
+				status = http.StatusServiceUnavailable
+				if leader := balloon.LeaderEndpoint(); leader != "" {
+					w.Header().Set(protocol.LeaderHeader, leader)
+				}
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if version > 0 {
+			w.Header().Set(protocol.VersionHeader, strconv.FormatUint(version-1, 10))
+		}
+
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// setVersionHeader sets the protocol.VersionHeader header of the response
+// to the current version of the proof it returns.
+func setVersionHeader(w http.ResponseWriter, version uint64) {
+	w.Header().Set(protocol.VersionHeader, strconv.FormatUint(version, 10))
+}
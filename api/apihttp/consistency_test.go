@@ -0,0 +1,80 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bbva/qed/protocol"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestConsistencyMiddleware(t *testing.T) {
+	served := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("served"))
+	})
+
+	tests := []struct {
+		name    string
+		balloon fakeRaftBalloon
+		query   string
+		status  int
+		version string
+		leader  string
+	}{
+		{"stale by default", fakeRaftBalloon{follower: true}, "", http.StatusOK, "2", ""},
+		{"stale in a follower", fakeRaftBalloon{follower: true}, "?consistency=stale", http.StatusOK, "2", ""},
+		{"leader in the leader", fakeRaftBalloon{}, "?consistency=leader", http.StatusOK, "2", ""},
+		{"linearizable in the leader", fakeRaftBalloon{}, "?consistency=linearizable", http.StatusOK, "2", ""},
+		{"leader in a follower", fakeRaftBalloon{follower: true, leader: "http://leader:8800"}, "?consistency=leader", http.StatusServiceUnavailable, "", "http://leader:8800"},
+		{"linearizable in a follower", fakeRaftBalloon{follower: true}, "?consistency=linearizable", http.StatusServiceUnavailable, "", ""},
+		{"unknown", fakeRaftBalloon{}, "?consistency=eventual", http.StatusBadRequest, "", ""},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest("POST", "/proofs/membership"+test.query, nil)
+		assert.NoError(t, err, test.name)
+
+		rr := httptest.NewRecorder()
+		ConsistencyMiddleware(test.balloon, served).ServeHTTP(rr, req)
+
+		assert.Equal(t, test.status, rr.Code, test.name)
+		assert.Equal(t, test.version, rr.Header().Get(protocol.VersionHeader), test.name)
+		assert.Equal(t, test.leader, rr.Header().Get(protocol.LeaderHeader), test.name)
+	}
+}
+
+func TestConsistencyMiddlewareWithProof(t *testing.T) {
+	query, _ := json.Marshal(&protocol.MembershipDigest{KeyDigest: make([]byte, 32), Version: 1})
+	req, err := http.NewRequest("POST", "/proofs/digest-membership", bytes.NewBuffer(query))
+	assert.NoError(t, err)
+
+	// the fake balloon applied version 2 but its proofs are of version 1,
+	// as if they were queried before it was applied
+	rr := httptest.NewRecorder()
+	ConsistencyMiddleware(fakeRaftBalloon{}, DigestMembership(fakeRaftBalloon{})).ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var result protocol.MembershipResult
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Equal(t, "1", rr.Header().Get(protocol.VersionHeader), "The version must be the one of the proof")
+	assert.Equal(t, uint64(1), result.CurrentVersion)
+}
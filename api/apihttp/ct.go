@@ -0,0 +1,380 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/balloon/ct"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/sign"
+	"github.com/bbva/qed/util"
+)
+
+// The handlers of this file serve the read api of Certificate Transparency
+// logs, as defined by RFC 6962, so the tooling built for those logs can
+// watch a QED log. It is backed by a Merkle tree of RFC 6962 kept along
+// with the history tree, whose size is the number of versions. Its leaves
+// are the MerkleTreeLeaf of the versions, timestamped entries whose X.509
+// certificate is the event digest, and the tree heads are signed by the
+// key that signs the last version.
+
+// CTSignedTreeHead is the body of the response to get-sth.
+type CTSignedTreeHead struct {
+	TreeSize          uint64 `json:"tree_size"`
+	Timestamp         uint64 `json:"timestamp"`
+	SHA256RootHash    []byte `json:"sha256_root_hash"`
+	TreeHeadSignature []byte `json:"tree_head_signature"`
+}
+
+// CTConsistencyProof is the body of the response to get-sth-consistency.
+type CTConsistencyProof struct {
+	Consistency [][]byte `json:"consistency"`
+}
+
+// CTAuditProof is the body of the response to get-proof-by-hash.
+type CTAuditProof struct {
+	LeafIndex uint64   `json:"leaf_index"`
+	AuditPath [][]byte `json:"audit_path"`
+}
+
+// CTEntry is a leaf of the log, whose input is the MerkleTreeLeaf of a
+// version. Its extra data is an empty certificate chain.
+type CTEntry struct {
+	LeafInput []byte `json:"leaf_input"`
+	ExtraData []byte `json:"extra_data"`
+}
+
+// CTEntries is the body of the response to get-entries.
+type CTEntries struct {
+	Entries []CTEntry `json:"entries"`
+}
+
+var errInvalidLeafHash = errors.New("the leaf hash is not a SHA-256 digest")
+
+var errUnsupportedTreeHeadSignature = errors.New("the algorithm of the signing key can not sign tree heads")
+
+// emptyChain is the encoding of an empty certificate chain.
+var emptyChain = []byte{0, 0, 0}
+
+// The codes of the hash and signature algorithms of the TLS 1.2 structs
+// RFC 6962 signs with. Ed25519 is signed without hashing it before, as RFC
+// 8422 defines.
+var treeHeadSignatureAlgorithms = map[string][2]byte{
+	sign.ECDSAP256SHA256: {4, 3},
+	sign.Ed25519:         {8, 7},
+}
+
+// treeHeadSignature signs the TreeHeadSignature of RFC 6962 of the tree
+// head and returns its DigitallySigned encoding.
+func treeHeadSignature(signer sign.Signer, size, timestamp uint64, rootHash hashing.Digest) ([]byte, error) {
+	algorithms, ok := treeHeadSignatureAlgorithms[signer.Algorithm()]
+	if !ok {
+		return nil, errUnsupportedTreeHeadSignature
+	}
+
+	// v1, tree_hash
+	message := []byte{0, 1}
+	message = append(message, util.Uint64AsBytes(timestamp)...)
+	message = append(message, util.Uint64AsBytes(size)...)
+	message = append(message, rootHash...)
+	signature, err := signer.Sign(message)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := append(algorithms[:], util.Uint16AsBytes(uint16(len(signature)))...)
+	return append(signed, signature...), nil
+}
+
+func isCTNotAvailable(err error) bool {
+	return err == balloon.ErrCTNotAvailable
+}
+
+// uintParam returns the value of an unsigned integer query parameter.
+func uintParam(r *http.Request, name string) (uint64, error) {
+	return strconv.ParseUint(r.URL.Query().Get(name), 10, 64)
+}
+
+func toPath(digests []hashing.Digest) [][]byte {
+	path := make([][]byte, len(digests))
+	for i, digest := range digests {
+		path[i] = digest
+	}
+	return path
+}
+
+// writeCT writes the JSON body of a response of the Certificate
+// Transparency api.
+func writeCT(w http.ResponseWriter, body interface{}) {
+	out, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+// CTGetSTH returns the latest tree head of the log, signed by the key that
+// signs its last version. The timestamp is the time the last version was
+// committed, in milliseconds since the Unix epoch.
+// The http get url is:
+//
+//	GET /ct/v1/get-sth
+//
+// The following statuses are expected:
+// If the log has versions added before its tree was kept, the HTTP status
+// is 404.
+// If the signing key is neither ECDSA nor Ed25519, the HTTP status is 501.
+// If everything is alright, the HTTP status is 200 and the body contains:
+//
+//	{
+//	  "tree_size": 10,
+//	  "timestamp": 1540000000000,
+//	  "sha256_root_hash": "<base64>",
+//	  "tree_head_signature": "<base64>"
+//	}
+func CTGetSTH(balloon raftwal.RaftBalloonApi, signer protocol.SnapshotSigner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		head, err := balloon.QueryCTTreeHead()
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isCTNotAvailable(err) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		var version uint64
+		if head.Size > 0 {
+			version = head.Size - 1
+		}
+		keySigner, err := signer.SignerFor(head.Namespace, version)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		timestamp := uint64(head.Timestamp / 1e6)
+		signature, err := treeHeadSignature(keySigner, head.Size, timestamp, head.RootHash)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if err == errUnsupportedTreeHeadSignature {
+				status = http.StatusNotImplemented
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		if head.Size > 0 {
+			setVersionHeader(w, version)
+		}
+		writeCT(w, &CTSignedTreeHead{
+			TreeSize:          head.Size,
+			Timestamp:         timestamp,
+			SHA256RootHash:    head.RootHash,
+			TreeHeadSignature: signature,
+		})
+	}
+}
+
+// CTGetSTHConsistency returns the proof of consistency between two tree
+// sizes, which is empty when they are the same.
+// The http get url is:
+//
+//	GET /ct/v1/get-sth-consistency?first=2&second=8
+//
+// The following statuses are expected:
+// If the first size is zero or greater than the second one, or the second
+// one is greater than the size of the tree, the HTTP status is 400.
+// If the log has versions added before its tree was kept, the HTTP status
+// is 404.
+// If everything is alright, the HTTP status is 200 and the body contains:
+//
+//	{
+//	  "consistency": ["<base64>", ...]
+//	}
+func CTGetSTHConsistency(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		first, err := uintParam(r, "first")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		second, err := uintParam(r, "second")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		proof, err := balloon.QueryCTConsistency(first, second)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if err == ct.ErrInvalidSize {
+				status = http.StatusBadRequest
+			}
+			if isCTNotAvailable(err) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		writeCT(w, &CTConsistencyProof{Consistency: toPath(proof)})
+	}
+}
+
+// CTGetProofByHash returns the proof of membership of a leaf in the tree
+// of the given size, given its hash, the SHA-256 digest of 0x00 followed
+// by its leaf input. The leaf index is the version it was added at.
+// The http get url is:
+//
+//	GET /ct/v1/get-proof-by-hash?hash=<base64>&tree_size=8
+//
+// The following statuses are expected:
+// If the hash is not a SHA-256 digest, or the size is zero or greater than
+// the size of the tree, the HTTP status is 400.
+// If the leaf was not added before that size, or the log has versions
+// added before its tree was kept, the HTTP status is 404.
+// If everything is alright, the HTTP status is 200 and the body contains:
+//
+//	{
+//	  "leaf_index": 3,
+//	  "audit_path": ["<base64>", ...]
+//	}
+func CTGetProofByHash(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		leafHash, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("hash"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(leafHash) != 32 {
+			http.Error(w, errInvalidLeafHash.Error(), http.StatusBadRequest)
+			return
+		}
+		size, err := uintParam(r, "tree_size")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		index, path, err := balloon.QueryCTMembership(leafHash, size)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if err == ct.ErrInvalidSize {
+				status = http.StatusBadRequest
+			}
+			if err == ct.ErrLeafNotFound || isCTNotAvailable(err) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		writeCT(w, &CTAuditProof{LeafIndex: index, AuditPath: toPath(path)})
+	}
+}
+
+// CTGetEntries returns the leaves added from the start to the end version,
+// both included. As RFC 6962 allows, fewer entries than requested are
+// returned when the end is beyond the size of the tree or the range is
+// larger than the maximum size of a range proof.
+// The http get url is:
+//
+//	GET /ct/v1/get-entries?start=2&end=8
+//
+// The following statuses are expected:
+// If the start is greater than the end or beyond the size of the tree, the
+// HTTP status is 400.
+// If the log has versions added before its tree was kept, the HTTP status
+// is 404.
+// If everything is alright, the HTTP status is 200 and the body contains:
+//
+//	{
+//	  "entries": [{"leaf_input": "<base64>", "extra_data": "AAAA"}, ...]
+//	}
+func CTGetEntries(balloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Make sure we can only be called with an HTTP GET request.
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		start, err := uintParam(r, "start")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		end, err := uintParam(r, "end")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		leafInputs, err := balloon.QueryCTEntries(start, end)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isInvalidRange(err) {
+				status = http.StatusBadRequest
+			}
+			if isCTNotAvailable(err) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		entries := make([]CTEntry, len(leafInputs))
+		for i, input := range leafInputs {
+			entries[i] = CTEntry{LeafInput: input, ExtraData: emptyChain}
+		}
+		writeCT(w, &CTEntries{Entries: entries})
+	}
+}
@@ -0,0 +1,226 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/bbva/qed/balloon/ct"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
+	"github.com/bbva/qed/util"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestCTGetSTH(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ecdsaSigner, err := sign.NewECDSASigner(ecdsaKey)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		signer             sign.Signer
+		expectedAlgorithms []byte
+	}{
+		{testKey, []byte{8, 7}},
+		{ecdsaSigner, []byte{4, 3}},
+	}
+
+	for _, c := range testCases {
+		req, err := http.NewRequest("GET", "/ct/v1/get-sth", nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		CTGetSTH(fakeRaftBalloon{}, protocol.NewSnapshotSigner(c.signer)).ServeHTTP(rr, req)
+		assert.Equalf(t, http.StatusOK, rr.Code, "Wrong status code for %s", c.signer.Algorithm())
+		assert.Equal(t, "1", rr.Header().Get(protocol.VersionHeader), "The version should be the last one")
+
+		sth := new(CTSignedTreeHead)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), sth))
+		assert.Equal(t, uint64(2), sth.TreeSize, "The tree size should be the number of versions")
+		assert.Equal(t, uint64(1540000000123), sth.Timestamp, "The timestamp should be in milliseconds")
+		assert.Equal(t, []byte(fakeCTRootHash), sth.SHA256RootHash, "The root hash should be the one of the tree")
+
+		// the signature is a DigitallySigned struct of a TreeHeadSignature
+		signed := sth.TreeHeadSignature
+		assert.Equalf(t, c.expectedAlgorithms, signed[:2], "Wrong algorithms for %s", c.signer.Algorithm())
+		length := binary.BigEndian.Uint16(signed[2:4])
+		assert.Equal(t, int(length), len(signed[4:]), "The signature should be preceded by its length")
+
+		message := []byte{0, 1}
+		message = append(message, util.Uint64AsBytes(sth.Timestamp)...)
+		message = append(message, util.Uint64AsBytes(sth.TreeSize)...)
+		message = append(message, sth.SHA256RootHash...)
+		ok, err := c.signer.Verify(message, signed[4:])
+		assert.NoError(t, err)
+		assert.Truef(t, ok, "The tree head should be signed with the %s key", c.signer.Algorithm())
+	}
+}
+
+func TestCTGetSTHUnsupportedKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	rsaSigner, err := sign.NewRSAPSSSigner(rsaKey)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "/ct/v1/get-sth", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	CTGetSTH(fakeRaftBalloon{}, protocol.NewSnapshotSigner(rsaSigner)).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotImplemented, rr.Code, "RSA-PSS keys should not sign tree heads")
+}
+
+func TestCTGetSTHRoutes(t *testing.T) {
+	api := NewApiHttp(fakeRaftBalloon{}, testSigner, RedirectWrites)
+
+	for _, path := range []string{"/ct/v1/get-sth", "/ns/ns/ct/v1/get-sth"} {
+		req, err := http.NewRequest("GET", path, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Api-Key", "my-key")
+
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, req)
+		assert.Equalf(t, http.StatusOK, rr.Code, "Wrong status code for path %s", path)
+
+		sth := new(CTSignedTreeHead)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), sth))
+		assert.NotEmptyf(t, sth.TreeHeadSignature, "The tree head of %s should be signed", path)
+	}
+}
+
+func TestCTGetSTHConsistency(t *testing.T) {
+	testCases := []struct {
+		query          string
+		expectedStatus int
+		expectedProof  [][]byte
+	}{
+		{"first=1&second=2", http.StatusOK, [][]byte{{0x00}}},
+		{"first=2&second=2", http.StatusOK, [][]byte{}},
+		{"first=0&second=2", http.StatusBadRequest, nil},
+		{"first=2&second=1", http.StatusBadRequest, nil},
+		{"first=1&second=3", http.StatusBadRequest, nil},
+		{"first=1", http.StatusBadRequest, nil},
+	}
+
+	for _, c := range testCases {
+		req, err := http.NewRequest("GET", "/ct/v1/get-sth-consistency?"+c.query, nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		CTGetSTHConsistency(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code for query %s", c.query)
+		if c.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		proof := new(CTConsistencyProof)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), proof))
+		assert.Equalf(t, c.expectedProof, proof.Consistency, "Wrong proof for query %s", c.query)
+	}
+}
+
+func TestCTGetProofByHash(t *testing.T) {
+	leafHash := url.QueryEscape(base64.StdEncoding.EncodeToString(ct.LeafHash(fakeCTLeafInput(1))))
+	// the digest of the event is not the hash of its leaf
+	eventDigest := url.QueryEscape(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+
+	testCases := []struct {
+		query          string
+		expectedStatus int
+		expectedIndex  uint64
+	}{
+		{"hash=" + leafHash + "&tree_size=2", http.StatusOK, 1},
+		{"hash=" + leafHash + "&tree_size=1", http.StatusNotFound, 0},
+		{"hash=" + eventDigest + "&tree_size=2", http.StatusNotFound, 0},
+		{"hash=" + leafHash + "&tree_size=3", http.StatusBadRequest, 0},
+		{"hash=" + leafHash + "&tree_size=0", http.StatusBadRequest, 0},
+		{"hash=AQID&tree_size=2", http.StatusBadRequest, 0},
+		{"hash=%25%25&tree_size=2", http.StatusBadRequest, 0},
+	}
+
+	for _, c := range testCases {
+		req, err := http.NewRequest("GET", "/ct/v1/get-proof-by-hash?"+c.query, nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		CTGetProofByHash(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code for query %s", c.query)
+		if c.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		proof := new(CTAuditProof)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), proof))
+		assert.Equalf(t, c.expectedIndex, proof.LeafIndex, "Wrong leaf index for query %s", c.query)
+		assert.Equalf(t, [][]byte{{0x00}}, proof.AuditPath, "Wrong audit path for query %s", c.query)
+	}
+}
+
+func TestCTGetEntries(t *testing.T) {
+	testCases := []struct {
+		query           string
+		expectedStatus  int
+		expectedEntries []CTEntry
+	}{
+		{"start=0&end=5", http.StatusOK, []CTEntry{
+			{LeafInput: fakeCTLeafInput(0), ExtraData: []byte{0, 0, 0}},
+			{LeafInput: fakeCTLeafInput(1), ExtraData: []byte{0, 0, 0}},
+		}},
+		{"start=1&end=1", http.StatusOK, []CTEntry{
+			{LeafInput: fakeCTLeafInput(1), ExtraData: []byte{0, 0, 0}},
+		}},
+		{"start=2&end=5", http.StatusBadRequest, nil},
+		{"start=1&end=0", http.StatusBadRequest, nil},
+		{"start=1", http.StatusBadRequest, nil},
+	}
+
+	for _, c := range testCases {
+		req, err := http.NewRequest("GET", "/ct/v1/get-entries?"+c.query, nil)
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		CTGetEntries(fakeRaftBalloon{}).ServeHTTP(rr, req)
+		assert.Equalf(t, c.expectedStatus, rr.Code, "Wrong status code for query %s", c.query)
+		if c.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		entries := new(CTEntries)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), entries))
+		assert.Equalf(t, c.expectedEntries, entries.Entries, "Wrong entries for query %s", c.query)
+	}
+}
+
+func TestCTMethodNotAllowed(t *testing.T) {
+	req, err := http.NewRequest("POST", "/ct/v1/get-sth", nil)
+	assert.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	CTGetSTH(fakeRaftBalloon{}, testSigner).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code, "Wrong status code")
+}
@@ -0,0 +1,118 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal"
+)
+
+// FollowerWrites is how a follower answers the writes only the leader can
+// apply, and the reads only the leader can answer.
+type FollowerWrites int
+
+const (
+	// RedirectWrites answers with a temporary redirect to the api of the
+	// leader, which keeps the method and the body.
+	RedirectWrites FollowerWrites = iota
+	// ForwardWrites proxies the request to the api of the leader and
+	// answers with its response.
+	ForwardWrites
+)
+
+// ParseFollowerWrites returns the policy of the given name: redirect or
+// forward.
+func ParseFollowerWrites(name string) (FollowerWrites, error) {
+	switch name {
+	case "redirect":
+		return RedirectWrites, nil
+	case "forward":
+		return ForwardWrites, nil
+	}
+	return 0, fmt.Errorf("unknown follower writes policy %q", name)
+}
+
+// forwardedHeader marks the requests a follower forwarded, which are not
+// forwarded again if they reach another follower while the leader changes.
+const forwardedHeader = "Qed-Forwarded"
+
+// leaderOnly returns whether the request adds to a balloon, either the
+// default one or a namespace, or queries it with a read consistency that
+// only the leader can provide.
+func leaderOnly(r *http.Request) bool {
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/ns/") {
+		parts := strings.SplitN(strings.TrimPrefix(path, "/ns/"), "/", 2)
+		if len(parts) != 2 {
+			return false
+		}
+		path = "/" + parts[1]
+	}
+	if _, ok := writeHandlers[path]; ok {
+		return r.Method == "POST"
+	}
+	consistency := protocol.ReadConsistency(r.URL.Query().Get(protocol.ConsistencyParam))
+	return consistency == protocol.LeaderReads || consistency == protocol.LinearizableReads
+}
+
+// LeaderMiddleware sends the writes that reach a follower to the leader, by
+// redirecting or forwarding them as the policy says, and so the reads with
+// the leader or linearizable consistency. The leader endpoint
+// is also given in the protocol.LeaderHeader header of the response. If
+// the leader is not known, or a forwarded request reaches a follower, the
+// HTTP status is 503 so the client can try again. Stale reads are always
+// served by the node.
+func LeaderMiddleware(balloon raftwal.RaftBalloonApi, writes FollowerWrites, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !leaderOnly(r) || balloon.IsLeader() {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		leader := balloon.LeaderEndpoint()
+		if leader == "" || r.Header.Get(forwardedHeader) != "" {
+			http.Error(w, raftwal.ErrNotLeader.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set(protocol.LeaderHeader, leader)
+
+		if writes == RedirectWrites {
+			http.Redirect(w, r, leader+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+			return
+		}
+
+		target, err := url.Parse(leader)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Infof("Unable to forward the write to the leader at %s: %v", leader, err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		}
+		r.Header.Set(forwardedHeader, "true")
+		proxy.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package apihttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bbva/qed/protocol"
+	assert "github.com/stretchr/testify/require"
+)
+
+func TestLeaderMiddleware(t *testing.T) {
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set(forwardedHeader, r.Header.Get(forwardedHeader))
+		_, _ = w.Write(append([]byte("leader: "), body...))
+	}))
+	defer leader.Close()
+
+	served := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("served"))
+	})
+
+	tests := []struct {
+		name     string
+		balloon  fakeRaftBalloon
+		writes   FollowerWrites
+		method   string
+		path     string
+		header   string
+		status   int
+		location string
+		body     string
+	}{
+		{"the leader serves writes", fakeRaftBalloon{}, RedirectWrites, "POST", "/events", "", http.StatusOK, "", "served"},
+		{"a follower serves reads", fakeRaftBalloon{follower: true, leader: leader.URL}, RedirectWrites, "POST", "/proofs/membership", "", http.StatusOK, "", "served"},
+		{"a follower redirects writes", fakeRaftBalloon{follower: true, leader: leader.URL}, RedirectWrites, "POST", "/events", "", http.StatusTemporaryRedirect, leader.URL + "/events", ""},
+		{"a follower redirects namespace writes", fakeRaftBalloon{follower: true, leader: leader.URL}, RedirectWrites, "POST", "/ns/ns/kv", "", http.StatusTemporaryRedirect, leader.URL + "/ns/ns/kv", ""},
+		{"a follower redirects linearizable reads", fakeRaftBalloon{follower: true, leader: leader.URL}, RedirectWrites, "POST", "/proofs/membership?consistency=linearizable", "", http.StatusTemporaryRedirect, leader.URL + "/proofs/membership?consistency=linearizable", ""},
+		{"a follower forwards leader reads", fakeRaftBalloon{follower: true, leader: leader.URL}, ForwardWrites, "POST", "/ns/ns/proofs/kv?consistency=leader", "", http.StatusOK, "", "leader: event"},
+		{"a follower forwards writes", fakeRaftBalloon{follower: true, leader: leader.URL}, ForwardWrites, "POST", "/events/bulk", "", http.StatusOK, "", "leader: event"},
+		{"a follower does not know the leader", fakeRaftBalloon{follower: true}, ForwardWrites, "POST", "/events", "", http.StatusServiceUnavailable, "", ""},
+		{"a follower does not forward twice", fakeRaftBalloon{follower: true, leader: leader.URL}, ForwardWrites, "POST", "/events", "true", http.StatusServiceUnavailable, "", ""},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.path, bytes.NewBufferString("event"))
+		assert.NoError(t, err, test.name)
+		if test.header != "" {
+			req.Header.Set(forwardedHeader, test.header)
+		}
+
+		rr := httptest.NewRecorder()
+		LeaderMiddleware(test.balloon, test.writes, served).ServeHTTP(rr, req)
+
+		assert.Equal(t, test.status, rr.Code, test.name)
+		if test.location != "" {
+			assert.Equal(t, test.location, rr.Header().Get("Location"), test.name)
+			assert.Equal(t, leader.URL, rr.Header().Get(protocol.LeaderHeader), test.name)
+		}
+		if test.body != "" {
+			assert.Equal(t, test.body, rr.Body.String(), test.name)
+		}
+	}
+}
+
+func TestParseFollowerWrites(t *testing.T) {
+	writes, err := ParseFollowerWrites("redirect")
+	assert.NoError(t, err)
+	assert.Equal(t, RedirectWrites, writes)
+
+	writes, err = ParseFollowerWrites("forward")
+	assert.NoError(t, err)
+	assert.Equal(t, ForwardWrites, writes)
+
+	_, err = ParseFollowerWrites("ignore")
+	assert.Error(t, err)
+}
@@ -0,0 +1,290 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mgmthttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal"
+)
+
+// MemberStatus is a node of the cluster along with the index of the last
+// log it applied, which is only known if the node could be reached.
+type MemberStatus struct {
+	raftwal.Member
+	AppliedIndex uint64
+	Unreachable  bool `json:",omitempty"`
+}
+
+// statusTimeout is how long the status of every other node is waited for
+// when listing the members.
+const statusTimeout = 2 * time.Second
+
+// membersHandle lists the nodes of the cluster, with their voter status
+// and the index of the last log they applied, which is asked to every
+// node through its management api:
+//
+//	GET /cluster/members
+//
+// If everything is alright, the HTTP status is 200 and the body contains:
+//
+//	[{"ID": "node0", "Address": "127.0.0.1:8500", "Voter": true, "Leader": true,
+//	  "APIEndpoint": "http://127.0.0.1:8800", "MgmtEndpoint": "http://127.0.0.1:8700",
+//	  "AppliedIndex": 42}]
+func membersHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		members, err := raftBalloon.Members()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		local, err := raftBalloon.Status()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		client := &http.Client{Timeout: statusTimeout}
+		statuses := make([]*MemberStatus, len(members))
+		var wg sync.WaitGroup
+		for i, member := range members {
+			statuses[i] = &MemberStatus{Member: *member}
+			if member.ID == local.ID {
+				statuses[i].AppliedIndex = local.AppliedIndex
+				continue
+			}
+			wg.Add(1)
+			go func(status *MemberStatus) {
+				defer wg.Done()
+				remote, err := fetchStatus(client, status.MgmtEndpoint)
+				if err != nil {
+					log.Infof("Unable to get the status of node %s: %v", status.ID, err)
+					status.Unreachable = true
+					return
+				}
+				status.AppliedIndex = remote.AppliedIndex
+			}(statuses[i])
+		}
+		wg.Wait()
+
+		out, err := json.Marshal(statuses)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+	}
+}
+
+// fetchStatus asks the node with the given management endpoint for its
+// Raft state.
+func fetchStatus(client *http.Client, endpoint string) (*raftwal.Status, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("the node did not register its management endpoint")
+	}
+	resp, err := client.Get(endpoint + "/cluster/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var status raftwal.Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// statusHandle returns the Raft state of the node handling the request:
+//
+//	GET /cluster/status
+//
+// If everything is alright, the HTTP status is 200 and the body contains:
+//
+//	{"ID": "node1", "State": "Follower", "LeaderID": "node0", "LastIndex": 42, "AppliedIndex": 42}
+func statusHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		status, err := raftBalloon.Status()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		out, err := json.Marshal(status)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+	}
+}
+
+// leaderHandle returns the leader of the cluster:
+//
+//	GET /cluster/leader
+//
+// If there is a leader, the HTTP status is 200 and the body contains the
+// member, like the ones listed by /cluster/members. Otherwise, the HTTP
+// status is 503.
+func leaderHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		leader, err := leaderOf(raftBalloon)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if leader == nil {
+			http.Error(w, "there is no leader", http.StatusServiceUnavailable)
+			return
+		}
+
+		out, err := json.Marshal(leader)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+	}
+}
+
+// leaderOf returns the member that leads the cluster, nil if there is no
+// leader.
+func leaderOf(raftBalloon raftwal.RaftBalloonApi) (*raftwal.Member, error) {
+	members, err := raftBalloon.Members()
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if member.Leader {
+			return member, nil
+		}
+	}
+	return nil, nil
+}
+
+// removeHandle removes the node with the ID given in the body from the
+// cluster, along with its metadata:
+//
+//	POST /cluster/remove {"id": "node1"}
+//
+// If the node is removed, the HTTP status is 200. If it is not a member of
+// the cluster, the HTTP status is 404. The node handling the request must
+// be the leader, otherwise the HTTP status is 503, with the management
+// endpoint of the leader in the protocol.LeaderHeader header if it is
+// known.
+func removeHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		m := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil || m["id"] == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		members, err := raftBalloon.Members()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var leader *raftwal.Member
+		found := false
+		for _, member := range members {
+			if member.Leader {
+				leader = member
+			}
+			if member.ID == m["id"] {
+				found = true
+			}
+		}
+		if !found {
+			http.Error(w, "the node is not a member of the cluster", http.StatusNotFound)
+			return
+		}
+
+		switch err := raftBalloon.Remove(m["id"]); err {
+		case nil:
+			w.WriteHeader(http.StatusOK)
+		case raftwal.ErrNotLeader:
+			if leader != nil && leader.MgmtEndpoint != "" {
+				w.Header().Set(protocol.LeaderHeader, leader.MgmtEndpoint)
+			}
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// snapshotHandle makes the node handling the request take a Raft snapshot
+// right away, which compacts its Raft log:
+//
+//	POST /cluster/snapshot
+//
+// If the snapshot is taken, the HTTP status is 200. If no log was applied
+// yet, the HTTP status is 409.
+func snapshotHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		switch err := raftBalloon.TakeSnapshot(); err {
+		case nil:
+			w.WriteHeader(http.StatusOK)
+		case raftwal.ErrNothingToSnapshot:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -18,47 +18,208 @@ package mgmthttp
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/sign"
 )
 
 // NewMgmtHttp will return a mux server with the endpoint required to
 // tamper the server. it's a internal debug implementation. Running a server
 // with this enabled will run useless the qed server.
-func NewMgmtHttp(raftBalloon raftwal.RaftBalloonApi) *http.ServeMux {
+func NewMgmtHttp(raftBalloon raftwal.RaftBalloonApi, keyring *sign.Keyring) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/join", joinHandle(raftBalloon))
+	mux.HandleFunc("/namespaces", createNamespaceHandle(raftBalloon))
+	mux.HandleFunc("/keys/rotate", rotateKeyHandle(raftBalloon, keyring))
+	mux.HandleFunc("/cluster/members", membersHandle(raftBalloon))
+	mux.HandleFunc("/cluster/status", statusHandle(raftBalloon))
+	mux.HandleFunc("/cluster/leader", leaderHandle(raftBalloon))
+	mux.HandleFunc("/cluster/remove", removeHandle(raftBalloon))
+	mux.HandleFunc("/cluster/snapshot", snapshotHandle(raftBalloon))
+	mux.HandleFunc("/backup", backupHandle(raftBalloon))
 	return mux
 }
 
-func joinHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
+// backupHandle streams a consistent backup of the store of the node
+// handling the request, which qed admin restore loads into a new data
+// directory:
+//
+//	POST /backup
+//
+// The body starts with a line with the header of the backup, in JSON,
+// followed by the data:
+//
+//	{"Format": "qed-backup/v1", "HashAlgorithm": "sha256", "Version": 42,
+//	 "HistoryDigest": "...", "HyperDigest": "...", "Checksum": "..."}
+//
+// If the backup fails before it is streamed, the HTTP status is 500.
+// Otherwise, the connection is closed before the end of the body.
+func backupHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		m := map[string]string{}
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
 
+		w.Header().Set("Content-Type", "application/octet-stream")
+		out := &countingWriter{w: w}
+		header, err := raftBalloon.Backup(out)
+		if err != nil {
+			if out.n == 0 {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			log.Errorf("Backup failed after streaming %d bytes: %v", out.n, err)
+			panic(http.ErrAbortHandler)
+		}
+		log.Infof("Backup of version %d streamed: %d bytes", header.Version, out.n)
+	}
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// rotateKeyHandle makes the key with the ID given in the body sign the
+// next version of every namespace. Every node must have loaded the key
+// before, and the node handling the request must be the leader:
+//
+//	POST /keys/rotate {"id": "03f1b2c4d5e6a798"}
+//
+// If the key is rotated, the HTTP status is 200. If the node has not
+// loaded the key, the HTTP status is 400. If the key has already signed
+// snapshots, the HTTP status is 409.
+func rotateKeyHandle(raftBalloon raftwal.RaftBalloonApi, keyring *sign.Keyring) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		m := map[string]string{}
 		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		if len(m) != 2 {
+		signer, ok := keyring.Get(m["id"])
+		if !ok {
+			http.Error(w, "the key is not loaded by the node", http.StatusBadRequest)
+			return
+		}
+
+		switch err := raftBalloon.RotateSigningKey(signer.Algorithm(), signer.PublicKey()); err {
+		case nil:
+			w.WriteHeader(http.StatusOK)
+		case raftwal.ErrSigningKeyUsed:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// createNamespaceHandle creates the namespace given in the body, along
+// with its mode, which adds events if it is not given, and its policy for
+// the events added more than once, which overwrites them if it is not
+// given:
+//
+//	POST /namespaces {"name": "my-namespace", "mode": "events", "duplicates": "reject"}
+//
+// If the namespace is created, the HTTP status is 201. If it already
+// exists, the HTTP status is 409.
+func createNamespaceHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		m := map[string]string{}
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		remoteAddr, ok := m["addr"]
+		name, ok := m["name"]
 		if !ok {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		nodeID, ok := m["id"]
-		if !ok {
+		mode := balloon.EventMode
+		if name, ok := m["mode"]; ok {
+			var err error
+			mode, err = balloon.ParseMode(name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		duplicates := balloon.OverwriteDuplicates
+		if policy, ok := m["duplicates"]; ok {
+			var err error
+			duplicates, err = balloon.ParseDuplicatePolicy(policy)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		switch err := raftBalloon.CreateNamespace(name, mode, duplicates); err {
+		case nil:
+			w.WriteHeader(http.StatusCreated)
+		case raftwal.ErrInvalidNamespace:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case raftwal.ErrNamespaceExists:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// joinHandle joins the node with the given ID and Raft address to the
+// cluster, along with its metadata, which is optional:
+//
+//	POST /join {"id": "node1", "addr": "127.0.0.1:8500", "metadata": {"api_endpoint": "http://127.0.0.1:8800"}}
+//
+// The node handling the request must be the leader.
+func joinHandle(raftBalloon raftwal.RaftBalloonApi) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var m struct {
+			ID       string            `json:"id"`
+			Addr     string            `json:"addr"`
+			Metadata map[string]string `json:"metadata"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if m.ID == "" || m.Addr == "" {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		if err := raftBalloon.Join(nodeID, remoteAddr); err != nil {
+		if err := raftBalloon.Join(m.ID, m.Addr, m.Metadata); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
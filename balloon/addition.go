@@ -0,0 +1,58 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package balloon
+
+import (
+	"sync/atomic"
+
+	"github.com/bbva/qed/balloon/hyper"
+	"github.com/bbva/qed/storage"
+)
+
+// Addition is an addition to the balloon whose mutations are not stored
+// yet. Queries do not see its versions until it is committed, which must
+// happen once the mutations are stored and before the next addition is
+// prepared. Commits must not run in parallel with the queries or a
+// checkpoint of the balloon.
+type Addition struct {
+	Snapshots []*Snapshot
+	Mutations []*storage.Mutation
+
+	balloon *Balloon
+	version uint64
+	hyper   *hyper.Pending
+}
+
+// newAddition returns an addition of the given number of versions, after
+// the ones already visible.
+func (b *Balloon) newAddition(snapshots []*Snapshot, mutations []*storage.Mutation, pending *hyper.Pending, versions int) *Addition {
+	return &Addition{
+		Snapshots: snapshots,
+		Mutations: mutations,
+		balloon:   b,
+		version:   b.Version() + uint64(versions),
+		hyper:     pending,
+	}
+}
+
+// Commit publishes the versions of the addition to the queries.
+func (a *Addition) Commit() {
+	if a.hyper != nil {
+		a.hyper.Commit()
+	}
+	atomic.StoreUint64(&a.balloon.version, a.version)
+}
@@ -17,10 +17,13 @@
 package balloon
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/bbva/qed/balloon/cache"
+	"github.com/bbva/qed/balloon/ct"
 	"github.com/bbva/qed/balloon/history"
 	"github.com/bbva/qed/balloon/hyper"
 	"github.com/bbva/qed/balloon/visitor"
@@ -32,54 +35,101 @@ import (
 
 var (
 	BalloonVersionKey = []byte("version")
+	ErrInvalidRange   = errors.New("invalid range of versions")
+	ErrRangeTooLarge  = errors.New("the range exceeds the maximum number of versions")
+	ErrInvalidDigest  = errors.New("the digest length does not match the hash algorithm")
+	ErrRangeNotStored = errors.New("the event digests of the range were added before they were stored, so it can not be proven")
 )
 
+// MaxRangeSize is the maximum number of versions of a range proof.
+const MaxRangeSize = 1 << 14
+
 type Balloon struct {
-	version uint64
-	hasherF func() hashing.Hasher
-	store   storage.Store
+	version    uint64
+	hasherF    func() hashing.Hasher
+	store      storage.Store
+	mode       Mode
+	duplicates DuplicatePolicy
 
 	historyTree *history.HistoryTree
 	hyperTree   *hyper.HyperTree
+	ctTree      *ct.Tree
 	hasher      hashing.Hasher
 }
 
 func NewBalloon(store storage.Store, hasherF func() hashing.Hasher) (*Balloon, error) {
+	return newBalloon(store, hasherF, "")
+}
+
+// NewBalloonFromCheckpoint returns a balloon whose hyper cache is loaded
+// from the checkpoint saved to the given file, instead of rebuilt from
+// the store. If the checkpoint cannot be used, the cache is rebuilt.
+func NewBalloonFromCheckpoint(store storage.Store, hasherF func() hashing.Hasher, checkpointPath string) (*Balloon, error) {
+	return newBalloon(store, hasherF, checkpointPath)
+}
+
+func newBalloon(store storage.Store, hasherF func() hashing.Hasher, checkpointPath string) (*Balloon, error) {
 
 	// create caches
 	hyperCache := cache.NewFastCache(hyper.CacheSize)
 
-	// create trees
-	historyTree := history.NewHistoryTree(hasherF, store, 300)
-	hyperTree := hyper.NewHyperTree(hasherF, store, hyperCache)
-
 	balloon := &Balloon{
-		version:     0,
-		hasherF:     hasherF,
-		store:       store,
-		historyTree: historyTree,
-		hyperTree:   hyperTree,
-		hasher:      hasherF(),
+		version: 0,
+		hasherF: hasherF,
+		store:   store,
+		hasher:  hasherF(),
 	}
 
-	// update version
-	balloon.RefreshVersion()
+	// update version, as the checkpoint is replayed up to it
+	if err := balloon.RefreshVersion(); err != nil {
+		return nil, err
+	}
+
+	// create trees
+	balloon.historyTree = history.NewHistoryTree(hasherF, store, 300)
+	balloon.ctTree = ct.NewTree(store)
+	if checkpointPath != "" {
+		balloon.hyperTree = hyper.NewHyperTreeFromCheckpoint(hasherF, store, hyperCache, checkpointPath, balloon.Version())
+	} else {
+		balloon.hyperTree = hyper.NewHyperTree(hasherF, store, hyperCache)
+	}
 
 	return balloon, nil
 }
 
+// Checkpoint copies the hyper cache, so it can be saved to be loaded when
+// the balloon is reopened instead of rebuilt. No addition must be
+// committed until it returns.
+func (b *Balloon) Checkpoint() *hyper.Checkpoint {
+	return b.hyperTree.Checkpoint(b.Version())
+}
+
+// PruneHyperJournal deletes the leaves the hyper tree keeps to replay the
+// versions added after a checkpoint, up to the version of the given one,
+// which must have been saved.
+func (b *Balloon) PruneHyperJournal(checkpoint *hyper.Checkpoint) error {
+	return b.hyperTree.PruneJournal(checkpoint.Version)
+}
+
 // Snapshot is the struct that has both history and hyper digest and the
-// current version for that rootNode digests.
+// current version for that rootNode digests. The timestamp is the time the
+// version was committed, in nanoseconds since the Unix epoch, or zero if
+// it is unknown. The namespace is the log the version belongs to, empty
+// for the default one.
 type Snapshot struct {
 	EventDigest   hashing.Digest
 	HistoryDigest hashing.Digest
 	HyperDigest   hashing.Digest
 	Version       uint64
+	Timestamp     int64
+	Namespace     string
 }
 
 // MembershipProof is the struct that is required to make a Exisitance Proof.
 // It has both Hyper and History AuditPaths, if it exists in first place and
-// Current, Actual and Query Versions.
+// Current, Actual and Query Versions. Versions has every version the event
+// was added at when it has more than one, as they are the value of its
+// hyper leaf.
 type MembershipProof struct {
 	Exists         bool
 	HyperProof     visitor.Verifiable
@@ -89,6 +139,7 @@ type MembershipProof struct {
 	ActualVersion  uint64 //required for consistency proof
 	KeyDigest      hashing.Digest
 	Hasher         hashing.Hasher
+	Versions       []uint64
 }
 
 func NewMembershipProof(
@@ -99,22 +150,24 @@ func NewMembershipProof(
 	Hasher hashing.Hasher) *MembershipProof {
 
 	return &MembershipProof{
-		exists,
-		hyperProof,
-		historyProof,
-		currentVersion,
-		queryVersion,
-		actualVersion,
-		keyDigest,
-		Hasher,
+		Exists:         exists,
+		HyperProof:     hyperProof,
+		HistoryProof:   historyProof,
+		CurrentVersion: currentVersion,
+		QueryVersion:   queryVersion,
+		ActualVersion:  actualVersion,
+		KeyDigest:      keyDigest,
+		Hasher:         Hasher,
 	}
 }
 
 // DigestVerify verifies a proof and answer from QueryMembership. Returns true if the
 // answer and proof are correct and consistent, otherwise false.
+// When the event does not exist, only the hyper proof is checked against the
+// snapshot, as it proves the absence of the digest in the hyper tree.
 // Run by a client on input that should be verified.
 func (p MembershipProof) DigestVerify(digest hashing.Digest, snapshot *Snapshot) bool {
-	if p.HyperProof == nil || p.HistoryProof == nil {
+	if p.HyperProof == nil || (p.Exists && p.HistoryProof == nil) {
 		return false
 	}
 
@@ -161,8 +214,69 @@ func (p IncrementalProof) Verify(snapshotStart, snapshotEnd *Snapshot) bool {
 	return ip.Verify(snapshotStart.HistoryDigest, snapshotEnd.HistoryDigest)
 }
 
-func (b Balloon) Version() uint64 {
-	return b.version
+// RangeProof proves that the event digests are exactly the ones added from
+// the start to the end version, both included.
+type RangeProof struct {
+	Start, End   uint64
+	Version      uint64
+	EventDigests []hashing.Digest
+	AuditPath    visitor.AuditPath
+	Hasher       hashing.Hasher
+}
+
+func NewRangeProof(
+	start, end, version uint64,
+	eventDigests []hashing.Digest,
+	auditPath visitor.AuditPath,
+	hasher hashing.Hasher,
+) *RangeProof {
+	return &RangeProof{
+		start,
+		end,
+		version,
+		eventDigests,
+		auditPath,
+		hasher,
+	}
+}
+
+// Verify verifies a proof and answer from QueryRange against the snapshot
+// of the version of the proof.
+func (p RangeProof) Verify(snapshot *Snapshot) bool {
+	if snapshot.Version != p.Version {
+		return false
+	}
+	rp := history.NewRangeProof(p.Start, p.End, p.Version, p.EventDigests, p.AuditPath, p.Hasher)
+	return rp.Verify(snapshot.HistoryDigest)
+}
+
+// Version returns the version the next addition will get. Queries must
+// read it only once to pin the version they work with, as it could be
+// updated meanwhile.
+func (b *Balloon) Version() uint64 {
+	return atomic.LoadUint64(&b.version)
+}
+
+// Digests returns the history and hyper digests of the last version, which
+// are nil if the balloon is empty. Additions must not be committed
+// meanwhile, as both digests must belong to the same version.
+func (b *Balloon) Digests() (historyDigest, hyperDigest hashing.Digest, err error) {
+	version := b.Version()
+	if version == 0 {
+		return nil, nil, nil
+	}
+	kv, err := b.store.Get(storage.HistoryLeafPrefix, util.Uint64AsBytes(version-1))
+	if err == storage.ErrKeyNotFound {
+		return nil, nil, ErrRangeNotStored
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	historyDigest, err = b.historyTree.RootHash(kv.Value, version-1)
+	if err != nil {
+		return nil, nil, err
+	}
+	return historyDigest, b.hyperTree.RootHash(), nil
 }
 
 func (b *Balloon) RefreshVersion() error {
@@ -173,23 +287,79 @@ func (b *Balloon) RefreshVersion() error {
 			return err
 		}
 	} else {
-		b.version = util.BytesAsUint64(kv.Key[:8]) + 1
+		atomic.StoreUint64(&b.version, util.BytesAsUint64(kv.Key[:8])+1)
 	}
 	return nil
 }
 
+// previousLeaf returns the hyper leaf of an event already added, or nil if
+// it has not been added or the policy does not need it.
+func (b *Balloon) previousLeaf(eventDigest hashing.Digest) (*storage.KVPair, error) {
+	if b.duplicates == OverwriteDuplicates {
+		return nil, nil
+	}
+	leaf, err := b.store.Get(storage.IndexPrefix, eventDigest)
+	if err == storage.ErrKeyNotFound {
+		return nil, nil
+	}
+	return leaf, err
+}
+
+// Add adds an event to the balloon. If it has already been added, the
+// duplicate policy decides whether it is added again, rejected or the
+// original snapshot is returned without mutations. The version is visible
+// to the queries before the mutations are stored.
 func (b *Balloon) Add(event []byte) (*Snapshot, []*storage.Mutation, error) {
+	addition, err := b.PrepareAdd(event)
+	if err != nil {
+		return nil, nil, err
+	}
+	addition.Commit()
+	return addition.Snapshots[0], addition.Mutations, nil
+}
+
+// PrepareAdd computes the snapshot and mutations of adding an event as Add
+// does, but its version is only visible to the queries once the addition
+// is committed.
+func (b *Balloon) PrepareAdd(event []byte) (*Addition, error) {
+
+	if err := b.checkMode(EventMode); err != nil {
+		return nil, err
+	}
 
 	// Activate metrics gathering
 	stats := metrics.Balloon
 
-	// Get version
-	version := b.version
-	b.version++
-
 	// Hash event
 	eventDigest := b.hasher.Do(event)
 
+	// Apply the duplicate policy before taking a version
+	leaf, err := b.previousLeaf(eventDigest)
+	if err != nil {
+		return nil, err
+	}
+	if leaf != nil {
+		switch b.duplicates {
+		case RejectDuplicates:
+			return nil, ErrDuplicateEvent
+		case IdempotentDuplicates:
+			snapshot, err := b.addedSnapshot(eventDigest, leaf.Value)
+			if err != nil {
+				return nil, err
+			}
+			return b.newAddition([]*Snapshot{snapshot}, nil, nil, 0), nil
+		}
+	}
+
+	// Get version
+	version := b.Version()
+
+	// Recorded duplicates keep the previous versions in the hyper leaf
+	value := util.Uint64AsBytes(version)
+	if leaf != nil {
+		value = append(append([]byte{}, leaf.Value...), value...)
+	}
+
 	// Update trees
 	var historyDigest hashing.Digest
 	var historyMutations []*storage.Mutation
@@ -202,19 +372,23 @@ func (b *Balloon) Add(event []byte) (*Snapshot, []*storage.Mutation, error) {
 		wg.Done()
 	}()
 
-	hyperDigest, mutations, hyperErr := b.hyperTree.Add(eventDigest, version)
+	hyperDigests, mutations, pending, hyperErr := b.hyperTree.Prepare([]hashing.Digest{eventDigest}, version, [][]byte{value})
 
 	wg.Wait()
 
 	if historyErr != nil {
-		return nil, nil, historyErr
+		return nil, historyErr
 	}
 	if hyperErr != nil {
-		return nil, nil, hyperErr
+		return nil, hyperErr
 	}
+	hyperDigest := hyperDigests[0]
 
 	// Append trees mutations
 	mutations = append(mutations, historyMutations...)
+	if b.duplicates == IdempotentDuplicates {
+		mutations = append(mutations, newHyperDigestMutation(version, hyperDigest))
+	}
 
 	snapshot := &Snapshot{
 		EventDigest:   eventDigest,
@@ -227,10 +401,144 @@ func (b *Balloon) Add(event []byte) (*Snapshot, []*storage.Mutation, error) {
 	stats.AddFloat("add_hits", 1)
 	stats.Set("version", metrics.Uint64ToVar(version))
 
-	return snapshot, mutations, nil
+	return b.newAddition([]*Snapshot{snapshot}, mutations, pending, 1), nil
+}
+
+// AddBulk adds a list of events to the balloon. Every event gets its own
+// version and snapshot but all the mutations are returned together so they
+// can be applied to the store in a single operation. The duplicate policy
+// also applies to the events repeated in the bulk: if any event is
+// rejected, none is added, and the events returned idempotently get no
+// version. The versions are visible to the queries before the mutations
+// are stored.
+func (b *Balloon) AddBulk(events [][]byte) ([]*Snapshot, []*storage.Mutation, error) {
+	addition, err := b.PrepareAddBulk(events)
+	if err != nil {
+		return nil, nil, err
+	}
+	addition.Commit()
+	return addition.Snapshots, addition.Mutations, nil
+}
+
+// PrepareAddBulk computes the snapshots and mutations of adding a list of
+// events as AddBulk does, but their versions are only visible to the
+// queries once the addition is committed.
+func (b *Balloon) PrepareAddBulk(events [][]byte) (*Addition, error) {
+
+	if err := b.checkMode(EventMode); err != nil {
+		return nil, err
+	}
+
+	// Activate metrics gathering
+	stats := metrics.Balloon
+
+	// Get initial version, which is only taken once the duplicates are known
+	initialVersion := b.Version()
+
+	// Hash events and apply the duplicate policy
+	snapshots := make([]*Snapshot, len(events))
+	eventDigests := make([]hashing.Digest, 0, len(events))
+	values := make([][]byte, 0, len(events))
+	positions := make([]int, 0, len(events))
+	repeated := make(map[int]int)
+	inBulk := make(map[string]int)
+	for i, event := range events {
+		eventDigest := b.hasher.Do(event)
+		value := util.Uint64AsBytes(initialVersion + uint64(len(eventDigests)))
+
+		// the previous value is the one of the bulk, as it is not stored yet
+		var previous []byte
+		if j, ok := inBulk[string(eventDigest)]; ok {
+			previous = values[j]
+		} else {
+			leaf, err := b.previousLeaf(eventDigest)
+			if err != nil {
+				return nil, err
+			}
+			if leaf != nil {
+				previous = leaf.Value
+			}
+		}
+
+		if previous != nil {
+			switch b.duplicates {
+			case RejectDuplicates:
+				return nil, ErrDuplicateEvent
+			case IdempotentDuplicates:
+				if j, ok := inBulk[string(eventDigest)]; ok {
+					repeated[i] = positions[j]
+					continue
+				}
+				snapshot, err := b.addedSnapshot(eventDigest, previous)
+				if err != nil {
+					return nil, err
+				}
+				snapshots[i] = snapshot
+				continue
+			case RecordDuplicates:
+				value = append(append([]byte{}, previous...), value...)
+			}
+		}
+
+		inBulk[string(eventDigest)] = len(eventDigests)
+		eventDigests = append(eventDigests, eventDigest)
+		values = append(values, value)
+		positions = append(positions, i)
+	}
+
+	if len(eventDigests) == 0 {
+		return b.newAddition(snapshots, nil, nil, 0), nil
+	}
+
+	// Update trees
+	var historyDigests []hashing.Digest
+	var historyMutations []*storage.Mutation
+	var historyErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		historyDigests, historyMutations, historyErr = b.historyTree.AddBulk(eventDigests, initialVersion)
+		wg.Done()
+	}()
+
+	hyperDigests, mutations, pending, hyperErr := b.hyperTree.Prepare(eventDigests, initialVersion, values)
+
+	wg.Wait()
+
+	if historyErr != nil {
+		return nil, historyErr
+	}
+	if hyperErr != nil {
+		return nil, hyperErr
+	}
+
+	// Append trees mutations
+	mutations = append(mutations, historyMutations...)
+
+	for j, i := range positions {
+		snapshots[i] = &Snapshot{
+			EventDigest:   eventDigests[j],
+			HistoryDigest: historyDigests[j],
+			HyperDigest:   hyperDigests[j],
+			Version:       initialVersion + uint64(j),
+		}
+		if b.duplicates == IdempotentDuplicates {
+			mutations = append(mutations, newHyperDigestMutation(initialVersion+uint64(j), hyperDigests[j]))
+		}
+	}
+	for i, j := range repeated {
+		snapshots[i] = snapshots[j]
+	}
+
+	// Increment add hits and version
+	stats.AddFloat("add_hits", float64(len(eventDigests)))
+	stats.Set("version", metrics.Uint64ToVar(initialVersion+uint64(len(eventDigests))-1))
+
+	return b.newAddition(snapshots, mutations, pending, len(eventDigests)), nil
 }
 
-func (b Balloon) QueryDigestMembership(keyDigest hashing.Digest, version uint64) (*MembershipProof, error) {
+func (b *Balloon) QueryDigestMembership(keyDigest hashing.Digest, version uint64) (*MembershipProof, error) {
 	stats := metrics.Balloon
 	stats.AddFloat("QueryMembership", 1)
 	var proof MembershipProof
@@ -239,29 +547,44 @@ func (b Balloon) QueryDigestMembership(keyDigest hashing.Digest, version uint64)
 	var hyperProof *hyper.QueryProof
 	var historyProof *history.MembershipProof
 
+	if err := b.checkMode(EventMode); err != nil {
+		return nil, err
+	}
 	proof.Hasher = b.hasherF()
+	if len(keyDigest)*8 != int(proof.Hasher.Len()) {
+		return nil, ErrInvalidDigest
+	}
 	proof.KeyDigest = keyDigest
 	proof.QueryVersion = version
-	proof.CurrentVersion = b.version - 1
+	proof.CurrentVersion = b.Version() - 1
 
 	leaf, err := b.store.Get(storage.IndexPrefix, proof.KeyDigest)
 	if err != nil {
-		return nil, fmt.Errorf("No leaf with digest %v", proof.KeyDigest)
+		if err != storage.ErrKeyNotFound {
+			return nil, err
+		}
+		// prove that the digest is not in the hyper tree
+		hyperProof, hyperErr = b.hyperTree.QueryMembership(proof.KeyDigest, nil)
+		if hyperErr != nil {
+			return nil, fmt.Errorf("Unable to get proof from hyper tree: %v", hyperErr)
+		}
+		proof.HyperProof = hyperProof
+		proof.Exists = false
+		return &proof, nil
 	}
 
 	proof.Exists = true
-	proof.ActualVersion = util.BytesAsUint64(leaf.Value)
-
-	if proof.ActualVersion <= proof.QueryVersion {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			historyProof, historyErr = b.historyTree.ProveMembership(proof.ActualVersion, proof.QueryVersion)
-		}()
-	} else {
-		return nil, fmt.Errorf("query version %d is not on history tree which version is %d", proof.QueryVersion, proof.ActualVersion)
+	proof.ActualVersion, proof.Versions, err = provenVersion(leaf.Value, proof.QueryVersion)
+	if err != nil {
+		return nil, err
 	}
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		historyProof, historyErr = b.historyTree.ProveMembership(proof.ActualVersion, proof.QueryVersion)
+	}()
+
 	hyperProof, hyperErr = b.hyperTree.QueryMembership(leaf.Key, leaf.Value)
 
 	wg.Wait()
@@ -279,12 +602,12 @@ func (b Balloon) QueryDigestMembership(keyDigest hashing.Digest, version uint64)
 	return &proof, nil
 }
 
-func (b Balloon) QueryMembership(event []byte, version uint64) (*MembershipProof, error) {
+func (b *Balloon) QueryMembership(event []byte, version uint64) (*MembershipProof, error) {
 	hasher := b.hasherF()
 	return b.QueryDigestMembership(hasher.Do(event), version)
 }
 
-func (b Balloon) QueryConsistency(start, end uint64) (*IncrementalProof, error) {
+func (b *Balloon) QueryConsistency(start, end uint64) (*IncrementalProof, error) {
 	stats := metrics.Balloon
 	stats.AddFloat("QueryConsistency", 1)
 	var proof IncrementalProof
@@ -302,10 +625,36 @@ func (b Balloon) QueryConsistency(start, end uint64) (*IncrementalProof, error)
 	return &proof, nil
 }
 
+// QueryRange returns the event digests added from the start to the end
+// version, both included, along with a proof of them at the given version.
+func (b *Balloon) QueryRange(start, end, version uint64) (*RangeProof, error) {
+	stats := metrics.Balloon
+	stats.AddFloat("QueryRange", 1)
+
+	current := b.Version()
+	if current == 0 || start > end || end > version || version > current-1 {
+		return nil, ErrInvalidRange
+	}
+	if end-start >= MaxRangeSize {
+		return nil, ErrRangeTooLarge
+	}
+
+	proof, err := b.historyTree.ProveRange(start, end, version)
+	if err == history.ErrLeavesNotFound {
+		return nil, ErrRangeNotStored
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get proof from history tree: %v", err)
+	}
+
+	return NewRangeProof(start, end, version, proof.EventDigests, proof.AuditPath(), b.hasherF()), nil
+}
+
 func (b *Balloon) Close() {
 	b.historyTree.Close()
 	b.hyperTree.Close()
 	b.historyTree = nil
 	b.hyperTree = nil
-	b.version = 0
+	b.ctTree = nil
+	atomic.StoreUint64(&b.version, 0)
 }
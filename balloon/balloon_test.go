@@ -23,6 +23,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/bbva/qed/balloon/history"
+	"github.com/bbva/qed/balloon/hyper"
 	"github.com/bbva/qed/balloon/visitor"
 	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
@@ -55,6 +57,78 @@ func TestAdd(t *testing.T) {
 
 }
 
+func TestAddBulk(t *testing.T) {
+
+	log.SetLogger("TestAddBulk", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	bulkStore, bulkCloseF := storage_utils.OpenBPlusTreeStore()
+	defer bulkCloseF()
+
+	balloon, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	bulkBalloon, err := NewBalloon(bulkStore, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+
+	for b := 0; b < 3; b++ {
+		events := make([][]byte, 50)
+		for i := range events {
+			events[i] = rand.Bytes(128)
+		}
+
+		snapshots, mutations, err := bulkBalloon.AddBulk(events)
+		require.NoError(t, err)
+		require.NoError(t, bulkStore.Mutate(mutations))
+		require.Len(t, snapshots, len(events))
+
+		// every snapshot must match the one obtained adding events one by one
+		for i, event := range events {
+			expected, mutations, err := balloon.Add(event)
+			require.NoError(t, err)
+			require.NoError(t, store.Mutate(mutations))
+			assert.Equalf(t, expected, snapshots[i], "Wrong snapshot for event %d in bulk %d", i, b)
+		}
+	}
+
+	assert.Equal(t, balloon.Version(), bulkBalloon.Version(), "Versions should match")
+
+}
+
+func TestPrepareAddAndCommit(t *testing.T) {
+
+	log.SetLogger("TestPrepareAddAndCommit", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+
+	_, mutations, err := b.Add([]byte("first event"))
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+	hyperDigest := b.hyperTree.RootHash()
+
+	event := []byte("second event")
+	addition, err := b.PrepareAdd(event)
+	require.NoError(t, err)
+	snapshot := addition.Snapshots[0]
+	require.NoError(t, store.Mutate(addition.Mutations))
+
+	// the addition is stored but not published yet
+	assert.Equal(t, uint64(1), b.Version(), "The version should not change before the commit")
+	assert.Equal(t, hyperDigest, b.hyperTree.RootHash(), "The hyper cache should not change before the commit")
+
+	addition.Commit()
+
+	assert.Equal(t, uint64(2), b.Version(), "The version should change after the commit")
+	assert.Equal(t, snapshot.HyperDigest, b.hyperTree.RootHash(), "The hyper cache should change after the commit")
+	proof, err := b.QueryMembership(event, snapshot.Version)
+	require.NoError(t, err)
+	assert.True(t, proof.Verify(event, snapshot), "The proof should verify correctly")
+}
+
 func TestQueryMembership(t *testing.T) {
 
 	log.SetLogger("TestQueryMembership", log.SILENT)
@@ -127,6 +201,8 @@ func TestMembershipProofVerify(t *testing.T) {
 			hashing.Digest("Some hyperDigest"),
 			hashing.Digest("Some historyDigest"),
 			c.actualVersion,
+			0,
+			"",
 		}
 		proof := NewMembershipProof(
 			c.exists,
@@ -204,6 +280,62 @@ func TestAddQueryAndVerify(t *testing.T) {
 	assert.True(t, proof.Verify(event, snapshot), "The proof should verify correctly")
 }
 
+func TestQueryNonMembershipAndVerify(t *testing.T) {
+	log.SetLogger("TestQueryNonMembershipAndVerify", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	// start balloon
+	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+
+	// Add events
+	var snapshot *Snapshot
+	for i := 0; i < 10; i++ {
+		var mutations []*storage.Mutation
+		snapshot, mutations, err = b.Add(rand.Bytes(32))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	// Query a non inserted event
+	event := hashing.Digest("Never inserted")
+	proof, err := b.QueryMembership(event, snapshot.Version)
+	require.NoError(t, err)
+	assert.False(t, proof.Exists, "The event should not exist")
+	assert.Nil(t, proof.HistoryProof, "There should not be a history proof")
+
+	// Verify
+	assert.True(t, proof.Verify(event, snapshot), "The non-membership proof should verify correctly")
+	assert.False(t, proof.Verify([]byte("Another event"), snapshot), "The proof should not verify another event")
+}
+
+func TestQueryDigestMembershipWithInvalidDigest(t *testing.T) {
+	log.SetLogger("TestQueryDigestMembershipWithInvalidDigest", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+
+	// enough events to reach the cached levels of the hyper tree
+	events := make([][]byte, 3000)
+	for i := range events {
+		events[i] = rand.Bytes(32)
+	}
+	snapshots, mutations, err := b.AddBulk(events)
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+	version := snapshots[len(snapshots)-1].Version
+
+	for _, digest := range []hashing.Digest{{}, {0x1}, make(hashing.Digest, 33)} {
+		_, err = b.QueryDigestMembership(digest, version)
+		assert.Equalf(t, ErrInvalidDigest, err, "A digest of %d bytes should be rejected", len(digest))
+	}
+}
+
 func TestCacheWarmingUp(t *testing.T) {
 
 	log.SetLogger("TestCacheWarmingUp", log.SILENT)
@@ -302,9 +434,12 @@ func TestDeleteAndVerify(t *testing.T) {
 	tampered, _ := store.Get(storage.IndexPrefix, eventDigest)
 	assert.Nil(t, tampered)
 
+	// the balloon returns a non-membership proof that must not verify
+	// because the hyper tree still holds the deleted leaf
 	proof, err := b.QueryMembership(event, snapshot.Version)
-	assert.Nil(t, proof)
-	assert.Error(t, err, "ballon should not return a proof")
+	assert.NoError(t, err)
+	assert.False(t, proof.Exists, "The event should not exist after deleting it")
+	assert.False(t, proof.Verify(event, snapshot), "The non-membership proof should not verify")
 }
 
 func TestGenIncrementalAndVerify(t *testing.T) {
@@ -334,6 +469,127 @@ func TestGenIncrementalAndVerify(t *testing.T) {
 	assert.True(t, correct, "Unable to verify incremental proof")
 }
 
+func TestDomainSeparationAndVerify(t *testing.T) {
+	log.SetLogger("TestDomainSeparationAndVerify", log.SILENT)
+
+	plainStore, closePlainF := storage_utils.OpenBPlusTreeStore()
+	defer closePlainF()
+	domainStore, closeDomainF := storage_utils.OpenBPlusTreeStore()
+	defer closeDomainF()
+
+	plain, err := NewBalloon(plainStore, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	domainHasherF := hashing.NewDomainHasherF(hashing.NewSha256Hasher)
+	domain, err := NewBalloon(domainStore, domainHasherF)
+	require.NoError(t, err)
+
+	size := 10
+	s := make([]*Snapshot, size)
+	for i := 0; i < size; i++ {
+		event := []byte(fmt.Sprintf("Never knows %d best", i))
+		plainSnapshot, mutations, err := plain.Add(event)
+		require.NoError(t, err)
+		require.NoError(t, plainStore.Mutate(mutations))
+		snapshot, mutations, err := domain.Add(event)
+		require.NoError(t, err)
+		require.NoError(t, domainStore.Mutate(mutations))
+		s[i] = snapshot
+
+		assert.Equal(t, plainSnapshot.EventDigest, snapshot.EventDigest, "The event digests should not change")
+		assert.NotEqual(t, plainSnapshot.HistoryDigest, snapshot.HistoryDigest, "The history digests should change")
+		assert.NotEqual(t, plainSnapshot.HyperDigest, snapshot.HyperDigest, "The hyper digests should change")
+	}
+
+	event := []byte("Never knows 3 best")
+	proof, err := domain.QueryMembership(event, 3)
+	require.NoError(t, err)
+	current := *s[3]
+	current.HyperDigest = s[size-1].HyperDigest
+	assert.True(t, proof.Verify(event, &current), "The proof should verify with domain separation")
+
+	// the same proof read with the hasher without domain separation
+	plainHasher := hashing.NewSha256Hasher()
+	proof.Hasher = plainHasher
+	hyperProof := proof.HyperProof.(*hyper.QueryProof)
+	historyProof := proof.HistoryProof.(*history.MembershipProof)
+	proof.HyperProof = hyper.NewQueryProof(hyperProof.Key, hyperProof.Value, hyperProof.AuditPath(), plainHasher)
+	proof.HistoryProof = history.NewMembershipProof(historyProof.Index, historyProof.Version, historyProof.AuditPath(), plainHasher)
+	assert.False(t, proof.Verify(event, &current), "The proof should not verify without domain separation")
+
+	incremental, err := domain.QueryConsistency(1, 7)
+	require.NoError(t, err)
+	assert.True(t, incremental.Verify(s[1], s[7]), "The incremental proof should verify with domain separation")
+}
+
+func TestQueryRangeAndVerify(t *testing.T) {
+	log.SetLogger("TestQueryRangeAndVerify", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+
+	size := 20
+	s := make([]*Snapshot, size)
+	for i := 0; i < size; i++ {
+		snapshot, mutations, err := b.Add([]byte(fmt.Sprintf("event %d", i)))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		s[i] = snapshot
+	}
+
+	proof, err := b.QueryRange(3, 11, 15)
+	require.NoError(t, err)
+	require.Len(t, proof.EventDigests, 9, "Wrong number of event digests")
+	for i, eventDigest := range proof.EventDigests {
+		assert.Equal(t, s[3+i].EventDigest, eventDigest, "Wrong event digest at version %d", 3+i)
+	}
+	assert.True(t, proof.Verify(s[15]), "The range proof should verify correctly")
+	assert.False(t, proof.Verify(s[16]), "The range proof should not verify with another snapshot")
+
+	tampered := *proof
+	tampered.EventDigests = append([]hashing.Digest{}, proof.EventDigests[1:]...)
+	tampered.End = 10
+	assert.False(t, tampered.Verify(s[15]), "A missing event should not verify")
+
+	_, err = b.QueryRange(5, 3, 15)
+	assert.Equal(t, ErrInvalidRange, err, "A reversed range should fail")
+
+	_, err = b.QueryRange(5, 25, 25)
+	assert.Equal(t, ErrInvalidRange, err, "A range beyond the last version should fail")
+}
+
+func TestQueryRangeWithoutStoredLeaves(t *testing.T) {
+	log.SetLogger("TestQueryRangeWithoutStoredLeaves", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+
+	// the first versions are stored as before the history leaves were kept
+	for i := 0; i < 10; i++ {
+		_, mutations, err := b.Add([]byte(fmt.Sprintf("event %d", i)))
+		require.NoError(t, err)
+		var kept []*storage.Mutation
+		for _, m := range mutations {
+			if i >= 5 || m.Prefix != storage.HistoryLeafPrefix {
+				kept = append(kept, m)
+			}
+		}
+		require.NoError(t, store.Mutate(kept))
+	}
+
+	_, err = b.QueryRange(3, 7, 9)
+	assert.Equal(t, ErrRangeNotStored, err, "A range with versions not stored should fail")
+
+	proof, err := b.QueryRange(5, 7, 9)
+	require.NoError(t, err)
+	assert.Len(t, proof.EventDigests, 3, "A range with stored versions should be proven")
+}
+
 func BenchmarkAddBadger(b *testing.B) {
 
 	log.SetLogger("BenchmarkAddBadger", log.SILENT)
@@ -0,0 +1,197 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package balloon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bbva/qed/balloon/history"
+	"github.com/bbva/qed/balloon/hyper"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/metrics"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
+)
+
+var (
+	ErrEmptyBatch        = errors.New("the batch has no key digests")
+	ErrDuplicatedInBatch = errors.New("the batch has duplicated key digests")
+	ErrBatchTooLarge     = errors.New("the batch exceeds the maximum number of key digests")
+)
+
+// MaxBatchSize is the maximum number of key digests of a batch proof.
+const MaxBatchSize = 1 << 12
+
+// BatchMembershipProof proves the membership or non-membership of several
+// key digests at once. The hyper proof covers every key digest, and the
+// history proof covers the ones that exist, so the nodes shared by their
+// audit paths are only included once. Versions is only set if any key
+// digest was added at more than one version, and has all of them for those.
+type BatchMembershipProof struct {
+	KeyDigests     []hashing.Digest
+	Exists         []bool
+	ActualVersions []uint64
+	Versions       [][]uint64
+	HyperProof     *hyper.BatchQueryProof
+	HistoryProof   *history.BatchMembershipProof
+	CurrentVersion uint64
+	QueryVersion   uint64
+	Hasher         hashing.Hasher
+}
+
+// Verify verifies a proof and answer from QueryBatchMembership. As the hyper
+// tree only keeps its current state, the hyper digest of the snapshot must be
+// the one of the current version of the proof, while the history digest must
+// be the one of the query version. Returns true if the answer for every key
+// digest is correct and consistent, otherwise false.
+// Run by a client on input that should be verified.
+func (p BatchMembershipProof) Verify(snapshot *Snapshot) bool {
+	n := len(p.KeyDigests)
+	if n == 0 || len(p.Exists) != n || len(p.ActualVersions) != n || p.HyperProof == nil {
+		return false
+	}
+	if len(p.HyperProof.Keys) != n || len(p.HyperProof.Values) != n {
+		return false
+	}
+	if p.Versions != nil && len(p.Versions) != n {
+		return false
+	}
+
+	var indexes []uint64
+	var eventDigests []hashing.Digest
+	for i, digest := range p.KeyDigests {
+		var expectedValue []byte
+		if p.Exists[i] {
+			if p.ActualVersions[i] > p.QueryVersion {
+				return false
+			}
+			expectedValue = util.Uint64AsBytes(p.ActualVersions[i])
+			if p.Versions != nil && len(p.Versions[i]) > 0 {
+				if !hasVersion(p.Versions[i], p.ActualVersions[i]) {
+					return false
+				}
+				expectedValue = LeafValue(p.Versions[i])
+			}
+			indexes = append(indexes, p.ActualVersions[i])
+			eventDigests = append(eventDigests, digest)
+		}
+		if !bytes.Equal(p.HyperProof.Keys[i], digest) || !bytes.Equal(p.HyperProof.Values[i], expectedValue) {
+			return false
+		}
+	}
+
+	if !p.HyperProof.Verify(snapshot.HyperDigest) {
+		return false
+	}
+
+	if len(indexes) == 0 {
+		return true
+	}
+	if p.HistoryProof == nil || p.HistoryProof.Version != p.QueryVersion {
+		return false
+	}
+	return p.HistoryProof.Verify(eventDigests, snapshot.HistoryDigest)
+}
+
+// QueryBatchMembership returns a single proof for several key digests at
+// the given version. Every existing key digest must have been added before
+// or at that version, and the batch can have up to MaxBatchSize of them.
+func (b *Balloon) QueryBatchMembership(keyDigests []hashing.Digest, version uint64) (*BatchMembershipProof, error) {
+	stats := metrics.Balloon
+	stats.AddFloat("QueryBatchMembership", 1)
+
+	if err := b.checkMode(EventMode); err != nil {
+		return nil, err
+	}
+	if len(keyDigests) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	if len(keyDigests) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	proof := &BatchMembershipProof{
+		KeyDigests:     keyDigests,
+		Exists:         make([]bool, len(keyDigests)),
+		ActualVersions: make([]uint64, len(keyDigests)),
+		CurrentVersion: b.Version() - 1,
+		QueryVersion:   version,
+		Hasher:         b.hasherF(),
+	}
+
+	seen := make(map[string]bool, len(keyDigests))
+	values := make([][]byte, len(keyDigests))
+	var indexes []uint64
+	for i, keyDigest := range keyDigests {
+		if len(keyDigest)*8 != int(proof.Hasher.Len()) {
+			return nil, ErrInvalidDigest
+		}
+		if seen[string(keyDigest)] {
+			return nil, ErrDuplicatedInBatch
+		}
+		seen[string(keyDigest)] = true
+
+		leaf, err := b.store.Get(storage.IndexPrefix, keyDigest)
+		if err != nil {
+			if err != storage.ErrKeyNotFound {
+				return nil, err
+			}
+			continue
+		}
+		actual, versions, err := provenVersion(leaf.Value, version)
+		if err != nil {
+			return nil, err
+		}
+		if versions != nil {
+			if proof.Versions == nil {
+				proof.Versions = make([][]uint64, len(keyDigests))
+			}
+			proof.Versions[i] = versions
+		}
+		proof.Exists[i] = true
+		proof.ActualVersions[i] = actual
+		values[i] = leaf.Value
+		indexes = append(indexes, proof.ActualVersions[i])
+	}
+
+	var wg sync.WaitGroup
+	var historyErr error
+	if len(indexes) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			proof.HistoryProof, historyErr = b.historyTree.ProveBatchMembership(indexes, version)
+		}()
+	}
+
+	hyperProof, hyperErr := b.hyperTree.QueryBatchMembership(keyDigests, values)
+
+	wg.Wait()
+	if hyperErr != nil {
+		return nil, fmt.Errorf("Unable to get proof from hyper tree: %v", hyperErr)
+	}
+
+	if historyErr != nil {
+		return nil, fmt.Errorf("Unable to get proof from history tree: %v", historyErr)
+	}
+
+	proof.HyperProof = hyperProof
+	return proof, nil
+}
@@ -0,0 +1,131 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package balloon
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+)
+
+func TestQueryBatchMembershipAndVerify(t *testing.T) {
+	log.SetLogger("TestQueryBatchMembershipAndVerify", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	hasher := hashing.NewSha256Hasher()
+
+	var snapshot *Snapshot
+	for i := 0; i < 50; i++ {
+		var mutations []*storage.Mutation
+		snapshot, mutations, err = b.Add([]byte(fmt.Sprintf("event %d", i)))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	keyDigests := []hashing.Digest{
+		hasher.Do([]byte("event 7")),
+		hasher.Do([]byte("a non inserted event")),
+		hasher.Do([]byte("event 0")),
+		hasher.Do([]byte("event 49")),
+		hasher.Do([]byte("event 23")),
+	}
+
+	proof, err := b.QueryBatchMembership(keyDigests, snapshot.Version)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, false, true, true, true}, proof.Exists, "Wrong memberships")
+	assert.Equal(t, []uint64{7, 0, 0, 49, 23}, proof.ActualVersions, "Wrong actual versions")
+	assert.True(t, proof.Verify(snapshot), "The batch proof should verify correctly")
+
+	// the batch proof is smaller than the single ones together
+	size := len(proof.HyperProof.AuditPath()) + len(proof.HistoryProof.AuditPath())
+	singleSize := 0
+	for _, keyDigest := range keyDigests {
+		single, err := b.QueryDigestMembership(keyDigest, snapshot.Version)
+		require.NoError(t, err)
+		singleSize += len(single.HyperProof.AuditPath())
+		if single.Exists {
+			singleSize += len(single.HistoryProof.AuditPath())
+		}
+	}
+	assert.True(t, size < singleSize, "The shared nodes should not be repeated")
+
+	tampered := *proof
+	tampered.Exists = []bool{true, true, true, true, true}
+	assert.False(t, tampered.Verify(snapshot), "An absent key should not be proven as member")
+
+	tampered = *proof
+	tampered.ActualVersions = []uint64{8, 0, 0, 49, 23}
+	assert.False(t, tampered.Verify(snapshot), "A wrong version should not verify")
+
+	tampered = *proof
+	tampered.KeyDigests = append([]hashing.Digest{hasher.Do([]byte("event 8"))}, keyDigests[1:]...)
+	assert.False(t, tampered.Verify(snapshot), "Another key should not verify")
+
+	_, err = b.QueryBatchMembership(nil, snapshot.Version)
+	assert.Equal(t, ErrEmptyBatch, err, "An empty batch should fail")
+
+	_, err = b.QueryBatchMembership([]hashing.Digest{keyDigests[0], keyDigests[0]}, snapshot.Version)
+	assert.Equal(t, ErrDuplicatedInBatch, err, "A batch with duplicated digests should fail")
+
+	_, err = b.QueryBatchMembership(keyDigests, 10)
+	assert.Error(t, err, "A batch with events after the query version should fail")
+}
+
+func TestQueryBatchMembershipWithInvalidBatch(t *testing.T) {
+	log.SetLogger("TestQueryBatchMembershipWithInvalidBatch", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	hasher := hashing.NewSha256Hasher()
+
+	// enough events to reach the cached levels of the hyper tree
+	events := make([][]byte, 3000)
+	for i := range events {
+		events[i] = []byte(fmt.Sprintf("event %d", i))
+	}
+	snapshots, mutations, err := b.AddBulk(events)
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+	version := snapshots[len(snapshots)-1].Version
+
+	_, err = b.QueryBatchMembership([]hashing.Digest{hasher.Do([]byte("event 0")), {0x1}}, version)
+	assert.Equal(t, ErrInvalidDigest, err, "A batch with a short digest should fail")
+
+	_, err = b.QueryBatchMembership([]hashing.Digest{make(hashing.Digest, 33)}, version)
+	assert.Equal(t, ErrInvalidDigest, err, "A batch with a long digest should fail")
+
+	keyDigests := make([]hashing.Digest, MaxBatchSize+1)
+	for i := range keyDigests {
+		keyDigests[i] = hasher.Do([]byte(fmt.Sprintf("digest %d", i)))
+	}
+	_, err = b.QueryBatchMembership(keyDigests, version)
+	assert.Equal(t, ErrBatchTooLarge, err, "A batch larger than the maximum should fail")
+}
@@ -0,0 +1,74 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package balloon
+
+import (
+	"github.com/bbva/qed/balloon/history"
+	"github.com/bbva/qed/balloon/hyper"
+	"github.com/bbva/qed/balloon/navigator"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
+)
+
+// CheckReport is the result of checking the integrity of a stored balloon.
+// The digests are the ones recomputed for the last version, so they can be
+// compared with its snapshot.
+type CheckReport struct {
+	// Version is the one the next addition would get, so there is no
+	// stored version if it is zero.
+	Version           uint64
+	HistoryDigest     hashing.Digest
+	HyperDigest       hashing.Digest
+	HistoryMismatches []navigator.Position
+	HyperMismatches   []navigator.Position
+}
+
+// Ok returns whether every stored node matches the recomputed one.
+func (r CheckReport) Ok() bool {
+	return len(r.HistoryMismatches) == 0 && len(r.HyperMismatches) == 0
+}
+
+// Check recomputes the trees of a stored balloon from their leaves, with
+// no balloon opened on it, and reports the positions of the stored nodes
+// that do not match.
+func Check(store storage.Store, hasherF func() hashing.Hasher) (*CheckReport, error) {
+	report := new(CheckReport)
+
+	kv, err := store.GetLast(storage.HistoryCachePrefix)
+	if err != nil && err != storage.ErrKeyNotFound {
+		return nil, err
+	}
+	if err == nil {
+		report.Version = util.BytesAsUint64(kv.Key[:8]) + 1
+		report.HistoryDigest, err = history.Check(hasherF(), store, report.Version-1, func(pos navigator.Position) {
+			report.HistoryMismatches = append(report.HistoryMismatches, pos)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report.HyperDigest, err = hyper.Check(hasherF(), store, func(pos navigator.Position) {
+		report.HyperMismatches = append(report.HyperMismatches, pos)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
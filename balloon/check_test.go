@@ -0,0 +1,95 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package balloon
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/balloon/history"
+	"github.com/bbva/qed/balloon/hyper"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+)
+
+func TestCheck(t *testing.T) {
+	log.SetLogger("TestCheck", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	report, err := Check(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	assert.True(t, report.Ok(), "An empty store should be consistent")
+	assert.Equal(t, uint64(0), report.Version, "An empty store should have no versions")
+
+	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+
+	historyDigest, hyperDigest, err := b.Digests()
+	require.NoError(t, err)
+	assert.Nil(t, historyDigest, "An empty balloon should have no history digest")
+	assert.Nil(t, hyperDigest, "An empty balloon should have no hyper digest")
+
+	var snapshot *Snapshot
+	for i := 0; i < 37; i++ {
+		var mutations []*storage.Mutation
+		snapshot, mutations, err = b.Add([]byte(fmt.Sprintf("event %d", i)))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	report, err = Check(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	assert.True(t, report.Ok(), "The stored balloon should be consistent")
+	assert.Equal(t, uint64(37), report.Version, "Wrong version")
+	assert.Equal(t, snapshot.HistoryDigest, report.HistoryDigest, "The history digest should match the last snapshot")
+	assert.Equal(t, snapshot.HyperDigest, report.HyperDigest, "The hyper digest should match the last snapshot")
+
+	historyDigest, hyperDigest, err = b.Digests()
+	require.NoError(t, err)
+	assert.Equal(t, snapshot.HistoryDigest, historyDigest, "The history digest should match the last snapshot")
+	assert.Equal(t, snapshot.HyperDigest, hyperDigest, "The hyper digest should match the last snapshot")
+
+	// tamper with a frozen history node and with the hyper nodes
+	historyPos := history.NewPosition(8, 3)
+	kv, err := store.GetLast(storage.HyperCachePrefix)
+	require.NoError(t, err)
+	hyperPos := hyper.NewPosition(kv.Key[:32], 231)
+	orphanPos := hyper.NewPosition(make([]byte, 32), 231)
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.HistoryCachePrefix, historyPos.Bytes(), []byte{0x0}),
+		storage.NewMutation(storage.HyperCachePrefix, hyperPos.Bytes(), []byte{0x0}),
+		storage.NewMutation(storage.HyperCachePrefix, orphanPos.Bytes(), []byte{0x0}),
+	}))
+
+	report, err = Check(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	assert.False(t, report.Ok(), "The tampered balloon should not be consistent")
+	assert.Equal(t, snapshot.HistoryDigest, report.HistoryDigest, "The history digest should be recomputed from the leaves")
+	assert.Equal(t, snapshot.HyperDigest, report.HyperDigest, "The hyper digest should be recomputed from the leaves")
+	require.Len(t, report.HistoryMismatches, 1, "Wrong number of history mismatches")
+	assert.Equal(t, historyPos.StringId(), report.HistoryMismatches[0].StringId(), "Wrong history mismatch")
+	require.Len(t, report.HyperMismatches, 2, "Wrong number of hyper mismatches")
+	assert.Equal(t, hyperPos.StringId(), report.HyperMismatches[0].StringId(), "Wrong hyper mismatch")
+	assert.Equal(t, orphanPos.StringId(), report.HyperMismatches[1].StringId(), "Wrong hyper mismatch")
+}
@@ -0,0 +1,158 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package balloon
+
+import (
+	"errors"
+
+	"github.com/bbva/qed/balloon/ct"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/metrics"
+	"github.com/bbva/qed/storage"
+)
+
+var (
+	ErrCTNotAvailable = errors.New("the log has versions added before its Certificate Transparency tree was kept")
+)
+
+// TreeHead is the head of the Certificate Transparency tree of the balloon
+// when it had the given number of versions. The timestamp is the time the
+// last one was committed, in nanoseconds since the Unix epoch.
+type TreeHead struct {
+	Size      uint64
+	Timestamp int64
+	RootHash  hashing.Digest
+	Namespace string
+}
+
+// CTMutations returns the mutations that add the versions of the
+// snapshots, which must have been added but not committed, to the
+// Certificate Transparency tree of the balloon, at the timestamps of the
+// snapshots. The tree of a balloon with versions added before it was kept
+// is not updated, as its leaves must be consecutive.
+func (b *Balloon) CTMutations(snapshots []*Snapshot) ([]*storage.Mutation, error) {
+	if len(snapshots) == 0 {
+		return nil, nil
+	}
+	size, err := b.ctTree.Size()
+	if err != nil {
+		return nil, err
+	}
+	if size != snapshots[0].Version {
+		return nil, nil
+	}
+	leafInputs := make([][]byte, len(snapshots))
+	for i, s := range snapshots {
+		leafInputs[i] = ct.LeafInput(s.EventDigest, s.Timestamp)
+	}
+	return b.ctTree.Add(leafInputs, size)
+}
+
+// ctSize returns the size of the Certificate Transparency tree, which is
+// the number of versions of the balloon.
+func (b *Balloon) ctSize() (uint64, error) {
+	version := b.Version()
+	size, err := b.ctTree.Size()
+	if err != nil {
+		return 0, err
+	}
+	if size < version {
+		return 0, ErrCTNotAvailable
+	}
+	return version, nil
+}
+
+// QueryCTTreeHead returns the head of the Certificate Transparency tree
+// of the last version.
+func (b *Balloon) QueryCTTreeHead() (*TreeHead, error) {
+	stats := metrics.Balloon
+	stats.AddFloat("QueryCTTreeHead", 1)
+
+	size, err := b.ctSize()
+	if err != nil {
+		return nil, err
+	}
+	rootHash, err := b.ctTree.RootHash(size)
+	if err != nil {
+		return nil, err
+	}
+	var timestamp int64
+	if size > 0 {
+		if timestamp, err = b.Timestamp(size - 1); err != nil {
+			return nil, err
+		}
+	}
+	return &TreeHead{Size: size, Timestamp: timestamp, RootHash: rootHash}, nil
+}
+
+// QueryCTConsistency returns the proof of consistency between the
+// Certificate Transparency trees of the given sizes.
+func (b *Balloon) QueryCTConsistency(first, second uint64) ([]hashing.Digest, error) {
+	stats := metrics.Balloon
+	stats.AddFloat("QueryCTConsistency", 1)
+
+	size, err := b.ctSize()
+	if err != nil {
+		return nil, err
+	}
+	if second > size {
+		return nil, ct.ErrInvalidSize
+	}
+	return b.ctTree.ProveConsistency(first, second)
+}
+
+// QueryCTMembership returns the index of the leaf with the given hash in
+// the Certificate Transparency tree of the given size, along with its
+// audit path. It returns ct.ErrLeafNotFound if the leaf was not added
+// before that size.
+func (b *Balloon) QueryCTMembership(leafHash hashing.Digest, size uint64) (uint64, []hashing.Digest, error) {
+	stats := metrics.Balloon
+	stats.AddFloat("QueryCTMembership", 1)
+
+	current, err := b.ctSize()
+	if err != nil {
+		return 0, nil, err
+	}
+	if size == 0 || size > current {
+		return 0, nil, ct.ErrInvalidSize
+	}
+	return b.ctTree.ProveMembership(leafHash, size)
+}
+
+// QueryCTEntries returns the inputs of the leaves of the Certificate
+// Transparency tree from the start to the end version, both included. As
+// RFC 6962 allows, fewer entries are returned when the end is beyond the
+// last version or the range is larger than MaxRangeSize.
+func (b *Balloon) QueryCTEntries(start, end uint64) ([][]byte, error) {
+	stats := metrics.Balloon
+	stats.AddFloat("QueryCTEntries", 1)
+
+	size, err := b.ctSize()
+	if err != nil {
+		return nil, err
+	}
+	if start > end || start >= size {
+		return nil, ErrInvalidRange
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if end-start >= MaxRangeSize {
+		end = start + MaxRangeSize - 1
+	}
+	return b.ctTree.Entries(start, end)
+}
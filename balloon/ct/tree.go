@@ -0,0 +1,307 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package ct keeps the Merkle tree of RFC 6962 over the versions of a
+// balloon, so the tooling built for Certificate Transparency logs can watch
+// it. Unlike the history tree, its nodes are not salted with their
+// positions: the leaves are the SHA-256 digests of 0x00 followed by their
+// MerkleTreeLeaf, and the interior nodes the ones of 0x01 followed by their
+// children.
+package ct
+
+import (
+	"errors"
+	"math/bits"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
+)
+
+var (
+	ErrInvalidSize  = errors.New("invalid tree size")
+	ErrLeafNotFound = errors.New("the leaf is not in the tree")
+)
+
+// The values of the MerkleTreeLeaf of the versions: a v1 timestamped entry
+// of an X.509 certificate, which is the event digest of the version.
+const (
+	v1               = byte(0)
+	timestampedEntry = byte(0)
+	x509Entry        = uint16(0)
+)
+
+func newHasher() hashing.TreeHasher {
+	return hashing.NewDomainHasherF(hashing.NewSha256Hasher)().(hashing.TreeHasher)
+}
+
+// LeafInput returns the MerkleTreeLeaf of a version, the leaf_input of the
+// entries of the log, given its event digest and the time it was committed
+// in nanoseconds since the Unix epoch. The certificate of the entry is the
+// event digest and its timestamp is in milliseconds, as RFC 6962 requires.
+func LeafInput(eventDigest hashing.Digest, timestamp int64) []byte {
+	input := make([]byte, 0, 15+len(eventDigest)+2)
+	input = append(input, v1, timestampedEntry)
+	input = append(input, util.Uint64AsBytes(uint64(timestamp/1e6))...)
+	input = append(input, util.Uint16AsBytes(x509Entry)...)
+	n := len(eventDigest)
+	input = append(input, byte(n>>16), byte(n>>8), byte(n))
+	input = append(input, eventDigest...)
+	// no extensions
+	return append(input, 0, 0)
+}
+
+// LeafHash returns the hash of a leaf of the tree given its input.
+func LeafHash(leafInput []byte) hashing.Digest {
+	return newHasher().Leaf(leafInput)
+}
+
+// Tree is the Merkle tree of RFC 6962. Only the nodes of its perfect
+// subtrees are stored, as they never change once their last leaf is added,
+// and the rest are recomputed from them when needed. The inputs of the
+// leaves are stored too, and the indexes of their hashes.
+type Tree struct {
+	store  storage.Store
+	hasher hashing.TreeHasher
+}
+
+func NewTree(store storage.Store) *Tree {
+	return &Tree{store: store, hasher: newHasher()}
+}
+
+func nodeKey(level uint8, index uint64) []byte {
+	return append([]byte{level}, util.Uint64AsBytes(index)...)
+}
+
+// split returns the largest power of two smaller than n, which must be
+// greater than one, as RFC 6962 splits the trees of n leaves.
+func split(n uint64) uint64 {
+	return 1 << uint(bits.Len64(n-1)-1)
+}
+
+// Size returns the number of leaves of the tree.
+func (t *Tree) Size() (uint64, error) {
+	kv, err := t.store.GetLast(storage.CTLeafPrefix)
+	if err == storage.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return util.BytesAsUint64(kv.Key) + 1, nil
+}
+
+// Add appends leaves to the tree, the first one at the given index, which
+// must be the size of the tree. It returns the mutations that store them
+// along with the nodes of the perfect subtrees they complete. A leaf hash
+// already in the tree keeps the index of its first leaf.
+func (t *Tree) Add(leafInputs [][]byte, index uint64) ([]*storage.Mutation, error) {
+	pending := make(map[string]hashing.Digest)
+	indexed := make(map[string]bool)
+	mutations := make([]*storage.Mutation, 0, 4*len(leafInputs))
+	for i, input := range leafInputs {
+		leaf := index + uint64(i)
+		hash := t.hasher.Leaf(input)
+
+		mutations = append(mutations, storage.NewMutation(storage.CTLeafPrefix, util.Uint64AsBytes(leaf), input))
+		if !indexed[string(hash)] {
+			_, err := t.store.Get(storage.CTIndexPrefix, hash)
+			if err == storage.ErrKeyNotFound {
+				mutations = append(mutations, storage.NewMutation(storage.CTIndexPrefix, hash, util.Uint64AsBytes(leaf)))
+			} else if err != nil {
+				return nil, err
+			}
+			indexed[string(hash)] = true
+		}
+
+		// the leaf is the last one of the perfect subtrees of the levels
+		// where it is a right child
+		level, pos := uint8(0), leaf
+		for {
+			key := nodeKey(level, pos)
+			pending[string(key)] = hash
+			mutations = append(mutations, storage.NewMutation(storage.CTNodePrefix, key, hash))
+			if pos%2 == 0 {
+				break
+			}
+			left, err := t.node(level, pos-1, pending)
+			if err != nil {
+				return nil, err
+			}
+			hash = t.hasher.Node(left, hash)
+			level, pos = level+1, pos/2
+		}
+	}
+	return mutations, nil
+}
+
+// node returns the root hash of the perfect subtree of the given level and
+// index, looking first in the nodes not stored yet.
+func (t *Tree) node(level uint8, index uint64, pending map[string]hashing.Digest) (hashing.Digest, error) {
+	key := nodeKey(level, index)
+	if hash, ok := pending[string(key)]; ok {
+		return hash, nil
+	}
+	kv, err := t.store.Get(storage.CTNodePrefix, key)
+	if err != nil {
+		return nil, err
+	}
+	return kv.Value, nil
+}
+
+// hash returns MTH(D[start:end]) of RFC 6962. The subtrees of the proofs
+// start at a multiple of the largest power of two smaller than their size,
+// so their perfect subtrees are always stored nodes.
+func (t *Tree) hash(start, end uint64) (hashing.Digest, error) {
+	n := end - start
+	if n&(n-1) == 0 {
+		level := uint8(bits.TrailingZeros64(n))
+		return t.node(level, start>>level, nil)
+	}
+	k := split(n)
+	left, err := t.hash(start, start+k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := t.hash(start+k, end)
+	if err != nil {
+		return nil, err
+	}
+	return t.hasher.Node(left, right), nil
+}
+
+// RootHash returns the root hash of the tree of the given size, which must
+// not be greater than its size. The one of an empty tree is the digest of
+// an empty string.
+func (t *Tree) RootHash(size uint64) (hashing.Digest, error) {
+	if size == 0 {
+		return hashing.NewSha256Hasher().Do(), nil
+	}
+	return t.hash(0, size)
+}
+
+// ProveMembership returns the index of the leaf with the given hash and
+// its audit path in the tree of the given size, which must not be greater
+// than its size. It returns ErrLeafNotFound if the leaf was not added
+// before that size.
+func (t *Tree) ProveMembership(leafHash hashing.Digest, size uint64) (uint64, []hashing.Digest, error) {
+	kv, err := t.store.Get(storage.CTIndexPrefix, leafHash)
+	if err == storage.ErrKeyNotFound {
+		return 0, nil, ErrLeafNotFound
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	index := util.BytesAsUint64(kv.Value)
+	if index >= size {
+		return 0, nil, ErrLeafNotFound
+	}
+	path, err := t.path(index, 0, size)
+	if err != nil {
+		return 0, nil, err
+	}
+	return index, path, nil
+}
+
+// path returns PATH(m, D[start:end]) of RFC 6962, from the leaf to the top.
+func (t *Tree) path(m, start, end uint64) ([]hashing.Digest, error) {
+	n := end - start
+	if n == 1 {
+		return []hashing.Digest{}, nil
+	}
+	k := split(n)
+	var path []hashing.Digest
+	var sibling hashing.Digest
+	var err error
+	if m < start+k {
+		path, err = t.path(m, start, start+k)
+		if err == nil {
+			sibling, err = t.hash(start+k, end)
+		}
+	} else {
+		path, err = t.path(m, start+k, end)
+		if err == nil {
+			sibling, err = t.hash(start, start+k)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(path, sibling), nil
+}
+
+// ProveConsistency returns the proof of consistency between the trees of
+// the given sizes, which is empty when they are the same. The first size
+// must not be zero nor greater than the second one, and the second one
+// must not be greater than the size of the tree.
+func (t *Tree) ProveConsistency(first, second uint64) ([]hashing.Digest, error) {
+	if first == 0 || first > second {
+		return nil, ErrInvalidSize
+	}
+	return t.subproof(first, 0, second, true)
+}
+
+// subproof returns SUBPROOF(m, D[start:end], complete) of RFC 6962, where
+// m is relative to the start.
+func (t *Tree) subproof(m, start, end uint64, complete bool) ([]hashing.Digest, error) {
+	n := end - start
+	if m == n {
+		if complete {
+			return []hashing.Digest{}, nil
+		}
+		hash, err := t.hash(start, end)
+		if err != nil {
+			return nil, err
+		}
+		return []hashing.Digest{hash}, nil
+	}
+	k := split(n)
+	var proof []hashing.Digest
+	var sibling hashing.Digest
+	var err error
+	if m <= k {
+		proof, err = t.subproof(m, start, start+k, complete)
+		if err == nil {
+			sibling, err = t.hash(start+k, end)
+		}
+	} else {
+		proof, err = t.subproof(m-k, start+k, end, false)
+		if err == nil {
+			sibling, err = t.hash(start, start+k)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(proof, sibling), nil
+}
+
+// Entries returns the inputs of the leaves from the start to the end index,
+// both included.
+func (t *Tree) Entries(start, end uint64) ([][]byte, error) {
+	kvRange, err := t.store.GetRange(storage.CTLeafPrefix, util.Uint64AsBytes(start), util.Uint64AsBytes(end))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(kvRange)) != end-start+1 {
+		return nil, ErrLeafNotFound
+	}
+	inputs := make([][]byte, len(kvRange))
+	for i, kv := range kvRange {
+		inputs[i] = kv.Value
+	}
+	return inputs, nil
+}
@@ -0,0 +1,233 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package ct
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/bbva/qed/hashing"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the leaves and root hashes of the test vectors of the Certificate
+// Transparency reference implementation
+var (
+	testLeaves = []string{"", "00", "10", "2021", "3031", "40414243", "5051525354555657", "606162636465666768696a6b6c6d6e6f"}
+	testRoots  = []string{
+		"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+		"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+		"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+		"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+		"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+	}
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+// mth computes the root hash of the leaves as RFC 6962 defines it.
+func mth(leaves [][]byte) hashing.Digest {
+	h := newHasher()
+	switch len(leaves) {
+	case 0:
+		return hashing.NewSha256Hasher().Do()
+	case 1:
+		return h.Leaf(leaves[0])
+	}
+	k := split(uint64(len(leaves)))
+	return h.Node(mth(leaves[:k]), mth(leaves[k:]))
+}
+
+// verifyMembership checks an audit path as RFC 9162 does.
+func verifyMembership(index, size uint64, leafHash hashing.Digest, path []hashing.Digest, root hashing.Digest) bool {
+	if index >= size {
+		return false
+	}
+	h := newHasher()
+	fn, sn, r := index, size-1, leafHash
+	for _, p := range path {
+		if sn == 0 {
+			return false
+		}
+		if fn%2 == 1 || fn == sn {
+			r = h.Node(p, r)
+			for fn%2 == 0 && fn != 0 {
+				fn, sn = fn>>1, sn>>1
+			}
+		} else {
+			r = h.Node(r, p)
+		}
+		fn, sn = fn>>1, sn>>1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
+
+// verifyConsistency checks a consistency proof as RFC 9162 does.
+func verifyConsistency(first, second uint64, firstRoot, secondRoot hashing.Digest, proof []hashing.Digest) bool {
+	if first == second {
+		return len(proof) == 0 && bytes.Equal(firstRoot, secondRoot)
+	}
+	if first&(first-1) == 0 {
+		proof = append([]hashing.Digest{firstRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return false
+	}
+	h := newHasher()
+	fn, sn := first-1, second-1
+	for fn%2 == 1 {
+		fn, sn = fn>>1, sn>>1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn%2 == 1 || fn == sn {
+			fr, sr = h.Node(c, fr), h.Node(c, sr)
+			for fn%2 == 0 && fn != 0 {
+				fn, sn = fn>>1, sn>>1
+			}
+		} else {
+			sr = h.Node(sr, c)
+		}
+		fn, sn = fn>>1, sn>>1
+	}
+	return sn == 0 && bytes.Equal(fr, firstRoot) && bytes.Equal(sr, secondRoot)
+}
+
+func TestRootHash(t *testing.T) {
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	tree := NewTree(store)
+
+	root, err := tree.RootHash(0)
+	require.NoError(t, err)
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", hex.EncodeToString(root), "The root hash of an empty tree is the digest of an empty string")
+
+	for i, leaf := range testLeaves {
+		mutations, err := tree.Add([][]byte{decodeHex(t, leaf)}, uint64(i))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+
+		size, err := tree.Size()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i+1), size)
+		for s := uint64(1); s <= size; s++ {
+			root, err := tree.RootHash(s)
+			require.NoError(t, err)
+			assert.Equal(t, testRoots[s-1], hex.EncodeToString(root), "Wrong root hash of size %d after adding leaf %d", s, i)
+		}
+	}
+}
+
+func TestProofs(t *testing.T) {
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	tree := NewTree(store)
+
+	// bulks of several sizes, so the nodes are computed from pending ones
+	leaves := make([][]byte, 0)
+	for i, n := range []int{1, 2, 5, 1, 13, 3, 8} {
+		bulk := make([][]byte, n)
+		for j := range bulk {
+			bulk[j] = LeafInput([]byte{byte(i), byte(j)}, int64(len(leaves)+j)*1e6)
+		}
+		mutations, err := tree.Add(bulk, uint64(len(leaves)))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		leaves = append(leaves, bulk...)
+	}
+
+	size := uint64(len(leaves))
+	for s := uint64(1); s <= size; s++ {
+		root, err := tree.RootHash(s)
+		require.NoError(t, err)
+		require.Equal(t, mth(leaves[:s]), root, "Wrong root hash of size %d", s)
+
+		for m := uint64(0); m < size; m++ {
+			index, path, err := tree.ProveMembership(LeafHash(leaves[m]), s)
+			if m >= s {
+				assert.Equal(t, ErrLeafNotFound, err, "The leaf %d must not be in the tree of size %d", m, s)
+				continue
+			}
+			require.NoError(t, err)
+			assert.Equal(t, m, index)
+			assert.True(t, verifyMembership(index, s, LeafHash(leaves[m]), path, root), "The audit path of leaf %d in the tree of size %d must verify", m, s)
+		}
+
+		for f := uint64(1); f <= s; f++ {
+			proof, err := tree.ProveConsistency(f, s)
+			require.NoError(t, err)
+			assert.True(t, verifyConsistency(f, s, mth(leaves[:f]), root, proof), "The consistency proof from %d to %d must verify", f, s)
+		}
+	}
+
+	_, err := tree.ProveConsistency(0, 1)
+	assert.Equal(t, ErrInvalidSize, err)
+	_, _, err = tree.ProveMembership(LeafHash([]byte("missing")), size)
+	assert.Equal(t, ErrLeafNotFound, err)
+
+	entries, err := tree.Entries(3, 9)
+	require.NoError(t, err)
+	assert.Equal(t, leaves[3:10], entries)
+	_, err = tree.Entries(size-1, size)
+	assert.Equal(t, ErrLeafNotFound, err)
+}
+
+func TestDuplicateLeaves(t *testing.T) {
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	tree := NewTree(store)
+
+	leaf := LeafInput([]byte{0x01}, 0)
+	mutations, err := tree.Add([][]byte{leaf, leaf}, 0)
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+	mutations, err = tree.Add([][]byte{leaf}, 2)
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+
+	index, path, err := tree.ProveMembership(LeafHash(leaf), 3)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), index, "A repeated leaf must be proven at its first index")
+	root, err := tree.RootHash(3)
+	require.NoError(t, err)
+	assert.True(t, verifyMembership(index, 3, LeafHash(leaf), path, root))
+}
+
+func TestLeafInput(t *testing.T) {
+	input := LeafInput([]byte{0xaa, 0xbb}, 1540000000123456789)
+	assert.Equal(t, []byte{
+		0x00,                                           // v1
+		0x00,                                           // timestamped_entry
+		0x00, 0x00, 0x01, 0x66, 0x8f, 0x27, 0x28, 0x7b, // 1540000000123 milliseconds
+		0x00, 0x00, // x509_entry
+		0x00, 0x00, 0x02, 0xaa, 0xbb, // the event digest
+		0x00, 0x00, // no extensions
+	}, input)
+}
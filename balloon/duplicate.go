@@ -0,0 +1,174 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package balloon
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
+)
+
+var (
+	ErrDuplicateEvent = errors.New("the event has already been added")
+)
+
+// DuplicatePolicy decides what happens when an event that has already
+// been added is added again.
+type DuplicatePolicy uint8
+
+const (
+	// OverwriteDuplicates adds the event again, and the hyper tree points
+	// to the new version only, so the membership of the previous one can
+	// not be proven anymore.
+	OverwriteDuplicates DuplicatePolicy = iota
+
+	// RejectDuplicates fails the addition with ErrDuplicateEvent.
+	RejectDuplicates
+
+	// IdempotentDuplicates returns the snapshot of the original version
+	// without adding the event again.
+	IdempotentDuplicates
+
+	// RecordDuplicates adds the event again, and the hyper tree keeps
+	// every version it was added at, so the membership of any of them can
+	// be proven.
+	RecordDuplicates
+)
+
+var duplicatePolicyNames = []string{"overwrite", "reject", "idempotent", "record"}
+
+func (p DuplicatePolicy) String() string {
+	if int(p) < len(duplicatePolicyNames) {
+		return duplicatePolicyNames[p]
+	}
+	return fmt.Sprintf("DuplicatePolicy(%d)", uint8(p))
+}
+
+// ParseDuplicatePolicy returns the policy with the given name.
+func ParseDuplicatePolicy(name string) (DuplicatePolicy, error) {
+	for i, n := range duplicatePolicyNames {
+		if n == name {
+			return DuplicatePolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown duplicate policy %q", name)
+}
+
+// DuplicatePolicies returns the names of the supported policies.
+func DuplicatePolicies() []string {
+	return append([]string(nil), duplicatePolicyNames...)
+}
+
+// DuplicatePolicy returns the policy applied to the events already added.
+func (b *Balloon) DuplicatePolicy() DuplicatePolicy {
+	return b.duplicates
+}
+
+// SetDuplicatePolicy changes the policy applied to the events already
+// added. Every replica must use the same one, as it changes the trees.
+func (b *Balloon) SetDuplicatePolicy(p DuplicatePolicy) {
+	b.duplicates = p
+}
+
+// LeafValue returns the value of the hyper leaf of an event added at the
+// given versions, which is the concatenation of them in ascending order.
+func LeafValue(versions []uint64) []byte {
+	value := make([]byte, 0, 8*len(versions))
+	for _, version := range versions {
+		value = append(value, util.Uint64AsBytes(version)...)
+	}
+	return value
+}
+
+// leafVersions reverses LeafValue.
+func leafVersions(value []byte) []uint64 {
+	versions := make([]uint64, len(value)/8)
+	for i := range versions {
+		versions[i] = util.BytesAsUint64(value[8*i : 8*i+8])
+	}
+	return versions
+}
+
+func hasVersion(versions []uint64, version uint64) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// provenVersion returns the last version of the leaf added before or at the
+// query version, and every version of the leaf if it has more than one, as
+// they are needed to verify the hyper proof.
+func provenVersion(value []byte, queryVersion uint64) (uint64, []uint64, error) {
+	versions := leafVersions(value)
+	if len(versions) == 0 {
+		return 0, nil, fmt.Errorf("invalid hyper leaf value %x", value)
+	}
+	if versions[0] > queryVersion {
+		return 0, nil, fmt.Errorf("query version %d is not on history tree which version is %d", queryVersion, versions[0])
+	}
+	actual := versions[0]
+	for _, version := range versions[1:] {
+		if version > queryVersion {
+			break
+		}
+		actual = version
+	}
+	if len(versions) == 1 {
+		versions = nil
+	}
+	return actual, versions, nil
+}
+
+// newHyperDigestMutation returns the mutation that keeps the hyper digest
+// of a version, as the hyper tree only keeps its current state. It is only
+// kept by the idempotent policy, to return the snapshot of an event added
+// again as it was first published.
+func newHyperDigestMutation(version uint64, hyperDigest hashing.Digest) *storage.Mutation {
+	return storage.NewMutation(storage.HyperDigestPrefix, util.Uint64AsBytes(version), hyperDigest)
+}
+
+// addedSnapshot returns the snapshot of the version an event was first
+// added at, for the idempotent policy, with the digests and timestamp it
+// was published with.
+func (b *Balloon) addedSnapshot(eventDigest hashing.Digest, value []byte) (*Snapshot, error) {
+	version := util.BytesAsUint64(value)
+	historyDigest, err := b.historyTree.RootHash(eventDigest, version)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get digest from history tree: %v", err)
+	}
+	hyperDigest, err := b.store.Get(storage.HyperDigestPrefix, util.Uint64AsBytes(version))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get hyper digest of version %d: %v", version, err)
+	}
+	timestamp, err := b.Timestamp(version)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		EventDigest:   eventDigest,
+		HistoryDigest: historyDigest,
+		HyperDigest:   hyperDigest.Value,
+		Version:       version,
+		Timestamp:     timestamp,
+	}, nil
+}
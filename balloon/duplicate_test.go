@@ -0,0 +1,171 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package balloon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+)
+
+func TestParseDuplicatePolicy(t *testing.T) {
+	for _, name := range DuplicatePolicies() {
+		policy, err := ParseDuplicatePolicy(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, policy.String(), "The policy should keep its name")
+	}
+	_, err := ParseDuplicatePolicy("ignore")
+	assert.Error(t, err, "Unknown policies should be rejected")
+}
+
+func TestAddRejectedDuplicates(t *testing.T) {
+	log.SetLogger("TestAddRejectedDuplicates", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	b.SetDuplicatePolicy(RejectDuplicates)
+
+	_, mutations, err := b.Add([]byte("event 0"))
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+
+	_, _, err = b.Add([]byte("event 0"))
+	assert.Equal(t, ErrDuplicateEvent, err, "An added event should be rejected")
+
+	_, _, err = b.AddBulk([][]byte{[]byte("event 1"), []byte("event 0")})
+	assert.Equal(t, ErrDuplicateEvent, err, "A bulk with an added event should be rejected")
+
+	_, _, err = b.AddBulk([][]byte{[]byte("event 1"), []byte("event 1")})
+	assert.Equal(t, ErrDuplicateEvent, err, "A bulk with a repeated event should be rejected")
+
+	assert.Equal(t, uint64(1), b.Version(), "Rejected events should not take a version")
+}
+
+func TestAddIdempotentDuplicates(t *testing.T) {
+	log.SetLogger("TestAddIdempotentDuplicates", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	b.SetDuplicatePolicy(IdempotentDuplicates)
+
+	original, mutations, err := b.Add([]byte("event 0"))
+	require.NoError(t, err)
+	mutations = append(mutations, NewTimestampMutation(original.Version, 100))
+	require.NoError(t, store.Mutate(mutations))
+
+	last, mutations, err := b.Add([]byte("event 1"))
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+
+	snapshot, mutations, err := b.Add([]byte("event 0"))
+	require.NoError(t, err)
+	assert.Empty(t, mutations, "A duplicate should not change the trees")
+	assert.Equal(t, original.Version, snapshot.Version, "Wrong version of the duplicate")
+	assert.Equal(t, original.EventDigest, snapshot.EventDigest, "Wrong event digest of the duplicate")
+	assert.Equal(t, original.HistoryDigest, snapshot.HistoryDigest, "Wrong history digest of the duplicate")
+	assert.Equal(t, original.HyperDigest, snapshot.HyperDigest, "The hyper digest should be the published one")
+	assert.Equal(t, int64(100), snapshot.Timestamp, "Wrong timestamp of the duplicate")
+	assert.Equal(t, uint64(2), b.Version(), "A duplicate should not take a version")
+
+	// the hyper tree only keeps its current state
+	proof, err := b.QueryMembership([]byte("event 0"), snapshot.Version)
+	require.NoError(t, err)
+	current := *snapshot
+	current.HyperDigest = last.HyperDigest
+	assert.True(t, proof.Verify([]byte("event 0"), &current), "The duplicate should verify with the current hyper digest")
+
+	snapshots, mutations, err := b.AddBulk([][]byte{[]byte("event 2"), []byte("event 0"), []byte("event 2")})
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+	assert.Equal(t, uint64(2), snapshots[0].Version, "Wrong version of the new event")
+	assert.Equal(t, original.Version, snapshots[1].Version, "Wrong version of the added event")
+	assert.Equal(t, original.HyperDigest, snapshots[1].HyperDigest, "Wrong hyper digest of the added event")
+	assert.Equal(t, snapshots[0], snapshots[2], "A repeated event should get the snapshot of the first one")
+	assert.Equal(t, uint64(3), b.Version(), "Only the new events should take a version")
+}
+
+func TestAddRecordedDuplicates(t *testing.T) {
+	log.SetLogger("TestAddRecordedDuplicates", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	b.SetDuplicatePolicy(RecordDuplicates)
+	hasher := hashing.NewSha256Hasher()
+
+	var snapshots []*Snapshot
+	for _, event := range []string{"event 0", "event 1", "event 0"} {
+		snapshot, mutations, err := b.Add([]byte(event))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		snapshots = append(snapshots, snapshot)
+	}
+	bulk, mutations, err := b.AddBulk([][]byte{[]byte("event 0"), []byte("event 2"), []byte("event 0")})
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+	snapshots = append(snapshots, bulk...)
+	current := snapshots[len(snapshots)-1]
+
+	testCases := []struct {
+		queryVersion, actualVersion uint64
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 2},
+		{3, 3},
+		{4, 3},
+		{5, 5},
+	}
+
+	for i, c := range testCases {
+		proof, err := b.QueryMembership([]byte("event 0"), c.queryVersion)
+		require.NoError(t, err)
+		assert.Equalf(t, c.actualVersion, proof.ActualVersion, "Wrong actual version in test case %d", i)
+		assert.Equalf(t, []uint64{0, 2, 3, 5}, proof.Versions, "Wrong versions in test case %d", i)
+
+		// the hyper tree only keeps its current state
+		snapshot := &Snapshot{
+			HistoryDigest: snapshots[c.queryVersion].HistoryDigest,
+			HyperDigest:   current.HyperDigest,
+			Version:       c.queryVersion,
+		}
+		assert.Truef(t, proof.Verify([]byte("event 0"), snapshot), "The proof should verify in test case %d", i)
+	}
+
+	proof, err := b.QueryBatchMembership([]hashing.Digest{hasher.Do([]byte("event 1")), hasher.Do([]byte("event 0"))}, current.Version)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 5}, proof.ActualVersions, "Wrong actual versions")
+	assert.Equal(t, [][]uint64{nil, {0, 2, 3, 5}}, proof.Versions, "Wrong versions")
+	assert.True(t, proof.Verify(current), "The batch proof should verify")
+
+	tampered := *proof
+	tampered.Versions = [][]uint64{nil, {0, 2, 3}}
+	assert.False(t, tampered.Verify(current), "A version should not be hidden")
+}
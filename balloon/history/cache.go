@@ -17,6 +17,8 @@
 package history
 
 import (
+	"sort"
+
 	"github.com/bbva/qed/balloon/navigator"
 )
 
@@ -73,3 +75,49 @@ func (r IncrementalCacheResolver) ShouldGetFromCache(pos navigator.Position) boo
 	}
 	return pos.IndexAsUint64() > r.start && lastDescendantIndex <= r.end
 }
+
+// MultiTargetedCacheResolver resolves the positions needed to prove the
+// membership of several indexes in the tree of the given version at once.
+// Only the subtrees without any target are taken from the cache.
+type MultiTargetedCacheResolver struct {
+	version uint64
+	targets []uint64 // sorted
+}
+
+func NewMultiTargetedCacheResolver(version uint64, targets []uint64) *MultiTargetedCacheResolver {
+	sorted := make([]uint64, len(targets))
+	copy(sorted, targets)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return &MultiTargetedCacheResolver{version, sorted}
+}
+
+func (r MultiTargetedCacheResolver) ShouldGetFromCache(pos navigator.Position) bool {
+	lastDescendantIndex := pos.IndexAsUint64() + 1<<pos.Height() - 1
+	if lastDescendantIndex > r.version {
+		// incomplete subtrees are not frozen yet
+		return false
+	}
+	i := sort.Search(len(r.targets), func(i int) bool {
+		return r.targets[i] >= pos.IndexAsUint64()
+	})
+	return i == len(r.targets) || r.targets[i] > lastDescendantIndex
+}
+
+// RangeCacheResolver resolves the positions needed to prove every index
+// from start to end, both included, in the tree of the given version.
+type RangeCacheResolver struct {
+	start, end, version uint64
+}
+
+func NewRangeCacheResolver(start, end, version uint64) *RangeCacheResolver {
+	return &RangeCacheResolver{start, end, version}
+}
+
+func (r RangeCacheResolver) ShouldGetFromCache(pos navigator.Position) bool {
+	lastDescendantIndex := pos.IndexAsUint64() + 1<<pos.Height() - 1
+	if lastDescendantIndex > r.version {
+		// incomplete subtrees are not frozen yet
+		return false
+	}
+	return lastDescendantIndex < r.start || pos.IndexAsUint64() > r.end
+}
@@ -0,0 +1,110 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package history
+
+import (
+	"bytes"
+
+	"github.com/bbva/qed/balloon/navigator"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
+)
+
+// Check recomputes every frozen node of the tree of the given version from
+// its leaves and reports the positions whose stored digest does not match.
+// The leaves are computed from the stored event digests, or taken from the
+// stored leaf hashes if the event digests are not available. It returns the
+// root hash computed for the version.
+func Check(hasher hashing.Hasher, store storage.Store, version uint64, mismatch func(pos navigator.Position)) (hashing.Digest, error) {
+
+	check := func(pos navigator.Position, digest hashing.Digest) error {
+		stored, err := store.Get(storage.HistoryCachePrefix, pos.Bytes())
+		if err != nil && err != storage.ErrKeyNotFound {
+			return err
+		}
+		if err == storage.ErrKeyNotFound || !bytes.Equal(stored.Value, digest) {
+			mismatch(pos)
+		}
+		return nil
+	}
+
+	// the frozen subtrees not merged yet, from left to right, whose
+	// heights are always decreasing
+	var frozen []*HistoryPosition
+	digests := make(map[string]hashing.Digest)
+
+	for index := uint64(0); index <= version; index++ {
+		pos := NewPosition(index, 0)
+		var digest hashing.Digest
+		kv, err := store.Get(storage.HistoryLeafPrefix, util.Uint64AsBytes(index))
+		switch err {
+		case nil:
+			digest = hashing.SaltedLeaf(hasher, pos.Bytes(), kv.Value)
+			if err := check(pos, digest); err != nil {
+				return nil, err
+			}
+		case storage.ErrKeyNotFound:
+			// with no event digest the stored leaf is trusted, and a
+			// missing one leaves every ancestor mismatched
+			stored, err := store.Get(storage.HistoryCachePrefix, pos.Bytes())
+			if err != nil && err != storage.ErrKeyNotFound {
+				return nil, err
+			}
+			if err == storage.ErrKeyNotFound {
+				mismatch(pos)
+			} else {
+				digest = stored.Value
+			}
+		default:
+			return nil, err
+		}
+		frozen = append(frozen, pos)
+		digests[pos.StringId()] = digest
+
+		// merge the sibling subtrees that are frozen by this leaf
+		for len(frozen) > 1 && frozen[len(frozen)-1].Height() == frozen[len(frozen)-2].Height() {
+			left, right := frozen[len(frozen)-2], frozen[len(frozen)-1]
+			parent := NewPosition(left.IndexAsUint64(), left.Height()+1)
+			digest := hashing.SaltedNode(hasher, parent.Bytes(), digests[left.StringId()], digests[right.StringId()])
+			if err := check(parent, digest); err != nil {
+				return nil, err
+			}
+			delete(digests, left.StringId())
+			delete(digests, right.StringId())
+			frozen = append(frozen[:len(frozen)-2], parent)
+			digests[parent.StringId()] = digest
+		}
+	}
+
+	nav := NewHistoryTreeNavigator(version)
+	return rootOf(hasher, nav, nav.Root(), digests), nil
+}
+
+// rootOf computes the digest of the given position from the digests of the
+// frozen subtrees that cover it.
+func rootOf(hasher hashing.Hasher, nav *HistoryTreeNavigator, pos navigator.Position, digests map[string]hashing.Digest) hashing.Digest {
+	if digest, ok := digests[pos.StringId()]; ok {
+		return digest
+	}
+	left := rootOf(hasher, nav, nav.GoToLeft(pos), digests)
+	rightPos := nav.GoToRight(pos)
+	if rightPos == nil {
+		return hashing.SaltedNode(hasher, pos.Bytes(), left)
+	}
+	return hashing.SaltedNode(hasher, pos.Bytes(), left, rootOf(hasher, nav, rightPos, digests))
+}
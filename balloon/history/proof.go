@@ -46,6 +46,16 @@ func (p MembershipProof) AuditPath() visitor.AuditPath {
 
 // Verify verifies a membership proof
 func (p MembershipProof) Verify(eventDigest []byte, expectedDigest hashing.Digest) (correct bool) {
+	recomputed, err := p.rootHash(eventDigest)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(recomputed, expectedDigest)
+}
+
+// rootHash recomputes the root hash of the tree of the proof version from
+// the event digest of the proof index and the audit path.
+func (p MembershipProof) rootHash(eventDigest []byte) (hashing.Digest, error) {
 
 	// visitors
 	computeHash := visitor.NewComputeHashVisitor(p.hasher)
@@ -65,6 +75,128 @@ func (p MembershipProof) Verify(eventDigest []byte, expectedDigest hashing.Diges
 
 	// traverse from root and generate a visitable pruned tree
 	pruned, err := NewVerifyPruner(eventDigest, context).Prune()
+	if err != nil {
+		return nil, err
+	}
+
+	// visit the pruned tree
+	return pruned.PostOrder(computeHash).(hashing.Digest), nil
+}
+
+// BatchMembershipProof proves the membership of several indexes in the
+// tree of a given version at once.
+type BatchMembershipProof struct {
+	auditPath visitor.AuditPath
+	Indexes   []uint64
+	Version   uint64
+	hasher    hashing.Hasher
+}
+
+func NewBatchMembershipProof(indexes []uint64, version uint64, auditPath visitor.AuditPath, hasher hashing.Hasher) *BatchMembershipProof {
+	return &BatchMembershipProof{
+		auditPath: auditPath,
+		Indexes:   indexes,
+		Version:   version,
+		hasher:    hasher,
+	}
+}
+
+func (p BatchMembershipProof) AuditPath() visitor.AuditPath {
+	return p.auditPath
+}
+
+// Verify verifies a batch membership proof given the event digest of each
+// index of the proof, in the same order.
+func (p BatchMembershipProof) Verify(eventDigests []hashing.Digest, expectedDigest hashing.Digest) (correct bool) {
+
+	if len(p.Indexes) == 0 || len(p.Indexes) != len(eventDigests) {
+		return false
+	}
+
+	digests := make(map[uint64]hashing.Digest, len(p.Indexes))
+	for i, index := range p.Indexes {
+		if index > p.Version {
+			return false
+		}
+		if digest, ok := digests[index]; ok && !bytes.Equal(digest, eventDigests[i]) {
+			return false
+		}
+		digests[index] = eventDigests[i]
+	}
+
+	// visitors
+	computeHash := visitor.NewComputeHashVisitor(p.hasher)
+
+	// build pruning context
+	context := PruningContext{
+		navigator:     NewHistoryTreeNavigator(p.Version),
+		cacheResolver: NewMultiTargetedCacheResolver(p.Version, p.Indexes),
+		cache:         p.auditPath,
+	}
+
+	// traverse from root and generate a visitable pruned tree
+	pruned, err := NewVerifyBatchPruner(digests, context).Prune()
+	if err != nil {
+		return false
+	}
+
+	// visit the pruned tree
+	recomputed := pruned.PostOrder(computeHash).(hashing.Digest)
+
+	return bytes.Equal(recomputed, expectedDigest)
+}
+
+// RangeProof proves that the event digests are exactly the ones of the
+// versions from start to end, both included, in the tree of a given
+// version.
+type RangeProof struct {
+	auditPath    visitor.AuditPath
+	Start, End   uint64
+	Version      uint64
+	EventDigests []hashing.Digest
+	hasher       hashing.Hasher
+}
+
+func NewRangeProof(start, end, version uint64, eventDigests []hashing.Digest, auditPath visitor.AuditPath, hasher hashing.Hasher) *RangeProof {
+	return &RangeProof{
+		auditPath:    auditPath,
+		Start:        start,
+		End:          end,
+		Version:      version,
+		EventDigests: eventDigests,
+		hasher:       hasher,
+	}
+}
+
+func (p RangeProof) AuditPath() visitor.AuditPath {
+	return p.auditPath
+}
+
+// Verify verifies a range proof. Any missing, additional or reordered
+// event digest makes the verification fail.
+func (p RangeProof) Verify(expectedDigest hashing.Digest) (correct bool) {
+
+	if p.Start > p.End || p.End > p.Version || uint64(len(p.EventDigests)) != p.End-p.Start+1 {
+		return false
+	}
+
+	digests := make(map[uint64]hashing.Digest, len(p.EventDigests))
+	for i, digest := range p.EventDigests {
+		digests[p.Start+uint64(i)] = digest
+	}
+
+	// visitors
+	computeHash := visitor.NewComputeHashVisitor(p.hasher)
+
+	// build pruning context
+	context := PruningContext{
+		navigator:     NewHistoryTreeNavigator(p.Version),
+		cacheResolver: NewRangeCacheResolver(p.Start, p.End, p.Version),
+		cache:         p.auditPath,
+	}
+
+	// traverse from root and generate a visitable pruned tree
+	pruned, err := NewVerifyBatchPruner(digests, context).Prune()
 	if err != nil {
 		return false
 	}
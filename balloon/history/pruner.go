@@ -36,7 +36,9 @@ type Pruner interface {
 }
 
 var (
-	ErrCacheNotFound = errors.New("this digest should be in cache")
+	ErrCacheNotFound  = errors.New("this digest should be in cache")
+	ErrInvalidRange   = errors.New("invalid range of versions")
+	ErrLeavesNotFound = errors.New("the event digests of the range are not stored")
 )
 
 type InsertPruner struct {
@@ -195,6 +197,60 @@ func (p *VerifyPruner) traverse(pos navigator.Position, eventDigest hashing.Dige
 
 }
 
+// VerifyBatchPruner recomputes the root of the tree from the audit path of
+// several indexes at once, given the event digest of each of them.
+type VerifyBatchPruner struct {
+	eventDigests map[uint64]hashing.Digest
+	PruningContext
+}
+
+func NewVerifyBatchPruner(eventDigests map[uint64]hashing.Digest, context PruningContext) *VerifyBatchPruner {
+	return &VerifyBatchPruner{eventDigests, context}
+}
+
+func (p *VerifyBatchPruner) Prune() (visitor.Visitable, error) {
+	return p.traverse(p.navigator.Root())
+}
+
+func (p *VerifyBatchPruner) traverse(pos navigator.Position) (visitor.Visitable, error) {
+	if p.cacheResolver.ShouldGetFromCache(pos) {
+		digest, ok := p.cache.Get(pos)
+		if !ok {
+			return nil, ErrCacheNotFound
+		}
+		return visitor.NewCached(pos, digest), nil
+	}
+	if p.navigator.IsLeaf(pos) {
+		eventDigest, ok := p.eventDigests[pos.IndexAsUint64()]
+		if !ok {
+			return nil, ErrCacheNotFound
+		}
+		return visitor.NewLeaf(pos, eventDigest), nil
+	}
+
+	// we do a post-order traversal
+	left, err := p.traverse(p.navigator.GoToLeft(pos))
+	if err != nil {
+		return nil, err
+	}
+
+	rightPos := p.navigator.GoToRight(pos)
+	if rightPos == nil {
+		return visitor.NewPartialNode(pos, left), nil
+	}
+
+	right, err := p.traverse(rightPos)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.navigator.IsRoot(pos) {
+		return visitor.NewRoot(pos, left, right), nil
+	}
+
+	return visitor.NewNode(pos, left, right), nil
+}
+
 type VerifyIncrementalPruner struct {
 	PruningContext
 }
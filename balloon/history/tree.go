@@ -26,11 +26,13 @@ import (
 	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
 )
 
 type HistoryTree struct {
 	hasherF    func() hashing.Hasher
 	hasher     hashing.Hasher
+	store      storage.Store
 	writeCache cache.ModifiableCache
 	readCache  cache.Cache
 }
@@ -46,6 +48,7 @@ func NewHistoryTree(hasherF func() hashing.Hasher, store storage.Store, cacheSiz
 	return &HistoryTree{
 		hasherF:    hasherF,
 		hasher:     hasherF(),
+		store:      store,
 		writeCache: writeCache,
 		readCache:  readCache,
 	}
@@ -85,10 +88,34 @@ func (t *HistoryTree) Add(eventDigest hashing.Digest, version uint64) (hashing.D
 	// visit the pruned tree
 	rh := pruned.PostOrder(collect).(hashing.Digest)
 
+	// the leaves only keep the hash of the event digests, so we store them
+	// apart to be able to prove ranges of versions
+	leafMutation := storage.NewMutation(storage.HistoryLeafPrefix, util.Uint64AsBytes(version), eventDigest)
+	mutations := append(collect.Result(), leafMutation)
+
 	// Increment add hits
 	stats.Add("add_hits", 1)
 
-	return rh, collect.Result(), nil
+	return rh, mutations, nil
+}
+
+// AddBulk inserts a list of event digests with consecutive versions starting
+// at initialVersion. It returns the root hash after each insertion and the
+// mutations of the whole bulk.
+func (t *HistoryTree) AddBulk(eventDigests []hashing.Digest, initialVersion uint64) ([]hashing.Digest, []*storage.Mutation, error) {
+	rootHashes := make([]hashing.Digest, len(eventDigests))
+	mutations := make([]*storage.Mutation, 0)
+	for i, eventDigest := range eventDigests {
+		// previous nodes are read from the write cache, which is updated
+		// on every insertion even if the mutations are not yet persisted
+		rh, eventMutations, err := t.Add(eventDigest, initialVersion+uint64(i))
+		if err != nil {
+			return nil, nil, err
+		}
+		rootHashes[i] = rh
+		mutations = append(mutations, eventMutations...)
+	}
+	return rootHashes, mutations, nil
 }
 
 func (t *HistoryTree) ProveMembership(index, version uint64) (*MembershipProof, error) {
@@ -132,6 +159,101 @@ func (t *HistoryTree) ProveMembership(index, version uint64) (*MembershipProof,
 	return proof, nil
 }
 
+// RootHash returns the root hash of the tree at the given version, which
+// is recomputed from its audit path, as only the frozen nodes are stored.
+// The event digest must be the one added at that version.
+func (t *HistoryTree) RootHash(eventDigest hashing.Digest, version uint64) (hashing.Digest, error) {
+	proof, err := t.ProveMembership(version, version)
+	if err != nil {
+		return nil, err
+	}
+	return proof.rootHash(eventDigest)
+}
+
+// ProveBatchMembership returns a single proof of membership of several
+// indexes in the tree of the given version, where the nodes shared by their
+// audit paths are included only once.
+func (t *HistoryTree) ProveBatchMembership(indexes []uint64, version uint64) (*BatchMembershipProof, error) {
+
+	log.Debugf("Proving membership for %d indexes with version %d", len(indexes), version)
+	stats := metrics.History
+	stats.Add("ProveBatchMembership_hits", 1)
+
+	// visitors
+	computeHash := visitor.NewComputeHashVisitor(t.hasherF())
+	calcAuditPath := visitor.NewAuditPathVisitor(computeHash)
+
+	// build pruning context
+	context := PruningContext{
+		navigator:     NewHistoryTreeNavigator(version),
+		cacheResolver: NewMultiTargetedCacheResolver(version, indexes),
+		cache:         t.readCache,
+	}
+
+	// traverse from root and generate a visitable pruned tree
+	pruned, err := NewSearchPruner(context).Prune()
+	if err != nil {
+		return nil, err
+	}
+
+	// visit the pruned tree
+	pruned.PostOrder(calcAuditPath)
+
+	proof := NewBatchMembershipProof(indexes, version, calcAuditPath.Result(), t.hasherF())
+
+	return proof, nil
+}
+
+// ProveRange returns the event digests of the versions from start to end,
+// both included, along with a single audit path that proves them in the
+// tree of the given version.
+func (t *HistoryTree) ProveRange(start, end, version uint64) (*RangeProof, error) {
+
+	log.Debugf("Proving range from %d to %d with version %d", start, end, version)
+	stats := metrics.History
+	stats.Add("ProveRange_hits", 1)
+
+	if start > end || end > version {
+		return nil, ErrInvalidRange
+	}
+
+	kvRange, err := t.store.GetRange(storage.HistoryLeafPrefix, util.Uint64AsBytes(start), util.Uint64AsBytes(end))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(kvRange)) != end-start+1 {
+		return nil, ErrLeavesNotFound
+	}
+	eventDigests := make([]hashing.Digest, len(kvRange))
+	for i, kv := range kvRange {
+		eventDigests[i] = kv.Value
+	}
+
+	// visitors
+	computeHash := visitor.NewComputeHashVisitor(t.hasherF())
+	calcAuditPath := visitor.NewAuditPathVisitor(computeHash)
+
+	// build pruning context
+	context := PruningContext{
+		navigator:     NewHistoryTreeNavigator(version),
+		cacheResolver: NewRangeCacheResolver(start, end, version),
+		cache:         t.readCache,
+	}
+
+	// traverse from root and generate a visitable pruned tree
+	pruned, err := NewSearchPruner(context).Prune()
+	if err != nil {
+		return nil, err
+	}
+
+	// visit the pruned tree
+	pruned.PostOrder(calcAuditPath)
+
+	proof := NewRangeProof(start, end, version, eventDigests, calcAuditPath.Result(), t.hasherF())
+
+	return proof, nil
+}
+
 func (t *HistoryTree) ProveConsistency(start, end uint64) (*IncrementalProof, error) {
 
 	log.Debugf("Proving consistency between versions %d and %d", start, end)
@@ -41,52 +41,52 @@ func TestAdd(t *testing.T) {
 		{
 			eventDigest:          hashing.Digest{0x0},
 			expectedRootHash:     hashing.Digest{0x0},
-			expectedMutationsLen: 1,
+			expectedMutationsLen: 2,
 		},
 		{
 			eventDigest:          hashing.Digest{0x1},
 			expectedRootHash:     hashing.Digest{0x1},
-			expectedMutationsLen: 2,
+			expectedMutationsLen: 3,
 		},
 		{
 			eventDigest:          hashing.Digest{0x2},
 			expectedRootHash:     hashing.Digest{0x3},
-			expectedMutationsLen: 1,
+			expectedMutationsLen: 2,
 		},
 		{
 			eventDigest:          hashing.Digest{0x3},
 			expectedRootHash:     hashing.Digest{0x0},
-			expectedMutationsLen: 3,
+			expectedMutationsLen: 4,
 		},
 		{
 			eventDigest:          hashing.Digest{0x4},
 			expectedRootHash:     hashing.Digest{0x4},
-			expectedMutationsLen: 1,
+			expectedMutationsLen: 2,
 		},
 		{
 			eventDigest:          hashing.Digest{0x5},
 			expectedRootHash:     hashing.Digest{0x1},
-			expectedMutationsLen: 2,
+			expectedMutationsLen: 3,
 		},
 		{
 			eventDigest:          hashing.Digest{0x6},
 			expectedRootHash:     hashing.Digest{0x7},
-			expectedMutationsLen: 1,
+			expectedMutationsLen: 2,
 		},
 		{
 			eventDigest:          hashing.Digest{0x7},
 			expectedRootHash:     hashing.Digest{0x0},
-			expectedMutationsLen: 4,
+			expectedMutationsLen: 5,
 		},
 		{
 			eventDigest:          hashing.Digest{0x8},
 			expectedRootHash:     hashing.Digest{0x8},
-			expectedMutationsLen: 1,
+			expectedMutationsLen: 2,
 		},
 		{
 			eventDigest:          hashing.Digest{0x9},
 			expectedRootHash:     hashing.Digest{0x1},
-			expectedMutationsLen: 2,
+			expectedMutationsLen: 3,
 		},
 	}
 
@@ -241,6 +241,115 @@ func TestProveMembership(t *testing.T) {
 
 }
 
+func TestProveBatchMembership(t *testing.T) {
+
+	log.SetLogger("TestProveBatchMembership", log.SILENT)
+
+	hasher := hashing.NewSha256Hasher()
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	tree := NewHistoryTree(hashing.NewSha256Hasher, store, 30)
+
+	digests := make([]hashing.Digest, 50)
+	rootHashes := make([]hashing.Digest, 50)
+	for i := range digests {
+		digests[i] = hasher.Do(rand.Bytes(32))
+		rootHash, mutations, err := tree.Add(digests[i], uint64(i))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		rootHashes[i] = rootHash
+	}
+
+	testCases := []struct {
+		indexes []uint64
+		version uint64
+	}{
+		{[]uint64{0}, 0},
+		{[]uint64{3}, 3},
+		{[]uint64{3}, 17},
+		{[]uint64{0, 1, 2, 3}, 3},
+		{[]uint64{5, 1, 30, 17}, 31},
+		{[]uint64{49, 0, 25}, 49},
+		{[]uint64{2, 7, 11, 13, 19, 23, 29}, 40},
+	}
+
+	for i, c := range testCases {
+		proof, err := tree.ProveBatchMembership(c.indexes, c.version)
+		require.NoError(t, err, "Error in test case %d", i)
+
+		eventDigests := make([]hashing.Digest, len(c.indexes))
+		singleSize := 0
+		for j, index := range c.indexes {
+			eventDigests[j] = digests[index]
+			single, err := tree.ProveMembership(index, c.version)
+			require.NoError(t, err)
+			singleSize += len(single.AuditPath())
+		}
+		assert.True(t, proof.Verify(eventDigests, rootHashes[c.version]), "The proof should be valid in test case %d", i)
+		assert.True(t, len(proof.AuditPath()) <= singleSize, "The shared nodes should not be repeated in test case %d", i)
+
+		eventDigests[0] = hasher.Do([]byte("another event"))
+		assert.False(t, proof.Verify(eventDigests, rootHashes[c.version]), "The proof should fail for another event in test case %d", i)
+	}
+}
+
+func TestProveRange(t *testing.T) {
+
+	log.SetLogger("TestProveRange", log.SILENT)
+
+	hasher := hashing.NewSha256Hasher()
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	tree := NewHistoryTree(hashing.NewSha256Hasher, store, 30)
+
+	digests := make([]hashing.Digest, 40)
+	rootHashes := make([]hashing.Digest, 40)
+	for i := range digests {
+		digests[i] = hasher.Do(rand.Bytes(32))
+		rootHash, mutations, err := tree.Add(digests[i], uint64(i))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		rootHashes[i] = rootHash
+	}
+
+	testCases := []struct {
+		start, end, version uint64
+	}{
+		{0, 0, 0},
+		{0, 39, 39},
+		{3, 3, 10},
+		{4, 7, 7},
+		{5, 20, 33},
+		{17, 39, 39},
+	}
+
+	for i, c := range testCases {
+		proof, err := tree.ProveRange(c.start, c.end, c.version)
+		require.NoError(t, err, "Error in test case %d", i)
+		assert.Equal(t, digests[c.start:c.end+1], proof.EventDigests, "Wrong event digests in test case %d", i)
+		assert.True(t, proof.Verify(rootHashes[c.version]), "The proof should be valid in test case %d", i)
+		assert.False(t, proof.Verify(hasher.Do([]byte("wrong root"))), "The proof should fail for another root in test case %d", i)
+
+		if len(proof.EventDigests) > 1 {
+			missing := *proof
+			missing.EventDigests = proof.EventDigests[1:]
+			assert.False(t, missing.Verify(rootHashes[c.version]), "A proof with a missing event should fail in test case %d", i)
+
+			missing.End--
+			assert.False(t, missing.Verify(rootHashes[c.version]), "A proof with a missing event should fail in test case %d", i)
+
+			reordered := *proof
+			reordered.EventDigests = append([]hashing.Digest{proof.EventDigests[1], proof.EventDigests[0]}, proof.EventDigests[2:]...)
+			assert.False(t, reordered.Verify(rootHashes[c.version]), "A reordered proof should fail in test case %d", i)
+		}
+	}
+
+	_, err := tree.ProveRange(5, 4, 10)
+	assert.Equal(t, ErrInvalidRange, err, "A reversed range should fail")
+	_, err = tree.ProveRange(5, 11, 10)
+	assert.Equal(t, ErrInvalidRange, err, "A range beyond the version should fail")
+}
+
 func TestProveConsistency(t *testing.T) {
 
 	log.SetLogger("TestProveConsistency", log.INFO)
@@ -16,7 +16,12 @@
 
 package hyper
 
-import "github.com/bbva/qed/balloon/navigator"
+import (
+	"bytes"
+	"sort"
+
+	"github.com/bbva/qed/balloon/navigator"
+)
 
 type CacheResolver interface {
 	ShouldBeInCache(pos navigator.Position) bool
@@ -69,3 +74,55 @@ func (r SingleTargetedCacheResolver) IsOnPath(pos navigator.Position) bool {
 func bitIsSet(bits []byte, i uint16) bool {
 	return bits[i/8]&(1<<uint(7-i%8)) != 0
 }
+
+// MultiTargetedCacheResolver resolves the positions on the paths to several
+// keys at once, so the siblings shared by those paths are only visited once.
+type MultiTargetedCacheResolver struct {
+	numBits    uint16
+	cacheLevel uint16
+	targetKeys [][]byte // sorted
+}
+
+func NewMultiTargetedCacheResolver(numBits, cacheLevel uint16, targetKeys [][]byte) *MultiTargetedCacheResolver {
+	sorted := make([][]byte, len(targetKeys))
+	copy(sorted, targetKeys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+	return &MultiTargetedCacheResolver{numBits, cacheLevel, sorted}
+}
+
+func (r MultiTargetedCacheResolver) ShouldBeInCache(pos navigator.Position) bool {
+	return pos.Height() >= r.cacheLevel && !r.IsOnPath(pos)
+}
+
+func (r MultiTargetedCacheResolver) ShouldCache(pos navigator.Position) bool {
+	return pos.Height() == r.cacheLevel
+}
+
+func (r MultiTargetedCacheResolver) ShouldCollect(pos navigator.Position) bool {
+	return pos.Height() == r.cacheLevel
+}
+
+// IsOnPath returns true if any of the target keys descends from the given
+// position. The index of a position only has the bits of its prefix set, so
+// the first key greater or equal than the index is the only candidate.
+func (r MultiTargetedCacheResolver) IsOnPath(pos navigator.Position) bool {
+	height := pos.Height()
+	if height == r.numBits {
+		return true
+	}
+	index := pos.Index()
+	i := sort.Search(len(r.targetKeys), func(i int) bool {
+		return bytes.Compare(r.targetKeys[i], index) >= 0
+	})
+	if i == len(r.targetKeys) {
+		return false
+	}
+	for bit := uint16(0); bit < r.numBits-height; bit++ {
+		if bitIsSet(r.targetKeys[i], bit) != bitIsSet(index, bit) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hyper
+
+import (
+	"bytes"
+
+	"github.com/bbva/qed/balloon/navigator"
+	"github.com/bbva/qed/balloon/visitor"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
+)
+
+// Check recomputes the nodes at the cache level from the leaves in the
+// store and reports the positions whose stored digest does not match, as
+// well as the ones stored without leaves under them. It returns the root
+// hash computed from the leaves, or nil if there are none.
+func Check(hasher hashing.Hasher, store storage.Store, mismatch func(pos navigator.Position)) (hashing.Digest, error) {
+	numBits := hasher.Len()
+	cacheLevel := cacheLevelOf(hasher)
+	nav := NewHyperTreeNavigator(numBits)
+	context := PruningContext{
+		navigator:     nav,
+		defaultHashes: DefaultHashes(hasher),
+	}
+
+	// the leaves are sorted, so the ones under the same node are together
+	var nodes []cachedNode
+	checkNode := func(pos navigator.Position, leaves storage.KVRange) error {
+		pruned, err := NewBulkInsertPruner(leaves, context).traverseWithoutCache(pos, leaves)
+		if err != nil {
+			return err
+		}
+		digest := pruned.PostOrder(visitor.NewComputeHashVisitor(hasher)).(hashing.Digest)
+		stored, err := store.Get(storage.HyperCachePrefix, pos.Bytes())
+		if err != nil || !bytes.Equal(stored.Value, digest) {
+			mismatch(pos)
+		}
+		nodes = append(nodes, cachedNode{pos, digest})
+		return nil
+	}
+
+	reader := store.GetAll(storage.IndexPrefix)
+	defer reader.Close()
+	var pos navigator.Position
+	leaves := storage.NewKVRange()
+	for {
+		entries := make([]*storage.KVPair, 100)
+		n, err := reader.Read(entries)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		for _, entry := range entries[:n] {
+			ancestor := ancestorAt(entry.Key, numBits, cacheLevel)
+			if pos != nil && !bytes.Equal(ancestor.Index(), pos.Index()) {
+				if err := checkNode(pos, leaves); err != nil {
+					return nil, err
+				}
+				leaves = storage.NewKVRange()
+			}
+			pos = ancestor
+			leaves = append(leaves, *entry)
+		}
+	}
+	if pos != nil {
+		if err := checkNode(pos, leaves); err != nil {
+			return nil, err
+		}
+	}
+
+	// the nodes stored at the cache level must be the ones with leaves
+	computed := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		computed[string(node.pos.Bytes())] = true
+	}
+	stored := store.GetAll(storage.HyperCachePrefix)
+	defer stored.Close()
+	indexLen := numBits / 8
+	for {
+		entries := make([]*storage.KVPair, 100)
+		n, err := stored.Read(entries)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		for _, entry := range entries[:n] {
+			if !computed[string(entry.Key)] {
+				index := entry.Key[:indexLen]
+				height := util.BytesAsUint16(entry.Key[indexLen : indexLen+2])
+				mismatch(NewPosition(index, height))
+			}
+		}
+	}
+
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	return rootFrom(hasher, nav, context.defaultHashes, nodes), nil
+}
+
+// ancestorAt returns the position of the node at the given height on the
+// path to the given key.
+func ancestorAt(key []byte, numBits, height uint16) navigator.Position {
+	index := make([]byte, len(key))
+	copy(index, key)
+	for bit := numBits - height; bit < numBits; bit++ {
+		index[bit/8] &^= 1 << uint(7-bit%8)
+	}
+	return NewPosition(index, height)
+}
+
+// rootFrom computes the root hash from the sorted nodes at the same height,
+// merging the siblings of every level, as the empty subtrees have default
+// digests.
+func rootFrom(hasher hashing.Hasher, nav *HyperTreeNavigator, defaultHashes []hashing.Digest, nodes []cachedNode) hashing.Digest {
+	numBits := hasher.Len()
+	for height := nodes[0].pos.Height(); height < numBits; height++ {
+		parents := make([]cachedNode, 0, len(nodes))
+		for i := 0; i < len(nodes); i++ {
+			parent := ancestorAt(nodes[i].pos.Index(), numBits, height+1)
+			left, right := defaultHashes[height], defaultHashes[height]
+			if bytes.Equal(nodes[i].pos.Index(), parent.Index()) {
+				left = nodes[i].digest
+				if i+1 < len(nodes) && bytes.Equal(nav.GoToRight(parent).Index(), nodes[i+1].pos.Index()) {
+					right = nodes[i+1].digest
+					i++
+				}
+			} else {
+				right = nodes[i].digest
+			}
+			parents = append(parents, cachedNode{parent, hashing.SaltedNode(hasher, parent.Bytes(), left, right)})
+		}
+		nodes = parents
+	}
+	return nodes[0].digest
+}
@@ -0,0 +1,265 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hyper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/bbva/qed/balloon/navigator"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/metrics"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
+)
+
+var (
+	ErrStaleCheckpoint      = errors.New("the checkpoint is newer than the stored tree")
+	ErrCorruptCheckpoint    = errors.New("the checkpoint is corrupt")
+	ErrIncompleteCheckpoint = errors.New("the leaves added after the checkpoint are not stored")
+)
+
+// A checkpoint file starts with the version it reflects, which is the one
+// the next addition would get, and the number of cached nodes, followed by
+// the index, height and digest of every one of them.
+const checkpointHeaderLen = 16
+
+// Checkpoint is a copy of the cache of a tree, tagged with the version it
+// reflects, which can be saved to a file to be loaded on restart.
+type Checkpoint struct {
+	Version uint64
+	cached  []cachedNode
+}
+
+// Checkpoint copies the cache. The given version must be the one the next
+// addition will get, and no addition must be in progress until it returns,
+// as the versions not stored yet would not be replayed on load.
+func (t *HyperTree) Checkpoint(version uint64) *Checkpoint {
+	t.RLock()
+	defer t.RUnlock()
+
+	stats := metrics.Hyper
+	stats.Add("Checkpoint_hits", 1)
+
+	nav := NewHyperTreeNavigator(t.hasher.Len())
+	root := nav.Root()
+	// skip root
+	var cached []cachedNode
+	cached = t.collectCache(nav.GoToLeft(root), nav, cached)
+	cached = t.collectCache(nav.GoToRight(root), nav, cached)
+	return &Checkpoint{version, cached}
+}
+
+// Save writes the checkpoint to the given file. The file is replaced at
+// once, so a failure keeps the previous checkpoint.
+func (c *Checkpoint) Save(path string) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	w.Write(util.Uint64AsBytes(c.Version))
+	w.Write(util.Uint64AsBytes(uint64(len(c.cached))))
+	for _, node := range c.cached {
+		w.Write(node.pos.Index())
+		w.Write(util.Uint16AsBytes(node.pos.Height()))
+		w.Write(node.digest)
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadCheckpoint fills the cache with the one saved to the given file and
+// replays the versions added after it, up to the current version, which is
+// the one the next addition will get.
+func (t *HyperTree) LoadCheckpoint(path string, currentVersion uint64) error {
+	t.Lock()
+	defer t.Unlock()
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(raw) < checkpointHeaderLen {
+		return ErrCorruptCheckpoint
+	}
+	version := util.BytesAsUint64(raw[:8])
+	count := util.BytesAsUint64(raw[8:16])
+	indexLen := int(t.hasher.Len() / 8)
+	recordLen := indexLen + 2 + indexLen
+	if uint64(len(raw)-checkpointHeaderLen) != count*uint64(recordLen) {
+		return ErrCorruptCheckpoint
+	}
+	if version > currentVersion {
+		return ErrStaleCheckpoint
+	}
+
+	log.Infof("Loading hyper cache checkpoint of version %d...", version)
+	for record := raw[checkpointHeaderLen:]; len(record) > 0; record = record[recordLen:] {
+		index := record[:indexLen]
+		height := util.BytesAsUint16(record[indexLen : indexLen+2])
+		if height < t.cacheLevel || height >= t.hasher.Len() {
+			return ErrCorruptCheckpoint
+		}
+		t.cache.Put(NewPosition(index, height), record[indexLen+2:recordLen])
+	}
+
+	if err := t.replay(version, currentVersion); err != nil {
+		return err
+	}
+	log.Infof("Loading done, replayed %d versions, elements cached: %d", currentVersion-version, t.cache.Size())
+	return nil
+}
+
+// PruneJournal deletes the journal of the leaves added before the given
+// version, which must be the one of a saved checkpoint, as they will not
+// be replayed anymore. Stores that cannot delete keys keep it.
+func (t *HyperTree) PruneJournal(version uint64) error {
+	store, ok := t.store.(storage.DeletableStore)
+	if !ok || version == 0 {
+		return nil
+	}
+
+	kvs, err := t.store.GetRange(storage.HyperLeafPrefix, util.Uint64AsBytes(0), util.Uint64AsBytes(version-1))
+	if err != nil {
+		return err
+	}
+	for _, kv := range kvs {
+		if err := store.Delete(storage.HyperLeafPrefix, kv.Key); err != nil {
+			return err
+		}
+	}
+	log.Debugf("Pruned %d journal entries of the hyper tree before version %d", len(kvs), version)
+	return nil
+}
+
+type cachedNode struct {
+	pos    navigator.Position
+	digest hashing.Digest
+}
+
+// collectCache returns the cached nodes under the given position. A node is
+// only cached if some of its children are, so the traversal stops at the
+// first missing one.
+func (t *HyperTree) collectCache(pos navigator.Position, nav *HyperTreeNavigator, cached []cachedNode) []cachedNode {
+	digest, ok := t.cache.Get(pos)
+	if !ok {
+		return cached
+	}
+	cached = append(cached, cachedNode{pos, digest})
+	if pos.Height() == t.cacheLevel {
+		return cached
+	}
+	cached = t.collectCache(nav.GoToLeft(pos), nav, cached)
+	return t.collectCache(nav.GoToRight(pos), nav, cached)
+}
+
+// replay updates the cache with the leaves added from the start version to
+// the end one, not included. The nodes at the cache level are read from the
+// store and the ones above are recomputed.
+func (t *HyperTree) replay(start, end uint64) error {
+	if start == end {
+		return nil
+	}
+
+	kvs, err := t.store.GetRange(storage.HyperLeafPrefix, util.Uint64AsBytes(start), util.Uint64AsBytes(end-1))
+	if err != nil {
+		return err
+	}
+	if uint64(len(kvs)) != end-start {
+		return ErrIncompleteCheckpoint
+	}
+
+	nav := NewHyperTreeNavigator(t.hasher.Len())
+
+	// a key could have been updated several times
+	keys := make(map[string]bool)
+	var paths [][]navigator.Position
+	for _, kv := range kvs {
+		if keys[string(kv.Value)] {
+			continue
+		}
+		keys[string(kv.Value)] = true
+
+		path := t.pathToCacheLevel(kv.Value, nav)
+		bottom := path[len(path)-1]
+		cached, err := t.store.Get(storage.HyperCachePrefix, bottom.Bytes())
+		if err != nil {
+			return fmt.Errorf("unable to get cached node %v: %v", bottom, err)
+		}
+		t.cache.Put(bottom, cached.Value)
+		paths = append(paths, path)
+	}
+
+	// every node is recomputed once all the nodes below are up to date,
+	// as they are only on the paths already recomputed
+	for _, path := range paths {
+		for i := len(path) - 2; i >= 0; i-- {
+			t.recompute(path[i], nav)
+		}
+	}
+
+	return nil
+}
+
+// pathToCacheLevel returns the positions from the children of the root down
+// to the cache level on the path to the given key.
+func (t *HyperTree) pathToCacheLevel(key []byte, nav *HyperTreeNavigator) []navigator.Position {
+	numBits := t.hasher.Len()
+	var path []navigator.Position
+	pos := nav.Root()
+	for pos.Height() > t.cacheLevel {
+		if bitIsSet(key, numBits-pos.Height()) {
+			pos = nav.GoToRight(pos)
+		} else {
+			pos = nav.GoToLeft(pos)
+		}
+		path = append(path, pos)
+	}
+	return path
+}
+
+func (t *HyperTree) recompute(pos navigator.Position, nav *HyperTreeNavigator) {
+	leftPos := nav.GoToLeft(pos)
+	rightPos := nav.GoToRight(pos)
+	left := t.cachedOrDefault(leftPos)
+	right := t.cachedOrDefault(rightPos)
+	t.cache.Put(pos, hashing.SaltedNode(t.hasher, pos.Bytes(), left, right))
+}
+
+func (t *HyperTree) cachedOrDefault(pos navigator.Position) hashing.Digest {
+	digest, ok := t.cache.Get(pos)
+	if !ok {
+		return t.defaultHashes[pos.Height()]
+	}
+	return digest
+}
@@ -0,0 +1,163 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hyper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/balloon/cache"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/testutils/rand"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+	"github.com/bbva/qed/util"
+)
+
+func TestLoadCheckpoint(t *testing.T) {
+
+	log.SetLogger("TestLoadCheckpoint", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	hasherF := hashing.NewSha256Hasher
+	hasher := hasherF()
+
+	dir, err := ioutil.TempDir("", "hyper-checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint")
+
+	tree := NewHyperTree(hasherF, store, cache.NewFastCache(CacheSize))
+
+	// some keys are updated before and after the checkpoint
+	keys := make([]hashing.Digest, 100)
+	for i := range keys {
+		keys[i] = hasher.Do(rand.Bytes(32))
+	}
+	add := func(tree *HyperTree, from, to uint64) hashing.Digest {
+		var rootHash hashing.Digest
+		for i := from; i < to; i++ {
+			key := keys[i%uint64(len(keys))]
+			if i%3 == 0 {
+				key = hasher.Do(rand.Bytes(32))
+			}
+			var mutations []*storage.Mutation
+			rootHash, mutations, err = tree.Add(key, i)
+			require.NoError(t, err)
+			require.NoError(t, store.Mutate(mutations))
+		}
+		return rootHash
+	}
+
+	add(tree, 0, 500)
+	require.NoError(t, tree.Checkpoint(500).Save(path))
+	expectedRootHash := add(tree, 500, 1000)
+
+	testCases := []struct {
+		currentVersion uint64
+		expectedErr    error
+	}{
+		{1000, nil},
+		{500, nil},
+		{499, ErrStaleCheckpoint},
+	}
+
+	for i, c := range testCases {
+		restored := newHyperTree(hasherF, store, cache.NewFastCache(CacheSize))
+		err := restored.LoadCheckpoint(path, c.currentVersion)
+		require.Equalf(t, c.expectedErr, err, "The error should match for test case %d", i)
+	}
+
+	// a truncated checkpoint cannot be loaded
+	raw, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	truncated := filepath.Join(dir, "truncated")
+	require.NoError(t, ioutil.WriteFile(truncated, raw[:len(raw)-1], 0644))
+	err = newHyperTree(hasherF, store, cache.NewFastCache(CacheSize)).LoadCheckpoint(truncated, 1000)
+	require.Equal(t, ErrCorruptCheckpoint, err, "A truncated checkpoint should be corrupt")
+
+	// the restored tree must compute the same roots as the original one
+	restored := NewHyperTreeFromCheckpoint(hasherF, store, cache.NewFastCache(CacheSize), path, 1000)
+	key := hasher.Do(rand.Bytes(32))
+	expectedRootHash, _, err = tree.Add(key, 1000)
+	require.NoError(t, err)
+	rootHash, _, err := restored.Add(key, 1000)
+	require.NoError(t, err)
+	require.Equal(t, expectedRootHash, rootHash, "The root hashes should match")
+}
+
+func TestPruneJournal(t *testing.T) {
+
+	log.SetLogger("TestPruneJournal", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	hasherF := hashing.NewSha256Hasher
+	hasher := hasherF()
+
+	dir, err := ioutil.TempDir("", "hyper-checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint")
+
+	tree := NewHyperTree(hasherF, store, cache.NewFastCache(CacheSize))
+	for i := uint64(0); i < 100; i++ {
+		_, mutations, err := tree.Add(hasher.Do(rand.Bytes(32)), i)
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+	checkpoint := tree.Checkpoint(60)
+	require.NoError(t, checkpoint.Save(path))
+	require.NoError(t, tree.PruneJournal(checkpoint.Version))
+
+	kvs, err := store.GetRange(storage.HyperLeafPrefix, util.Uint64AsBytes(0), util.Uint64AsBytes(99))
+	require.NoError(t, err)
+	require.Len(t, kvs, 40, "Only the versions after the checkpoint should be kept")
+	require.Equal(t, util.Uint64AsBytes(60), kvs[0].Key, "The first kept version should be the checkpoint one")
+
+	// the versions after the checkpoint can still be replayed
+	restored := newHyperTree(hasherF, store, cache.NewFastCache(CacheSize))
+	require.NoError(t, restored.LoadCheckpoint(path, 100))
+}
+
+func TestNewHyperTreeFromMissingCheckpoint(t *testing.T) {
+
+	log.SetLogger("TestNewHyperTreeFromMissingCheckpoint", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	hasherF := hashing.NewSha256Hasher
+	hasher := hasherF()
+
+	firstCache := cache.NewFastCache(CacheSize)
+	tree := NewHyperTree(hasherF, store, firstCache)
+	for i := 0; i < 100; i++ {
+		_, mutations, _ := tree.Add(hasher.Do(rand.Bytes(32)), uint64(i))
+		store.Mutate(mutations)
+	}
+
+	// the cache is rebuilt from the store
+	secondCache := cache.NewFastCache(CacheSize)
+	NewHyperTreeFromCheckpoint(hasherF, store, secondCache, "/nonexistent/checkpoint", 100)
+	require.True(t, firstCache.Equal(secondCache), "The caches should be equal")
+}
@@ -0,0 +1,91 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hyper
+
+import (
+	"errors"
+
+	"github.com/bbva/qed/balloon/cache"
+	"github.com/bbva/qed/balloon/navigator"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/storage"
+)
+
+// Pending is an addition to the tree whose nodes are not cached yet, so
+// queries do not see it until it is committed, once its mutations are
+// stored. An addition must be committed before the next one is prepared,
+// as it is computed from the nodes cached by the previous ones.
+type Pending struct {
+	tree   *HyperTree
+	staged *stagedCache
+}
+
+// Commit caches the nodes of the addition, which becomes visible to the
+// queries.
+func (p *Pending) Commit() {
+	p.tree.Lock()
+	defer p.tree.Unlock()
+
+	for _, node := range p.staged.nodes {
+		p.tree.cache.Put(node.pos, node.digest)
+	}
+}
+
+type stagedNode struct {
+	pos    navigator.Position
+	digest hashing.Digest
+}
+
+// stagedCache keeps the nodes cached by an addition apart from the cache of
+// the tree, which is read through for the rest of them.
+type stagedCache struct {
+	cache.Cache
+	index map[string]int
+	nodes []stagedNode
+}
+
+func newStagedCache(c cache.Cache) *stagedCache {
+	return &stagedCache{
+		Cache: c,
+		index: make(map[string]int),
+	}
+}
+
+func (c *stagedCache) Get(pos navigator.Position) (hashing.Digest, bool) {
+	if i, ok := c.index[string(pos.Bytes())]; ok {
+		return c.nodes[i].digest, true
+	}
+	return c.Cache.Get(pos)
+}
+
+func (c *stagedCache) Put(pos navigator.Position, value hashing.Digest) {
+	key := string(pos.Bytes())
+	if i, ok := c.index[key]; ok {
+		c.nodes[i].digest = value
+		return
+	}
+	c.index[key] = len(c.nodes)
+	c.nodes = append(c.nodes, stagedNode{pos, value})
+}
+
+func (c *stagedCache) Fill(r storage.KVPairReader) error {
+	return errors.New("a staged cache cannot be filled")
+}
+
+func (c *stagedCache) Size() int {
+	return len(c.nodes)
+}
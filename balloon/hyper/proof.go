@@ -21,6 +21,7 @@ import (
 
 	"github.com/bbva/qed/balloon/visitor"
 	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/storage"
 )
 
 type QueryProof struct {
@@ -43,13 +44,13 @@ func (p QueryProof) AuditPath() visitor.AuditPath {
 }
 
 // Verify verifies a membership query for a provided key from an expected
-// root hash that fixes the hyper tree. Returns true if the proof is valid,
-// false otherwise.
+// root hash that fixes the hyper tree. A proof without value is verified as
+// a proof of non-membership. Returns true if the proof is valid, false
+// otherwise.
 func (p QueryProof) Verify(key []byte, expectedDigest hashing.Digest) (valid bool) {
 
-	if len(p.auditPath) == 0 {
-		// and empty audit path shows non-membership for any key
-		return p.Value == nil
+	if p.Value == nil {
+		return p.verifyNonMembership(key, expectedDigest)
 	}
 
 	// visitors
@@ -75,3 +76,99 @@ func (p QueryProof) Verify(key []byte, expectedDigest hashing.Digest) (valid boo
 
 	return bytes.Equal(key, p.Key) && bytes.Equal(recomputed, expectedDigest)
 }
+
+func (p QueryProof) verifyNonMembership(key []byte, expectedDigest hashing.Digest) bool {
+
+	numBits := p.hasher.Len()
+	if !bytes.Equal(key, p.Key) || len(key)*8 != int(numBits) || len(p.auditPath) == 0 {
+		return false
+	}
+
+	// visitors
+	computeHash := visitor.NewComputeHashVisitor(p.hasher)
+
+	// build pruning context
+	context := PruningContext{
+		navigator:     NewHyperTreeNavigator(numBits),
+		cacheResolver: NewSingleTargetedCacheResolver(numBits, 0, key),
+		cache:         p.auditPath,
+		store:         nil,
+		defaultHashes: DefaultHashes(p.hasher),
+	}
+
+	// traverse from root and generate a visitable pruned tree
+	pruned, err := NewVerifyNonMembershipPruner(key, context).Prune()
+	if err != nil {
+		return false
+	}
+
+	// visit the pruned tree
+	recomputed := pruned.PostOrder(computeHash).(hashing.Digest)
+
+	return bytes.Equal(recomputed, expectedDigest)
+}
+
+// BatchQueryProof proves the membership or non-membership of several keys
+// at once. The values of the keys that are not in the tree are nil.
+type BatchQueryProof struct {
+	Keys, Values [][]byte
+	auditPath    visitor.AuditPath
+	hasher       hashing.Hasher
+}
+
+func NewBatchQueryProof(keys, values [][]byte, auditPath visitor.AuditPath, hasher hashing.Hasher) *BatchQueryProof {
+	return &BatchQueryProof{
+		Keys:      keys,
+		Values:    values,
+		auditPath: auditPath,
+		hasher:    hasher,
+	}
+}
+
+func (p BatchQueryProof) AuditPath() visitor.AuditPath {
+	return p.auditPath
+}
+
+// Verify verifies the keys and values of the proof from an expected root
+// hash that fixes the hyper tree. Returns true if the proof is valid for
+// every key, false otherwise.
+func (p BatchQueryProof) Verify(expectedDigest hashing.Digest) bool {
+
+	numBits := p.hasher.Len()
+	if len(p.Keys) == 0 || len(p.Keys) != len(p.Values) {
+		return false
+	}
+
+	leaves := storage.NewKVRange()
+	for i, key := range p.Keys {
+		if len(key)*8 != int(numBits) {
+			return false
+		}
+		if p.Values[i] != nil {
+			leaves = leaves.InsertSorted(storage.NewKVPair(key, p.Values[i]))
+		}
+	}
+
+	// visitors
+	computeHash := visitor.NewComputeHashVisitor(p.hasher)
+
+	// build pruning context
+	context := PruningContext{
+		navigator:     NewHyperTreeNavigator(numBits),
+		cacheResolver: NewMultiTargetedCacheResolver(numBits, 0, p.Keys),
+		cache:         p.auditPath,
+		store:         nil,
+		defaultHashes: DefaultHashes(p.hasher),
+	}
+
+	// traverse from root and generate a visitable pruned tree
+	pruned, err := NewVerifyBatchPruner(leaves, context).Prune()
+	if err != nil {
+		return false
+	}
+
+	// visit the pruned tree
+	recomputed := pruned.PostOrder(computeHash).(hashing.Digest)
+
+	return bytes.Equal(recomputed, expectedDigest)
+}
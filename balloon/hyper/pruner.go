@@ -17,6 +17,7 @@
 package hyper
 
 import (
+	"bytes"
 	"errors"
 
 	"github.com/bbva/qed/balloon/cache"
@@ -44,18 +45,24 @@ var (
 )
 
 type InsertPruner struct {
-	key   hashing.Digest
-	value []byte
+	leaves storage.KVRange
 	PruningContext
 }
 
 func NewInsertPruner(key, value []byte, context PruningContext) *InsertPruner {
-	return &InsertPruner{key, value, context}
+	leaves := storage.KVRange{storage.NewKVPair(key, value)}
+	return &InsertPruner{leaves, context}
+}
+
+// NewBulkInsertPruner returns a pruner that inserts a sorted range of
+// leaves that have not been persisted yet. It is used to chain several
+// insertions before mutating the store.
+func NewBulkInsertPruner(leaves storage.KVRange, context PruningContext) *InsertPruner {
+	return &InsertPruner{leaves, context}
 }
 
 func (p *InsertPruner) Prune() (visitor.Visitable, error) {
-	leaves := storage.KVRange{storage.NewKVPair(p.key, p.value)}
-	return p.traverse(p.navigator.Root(), leaves)
+	return p.traverse(p.navigator.Root(), p.leaves)
 }
 
 func (p *InsertPruner) traverse(pos navigator.Position, leaves storage.KVRange) (visitor.Visitable, error) {
@@ -152,12 +159,28 @@ func (p *InsertPruner) traverseWithoutCache(pos navigator.Position, leaves stora
 }
 
 type SearchPruner struct {
-	key []byte
+	key         []byte
+	stopAtEmpty bool
 	PruningContext
 }
 
 func NewSearchPruner(key []byte, context PruningContext) *SearchPruner {
-	return &SearchPruner{key, context}
+	return &SearchPruner{key, false, context}
+}
+
+// NewNonMembershipSearchPruner returns a pruner for a key that is not in the
+// tree. It stops at the highest empty subtree on the path to the key, whose
+// digest is the default one, instead of recomputing it from its children.
+func NewNonMembershipSearchPruner(key []byte, context PruningContext) *SearchPruner {
+	return &SearchPruner{key, true, context}
+}
+
+// NewBatchSearchPruner returns a pruner for the paths to several keys at
+// once, which must be resolved by the cache resolver of the context. The
+// keys that are not in the tree end at the highest empty subtree on their
+// paths, as with NewNonMembershipSearchPruner.
+func NewBatchSearchPruner(context PruningContext) *SearchPruner {
+	return &SearchPruner{nil, true, context}
 }
 
 func (p *SearchPruner) Prune() (visitor.Visitable, error) {
@@ -174,6 +197,15 @@ func (p *SearchPruner) traverseCache(pos navigator.Position, leaves storage.KVRa
 		return visitor.NewCollectable(visitor.NewCached(pos, digest)), nil
 	}
 
+	// every non empty node over the cache level is cached, so a missing
+	// node on the path is an empty subtree
+	if p.stopAtEmpty && !p.navigator.IsRoot(pos) {
+		if _, ok := p.cache.Get(pos); !ok {
+			cached := visitor.NewCached(pos, p.defaultHashes[pos.Height()])
+			return visitor.NewCollectable(cached), nil
+		}
+	}
+
 	// if we are over the cache level, we need to do a range query to get the leaves
 	var atLastLevel bool
 	if atLastLevel = p.cacheResolver.ShouldCache(pos); atLastLevel {
@@ -352,3 +384,120 @@ func (p *VerifyPruner) traverse(pos navigator.Position, leaves storage.KVRange)
 	}
 	return visitor.NewNode(pos, left, right), nil
 }
+
+// VerifyNonMembershipPruner follows the path to a key that should not be in
+// the tree. The audit path must contain every sibling on that path until
+// reaching an empty subtree, whose digest must be the default one.
+type VerifyNonMembershipPruner struct {
+	key hashing.Digest
+	PruningContext
+}
+
+func NewVerifyNonMembershipPruner(key []byte, context PruningContext) *VerifyNonMembershipPruner {
+	return &VerifyNonMembershipPruner{key, context}
+}
+
+func (p *VerifyNonMembershipPruner) Prune() (visitor.Visitable, error) {
+	return p.traverse(p.navigator.Root())
+}
+
+func (p *VerifyNonMembershipPruner) traverse(pos navigator.Position) (visitor.Visitable, error) {
+	if !p.navigator.IsRoot(pos) {
+		digest, ok := p.cache.Get(pos)
+		if !p.cacheResolver.IsOnPath(pos) {
+			if !ok {
+				return nil, ErrWrongAuditPath
+			}
+			return visitor.NewCached(pos, digest), nil
+		}
+		if ok {
+			// the empty subtree that proves the absence of the key
+			if !bytes.Equal(digest, p.defaultHashes[pos.Height()]) {
+				return nil, ErrWrongAuditPath
+			}
+			return visitor.NewCached(pos, digest), nil
+		}
+	}
+	if p.navigator.IsLeaf(pos) {
+		// we reached the leaf without finding an empty subtree
+		return nil, ErrWrongAuditPath
+	}
+
+	// we do a post-order traversal
+	left, err := p.traverse(p.navigator.GoToLeft(pos))
+	if err != nil {
+		return nil, err
+	}
+	right, err := p.traverse(p.navigator.GoToRight(pos))
+	if err != nil {
+		return nil, err
+	}
+	if p.navigator.IsRoot(pos) {
+		return visitor.NewRoot(pos, left, right), nil
+	}
+	return visitor.NewNode(pos, left, right), nil
+}
+
+// VerifyBatchPruner recomputes the root of the tree from the audit path of
+// several keys at once. The leaves are the keys in the tree along with
+// their values, and the rest of the keys resolved by the cache resolver
+// must end at an empty subtree, whose digest must be the default one.
+type VerifyBatchPruner struct {
+	leaves storage.KVRange
+	PruningContext
+}
+
+func NewVerifyBatchPruner(leaves storage.KVRange, context PruningContext) *VerifyBatchPruner {
+	return &VerifyBatchPruner{leaves, context}
+}
+
+func (p *VerifyBatchPruner) Prune() (visitor.Visitable, error) {
+	return p.traverse(p.navigator.Root(), p.leaves)
+}
+
+func (p *VerifyBatchPruner) traverse(pos navigator.Position, leaves storage.KVRange) (visitor.Visitable, error) {
+	if p.navigator.IsLeaf(pos) && len(leaves) == 1 {
+		return visitor.NewLeaf(pos, leaves[0].Value), nil
+	}
+	if len(leaves) > 1 && p.navigator.IsLeaf(pos) {
+		return nil, ErrLeavesSlice
+	}
+	if !p.navigator.IsRoot(pos) && len(leaves) == 0 {
+		digest, ok := p.cache.Get(pos)
+		if !p.cacheResolver.IsOnPath(pos) {
+			if !ok {
+				return nil, ErrWrongAuditPath
+			}
+			return visitor.NewCached(pos, digest), nil
+		}
+		if ok {
+			// the empty subtree that proves the absence of the keys below
+			if !bytes.Equal(digest, p.defaultHashes[pos.Height()]) {
+				return nil, ErrWrongAuditPath
+			}
+			return visitor.NewCached(pos, digest), nil
+		}
+		if p.navigator.IsLeaf(pos) {
+			// we reached the leaf without finding an empty subtree
+			return nil, ErrWrongAuditPath
+		}
+	}
+
+	// we do a post-order traversal
+
+	// split leaves
+	rightPos := p.navigator.GoToRight(pos)
+	leftSlice, rightSlice := leaves.Split(rightPos.Index())
+	left, err := p.traverse(p.navigator.GoToLeft(pos), leftSlice)
+	if err != nil {
+		return nil, err
+	}
+	right, err := p.traverse(rightPos, rightSlice)
+	if err != nil {
+		return nil, err
+	}
+	if p.navigator.IsRoot(pos) {
+		return visitor.NewRoot(pos, left, right), nil
+	}
+	return visitor.NewNode(pos, left, right), nil
+}
@@ -35,6 +35,11 @@ const (
 	CacheSize int64 = (1 << 26) * 68 // 2^26 elements * 68 bytes for entry
 )
 
+// HyperTree is safe for concurrent use. Additions are computed sharing the
+// lock with the queries, which run in parallel using their own hashers, as
+// the one of the tree is only meant for additions, and only take it
+// exclusively to cache their nodes. Additions must not run in parallel
+// with each other.
 type HyperTree struct {
 	store         storage.Store
 	cache         cache.ModifiableCache
@@ -47,52 +52,148 @@ type HyperTree struct {
 }
 
 func NewHyperTree(hasherF func() hashing.Hasher, store storage.Store, cache cache.ModifiableCache) *HyperTree {
+	tree := newHyperTree(hasherF, store, cache)
+
+	// warm-up cache
+	tree.RebuildCache()
+
+	return tree
+}
+
+// NewHyperTreeFromCheckpoint returns a tree whose cache is loaded from the
+// checkpoint at the given path, replaying the versions added after it up to
+// the current one. If the checkpoint cannot be used, the cache is rebuilt
+// from the store as NewHyperTree does.
+func NewHyperTreeFromCheckpoint(hasherF func() hashing.Hasher, store storage.Store, cache cache.ModifiableCache, path string, currentVersion uint64) *HyperTree {
+	tree := newHyperTree(hasherF, store, cache)
+
+	if err := tree.LoadCheckpoint(path, currentVersion); err != nil {
+		// the entries already loaded are overwritten by the rebuild
+		log.Infof("Unable to load hyper cache checkpoint, rebuilding it: %v", err)
+		tree.RebuildCache()
+	}
+
+	return tree
+}
+
+func newHyperTree(hasherF func() hashing.Hasher, store storage.Store, cache cache.ModifiableCache) *HyperTree {
 	hasher := hasherF()
-	cacheLevel := hasher.Len() - uint16(math.Max(float64(2), math.Floor(float64(hasher.Len())/10)))
-	tree := &HyperTree{
+	return &HyperTree{
 		store:         store,
 		cache:         cache,
 		hasherF:       hasherF,
-		cacheLevel:    cacheLevel,
-		defaultHashes: make([]hashing.Digest, hasher.Len()),
+		cacheLevel:    cacheLevelOf(hasher),
+		defaultHashes: DefaultHashes(hasher),
 		hasher:        hasher,
 	}
+}
+
+// cacheLevelOf returns the height of the lowest cached nodes, which are
+// the ones stored to warm up the cache.
+func cacheLevelOf(hasher hashing.Hasher) uint16 {
+	return hasher.Len() - uint16(math.Max(float64(2), math.Floor(float64(hasher.Len())/10)))
+}
 
-	tree.defaultHashes[0] = tree.hasher.Do([]byte{0x0}, []byte{0x0})
+// DefaultHashes returns the digests of the empty subtrees for
+// every height of the tree.
+func DefaultHashes(hasher hashing.Hasher) []hashing.Digest {
+	defaultHashes := make([]hashing.Digest, hasher.Len())
+	defaultHashes[0] = hashing.DoLeaf(hasher, []byte{0x0}, []byte{0x0})
 	for i := uint16(1); i < hasher.Len(); i++ {
-		tree.defaultHashes[i] = tree.hasher.Do(tree.defaultHashes[i-1], tree.defaultHashes[i-1])
+		defaultHashes[i] = hashing.DoNode(hasher, defaultHashes[i-1], defaultHashes[i-1])
 	}
+	return defaultHashes
+}
 
-	// warm-up cache
-	tree.RebuildCache()
+func (t *HyperTree) Add(eventDigest hashing.Digest, version uint64) (hashing.Digest, []*storage.Mutation, error) {
+	return t.AddValue(eventDigest, version, util.Uint64AsBytes(version))
+}
 
-	return tree
+// AddValue inserts an event digest added at the given version, as Add does,
+// but the value of its leaf is the given one instead of the version.
+func (t *HyperTree) AddValue(eventDigest hashing.Digest, version uint64, value []byte) (hashing.Digest, []*storage.Mutation, error) {
+	rootHashes, mutations, err := t.AddBulkValues([]hashing.Digest{eventDigest}, version, [][]byte{value})
+	if err != nil {
+		return nil, nil, err
+	}
+	return rootHashes[0], mutations, nil
 }
 
-func (t *HyperTree) Add(eventDigest hashing.Digest, version uint64) (hashing.Digest, []*storage.Mutation, error) {
-	t.Lock()
-	defer t.Unlock()
+// AddBulk inserts a list of event digests with consecutive versions starting
+// at initialVersion. It returns the root hash after each insertion and the
+// mutations of the whole bulk, which must be applied to the store at once.
+func (t *HyperTree) AddBulk(eventDigests []hashing.Digest, initialVersion uint64) ([]hashing.Digest, []*storage.Mutation, error) {
+	values := make([][]byte, len(eventDigests))
+	for i := range eventDigests {
+		values[i] = util.Uint64AsBytes(initialVersion + uint64(i))
+	}
+	return t.AddBulkValues(eventDigests, initialVersion, values)
+}
+
+// AddBulkValues inserts a list of event digests as AddBulk does, but the
+// values of their leaves are the given ones instead of the versions.
+func (t *HyperTree) AddBulkValues(eventDigests []hashing.Digest, initialVersion uint64, values [][]byte) ([]hashing.Digest, []*storage.Mutation, error) {
+	rootHashes, mutations, pending, err := t.Prepare(eventDigests, initialVersion, values)
+	if err != nil {
+		return nil, nil, err
+	}
+	pending.Commit()
+	return rootHashes, mutations, nil
+}
+
+// Prepare computes the root hashes and mutations of inserting a list of
+// event digests as AddBulkValues does, but the cache is only updated once
+// the returned addition is committed. Queries run in parallel meanwhile.
+func (t *HyperTree) Prepare(eventDigests []hashing.Digest, initialVersion uint64, values [][]byte) ([]hashing.Digest, []*storage.Mutation, *Pending, error) {
+	t.RLock()
+	defer t.RUnlock()
 
 	// Activate metrics gathering
 	stats := metrics.Hyper
 
+	staged := newStagedCache(t.cache)
+	rootHashes := make([]hashing.Digest, len(eventDigests))
+	mutations := make([]*storage.Mutation, 0)
+
+	// leaves inserted by previous events in the bulk are not in the store yet
+	// so we need to carry them along to compute every intermediate root hash
+	pending := storage.NewKVRange()
+	for i, eventDigest := range eventDigests {
+		versionAsBytes := util.Uint64AsBytes(initialVersion + uint64(i))
+		pending = pending.InsertSorted(storage.NewKVPair(eventDigest, values[i]))
+
+		rootHash, eventMutations, err := t.add(eventDigest, versionAsBytes, values[i], pending, staged)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		rootHashes[i] = rootHash
+		mutations = append(mutations, eventMutations...)
+	}
+
+	// Increment add hits
+	stats.Add("add_hits", int64(len(eventDigests)))
+
+	return rootHashes, mutations, &Pending{tree: t, staged: staged}, nil
+}
+
+func (t *HyperTree) add(eventDigest hashing.Digest, versionAsBytes, value []byte, leaves storage.KVRange, staged *stagedCache) (hashing.Digest, []*storage.Mutation, error) {
+
 	// visitors
 	computeHash := visitor.NewComputeHashVisitor(t.hasher)
-	caching := visitor.NewCachingVisitor(computeHash, t.cache)
+	caching := visitor.NewCachingVisitor(computeHash, staged)
 	collect := visitor.NewCollectMutationsVisitor(caching, storage.HyperCachePrefix)
 
 	// build pruning context
-	versionAsBytes := util.Uint64AsBytes(version)
 	context := PruningContext{
 		navigator:     NewHyperTreeNavigator(t.hasher.Len()),
 		cacheResolver: NewSingleTargetedCacheResolver(t.hasher.Len(), t.cacheLevel, eventDigest),
-		cache:         t.cache,
+		cache:         staged,
 		store:         t.store,
 		defaultHashes: t.defaultHashes,
 	}
 
 	// traverse from root and generate a visitable pruned tree
-	pruned, err := NewInsertPruner(eventDigest, versionAsBytes, context).Prune()
+	pruned, err := NewBulkInsertPruner(leaves, context).Prune()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -101,26 +202,50 @@ func (t *HyperTree) Add(eventDigest hashing.Digest, version uint64) (hashing.Dig
 	rootHash := pruned.PostOrder(collect).(hashing.Digest)
 
 	// create a mutation for the new leaf
-	leafMutation := storage.NewMutation(storage.IndexPrefix, eventDigest, versionAsBytes)
+	leafMutation := storage.NewMutation(storage.IndexPrefix, eventDigest, value)
 
-	// collect mutations
-	mutations := append(collect.Result(), leafMutation)
+	// keep the leaf updated by every version, so the cache can be
+	// brought up to date from a checkpoint
+	journalMutation := storage.NewMutation(storage.HyperLeafPrefix, versionAsBytes, eventDigest)
 
-	// Increment add hits
-	stats.Add("add_hits", 1)
+	// collect mutations
+	mutations := append(collect.Result(), leafMutation, journalMutation)
 
 	return rootHash, mutations, nil
 }
 
+// RootHash returns the current root hash of the tree, which is computed
+// from the cached children of the root.
+func (t *HyperTree) RootHash() hashing.Digest {
+	t.RLock()
+	defer t.RUnlock()
+
+	nav := NewHyperTreeNavigator(t.hasher.Len())
+	root := nav.Root()
+	children := make([]hashing.Digest, 2)
+	for i, pos := range []navigator.Position{nav.GoToLeft(root), nav.GoToRight(root)} {
+		digest, ok := t.cache.Get(pos)
+		if !ok {
+			digest = t.defaultHashes[pos.Height()]
+		}
+		children[i] = digest
+	}
+	return hashing.SaltedNode(t.hasherF(), root.Bytes(), children[0], children[1])
+}
+
+// QueryMembership returns a proof for the given event digest. When the event
+// has not been inserted, version must be nil and the proof contains the audit
+// path to the highest empty subtree on the path to the event digest, which
+// proves its absence.
 func (t *HyperTree) QueryMembership(eventDigest hashing.Digest, version []byte) (proof *QueryProof, err error) {
-	t.Lock()
-	defer t.Unlock()
+	t.RLock()
+	defer t.RUnlock()
 
 	stats := metrics.Hyper
 	stats.Add("QueryMembership_hits", 1)
 
 	// visitors
-	computeHash := visitor.NewComputeHashVisitor(t.hasher)
+	computeHash := visitor.NewComputeHashVisitor(t.hasherF())
 	calcAuditPath := visitor.NewAuditPathVisitor(computeHash)
 
 	// build pruning context
@@ -133,7 +258,13 @@ func (t *HyperTree) QueryMembership(eventDigest hashing.Digest, version []byte)
 	}
 
 	// traverse from root and generate a visitable pruned tree
-	pruned, err := NewSearchPruner(eventDigest, context).Prune()
+	var pruner Pruner
+	if version == nil {
+		pruner = NewNonMembershipSearchPruner(eventDigest, context)
+	} else {
+		pruner = NewSearchPruner(eventDigest, context)
+	}
+	pruned, err := pruner.Prune()
 	if err != nil {
 		return nil, err
 	}
@@ -145,15 +276,55 @@ func (t *HyperTree) QueryMembership(eventDigest hashing.Digest, version []byte)
 	return NewQueryProof(eventDigest, version, calcAuditPath.Result(), t.hasherF()), nil
 }
 
+// QueryBatchMembership returns a single proof for several event digests,
+// where the nodes shared by their audit paths are included only once. The
+// versions of the events that have not been inserted must be nil.
+func (t *HyperTree) QueryBatchMembership(eventDigests []hashing.Digest, versions [][]byte) (*BatchQueryProof, error) {
+	t.RLock()
+	defer t.RUnlock()
+
+	stats := metrics.Hyper
+	stats.Add("QueryBatchMembership_hits", 1)
+
+	keys := make([][]byte, len(eventDigests))
+	for i, digest := range eventDigests {
+		keys[i] = digest
+	}
+
+	// visitors
+	computeHash := visitor.NewComputeHashVisitor(t.hasherF())
+	calcAuditPath := visitor.NewAuditPathVisitor(computeHash)
+
+	// build pruning context
+	context := PruningContext{
+		navigator:     NewHyperTreeNavigator(t.hasher.Len()),
+		cacheResolver: NewMultiTargetedCacheResolver(t.hasher.Len(), t.cacheLevel, keys),
+		cache:         t.cache,
+		store:         t.store,
+		defaultHashes: t.defaultHashes,
+	}
+
+	// traverse from root and generate a visitable pruned tree
+	pruned, err := NewBatchSearchPruner(context).Prune()
+	if err != nil {
+		return nil, err
+	}
+
+	// visit the pruned tree
+	pruned.PostOrder(calcAuditPath)
+
+	return NewBatchQueryProof(keys, versions, calcAuditPath.Result(), t.hasherF()), nil
+}
+
 func (t *HyperTree) VerifyMembership(proof *QueryProof, version uint64, eventDigest, expectedDigest hashing.Digest) bool {
-	t.Lock()
-	defer t.Unlock()
+	t.RLock()
+	defer t.RUnlock()
 
 	log.Debugf("Verifying membership for eventDigest %x", eventDigest)
 	stats := metrics.Hyper
 	stats.Add("VerifyMembership_hits", 1)
 	// visitors
-	computeHash := visitor.NewComputeHashVisitor(t.hasher)
+	computeHash := visitor.NewComputeHashVisitor(t.hasherF())
 
 	// build pruning context
 	versionAsBytes := util.Uint64AsBytes(version)
@@ -236,7 +407,7 @@ func (t *HyperTree) populateCache(pos navigator.Position, nav navigator.TreeNavi
 		right = t.defaultHashes[rightPos.Height()]
 	}
 
-	digest := t.hasher.Salted(pos.Bytes(), left, right)
+	digest := hashing.SaltedNode(t.hasher, pos.Bytes(), left, right)
 	t.cache.Put(pos, digest)
 	return digest
 }
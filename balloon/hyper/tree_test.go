@@ -17,6 +17,7 @@
 package hyper
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -67,6 +68,40 @@ func TestAdd(t *testing.T) {
 	}
 }
 
+func TestAddBulk(t *testing.T) {
+
+	log.SetLogger("TestAddBulk", log.SILENT)
+
+	eventDigests := make([]hashing.Digest, 0)
+	for i := 0; i < 64; i++ {
+		eventDigests = append(eventDigests, hashing.Digest{byte(i * 3)})
+	}
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	tree := NewHyperTree(hashing.NewFakeXorHasher, store, cache.NewSimpleCache(10))
+
+	bulkStore, bulkCloseF := storage_utils.OpenBPlusTreeStore()
+	defer bulkCloseF()
+	bulkTree := NewHyperTree(hashing.NewFakeXorHasher, bulkStore, cache.NewSimpleCache(10))
+
+	// two bulks to check that the second one sees the stored leaves
+	for b, bulk := range [][]hashing.Digest{eventDigests[:32], eventDigests[32:]} {
+		initialVersion := uint64(b * 32)
+
+		rootHashes, mutations, err := bulkTree.AddBulk(bulk, initialVersion)
+		require.NoError(t, err)
+		require.NoError(t, bulkStore.Mutate(mutations))
+
+		for i, eventDigest := range bulk {
+			expected, mutations, err := tree.Add(eventDigest, initialVersion+uint64(i))
+			require.NoError(t, err)
+			require.NoError(t, store.Mutate(mutations))
+			assert.Equalf(t, expected, rootHashes[i], "Incorrect root hash for index %d", initialVersion+uint64(i))
+		}
+	}
+}
+
 func TestProveMembership(t *testing.T) {
 
 	log.SetLogger("TestProveMembership", log.SILENT)
@@ -170,6 +205,115 @@ func TestAddAndVerify(t *testing.T) {
 	}
 }
 
+func TestProveNonMembership(t *testing.T) {
+
+	log.SetLogger("TestProveNonMembership", log.SILENT)
+
+	hasher := hashing.NewSha256Hasher()
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	tree := NewHyperTree(hashing.NewSha256Hasher, store, cache.NewSimpleCache(10))
+
+	var rootHash hashing.Digest
+	for i := uint64(0); i < 10; i++ {
+		var mutations []*storage.Mutation
+		var err error
+		rootHash, mutations, err = tree.Add(hasher.Do(rand.Bytes(32)), i)
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	// an absent key must be proven against the current root hash
+	absentKey := hasher.Do([]byte("a non inserted event"))
+	proof, err := tree.QueryMembership(absentKey, nil)
+	require.NoError(t, err)
+	assert.True(t, proof.Verify(absentKey, rootHash), "The non-membership proof should be valid")
+	assert.False(t, proof.Verify(absentKey, hasher.Do([]byte("wrong root"))), "The proof should fail with a wrong root hash")
+	assert.False(t, proof.Verify(hasher.Do([]byte("another key")), rootHash), "The proof should fail for another key")
+
+	// tampering the empty subtree must invalidate the proof
+	tampered := make(visitor.AuditPath)
+	for k, v := range proof.AuditPath() {
+		tampered[k] = v
+	}
+	for k, v := range tampered {
+		for _, d := range tree.defaultHashes {
+			if bytes.Equal(v, d) {
+				tampered[k] = hasher.Do([]byte("tampered"))
+			}
+		}
+	}
+	tamperedProof := NewQueryProof(absentKey, nil, tampered, hasher)
+	assert.False(t, tamperedProof.Verify(absentKey, rootHash), "A tampered proof should fail")
+
+	// a present key cannot be proven as absent
+	leaves := store.GetAll(storage.IndexPrefix)
+	buffer := make([]*storage.KVPair, 1)
+	_, err = leaves.Read(buffer)
+	leaves.Close()
+	require.NoError(t, err)
+	presentKey := buffer[0].Key
+	memberProof, err := tree.QueryMembership(presentKey, buffer[0].Value)
+	require.NoError(t, err)
+	fakeProof := NewQueryProof(presentKey, nil, memberProof.AuditPath(), hasher)
+	assert.False(t, fakeProof.Verify(presentKey, rootHash), "A member key should not be proven as absent")
+}
+
+func TestProveBatchMembership(t *testing.T) {
+
+	log.SetLogger("TestProveBatchMembership", log.SILENT)
+
+	hasher := hashing.NewSha256Hasher()
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+	tree := NewHyperTree(hashing.NewSha256Hasher, store, cache.NewSimpleCache(10))
+
+	var rootHash hashing.Digest
+	digests := make([]hashing.Digest, 100)
+	for i := range digests {
+		var mutations []*storage.Mutation
+		var err error
+		digests[i] = hasher.Do(rand.Bytes(32))
+		rootHash, mutations, err = tree.Add(digests[i], uint64(i))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	// some members and some absent keys
+	var keys []hashing.Digest
+	var values [][]byte
+	singleSize := 0
+	for i := 0; i < 100; i += 10 {
+		keys = append(keys, digests[i])
+		values = append(values, util.Uint64AsBytes(uint64(i)))
+		single, err := tree.QueryMembership(digests[i], util.Uint64AsBytes(uint64(i)))
+		require.NoError(t, err)
+		singleSize += len(single.AuditPath())
+	}
+	for i := 0; i < 5; i++ {
+		keys = append(keys, hasher.Do(rand.Bytes(32)))
+		values = append(values, nil)
+	}
+
+	proof, err := tree.QueryBatchMembership(keys, values)
+	require.NoError(t, err)
+	assert.True(t, proof.Verify(rootHash), "The batch proof should be valid")
+	assert.False(t, proof.Verify(hasher.Do([]byte("wrong root"))), "The proof should fail with a wrong root hash")
+	assert.True(t, len(proof.AuditPath()) < singleSize, "The shared nodes should not be repeated")
+
+	wrongVersion := NewBatchQueryProof(proof.Keys, append([][]byte{util.Uint64AsBytes(1)}, proof.Values[1:]...), proof.AuditPath(), hasher)
+	assert.False(t, wrongVersion.Verify(rootHash), "The proof should fail with a wrong version")
+
+	absentMember := NewBatchQueryProof(proof.Keys, append([][]byte{nil}, proof.Values[1:]...), proof.AuditPath(), hasher)
+	assert.False(t, absentMember.Verify(rootHash), "A member key should not be proven as absent")
+
+	last := len(proof.Values) - 1
+	presentAbsent := NewBatchQueryProof(proof.Keys, append(append([][]byte{}, proof.Values[:last]...), util.Uint64AsBytes(0)), proof.AuditPath(), hasher)
+	assert.False(t, presentAbsent.Verify(rootHash), "An absent key should not be proven as member")
+}
+
 func TestDeterministicAdd(t *testing.T) {
 
 	log.SetLogger("TestDeterministicAdd", log.SILENT)
@@ -0,0 +1,434 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package balloon
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/bbva/qed/balloon/history"
+	"github.com/bbva/qed/balloon/hyper"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/metrics"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
+)
+
+// In KeyValueMode the hyper tree maps the digest of a key to the version
+// of its latest update, and every update is appended to the history tree as
+// an entry digest that commits to the key, the value and the version of
+// the previous update of the same key. The first update of a key points to
+// itself. That way, the updates of a key form a chain that clients can
+// follow backwards to check that no value has been left out.
+
+// KeyValueEntry is an update of the value of a key along with the proof of
+// its membership in the history tree.
+type KeyValueEntry struct {
+	Version      uint64
+	Previous     uint64
+	Value        []byte
+	HistoryProof *history.MembershipProof
+}
+
+// EntryDigest returns the digest stored in the history tree for the update
+// of a key.
+func EntryDigest(hasher hashing.Hasher, keyDigest hashing.Digest, previous uint64, value []byte) hashing.Digest {
+	return hasher.Do(keyDigest, util.Uint64AsBytes(previous), value)
+}
+
+func (e KeyValueEntry) isFirst() bool {
+	return e.Previous == e.Version
+}
+
+// startsBefore returns true if the entry is the first update of a key made
+// from the given version.
+func (e KeyValueEntry) startsBefore(version uint64) bool {
+	return e.isFirst() || e.Previous < version
+}
+
+func (e KeyValueEntry) verify(keyDigest hashing.Digest, snapshot *Snapshot, hasher hashing.Hasher) bool {
+	if e.HistoryProof == nil || e.HistoryProof.Index != e.Version || e.Previous > e.Version {
+		return false
+	}
+	digest := EntryDigest(hasher, keyDigest, e.Previous, e.Value)
+	return e.HistoryProof.Verify(digest, snapshot.HistoryDigest)
+}
+
+// KeyValueProof is the struct required to verify the current value of a
+// key. It has the hyper proof of the latest version of the key and the
+// entry of that version. If the key does not exist, the hyper proof is a
+// proof of non-membership and there is no entry.
+type KeyValueProof struct {
+	Exists         bool
+	Key            []byte
+	KeyDigest      hashing.Digest
+	Entry          *KeyValueEntry
+	HyperProof     *hyper.QueryProof
+	CurrentVersion uint64
+	Hasher         hashing.Hasher
+}
+
+// Verify verifies a proof and answer from QueryKeyValue against the
+// snapshot of the current version. Returns true if the answer and proof
+// are correct and consistent, otherwise false.
+// Run by a client on input that should be verified.
+func (p KeyValueProof) Verify(key []byte, snapshot *Snapshot) bool {
+	keyDigest := p.Hasher.Do(key)
+	if p.HyperProof == nil || !bytes.Equal(keyDigest, p.KeyDigest) {
+		return false
+	}
+
+	if !p.Exists {
+		return p.Entry == nil &&
+			p.HyperProof.Value == nil &&
+			p.HyperProof.Verify(keyDigest, snapshot.HyperDigest)
+	}
+
+	if p.Entry == nil || !bytes.Equal(p.HyperProof.Value, util.Uint64AsBytes(p.Entry.Version)) {
+		return false
+	}
+
+	return p.HyperProof.Verify(keyDigest, snapshot.HyperDigest) &&
+		p.Entry.verify(keyDigest, snapshot, p.Hasher)
+}
+
+// KeyHistoryProof is the struct required to verify all the values of a key
+// between two versions. It has the hyper proof of the latest version of
+// the key, the entries between the Start and End versions and, if any, the
+// first entry after the End version. All the entries are proved against
+// the history tree of the current version.
+type KeyHistoryProof struct {
+	Exists         bool
+	Key            []byte
+	KeyDigest      hashing.Digest
+	Start, End     uint64
+	LatestVersion  uint64
+	Entries        []*KeyValueEntry
+	Next           *KeyValueEntry
+	HyperProof     *hyper.QueryProof
+	CurrentVersion uint64
+	Hasher         hashing.Hasher
+}
+
+// Verify verifies a proof and answer from QueryKeyHistory against the
+// snapshot of the current version. It checks that every entry belongs to
+// the history tree and that the entries form an unbroken chain from the
+// last update before the Start version to the first update after the End
+// version or the latest one. Returns true if the answer and proof are
+// correct and complete, otherwise false.
+// Run by a client on input that should be verified.
+func (p KeyHistoryProof) Verify(key []byte, snapshot *Snapshot) bool {
+	keyDigest := p.Hasher.Do(key)
+	if p.HyperProof == nil || !bytes.Equal(keyDigest, p.KeyDigest) || p.Start > p.End {
+		return false
+	}
+
+	if !p.Exists {
+		return len(p.Entries) == 0 && p.Next == nil &&
+			p.HyperProof.Value == nil &&
+			p.HyperProof.Verify(keyDigest, snapshot.HyperDigest)
+	}
+
+	if !bytes.Equal(p.HyperProof.Value, util.Uint64AsBytes(p.LatestVersion)) ||
+		!p.HyperProof.Verify(keyDigest, snapshot.HyperDigest) {
+		return false
+	}
+
+	var last *KeyValueEntry
+	for _, e := range p.Entries {
+		if e == nil || e.Version < p.Start || e.Version > p.End {
+			return false
+		}
+		if last == nil && !e.startsBefore(p.Start) {
+			return false
+		}
+		if last != nil && e.Previous != last.Version {
+			return false
+		}
+		if !e.verify(keyDigest, snapshot, p.Hasher) {
+			return false
+		}
+		last = e
+	}
+
+	if p.Next == nil {
+		// there are no updates after the range, so the last entry must
+		// be the latest one
+		if last == nil {
+			return p.LatestVersion < p.Start
+		}
+		return last.Version == p.LatestVersion
+	}
+
+	if p.Next.Version <= p.End || p.Next.Version > p.LatestVersion {
+		return false
+	}
+	if last == nil && !p.Next.startsBefore(p.Start) {
+		return false
+	}
+	if last != nil && p.Next.Previous != last.Version {
+		return false
+	}
+	return p.Next.verify(keyDigest, snapshot, p.Hasher)
+}
+
+func keyValueKey(keyDigest hashing.Digest, version uint64) []byte {
+	return append(append([]byte{}, keyDigest...), util.Uint64AsBytes(version)...)
+}
+
+// AddKeyValue sets the value of a key. The hyper tree is updated to point
+// to the new version and the entry of the update is appended to the
+// history tree. The value is stored along with the previous version of the
+// key so it can be returned in later queries. The balloon must be in
+// KeyValueMode, where the duplicate policy does not apply.
+func (b *Balloon) AddKeyValue(key, value []byte) (*Snapshot, []*storage.Mutation, error) {
+	addition, err := b.PrepareAddKeyValue(key, value)
+	if err != nil {
+		return nil, nil, err
+	}
+	addition.Commit()
+	return addition.Snapshots[0], addition.Mutations, nil
+}
+
+// PrepareAddKeyValue computes the snapshot and mutations of updating a key
+// as AddKeyValue does, but its version is only visible to the queries once
+// the addition is committed.
+func (b *Balloon) PrepareAddKeyValue(key, value []byte) (*Addition, error) {
+
+	if err := b.checkMode(KeyValueMode); err != nil {
+		return nil, err
+	}
+
+	// Activate metrics gathering
+	stats := metrics.Balloon
+
+	// Hash key
+	keyDigest := b.hasher.Do(key)
+
+	// Get version
+	version := b.Version()
+
+	// Chain the update with the previous one of the same key
+	previous := version
+	leaf, err := b.store.Get(storage.IndexPrefix, keyDigest)
+	if err != nil {
+		if err != storage.ErrKeyNotFound {
+			return nil, err
+		}
+	} else {
+		previous = util.BytesAsUint64(leaf.Value)
+	}
+
+	entryDigest := EntryDigest(b.hasher, keyDigest, previous, value)
+
+	// Update trees
+	var historyDigest hashing.Digest
+	var historyMutations []*storage.Mutation
+	var historyErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		historyDigest, historyMutations, historyErr = b.historyTree.Add(entryDigest, version)
+		wg.Done()
+	}()
+
+	hyperDigests, mutations, pending, hyperErr := b.hyperTree.Prepare([]hashing.Digest{keyDigest}, version, [][]byte{util.Uint64AsBytes(version)})
+
+	wg.Wait()
+
+	if historyErr != nil {
+		return nil, historyErr
+	}
+	if hyperErr != nil {
+		return nil, hyperErr
+	}
+	hyperDigest := hyperDigests[0]
+
+	// Append trees mutations and the value of the key
+	mutations = append(mutations, historyMutations...)
+	mutations = append(mutations, storage.NewMutation(
+		storage.KeyValuePrefix,
+		keyValueKey(keyDigest, version),
+		append(util.Uint64AsBytes(previous), value...),
+	))
+
+	snapshot := &Snapshot{
+		EventDigest:   entryDigest,
+		HistoryDigest: historyDigest,
+		HyperDigest:   hyperDigest,
+		Version:       version,
+	}
+
+	// Increment add hits and version
+	stats.AddFloat("add_hits", 1)
+	stats.Set("version", metrics.Uint64ToVar(version))
+
+	return b.newAddition([]*Snapshot{snapshot}, mutations, pending, 1), nil
+}
+
+func (b *Balloon) proveKeyValueEntry(kv storage.KVPair, currentVersion uint64) (*KeyValueEntry, error) {
+	version := util.BytesAsUint64(kv.Key[len(kv.Key)-8:])
+	historyProof, err := b.historyTree.ProveMembership(version, currentVersion)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get proof from history tree: %v", err)
+	}
+	return &KeyValueEntry{
+		Version:      version,
+		Previous:     util.BytesAsUint64(kv.Value[:8]),
+		Value:        kv.Value[8:],
+		HistoryProof: historyProof,
+	}, nil
+}
+
+// latestKeyValue returns the hyper proof of a key and the version of its
+// latest update. If the key does not exist, the proof is a proof of
+// non-membership.
+func (b *Balloon) latestKeyValue(keyDigest hashing.Digest) (*hyper.QueryProof, uint64, bool, error) {
+	leaf, err := b.store.Get(storage.IndexPrefix, keyDigest)
+	if err != nil {
+		if err != storage.ErrKeyNotFound {
+			return nil, 0, false, err
+		}
+		hyperProof, err := b.hyperTree.QueryMembership(keyDigest, nil)
+		if err != nil {
+			return nil, 0, false, fmt.Errorf("Unable to get proof from hyper tree: %v", err)
+		}
+		return hyperProof, 0, false, nil
+	}
+	hyperProof, err := b.hyperTree.QueryMembership(leaf.Key, leaf.Value)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("Unable to get proof from hyper tree: %v", err)
+	}
+	return hyperProof, util.BytesAsUint64(leaf.Value), true, nil
+}
+
+// QueryKeyValue returns the current value of a key along with the proof of
+// its latest update.
+func (b *Balloon) QueryKeyValue(key []byte) (*KeyValueProof, error) {
+	stats := metrics.Balloon
+	stats.AddFloat("QueryKeyValue", 1)
+
+	if err := b.checkMode(KeyValueMode); err != nil {
+		return nil, err
+	}
+
+	// pin the version, as the balloon could be updated meanwhile
+	hasher := b.hasherF()
+	proof := &KeyValueProof{
+		Key:            key,
+		KeyDigest:      hasher.Do(key),
+		CurrentVersion: b.Version() - 1,
+		Hasher:         hasher,
+	}
+
+	hyperProof, latest, exists, err := b.latestKeyValue(proof.KeyDigest)
+	if err != nil {
+		return nil, err
+	}
+	proof.HyperProof = hyperProof
+	proof.Exists = exists
+	if !exists {
+		return proof, nil
+	}
+
+	kv, err := b.store.Get(storage.KeyValuePrefix, keyValueKey(proof.KeyDigest, latest))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get value of version %d: %v", latest, err)
+	}
+	proof.Entry, err = b.proveKeyValueEntry(*kv, proof.CurrentVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return proof, nil
+}
+
+// QueryKeyHistory returns all the values of a key between the start and
+// end versions, both included, along with the proofs required to check
+// that none of them has been left out.
+func (b *Balloon) QueryKeyHistory(key []byte, start, end uint64) (*KeyHistoryProof, error) {
+	stats := metrics.Balloon
+	stats.AddFloat("QueryKeyHistory", 1)
+
+	if err := b.checkMode(KeyValueMode); err != nil {
+		return nil, err
+	}
+
+	version := b.Version()
+	currentVersion := version - 1
+	if version == 0 || start > end || end > currentVersion {
+		return nil, fmt.Errorf("invalid range [%d, %d] for current version %d", start, end, currentVersion)
+	}
+
+	proof := &KeyHistoryProof{
+		Key:            key,
+		KeyDigest:      b.hasherF().Do(key),
+		Start:          start,
+		End:            end,
+		CurrentVersion: currentVersion,
+		Hasher:         b.hasherF(),
+	}
+
+	hyperProof, latest, exists, err := b.latestKeyValue(proof.KeyDigest)
+	if err != nil {
+		return nil, err
+	}
+	proof.HyperProof = hyperProof
+	proof.Exists = exists
+	if !exists {
+		return proof, nil
+	}
+	proof.LatestVersion = latest
+
+	kvs, err := b.store.GetRange(
+		storage.KeyValuePrefix,
+		keyValueKey(proof.KeyDigest, start),
+		keyValueKey(proof.KeyDigest, end),
+	)
+	if err != nil {
+		return nil, err
+	}
+	proof.Entries = make([]*KeyValueEntry, len(kvs))
+	for i, kv := range kvs {
+		proof.Entries[i], err = b.proveKeyValueEntry(kv, currentVersion)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if latest > end {
+		next, err := b.store.GetRange(
+			storage.KeyValuePrefix,
+			keyValueKey(proof.KeyDigest, end+1),
+			keyValueKey(proof.KeyDigest, latest),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if len(next) == 0 {
+			return nil, fmt.Errorf("Unable to get value of version %d", latest)
+		}
+		proof.Next, err = b.proveKeyValueEntry(next[0], currentVersion)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return proof, nil
+}
@@ -0,0 +1,143 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package balloon
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+)
+
+func TestAddKeyValueAndQuery(t *testing.T) {
+	log.SetLogger("TestAddKeyValueAndQuery", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	b.SetMode(KeyValueMode)
+
+	key := []byte("alice")
+	var snapshot *Snapshot
+	for i := 0; i < 5; i++ {
+		var mutations []*storage.Mutation
+		snapshot, mutations, err = b.AddKeyValue(key, []byte(fmt.Sprintf("key-%d", i)))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		_, mutations, err = b.AddKeyValue([]byte(fmt.Sprintf("other-%d", i)), []byte("value"))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	// the last snapshot is not the current one, so we need the current
+	// digests to verify
+	current, mutations, err := b.AddKeyValue([]byte("bob"), []byte("bob-key"))
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate(mutations))
+
+	proof, err := b.QueryKeyValue(key)
+	require.NoError(t, err)
+	require.True(t, proof.Exists, "The key should exist")
+	assert.Equal(t, []byte("key-4"), proof.Entry.Value, "The value should be the latest one")
+	assert.Equal(t, snapshot.Version, proof.Entry.Version, "The version should be the latest one")
+	assert.Equal(t, snapshot.Version-2, proof.Entry.Previous, "The update should point to the previous one")
+	assert.True(t, proof.Verify(key, current), "The proof should verify correctly")
+	assert.False(t, proof.Verify([]byte("bob"), current), "The proof should not verify another key")
+
+	proof.Entry.Value = []byte("forged")
+	assert.False(t, proof.Verify(key, current), "The proof should not verify a forged value")
+
+	proof, err = b.QueryKeyValue([]byte("charlie"))
+	require.NoError(t, err)
+	assert.False(t, proof.Exists, "The key should not exist")
+	assert.True(t, proof.Verify([]byte("charlie"), current), "The non-membership proof should verify correctly")
+}
+
+func TestQueryKeyHistory(t *testing.T) {
+	log.SetLogger("TestQueryKeyHistory", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	b.SetMode(KeyValueMode)
+
+	// the key is updated in versions 0, 3, 6, 9, 12, 15, 18
+	key := []byte("alice")
+	var current *Snapshot
+	for i := 0; i < 20; i++ {
+		var mutations []*storage.Mutation
+		if i%3 == 0 {
+			current, mutations, err = b.AddKeyValue(key, []byte(fmt.Sprintf("key-%d", i)))
+		} else {
+			current, mutations, err = b.AddKeyValue([]byte(fmt.Sprintf("other-%d", i)), []byte("value"))
+		}
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	testCases := []struct {
+		start, end       uint64
+		expectedVersions []uint64
+		expectedNext     bool
+	}{
+		{0, 19, []uint64{0, 3, 6, 9, 12, 15, 18}, false},
+		{4, 10, []uint64{6, 9}, true},
+		{3, 3, []uint64{3}, true},
+		{4, 5, []uint64{}, true},
+		{19, 19, []uint64{}, false},
+	}
+
+	for i, c := range testCases {
+		proof, err := b.QueryKeyHistory(key, c.start, c.end)
+		require.NoError(t, err, "Error in test case %d", i)
+		require.True(t, proof.Exists, "The key should exist in test case %d", i)
+
+		versions := make([]uint64, len(proof.Entries))
+		for j, e := range proof.Entries {
+			versions[j] = e.Version
+			assert.Equal(t, []byte(fmt.Sprintf("key-%d", e.Version)), e.Value, "Wrong value in test case %d", i)
+		}
+		assert.Equal(t, c.expectedVersions, versions, "Wrong versions in test case %d", i)
+		assert.Equal(t, c.expectedNext, proof.Next != nil, "Wrong next entry in test case %d", i)
+		assert.True(t, proof.Verify(key, current), "The proof should verify correctly in test case %d", i)
+
+		// omitting any entry must be detected
+		for j := range proof.Entries {
+			tampered := *proof
+			tampered.Entries = append(append([]*KeyValueEntry{}, proof.Entries[:j]...), proof.Entries[j+1:]...)
+			assert.False(t, tampered.Verify(key, current), "An incomplete proof should not verify in test case %d", i)
+		}
+		if proof.Next != nil {
+			tampered := *proof
+			tampered.Next = nil
+			assert.False(t, tampered.Verify(key, current), "A proof without next entry should not verify in test case %d", i)
+		}
+	}
+
+	_, err = b.QueryKeyHistory(key, 10, 20)
+	assert.Error(t, err, "A range beyond the current version should fail")
+}
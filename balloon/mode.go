@@ -0,0 +1,84 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package balloon
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrWrongMode = errors.New("the operation is not supported by the mode of the balloon")
+)
+
+// Mode decides what the leaves of the hyper tree are. Events and keys
+// share the same digests, so a balloon only holds one of them, otherwise
+// a key and an event with the same bytes would overwrite each other.
+type Mode uint8
+
+const (
+	// EventMode adds events, and the hyper tree maps the digest of every
+	// event to the versions it was added at.
+	EventMode Mode = iota
+
+	// KeyValueMode sets the values of keys, and the hyper tree maps the
+	// digest of every key to the version of its latest update. The
+	// duplicate policy does not apply, as updating a key is expected.
+	KeyValueMode
+)
+
+var modeNames = []string{"events", "keyvalue"}
+
+func (m Mode) String() string {
+	if int(m) < len(modeNames) {
+		return modeNames[m]
+	}
+	return fmt.Sprintf("Mode(%d)", uint8(m))
+}
+
+// ParseMode returns the mode with the given name.
+func ParseMode(name string) (Mode, error) {
+	for i, n := range modeNames {
+		if n == name {
+			return Mode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown balloon mode %q", name)
+}
+
+// Modes returns the names of the supported modes.
+func Modes() []string {
+	return append([]string(nil), modeNames...)
+}
+
+// Mode returns what the leaves of the hyper tree are.
+func (b *Balloon) Mode() Mode {
+	return b.mode
+}
+
+// SetMode changes what the leaves of the hyper tree are. It must be set
+// before the first addition and never changed afterwards.
+func (b *Balloon) SetMode(m Mode) {
+	b.mode = m
+}
+
+func (b *Balloon) checkMode(m Mode) error {
+	if b.mode != m {
+		return ErrWrongMode
+	}
+	return nil
+}
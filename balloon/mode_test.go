@@ -0,0 +1,109 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package balloon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/storage"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+)
+
+func TestParseMode(t *testing.T) {
+	for _, name := range Modes() {
+		mode, err := ParseMode(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, mode.String(), "The mode should keep its name")
+	}
+	_, err := ParseMode("mixed")
+	assert.Error(t, err, "Unknown modes should be rejected")
+}
+
+func TestWrongMode(t *testing.T) {
+	log.SetLogger("TestWrongMode", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	events, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+
+	_, _, err = events.AddKeyValue([]byte("K"), []byte("value"))
+	assert.Equal(t, ErrWrongMode, err, "An event balloon should not set keys")
+	_, err = events.QueryKeyValue([]byte("K"))
+	assert.Equal(t, ErrWrongMode, err, "An event balloon should not prove keys")
+
+	keys, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	keys.SetMode(KeyValueMode)
+
+	_, _, err = keys.Add([]byte("K"))
+	assert.Equal(t, ErrWrongMode, err, "A key/value balloon should not add events")
+	_, _, err = keys.AddBulk([][]byte{[]byte("K")})
+	assert.Equal(t, ErrWrongMode, err, "A key/value balloon should not add events")
+	_, err = keys.QueryMembership([]byte("K"), 0)
+	assert.Equal(t, ErrWrongMode, err, "A key/value balloon should not prove events")
+}
+
+func TestKeyAndEventWithSameBytes(t *testing.T) {
+	log.SetLogger("TestKeyAndEventWithSameBytes", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	// both balloons share the underlying store, each in its own namespace
+	eventStore := storage.NewNamespacedStore(store, "events")
+	events, err := NewBalloon(eventStore, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	events.SetDuplicatePolicy(RejectDuplicates)
+
+	keyStore := storage.NewNamespacedStore(store, "keys")
+	keys, err := NewBalloon(keyStore, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+	keys.SetMode(KeyValueMode)
+
+	same := []byte("K")
+	eventSnapshot, mutations, err := events.Add(same)
+	require.NoError(t, err)
+	require.NoError(t, eventStore.Mutate(mutations))
+
+	var keySnapshot *Snapshot
+	for _, value := range []string{"value 0", "value 1"} {
+		keySnapshot, mutations, err = keys.AddKeyValue(same, []byte(value))
+		require.NoError(t, err)
+		require.NoError(t, keyStore.Mutate(mutations))
+	}
+
+	// setting the key neither overwrites nor duplicates the event
+	_, _, err = events.Add(same)
+	assert.Equal(t, ErrDuplicateEvent, err, "The event should still be the only one")
+	membership, err := events.QueryMembership(same, eventSnapshot.Version)
+	require.NoError(t, err)
+	assert.True(t, membership.Exists, "The event should exist")
+	assert.True(t, membership.Verify(same, eventSnapshot), "The event proof should verify")
+
+	value, err := keys.QueryKeyValue(same)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value 1"), value.Entry.Value, "The key should have its latest value")
+	assert.Equal(t, uint64(0), value.Entry.Previous, "The key should be chained to its own first update")
+	assert.True(t, value.Verify(same, keySnapshot), "The key proof should verify")
+}
@@ -0,0 +1,92 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package balloon
+
+import (
+	"errors"
+
+	"github.com/bbva/qed/metrics"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/util"
+)
+
+var (
+	ErrVersionNotFound = errors.New("there is no version committed at or before that time")
+)
+
+// NewTimestampMutation returns the mutation that records the time a
+// version was committed. Timestamps must never decrease from one version
+// to the next, so versions can be looked up by time.
+func NewTimestampMutation(version uint64, timestamp int64) *storage.Mutation {
+	return storage.NewMutation(
+		storage.TimestampPrefix,
+		util.Uint64AsBytes(version),
+		util.Uint64AsBytes(uint64(timestamp)),
+	)
+}
+
+// Timestamp returns the time the given version was committed, or zero if
+// it was not recorded, as it happens with versions added before timestamps
+// were introduced.
+func (b *Balloon) Timestamp(version uint64) (int64, error) {
+	kv, err := b.store.Get(storage.TimestampPrefix, util.Uint64AsBytes(version))
+	if err == storage.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int64(util.BytesAsUint64(kv.Value)), nil
+}
+
+// QueryVersionAt returns the last version committed at or before the given
+// timestamp, along with the time it was committed.
+func (b *Balloon) QueryVersionAt(timestamp int64) (uint64, int64, error) {
+	stats := metrics.Balloon
+	stats.AddFloat("QueryVersionAt", 1)
+
+	// binary search for the first version committed after the timestamp.
+	// versions without a recorded timestamp are older than any other one.
+	lo, hi := uint64(0), b.Version()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		t, err := b.Timestamp(mid)
+		if err != nil {
+			return 0, 0, err
+		}
+		if t <= timestamp {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return 0, 0, ErrVersionNotFound
+	}
+
+	version := lo - 1
+	t, err := b.Timestamp(version)
+	if err != nil {
+		return 0, 0, err
+	}
+	if t == 0 {
+		// the time of this version is unknown, so it cannot be proven
+		// to have been committed before the timestamp
+		return 0, 0, ErrVersionNotFound
+	}
+	return version, t, nil
+}
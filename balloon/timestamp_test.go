@@ -0,0 +1,71 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package balloon
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+)
+
+func TestQueryVersionAt(t *testing.T) {
+	log.SetLogger("TestQueryVersionAt", log.SILENT)
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+
+	// the first versions have no timestamps, as if they had been added
+	// before timestamps were recorded
+	for i := 0; i < 10; i++ {
+		snapshot, mutations, err := b.Add([]byte(fmt.Sprintf("event %d", i)))
+		require.NoError(t, err)
+		if i >= 3 {
+			mutations = append(mutations, NewTimestampMutation(snapshot.Version, int64(i*10)))
+		}
+		require.NoError(t, store.Mutate(mutations))
+	}
+
+	testCases := []struct {
+		timestamp         int64
+		expectedVersion   uint64
+		expectedTimestamp int64
+		expectedErr       error
+	}{
+		{0, 0, 0, ErrVersionNotFound},
+		{29, 0, 0, ErrVersionNotFound},
+		{30, 3, 30, nil},
+		{45, 4, 40, nil},
+		{90, 9, 90, nil},
+		{1000, 9, 90, nil},
+	}
+
+	for i, c := range testCases {
+		version, timestamp, err := b.QueryVersionAt(c.timestamp)
+		assert.Equalf(t, c.expectedErr, err, "Wrong error in test case %d", i)
+		assert.Equalf(t, c.expectedVersion, version, "Wrong version in test case %d", i)
+		assert.Equalf(t, c.expectedTimestamp, timestamp, "Wrong timestamp in test case %d", i)
+	}
+}
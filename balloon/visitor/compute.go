@@ -30,19 +30,19 @@ func NewComputeHashVisitor(hasher hashing.Hasher) *ComputeHashVisitor {
 }
 
 func (v *ComputeHashVisitor) VisitRoot(pos navigator.Position, leftResult, rightResult interface{}) interface{} {
-	return v.hasher.Salted(pos.Bytes(), leftResult.(hashing.Digest), rightResult.(hashing.Digest))
+	return hashing.SaltedNode(v.hasher, pos.Bytes(), leftResult.(hashing.Digest), rightResult.(hashing.Digest))
 }
 
 func (v *ComputeHashVisitor) VisitNode(pos navigator.Position, leftResult, rightResult interface{}) interface{} {
-	return v.hasher.Salted(pos.Bytes(), leftResult.(hashing.Digest), rightResult.(hashing.Digest))
+	return hashing.SaltedNode(v.hasher, pos.Bytes(), leftResult.(hashing.Digest), rightResult.(hashing.Digest))
 }
 
 func (v *ComputeHashVisitor) VisitPartialNode(pos navigator.Position, leftResult interface{}) interface{} {
-	return v.hasher.Salted(pos.Bytes(), leftResult.(hashing.Digest))
+	return hashing.SaltedNode(v.hasher, pos.Bytes(), leftResult.(hashing.Digest))
 }
 
 func (v *ComputeHashVisitor) VisitLeaf(pos navigator.Position, value []byte) interface{} {
-	return v.hasher.Salted(pos.Bytes(), value)
+	return hashing.SaltedLeaf(v.hasher, pos.Bytes(), value)
 }
 
 func (v *ComputeHashVisitor) VisitCached(pos navigator.Position, cachedDigest hashing.Digest) interface{} {
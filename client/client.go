@@ -21,13 +21,16 @@ package client
 import (
 	"bytes"
 	"crypto/tls"
+	"encoding"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/bbva/qed/balloon"
@@ -37,7 +40,8 @@ import (
 
 // HTTPClient ist the stuct that has the required information for the cli.
 type HTTPClient struct {
-	conf *Config
+	conf    *Config
+	cluster *cluster
 
 	*http.Client
 }
@@ -52,8 +56,14 @@ func NewHTTPClient(conf Config) *HTTPClient {
 		tlsConf = &tls.Config{}
 	}
 
+	endpoints := conf.Endpoints
+	if conf.Endpoint != "" {
+		endpoints = append([]string{conf.Endpoint}, endpoints...)
+	}
+
 	return &HTTPClient{
 		&conf,
+		newCluster(endpoints),
 		&http.Client{
 			Timeout: time.Second * 10,
 			Transport: &http.Transport{
@@ -68,28 +78,146 @@ func NewHTTPClient(conf Config) *HTTPClient {
 
 }
 
-func (c HTTPClient) exponentialBackoff(req *http.Request) (*http.Response, error) {
+// ForNamespace returns a client that shares the connections of this one
+// but uses the log of the given namespace.
+func (c HTTPClient) ForNamespace(namespace string) *HTTPClient {
+	conf := *c.conf
+	conf.Namespace = namespace
+	return &HTTPClient{&conf, c.cluster, c.Client}
+}
+
+// WithReadConsistency returns a client that shares the connections of this
+// one but sends its queries with the given read consistency.
+func (c HTTPClient) WithReadConsistency(consistency protocol.ReadConsistency) *HTTPClient {
+	conf := *c.conf
+	conf.ReadConsistency = consistency
+	return &HTTPClient{&conf, c.cluster, c.Client}
+}
+
+// writePaths are the paths of the requests that only the leader applies.
+var writePaths = map[string]bool{
+	"/events":      true,
+	"/events/bulk": true,
+	"/kv":          true,
+}
+
+// maxRetries is the number of times the endpoints are tried again, waiting
+// longer every time, before a request fails.
+const maxRetries = 5
+
+// unavailableError is returned when a server can not serve a request for
+// now, like a follower asked for a write, so it is tried on another one.
+// The server may hint at the endpoint of the leader.
+type unavailableError struct {
+	message string
+	leader  string
+}
+
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("Server unavailable: %s", e.message)
+}
+
+// isRetriable returns whether the request can be tried on another endpoint,
+// as the server could not be reached or could not serve it. A write is only
+// tried again if the server refused it or could not be connected to, as
+// once its body is sent the server may apply it even if the response never
+// arrives, and sending it again would add the event twice.
+func isRetriable(err error, write bool) bool {
+	switch e := err.(type) {
+	case *unavailableError:
+		return true
+	case *url.Error:
+		return !write || isDialError(e.Err)
+	}
+	return false
+}
 
-	var retries uint
+// isDialError returns whether the error happened while connecting to the
+// server, before the request was sent.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (c HTTPClient) doReq(method, path string, data []byte) ([]byte, error) {
+	body, _, err := c.doReqAccept(method, path, data, "application/json")
+	return body, err
+}
+
+// doReqAccept sends a request accepting the given content type and returns
+// the body of the response along with its actual content type. Writes, and
+// reads that only the leader can answer, are sent to the leader first and
+// the rest of the reads to every endpoint in turn. When a
+// server can not be reached or can not serve the request, the next one is
+// tried, or the leader it hints at, and every endpoint is tried again with
+// an exponential backoff. Writes are only tried again while they have not
+// been sent, see isRetriable.
+func (c HTTPClient) doReqAccept(method, path string, data []byte, accept string) ([]byte, string, error) {
+	write := writePaths[path]
+	if c.conf.Namespace != "" {
+		path = "/ns/" + c.conf.Namespace + path
+	}
+	toLeader := write
+	if !write && c.conf.ReadConsistency != "" {
+		path += "?" + protocol.ConsistencyParam + "=" + url.QueryEscape(string(c.conf.ReadConsistency))
+		toLeader = c.conf.ReadConsistency != protocol.StaleReads
+	}
+
+	var lastErr error
+	for retries := uint(0); ; retries++ {
+		order := c.cluster.readOrder()
+		if toLeader {
+			order = c.cluster.writeOrder()
+		}
+		if len(order) == 0 {
+			return nil, "", fmt.Errorf("No endpoints to send the request to")
+		}
+
+		hinted := make(map[string]bool)
+		for i := 0; i < len(order); i++ {
+			resp, err := c.send(order[i], method, path, data, accept)
+			if err == nil {
+				if toLeader {
+					c.cluster.setLeader(resp.endpoint)
+				}
+				return resp.body, resp.contentType, nil
+			}
+			if !isRetriable(err, write) {
+				return nil, "", err
+			}
+			// the answer of a server tells more than one that is down
+			if _, ok := err.(*unavailableError); ok || lastErr == nil {
+				lastErr = err
+			}
 
-	for {
-		resp, err := c.Do(req)
-		if err != nil {
-			if retries == 5 {
-				return nil, err
+			// follow the hint right away, but only once, as the leader
+			// may be changing
+			if e, ok := err.(*unavailableError); ok && e.leader != "" && !hinted[e.leader] {
+				hinted[e.leader] = true
+				c.cluster.setLeader(e.leader)
+				order = append(order[:i+1], append([]string{e.leader}, order[i+1:]...)...)
 			}
-			retries = retries + 1
-			delay := time.Duration(10 << retries * time.Millisecond)
-			time.Sleep(delay)
-			continue
 		}
-		return resp, err
+
+		if retries == maxRetries {
+			return nil, "", lastErr
+		}
+		time.Sleep(time.Duration(10 << (retries + 1) * time.Millisecond))
 	}
 
 }
 
-func (c HTTPClient) doReq(method, path string, data []byte) ([]byte, error) {
-	url, err := url.Parse(c.conf.Endpoint + path)
+// response is the answer of a server, along with the endpoint that served
+// it, which is not the one the request was sent to if it was redirected.
+type response struct {
+	body        []byte
+	contentType string
+	endpoint    string
+}
+
+// send sends a request to the given endpoint.
+func (c HTTPClient) send(endpoint, method, path string, data []byte, accept string) (*response, error) {
+	url, err := url.Parse(endpoint + path)
 	if err != nil {
 		panic(err)
 	}
@@ -100,9 +228,10 @@ func (c HTTPClient) doReq(method, path string, data []byte) ([]byte, error) {
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
 	req.Header.Set("Api-Key", c.conf.APIKey)
 
-	resp, err := c.exponentialBackoff(req)
+	resp, err := c.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -110,6 +239,13 @@ func (c HTTPClient) doReq(method, path string, data []byte) ([]byte, error) {
 
 	bodyBytes, _ := ioutil.ReadAll(resp.Body)
 
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		return nil, &unavailableError{
+			message: strings.TrimSpace(string(bodyBytes)),
+			leader:  resp.Header.Get(protocol.LeaderHeader),
+		}
+	}
+
 	if resp.StatusCode >= 500 {
 		return nil, fmt.Errorf("Unexpected server error")
 	}
@@ -118,13 +254,88 @@ func (c HTTPClient) doReq(method, path string, data []byte) ([]byte, error) {
 		return nil, fmt.Errorf("Invalid request")
 	}
 
-	return bodyBytes, nil
+	// a follower may redirect a write to the leader
+	if served := resp.Request.URL; served.Host != req.URL.Host {
+		endpoint = served.Scheme + "://" + served.Host
+	}
+
+	return &response{bodyBytes, resp.Header.Get("Content-Type"), endpoint}, nil
+
+}
 
+// queryProof posts a proof query and decodes the response into result. It
+// asks for the compact binary encoding when the client is configured to,
+// and decodes JSON if the server answers with it instead.
+func (c HTTPClient) queryProof(path string, query []byte, result encoding.BinaryUnmarshaler) error {
+	accept := "application/json"
+	if c.conf.CompactProofs {
+		accept = protocol.ProofContentType
+	}
+
+	body, contentType, err := c.doReqAccept("POST", path, query, accept)
+	if err != nil {
+		return err
+	}
+
+	if contentType == protocol.ProofContentType {
+		return result.UnmarshalBinary(body)
+	}
+	json.Unmarshal(body, result)
+	return nil
+}
+
+// verifySignature checks the signature of a snapshot returned by the
+// server like verifySignatures, and returns the snapshot.
+func (c HTTPClient) verifySignature(signed *protocol.SignedSnapshot) (*protocol.Snapshot, error) {
+	if err := c.verifySignatures([]*protocol.SignedSnapshot{signed}); err != nil {
+		return nil, err
+	}
+	return signed.Snapshot, nil
+}
+
+// verifySignatures checks the signatures of the snapshots returned by the
+// server with the configured public keys, if any. The key of every
+// signature must sign the version of its snapshot, so the versions the
+// keys sign are asked to the server once for all of them.
+func (c HTTPClient) verifySignatures(signed []*protocol.SignedSnapshot) error {
+	for _, s := range signed {
+		if s.Snapshot == nil {
+			return fmt.Errorf("Missing snapshot in the response")
+		}
+	}
+	if len(c.conf.Keys) == 0 {
+		return nil
+	}
+	keys, err := c.SigningKeys()
+	if err != nil {
+		return err
+	}
+	ranges := protocol.NewKeyRanges(keys...)
+	for _, s := range signed {
+		if err := s.Verify(c.conf.Keys, ranges); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Add will do a request to the server with a post data to store a new event.
+// The signature of the snapshot is checked with the configured public keys.
 func (c HTTPClient) Add(event string) (*protocol.Snapshot, error) {
 
+	signed, err := c.AddSigned(event)
+	if err != nil {
+		return nil, err
+	}
+
+	return signed.Snapshot, nil
+
+}
+
+// AddSigned stores a new event like Add, but it returns the snapshot along
+// with its signature, which receipts include.
+func (c HTTPClient) AddSigned(event string) (*protocol.SignedSnapshot, error) {
+
 	data, _ := json.Marshal(&protocol.Event{[]byte(event)})
 
 	body, err := c.doReq("POST", "/events", data)
@@ -132,10 +343,136 @@ func (c HTTPClient) Add(event string) (*protocol.Snapshot, error) {
 		return nil, err
 	}
 
-	var snapshot protocol.Snapshot
-	json.Unmarshal(body, &snapshot)
+	var signed protocol.SignedSnapshot
+	json.Unmarshal(body, &signed)
+
+	if _, err := c.verifySignature(&signed); err != nil {
+		return nil, err
+	}
+
+	return &signed, nil
+
+}
+
+// Receipt asks the server for the membership proof of the event at the
+// version of the signed snapshot, and returns the receipt that bundles
+// them, which can be verified offline. The event is hashed with the hash
+// algorithm of the snapshot.
+func (c HTTPClient) Receipt(event []byte, signed *protocol.SignedSnapshot) (*protocol.Receipt, error) {
+
+	hasherF, err := hashing.NewHasherF(signed.Snapshot.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.MembershipDigest(hasherF().Do(event), signed.Snapshot.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	return protocol.NewReceipt(event, result, signed), nil
+
+}
+
+// AddBulk will do a request to the server with a post data to store a bulk
+// of events in a single operation.
+func (c HTTPClient) AddBulk(events []string) ([]*protocol.Snapshot, error) {
+
+	bulk := &protocol.EventsBulk{Events: make([][]byte, len(events))}
+	for i, event := range events {
+		bulk.Events[i] = []byte(event)
+	}
+	data, _ := json.Marshal(bulk)
+
+	body, err := c.doReq("POST", "/events/bulk", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var signed []*protocol.SignedSnapshot
+	json.Unmarshal(body, &signed)
 
-	return &snapshot, nil
+	if err := c.verifySignatures(signed); err != nil {
+		return nil, err
+	}
+	snapshots := make([]*protocol.Snapshot, len(signed))
+	for i, s := range signed {
+		snapshots[i] = s.Snapshot
+	}
+
+	return snapshots, nil
+
+}
+
+// AddKeyValue will do a request to the server with a post data to set the
+// value of a key.
+func (c HTTPClient) AddKeyValue(key, value string) (*protocol.Snapshot, error) {
+
+	data, _ := json.Marshal(&protocol.KeyValue{[]byte(key), []byte(value)})
+
+	body, err := c.doReq("POST", "/kv", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var signed protocol.SignedSnapshot
+	json.Unmarshal(body, &signed)
+
+	return c.verifySignature(&signed)
+
+}
+
+// SigningKeys will ask the server for the keys that signed the snapshots of
+// the log, with the versions each one signs. Their public keys are not
+// trusted by the client, which only verifies signatures with its
+// configured keys, but their versions are checked for every signature.
+func (c HTTPClient) SigningKeys() ([]*protocol.SigningKey, error) {
+
+	body, err := c.doReq("GET", "/keys", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*protocol.SigningKey
+	err = json.Unmarshal(body, &keys)
+
+	return keys, err
+
+}
+
+// KeyValue will ask the server for the current value of a key along with
+// its proof.
+func (c HTTPClient) KeyValue(key string) (*protocol.KeyValueResult, error) {
+
+	query, _ := json.Marshal(&protocol.KeyValueQuery{[]byte(key)})
+
+	body, err := c.doReq("POST", "/proofs/kv", query)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *protocol.KeyValueResult
+	json.Unmarshal(body, &result)
+
+	return result, nil
+
+}
+
+// KeyHistory will ask the server for all the values of a key between the
+// start and end versions along with their proofs.
+func (c HTTPClient) KeyHistory(key string, start, end uint64) (*protocol.KeyHistoryResult, error) {
+
+	query, _ := json.Marshal(&protocol.KeyHistoryQuery{[]byte(key), start, end})
+
+	body, err := c.doReq("POST", "/proofs/kv-history", query)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *protocol.KeyHistoryResult
+	json.Unmarshal(body, &result)
+
+	return result, nil
 
 }
 
@@ -147,14 +484,11 @@ func (c HTTPClient) Membership(key []byte, version uint64) (*protocol.Membership
 		version,
 	})
 
-	body, err := c.doReq("POST", "/proofs/membership", query)
-	if err != nil {
+	proof := new(protocol.MembershipResult)
+	if err := c.queryProof("/proofs/membership", query, proof); err != nil {
 		return nil, err
 	}
 
-	var proof *protocol.MembershipResult
-	json.Unmarshal(body, &proof)
-
 	return proof, nil
 
 }
@@ -167,15 +501,33 @@ func (c HTTPClient) MembershipDigest(keyDigest hashing.Digest, version uint64) (
 		version,
 	})
 
-	body, err := c.doReq("POST", "/proofs/digest-membership", query)
+	proof := new(protocol.MembershipResult)
+	if err := c.queryProof("/proofs/digest-membership", query, proof); err != nil {
+		return nil, err
+	}
+
+	return proof, nil
+
+}
+
+// BatchMembership will ask the server for a single Proof of several event
+// digests at once.
+func (c HTTPClient) BatchMembership(keyDigests []hashing.Digest, version uint64) (*protocol.BatchMembershipResult, error) {
+
+	query, _ := json.Marshal(&protocol.BatchMembershipDigests{
+		keyDigests,
+		version,
+	})
+
+	body, err := c.doReq("POST", "/proofs/batch-membership", query)
 	if err != nil {
 		return nil, err
 	}
 
-	var proof *protocol.MembershipResult
-	json.Unmarshal(body, &proof)
+	var result *protocol.BatchMembershipResult
+	json.Unmarshal(body, &result)
 
-	return proof, nil
+	return result, nil
 
 }
 
@@ -187,15 +539,54 @@ func (c HTTPClient) Incremental(start, end uint64) (*protocol.IncrementalRespons
 		end,
 	})
 
-	body, err := c.doReq("POST", "/proofs/incremental", query)
+	response := new(protocol.IncrementalResponse)
+	if err := c.queryProof("/proofs/incremental", query, response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Range will ask the server for the event digests added between two
+// versions, both included, along with a proof of them at the given version.
+func (c HTTPClient) Range(start, end, version uint64) (*protocol.RangeResult, error) {
+
+	query, _ := json.Marshal(&protocol.RangeQuery{
+		start,
+		end,
+		version,
+	})
+
+	body, err := c.doReq("POST", "/proofs/range", query)
 	if err != nil {
 		return nil, err
 	}
 
-	var response *protocol.IncrementalResponse
-	json.Unmarshal(body, &response)
+	var result *protocol.RangeResult
+	json.Unmarshal(body, &result)
+
+	return result, nil
+
+}
+
+// VersionAt will ask the server for the last version committed at or
+// before the given time.
+func (c HTTPClient) VersionAt(t time.Time) (*protocol.VersionAtResult, error) {
+
+	query, _ := json.Marshal(&protocol.VersionAtQuery{
+		t.UnixNano(),
+	})
+
+	body, err := c.doReq("POST", "/versions/at", query)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *protocol.VersionAtResult
+	json.Unmarshal(body, &result)
+
+	return result, nil
 
-	return response, nil
 }
 
 func uint2bytes(i uint64) []byte {
@@ -205,7 +596,9 @@ func uint2bytes(i uint64) []byte {
 }
 
 // Verify will compute the Proof given in Membership and the snapshot from the
-// add and returns a proof of existence.
+// add and returns a proof of existence. If the event is not a member, it
+// checks the proof of non-membership of the queried key against the hyper
+// digest of the snapshot.
 func (c HTTPClient) Verify(
 	result *protocol.MembershipResult,
 	snap *protocol.Snapshot,
@@ -214,11 +607,69 @@ func (c HTTPClient) Verify(
 
 	proof := protocol.ToBalloonProof(result, hasherF)
 
+	if !result.Exists {
+		// a non-member event has no snapshot, so we check that the
+		// queried key is absent from the hyper tree of the given one
+		return proof.Verify(result.Key, &balloon.Snapshot{
+			nil,
+			snap.HistoryDigest,
+			snap.HyperDigest,
+			snap.Version,
+			snap.Timestamp,
+			snap.Namespace,
+		})
+	}
+
 	return proof.Verify(snap.EventDigest, &balloon.Snapshot{
 		snap.EventDigest,
 		snap.HistoryDigest,
 		snap.HyperDigest,
 		snap.Version,
+		snap.Timestamp,
+		snap.Namespace,
+	})
+
+}
+
+// VerifyBatch will compute the Proof given in BatchMembership and check the
+// answer for every event digest against the snapshot.
+func (c HTTPClient) VerifyBatch(
+	result *protocol.BatchMembershipResult,
+	snap *protocol.Snapshot,
+	hasherF func() hashing.Hasher,
+) bool {
+
+	proof := protocol.ToBatchMembershipProof(result, hasherF)
+
+	return proof.Verify(&balloon.Snapshot{
+		snap.EventDigest,
+		snap.HistoryDigest,
+		snap.HyperDigest,
+		snap.Version,
+		snap.Timestamp,
+		snap.Namespace,
+	})
+
+}
+
+// VerifyRange will compute the Proof given in Range and check that the event
+// digests are exactly the ones of the range against the snapshot of the
+// version of the proof.
+func (c HTTPClient) VerifyRange(
+	result *protocol.RangeResult,
+	snap *protocol.Snapshot,
+	hasherF func() hashing.Hasher,
+) bool {
+
+	proof := protocol.ToRangeProof(result, hasherF)
+
+	return proof.Verify(&balloon.Snapshot{
+		snap.EventDigest,
+		snap.HistoryDigest,
+		snap.HyperDigest,
+		snap.Version,
+		snap.Timestamp,
+		snap.Namespace,
 	})
 
 }
@@ -238,6 +689,51 @@ func (c HTTPClient) DigestVerify(
 		snap.HistoryDigest,
 		snap.HyperDigest,
 		snap.Version,
+		snap.Timestamp,
+		snap.Namespace,
+	})
+
+}
+
+// VerifyKeyValue will compute the proof given in KeyValue against the
+// snapshot of its current version.
+func (c HTTPClient) VerifyKeyValue(
+	result *protocol.KeyValueResult,
+	snap *protocol.Snapshot,
+	hasherF func() hashing.Hasher,
+) bool {
+
+	proof := protocol.ToKeyValueProof(result, hasherF)
+
+	return proof.Verify(result.Key, &balloon.Snapshot{
+		snap.EventDigest,
+		snap.HistoryDigest,
+		snap.HyperDigest,
+		snap.Version,
+		snap.Timestamp,
+		snap.Namespace,
+	})
+
+}
+
+// VerifyKeyHistory will compute the proofs given in KeyHistory against the
+// snapshot of its current version, checking that no value of the key has
+// been left out.
+func (c HTTPClient) VerifyKeyHistory(
+	result *protocol.KeyHistoryResult,
+	snap *protocol.Snapshot,
+	hasherF func() hashing.Hasher,
+) bool {
+
+	proof := protocol.ToKeyHistoryProof(result, hasherF)
+
+	return proof.Verify(result.Key, &balloon.Snapshot{
+		snap.EventDigest,
+		snap.HistoryDigest,
+		snap.HyperDigest,
+		snap.Version,
+		snap.Timestamp,
+		snap.Namespace,
 	})
 
 }
@@ -255,12 +751,16 @@ func (c HTTPClient) VerifyIncremental(
 		startSnapshot.HistoryDigest,
 		startSnapshot.HyperDigest,
 		startSnapshot.Version,
+		startSnapshot.Timestamp,
+		startSnapshot.Namespace,
 	}
 	end := &balloon.Snapshot{
 		endSnapshot.EventDigest,
 		endSnapshot.HistoryDigest,
 		endSnapshot.HyperDigest,
 		endSnapshot.Version,
+		endSnapshot.Timestamp,
+		endSnapshot.Namespace,
 	}
 
 	return proof.Verify(start, end)
@@ -19,13 +19,20 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/bbva/qed/balloon"
 	"github.com/bbva/qed/balloon/visitor"
+	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
+	"github.com/bbva/qed/storage"
+	storage_utils "github.com/bbva/qed/testutils/storage"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -33,6 +40,7 @@ var (
 	client *HTTPClient
 	mux    *http.ServeMux
 	server *httptest.Server
+	signer = sign.NewEd25519Signer()
 )
 
 func init() {
@@ -46,26 +54,42 @@ func setup() func() {
 		Endpoint:  server.URL,
 		APIKey:    "my-awesome-api-key",
 		Insecure: false,
+		Keys:     sign.NewKeySet(signer),
 	})
 	return func() {
 		server.Close()
 	}
 }
 
+// signingKey returns the key of the signer that signs since the start
+// version.
+func signingKey(start uint64) *protocol.SigningKey {
+	return &protocol.SigningKey{KeyID: sign.KeyID(signer.PublicKey()), PublicKey: signer.PublicKey(), Start: start, Active: true}
+}
+
+// keysHandler lists the signing keys like the server does at /keys.
+func keysHandler(keys ...*protocol.SigningKey) func(http.ResponseWriter, *http.Request) {
+	result, _ := json.Marshal(keys)
+	return okHandler(result)
+}
+
 func TestAddSuccess(t *testing.T) {
 	tearDown := setup()
 	defer tearDown()
 
 	event := "Hello world!"
 	snap := &protocol.Snapshot{
-		[]byte("hyper"),
-		[]byte("history"),
-		0,
-		[]byte(event),
+		HistoryDigest: []byte("hyper"),
+		HyperDigest:   []byte("history"),
+		Version:       0,
+		EventDigest:   []byte(event),
+		HashAlgorithm: hashing.SHA256,
 	}
 
-	result, _ := json.Marshal(snap)
+	signed, _ := snap.Sign(signer)
+	result, _ := json.Marshal(signed)
 	mux.HandleFunc("/events", okHandler(result))
+	mux.HandleFunc("/keys", keysHandler(signingKey(0)))
 
 	snapshot, err := client.Add(event)
 	assert.NoError(t, err)
@@ -73,6 +97,67 @@ func TestAddSuccess(t *testing.T) {
 
 }
 
+func TestAddWithInvalidSignature(t *testing.T) {
+	tearDown := setup()
+	defer tearDown()
+
+	event := "Hello world!"
+	snap := &protocol.Snapshot{
+		HistoryDigest: []byte("hyper"),
+		HyperDigest:   []byte("history"),
+		Version:       0,
+		EventDigest:   []byte(event),
+		HashAlgorithm: hashing.SHA256,
+	}
+
+	signed, _ := snap.Sign(sign.NewEd25519Signer())
+	result, _ := json.Marshal(signed)
+	mux.HandleFunc("/events", okHandler(result))
+	mux.HandleFunc("/keys", keysHandler(signingKey(0)))
+
+	_, err := client.Add(event)
+	assert.Equal(t, protocol.ErrUnknownKey, err, "A snapshot signed with another key must be rejected")
+
+	forged, _ := snap.Sign(sign.NewEd25519Signer())
+	forged.KeyID = sign.KeyID(signer.PublicKey())
+	result, _ = json.Marshal(forged)
+	mux.HandleFunc("/ns/forged/events", okHandler(result))
+	mux.HandleFunc("/ns/forged/keys", keysHandler(signingKey(0)))
+
+	_, err = client.ForNamespace("forged").Add(event)
+	assert.Equal(t, protocol.ErrInvalidSignature, err, "A snapshot signed with another key must be rejected")
+
+}
+
+func TestAddWithRetiredKey(t *testing.T) {
+	tearDown := setup()
+	defer tearDown()
+
+	event := "Hello world!"
+	snap := &protocol.Snapshot{
+		HistoryDigest: []byte("hyper"),
+		HyperDigest:   []byte("history"),
+		Version:       10,
+		EventDigest:   []byte(event),
+		HashAlgorithm: hashing.SHA256,
+	}
+
+	signed, _ := snap.Sign(signer)
+	result, _ := json.Marshal(signed)
+	mux.HandleFunc("/events", okHandler(result))
+
+	// the key was rotated at version 5, so a leaked copy of it must not
+	// sign later versions
+	retired := signingKey(0)
+	retired.Active, retired.End = false, 5
+	successor := &protocol.SigningKey{KeyID: "successor", Start: 5, Active: true}
+	mux.HandleFunc("/keys", keysHandler(retired, successor))
+
+	_, err := client.Add(event)
+	assert.Equal(t, protocol.ErrKeyNotSigning, err, "A snapshot signed with a retired key must be rejected")
+
+}
+
 func TestAddWithServerFailure(t *testing.T) {
 	tearDown := setup()
 	defer tearDown()
@@ -85,6 +170,198 @@ func TestAddWithServerFailure(t *testing.T) {
 
 }
 
+func TestAddFollowsLeader(t *testing.T) {
+	snap := &protocol.Snapshot{
+		HistoryDigest: []byte("hyper"),
+		HyperDigest:   []byte("history"),
+		Version:       0,
+		EventDigest:   []byte("Hello world!"),
+		HashAlgorithm: hashing.SHA256,
+	}
+	signed, _ := snap.Sign(signer)
+	result, _ := json.Marshal(signed)
+
+	leaderMux := http.NewServeMux()
+	leaderMux.HandleFunc("/events", okHandler(result))
+	leaderMux.HandleFunc("/keys", keysHandler(signingKey(0)))
+	leader := httptest.NewServer(leaderMux)
+	defer leader.Close()
+
+	followerWrites := 0
+	followerMux := http.NewServeMux()
+	followerMux.HandleFunc("/keys", keysHandler(signingKey(0)))
+	followerMux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		followerWrites++
+		w.Header().Set(protocol.LeaderHeader, leader.URL)
+		http.Error(w, "not leader", http.StatusServiceUnavailable)
+	})
+	follower := httptest.NewServer(followerMux)
+	defer follower.Close()
+
+	down := httptest.NewServer(http.NewServeMux())
+	down.Close()
+
+	client := NewHTTPClient(Config{
+		Endpoints: []string{down.URL, follower.URL},
+		APIKey:    "my-awesome-api-key",
+		Keys:      sign.NewKeySet(signer),
+	})
+
+	snapshot, err := client.Add("Hello world!")
+	assert.NoError(t, err, "The write should be sent to the leader the follower hints at")
+	assert.Equal(t, snap, snapshot)
+	assert.Equal(t, 1, followerWrites)
+
+	_, err = client.ForNamespace("").Add("Hello world!")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, followerWrites, "The leader should be remembered by every namespace")
+
+	// a write sent over the connection kept alive with the leader could
+	// have been applied, so it is dropped to find the leader down
+	leader.Close()
+	client.CloseIdleConnections()
+	_, err = client.Add("Hello world!")
+	assert.Error(t, err, "The write should fail without a leader")
+	assert.Contains(t, err.Error(), "not leader")
+}
+
+func TestAddIsNotSentAgainAfterTimeout(t *testing.T) {
+	// both servers apply the write, but the first one never answers
+	release := make(chan struct{})
+	writes := make([]int, 2)
+	endpoints := make([]string, len(writes))
+	for i := range writes {
+		i := i
+		mux := http.NewServeMux()
+		mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+			writes[i]++
+			<-release
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+		endpoints[i] = server.URL
+	}
+	defer close(release)
+
+	client := NewHTTPClient(Config{Endpoints: endpoints, APIKey: "my-awesome-api-key"})
+	client.Timeout = 100 * time.Millisecond
+
+	_, err := client.Add("Hello world!")
+	assert.Error(t, err, "The write should fail when its response times out")
+	assert.Equal(t, []int{1, 0}, writes, "A write that was sent should not be sent again")
+}
+
+func TestNoEndpoints(t *testing.T) {
+	client := NewHTTPClient(Config{APIKey: "my-awesome-api-key"})
+
+	_, err := client.SigningKeys()
+	assert.EqualError(t, err, "No endpoints to send the request to")
+	_, err = client.Add("Hello world!")
+	assert.EqualError(t, err, "No endpoints to send the request to")
+}
+
+func TestReadsAreBalanced(t *testing.T) {
+	result, _ := json.Marshal([]*protocol.SigningKey{})
+
+	reads := make([]int, 3)
+	endpoints := make([]string, len(reads))
+	for i := range reads {
+		i := i
+		mux := http.NewServeMux()
+		mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+			reads[i]++
+			okHandler(result)(w, r)
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+		endpoints[i] = server.URL
+	}
+
+	client := NewHTTPClient(Config{Endpoint: endpoints[0], Endpoints: endpoints[1:]})
+	for i := 0; i < 6; i++ {
+		_, err := client.SigningKeys()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []int{2, 2, 2}, reads, "Reads should be sent to every endpoint in turn")
+}
+
+func TestReadConsistency(t *testing.T) {
+	result, _ := json.Marshal([]*protocol.SigningKey{})
+
+	reads := make([]int, 3)
+	consistencies := make([]string, 0)
+	endpoints := make([]string, len(reads))
+	for i := range reads {
+		i := i
+		mux := http.NewServeMux()
+		mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+			consistency := r.URL.Query().Get(protocol.ConsistencyParam)
+			if i != 2 && consistency != "" && consistency != string(protocol.StaleReads) {
+				w.Header().Set(protocol.LeaderHeader, endpoints[2])
+				http.Error(w, "not leader", http.StatusServiceUnavailable)
+				return
+			}
+			reads[i]++
+			consistencies = append(consistencies, consistency)
+			okHandler(result)(w, r)
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+		endpoints[i] = server.URL
+	}
+
+	client := NewHTTPClient(Config{Endpoints: endpoints, ReadConsistency: protocol.LinearizableReads})
+	for i := 0; i < 3; i++ {
+		_, err := client.SigningKeys()
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []int{0, 0, 3}, reads, "Linearizable reads should be sent to the leader")
+
+	_, err := client.WithReadConsistency(protocol.StaleReads).SigningKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"linearizable", "linearizable", "linearizable", "stale"}, consistencies)
+}
+
+func TestAddBulkSuccess(t *testing.T) {
+	tearDown := setup()
+	defer tearDown()
+
+	events := []string{"Hello world!", "Bye world!"}
+	snaps := []*protocol.Snapshot{
+		{HistoryDigest: []byte("hyper"), HyperDigest: []byte("history"), Version: 0, EventDigest: []byte(events[0]), HashAlgorithm: hashing.SHA256},
+		{HistoryDigest: []byte("hyper"), HyperDigest: []byte("history"), Version: 1, EventDigest: []byte(events[1]), HashAlgorithm: hashing.SHA256},
+	}
+
+	signed := make([]*protocol.SignedSnapshot, len(snaps))
+	for i, snap := range snaps {
+		signed[i], _ = snap.Sign(signer)
+	}
+	result, _ := json.Marshal(signed)
+	mux.HandleFunc("/events/bulk", okHandler(result))
+	mux.HandleFunc("/keys", keysHandler(signingKey(0)))
+
+	snapshots, err := client.AddBulk(events)
+	assert.NoError(t, err)
+	assert.Equal(t, snaps, snapshots, "The snapshots should match")
+
+}
+
+func TestSigningKeys(t *testing.T) {
+	tearDown := setup()
+	defer tearDown()
+
+	keys := []*protocol.SigningKey{
+		{KeyID: sign.KeyID(signer.PublicKey()), PublicKey: signer.PublicKey(), Start: 0, Active: true},
+	}
+	result, _ := json.Marshal(keys)
+	mux.HandleFunc("/keys", okHandler(result))
+
+	actual, err := client.SigningKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, keys, actual, "The keys should match")
+
+}
+
 func TestMembership(t *testing.T) {
 	tearDown := setup()
 	defer tearDown()
@@ -156,6 +433,7 @@ func TestIncremental(t *testing.T) {
 		start,
 		end,
 		visitor.AuditPath{"0|0": []uint8{0x0}},
+		hashing.SHA256,
 	}
 
 	resultJSON, _ := json.Marshal(fakeResult)
@@ -166,6 +444,29 @@ func TestIncremental(t *testing.T) {
 	assert.Equal(t, fakeResult, result, "The results should match")
 }
 
+func TestVersionAt(t *testing.T) {
+	tearDown := setup()
+	defer tearDown()
+
+	now := time.Now()
+	fakeResult := &protocol.VersionAtResult{
+		8,
+		now.UnixNano() - 1,
+	}
+
+	var query protocol.VersionAtQuery
+	resultJSON, _ := json.Marshal(fakeResult)
+	mux.HandleFunc("/versions/at", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&query)
+		okHandler(resultJSON)(w, r)
+	})
+
+	result, err := client.VersionAt(now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.UnixNano(), query.Timestamp, "The queried time should be sent in nanoseconds")
+	assert.Equal(t, fakeResult, result, "The results should match")
+}
+
 func TestIncrementalWithServerFailure(t *testing.T) {
 	tearDown := setup()
 	defer tearDown()
@@ -177,6 +478,249 @@ func TestIncrementalWithServerFailure(t *testing.T) {
 
 }
 
+func TestVerifyNonMembership(t *testing.T) {
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := balloon.NewBalloon(store, hashing.NewSha256Hasher)
+	assert.NoError(t, err)
+
+	var snapshot *balloon.Snapshot
+	for i := 0; i < 10; i++ {
+		var mutations []*storage.Mutation
+		snapshot, mutations, err = b.Add([]byte(fmt.Sprintf("event %d", i)))
+		assert.NoError(t, err)
+		assert.NoError(t, store.Mutate(mutations))
+	}
+
+	key := []byte("a non inserted event")
+	proof, err := b.QueryMembership(key, snapshot.Version)
+	assert.NoError(t, err)
+
+	result := protocol.ToMembershipResult(key, proof, hashing.SHA256)
+	snap := protocol.ToSnapshot(snapshot, hashing.SHA256)
+	assert.False(t, result.Exists, "The event should not be a member")
+	assert.True(t, client.Verify(result, snap, hashing.NewSha256Hasher), "The non-membership proof should be valid")
+
+	result.Key = []byte("another key")
+	assert.False(t, client.Verify(result, snap, hashing.NewSha256Hasher), "The proof should not be valid for another key")
+}
+
+func TestMembershipCompactProof(t *testing.T) {
+	tearDown := setup()
+	defer tearDown()
+	client.conf.CompactProofs = true
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := balloon.NewBalloon(store, hashing.NewSha256Hasher)
+	assert.NoError(t, err)
+
+	var snapshot *balloon.Snapshot
+	for i := 0; i < 10; i++ {
+		var mutations []*storage.Mutation
+		snapshot, mutations, err = b.Add([]byte(fmt.Sprintf("event %d", i)))
+		assert.NoError(t, err)
+		assert.NoError(t, store.Mutate(mutations))
+	}
+
+	key := []byte("event 3")
+	proof, err := b.QueryMembership(key, snapshot.Version)
+	assert.NoError(t, err)
+	encoded, err := protocol.ToMembershipResult(key, proof, hashing.SHA256).MarshalBinary()
+	assert.NoError(t, err)
+
+	mux.HandleFunc("/proofs/membership", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, protocol.ProofContentType, r.Header.Get("Accept"), "The client should accept compact proofs")
+		w.Header().Set("Content-Type", protocol.ProofContentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(encoded)
+	})
+
+	result, err := client.Membership(key, snapshot.Version)
+	assert.NoError(t, err)
+	assert.True(t, result.Exists, "The event should be a member")
+
+	snap := protocol.ToSnapshot(snapshot, hashing.SHA256)
+	snap.EventDigest = hashing.NewSha256Hasher().Do(key)
+	assert.True(t, client.DigestVerify(result, snap, hashing.NewSha256Hasher), "The decoded proof should be valid")
+}
+
+func TestAddSignedAndReceipt(t *testing.T) {
+	tearDown := setup()
+	defer tearDown()
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := balloon.NewBalloon(store, hashing.NewSha256Hasher)
+	assert.NoError(t, err)
+
+	event := []byte("event 0")
+	snapshot, mutations, err := b.Add(event)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Mutate(mutations))
+
+	signed, _ := protocol.ToSnapshot(snapshot, hashing.SHA256).Sign(signer)
+	body, _ := json.Marshal(signed)
+	mux.HandleFunc("/events", okHandler(body))
+	mux.HandleFunc("/keys", keysHandler(signingKey(0)))
+
+	proof, err := b.QueryMembership(event, snapshot.Version)
+	assert.NoError(t, err)
+	body, _ = json.Marshal(protocol.ToMembershipResult(event, proof, hashing.SHA256))
+	mux.HandleFunc("/proofs/digest-membership", okHandler(body))
+
+	added, err := client.AddSigned(string(event))
+	assert.NoError(t, err)
+	assert.Equal(t, signed.Signature, added.Signature, "The signature should be returned")
+
+	receipt, err := client.Receipt(event, added)
+	assert.NoError(t, err)
+	assert.NoError(t, receipt.Verify(sign.NewKeySet(signer), protocol.NewKeyRanges(signingKey(0))), "The receipt should be verified offline")
+}
+
+func TestBatchMembershipAndVerify(t *testing.T) {
+	tearDown := setup()
+	defer tearDown()
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := balloon.NewBalloon(store, hashing.NewSha256Hasher)
+	assert.NoError(t, err)
+
+	var snapshot *balloon.Snapshot
+	for i := 0; i < 10; i++ {
+		var mutations []*storage.Mutation
+		snapshot, mutations, err = b.Add([]byte(fmt.Sprintf("event %d", i)))
+		assert.NoError(t, err)
+		assert.NoError(t, store.Mutate(mutations))
+	}
+
+	hasher := hashing.NewSha256Hasher()
+	keyDigests := []hashing.Digest{
+		hasher.Do([]byte("event 2")),
+		hasher.Do([]byte("a non inserted event")),
+		hasher.Do([]byte("event 9")),
+	}
+	proof, err := b.QueryBatchMembership(keyDigests, snapshot.Version)
+	assert.NoError(t, err)
+	resultJSON, _ := json.Marshal(protocol.ToBatchMembershipResult(proof, hashing.SHA256))
+	mux.HandleFunc("/proofs/batch-membership", okHandler(resultJSON))
+
+	result, err := client.BatchMembership(keyDigests, snapshot.Version)
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false, true}, result.Exists, "Wrong memberships")
+
+	snap := protocol.ToSnapshot(snapshot, hashing.SHA256)
+	assert.True(t, client.VerifyBatch(result, snap, hashing.NewSha256Hasher), "The batch proof should be valid")
+
+	result.Exists[1] = true
+	assert.False(t, client.VerifyBatch(result, snap, hashing.NewSha256Hasher), "The proof should not be valid for a forged answer")
+}
+
+func TestRangeAndVerify(t *testing.T) {
+	tearDown := setup()
+	defer tearDown()
+
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := balloon.NewBalloon(store, hashing.NewSha256Hasher)
+	assert.NoError(t, err)
+
+	var snapshot *balloon.Snapshot
+	for i := 0; i < 10; i++ {
+		var mutations []*storage.Mutation
+		snapshot, mutations, err = b.Add([]byte(fmt.Sprintf("event %d", i)))
+		assert.NoError(t, err)
+		assert.NoError(t, store.Mutate(mutations))
+	}
+
+	proof, err := b.QueryRange(2, 6, snapshot.Version)
+	assert.NoError(t, err)
+	resultJSON, _ := json.Marshal(protocol.ToRangeResult(proof, hashing.SHA256))
+	mux.HandleFunc("/proofs/range", okHandler(resultJSON))
+
+	result, err := client.Range(2, 6, snapshot.Version)
+	assert.NoError(t, err)
+	assert.Len(t, result.EventDigests, 5, "Wrong number of event digests")
+
+	snap := protocol.ToSnapshot(snapshot, hashing.SHA256)
+	assert.True(t, client.VerifyRange(result, snap, hashing.NewSha256Hasher), "The range proof should be valid")
+
+	result.EventDigests[1], result.EventDigests[2] = result.EventDigests[2], result.EventDigests[1]
+	assert.False(t, client.VerifyRange(result, snap, hashing.NewSha256Hasher), "The proof should not be valid for reordered events")
+}
+
+func TestKeyValue(t *testing.T) {
+	tearDown := setup()
+	defer tearDown()
+
+	key := "alice"
+	fakeResult := &protocol.KeyValueResult{
+		Exists:    true,
+		Key:       []byte(key),
+		KeyDigest: []byte("digest"),
+		Entry: &protocol.KeyValueEntry{
+			Version:  2,
+			Previous: 0,
+			Value:    []byte("value"),
+			History:  visitor.AuditPath{"0|0": []byte{0x0}},
+		},
+		Hyper:          visitor.AuditPath{"0|0": []byte{0x0}},
+		CurrentVersion: 3,
+	}
+	resultJSON, _ := json.Marshal(fakeResult)
+	mux.HandleFunc("/proofs/kv", okHandler(resultJSON))
+
+	result, err := client.KeyValue(key)
+	assert.NoError(t, err)
+	assert.Equal(t, fakeResult, result, "The results should match")
+}
+
+func TestVerifyKeyValueAndHistory(t *testing.T) {
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+	defer closeF()
+
+	b, err := balloon.NewBalloon(store, hashing.NewSha256Hasher)
+	assert.NoError(t, err)
+	b.SetMode(balloon.KeyValueMode)
+
+	key := []byte("alice")
+	var snapshot *balloon.Snapshot
+	for i := 0; i < 10; i++ {
+		var mutations []*storage.Mutation
+		if i%2 == 0 {
+			snapshot, mutations, err = b.AddKeyValue(key, []byte(fmt.Sprintf("key %d", i)))
+		} else {
+			snapshot, mutations, err = b.AddKeyValue([]byte(fmt.Sprintf("other %d", i)), []byte("value"))
+		}
+		assert.NoError(t, err)
+		assert.NoError(t, store.Mutate(mutations))
+	}
+	snap := protocol.ToSnapshot(snapshot, hashing.SHA256)
+
+	proof, err := b.QueryKeyValue(key)
+	assert.NoError(t, err)
+	result := protocol.ToKeyValueResult(proof, hashing.SHA256)
+	assert.True(t, client.VerifyKeyValue(result, snap, hashing.NewSha256Hasher), "The key value proof should be valid")
+
+	result.Entry.Value = []byte("forged")
+	assert.False(t, client.VerifyKeyValue(result, snap, hashing.NewSha256Hasher), "The proof should not be valid for a forged value")
+
+	historyProof, err := b.QueryKeyHistory(key, 1, 5)
+	assert.NoError(t, err)
+	historyResult := protocol.ToKeyHistoryResult(historyProof, hashing.SHA256)
+	assert.Len(t, historyResult.Entries, 2, "There should be two values in the range")
+	assert.True(t, client.VerifyKeyHistory(historyResult, snap, hashing.NewSha256Hasher), "The key history proof should be valid")
+
+	historyResult.Entries = historyResult.Entries[1:]
+	assert.False(t, client.VerifyKeyHistory(historyResult, snap, hashing.NewSha256Hasher), "The proof should not be valid without all the values")
+}
+
 // TODO implement a test to verify proofs using fake hash function
 
 func okHandler(result []byte) func(http.ResponseWriter, *http.Request) {
@@ -0,0 +1,86 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package client
+
+import "sync"
+
+// cluster keeps the endpoints of the servers, along with the one believed
+// to be the leader. It is shared by the clients of every namespace.
+type cluster struct {
+	mu        sync.Mutex
+	endpoints []string
+	leader    int
+	next      int
+}
+
+func newCluster(endpoints []string) *cluster {
+	c := &cluster{}
+	for _, e := range endpoints {
+		c.add(e)
+	}
+	return c
+}
+
+// add appends the endpoint if it is not known yet and returns its index.
+func (c *cluster) add(endpoint string) int {
+	for i, e := range c.endpoints {
+		if e == endpoint {
+			return i
+		}
+	}
+	c.endpoints = append(c.endpoints, endpoint)
+	return len(c.endpoints) - 1
+}
+
+// rotate returns the endpoints starting from the one at index i, or none
+// if there are no endpoints.
+func (c *cluster) rotate(i int) []string {
+	order := make([]string, 0, len(c.endpoints))
+	if len(c.endpoints) == 0 {
+		return order
+	}
+	order = append(order, c.endpoints[i:]...)
+	return append(order, c.endpoints[:i]...)
+}
+
+// writeOrder returns the endpoints in the order writes try them, the leader
+// first.
+func (c *cluster) writeOrder() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rotate(c.leader)
+}
+
+// readOrder returns the endpoints in the order reads try them, starting
+// from a different one every time so reads are balanced across them.
+func (c *cluster) readOrder() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	order := c.rotate(c.next)
+	if len(order) > 0 {
+		c.next = (c.next + 1) % len(c.endpoints)
+	}
+	return order
+}
+
+// setLeader records the endpoint of the leader, which is added to the
+// known ones if a server hinted at a new one.
+func (c *cluster) setLeader(endpoint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.leader = c.add(endpoint)
+}
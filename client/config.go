@@ -16,21 +16,51 @@
 
 package client
 
+import (
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
+)
+
 type Config struct {
 	// Server host:port to consult.
 	Endpoint string
 
+	// Servers of the cluster to consult along with Endpoint. Writes are
+	// sent to the leader, which is found by following the hints of the
+	// followers or trying every server, and reads are balanced across all
+	// of them.
+	Endpoints []string
+
 	// ApiKey to query the server endpoint.
 	APIKey string
 
 	// Enable self-signed certificates, allowing MiTM vector attacks.
 	Insecure bool
+
+	// Request membership and incremental proofs in the compact binary
+	// encoding instead of JSON.
+	CompactProofs bool
+
+	// Namespace whose log is used, the default one if empty.
+	Namespace string
+
+	// Read consistency of the queries, stale if empty. The leader and
+	// linearizable queries are sent to the leader like the writes.
+	ReadConsistency protocol.ReadConsistency
+
+	// Public keys of the server, which check the signatures of the
+	// snapshots it returns with the key of their key ID. The versions each
+	// key signs are asked to the server to check them, so a retired key is
+	// not accepted for the versions after it. They are not checked if
+	// empty.
+	Keys sign.KeySet
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		Endpoint: "localhost:8080",
-		APIKey:   "my-key",
-		Insecure: true,
+		Endpoint:      "localhost:8080",
+		APIKey:        "my-key",
+		Insecure:      true,
+		CompactProofs: false,
 	}
 }
@@ -0,0 +1,40 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/bbva/qed/log"
+	"github.com/spf13/cobra"
+)
+
+func newAdminCommand(ctx *cmdContext) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Administration tasks for qed",
+		Long:  `Administration tasks to run on the data of a stopped qed server`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			log.SetLogger("QedAdmin", ctx.logLevel)
+		},
+		TraverseChildren: true,
+	}
+
+	cmd.AddCommand(newFsckCommand())
+	cmd.AddCommand(newRestoreCommand())
+
+	return cmd
+}
@@ -0,0 +1,99 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/server"
+)
+
+func newFsckCommand() *cobra.Command {
+
+	var dbPath, engine string
+
+	cmd := &cobra.Command{
+		Use:   "fsck",
+		Short: "Check the integrity of a qed database",
+		Long: `Check the integrity of the database of a stopped qed server. It recomputes
+			the history and hyper trees from the stored leaves, checks that the state
+			matches the last version and reports every mismatched position.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := server.OpenStore(engine, dbPath, true)
+			if err != nil {
+				return fmt.Errorf("can't open the database: %v", err)
+			}
+			defer store.Close()
+
+			report, err := raftwal.Fsck(store)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Hash algorithm: %s\n", report.HashAlgorithm)
+			fmt.Fprintf(out, "Versions: %d\n", report.Version)
+			fmt.Fprintf(out, "History digest: %x\n", report.HistoryDigest)
+			fmt.Fprintf(out, "Hyper digest: %x\n", report.HyperDigest)
+			for _, pos := range report.HistoryMismatches {
+				fmt.Fprintf(out, "History mismatch: %s\n", pos)
+			}
+			for _, pos := range report.HyperMismatches {
+				fmt.Fprintf(out, "Hyper mismatch: %s\n", pos)
+			}
+			names := make([]string, 0, len(report.Namespaces))
+			for name := range report.Namespaces {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				ns := report.Namespaces[name]
+				fmt.Fprintf(out, "Namespace %s: %d versions\n", name, ns.Version)
+				for _, pos := range ns.HistoryMismatches {
+					fmt.Fprintf(out, "History mismatch in namespace %s: %s\n", name, pos)
+				}
+				for _, pos := range ns.HyperMismatches {
+					fmt.Fprintf(out, "Hyper mismatch in namespace %s: %s\n", name, pos)
+				}
+			}
+			if !report.StateMatches() {
+				if report.StateFound {
+					fmt.Fprintf(out, "State mismatch: version %d\n", report.StateVersion)
+				} else {
+					fmt.Fprintf(out, "State mismatch: not found\n")
+				}
+			}
+
+			if !report.Ok() {
+				return errors.New("the database is not consistent")
+			}
+			fmt.Fprintln(out, "Database OK")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&dbPath, "dbpath", "p", "/var/tmp/qed/data", "Path of the database to check")
+	cmd.Flags().StringVar(&engine, "storage", server.BadgerStorage, fmt.Sprintf("Storage engine of the database (%s)", strings.Join(server.StorageEngines(), ", ")))
+
+	return cmd
+}
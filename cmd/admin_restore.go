@@ -0,0 +1,120 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/raftwal"
+	"github.com/bbva/qed/server"
+)
+
+func newRestoreCommand() *cobra.Command {
+
+	var dbPath, engine string
+
+	cmd := &cobra.Command{
+		Use:   "restore <backup>",
+		Short: "Restore a backup of a qed database",
+		Long: `Restore a backup streamed by the /backup management endpoint into a new
+			data directory, or - to read it from the standard input. It recomputes
+			the history and hyper trees of the restored data and checks them against
+			the header of the backup before any server is started on it.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := ensureEmptyDir(dbPath); err != nil {
+				return err
+			}
+
+			var in io.Reader = os.Stdin
+			if args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return fmt.Errorf("can't open the backup: %v", err)
+				}
+				defer f.Close()
+				in = f
+			}
+
+			store, err := server.OpenStore(engine, dbPath, false)
+			if err != nil {
+				return fmt.Errorf("can't open the database: %v", err)
+			}
+			defer store.Close()
+
+			header, report, err := raftwal.RestoreBackup(in, store)
+			if err == raftwal.ErrStorageMismatch {
+				return fmt.Errorf("%v, restore it with --storage %s", err, header.Storage)
+			}
+			if err != nil && err != raftwal.ErrBackupMismatch {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Hash algorithm: %s\n", header.HashAlgorithm)
+			fmt.Fprintf(out, "Versions: %d (backup %d)\n", report.Version, header.Version)
+			fmt.Fprintf(out, "History digest: %x (backup %x)\n", report.HistoryDigest, header.HistoryDigest)
+			fmt.Fprintf(out, "Hyper digest: %x (backup %x)\n", report.HyperDigest, header.HyperDigest)
+			names := make([]string, 0, len(header.Namespaces))
+			for name := range header.Namespaces {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if ns, ok := report.Namespaces[name]; ok {
+					fmt.Fprintf(out, "Namespace %s: %d versions (backup %d)\n", name, ns.Version, header.Namespaces[name].Version)
+				} else {
+					fmt.Fprintf(out, "Namespace %s: not restored\n", name)
+				}
+			}
+
+			if err != nil {
+				return fmt.Errorf("%v, the data directory %s must be discarded", err, dbPath)
+			}
+			fmt.Fprintln(out, "Restore OK")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&dbPath, "dbpath", "p", "/var/tmp/qed/data", "Path of the new database, which must not exist or be empty")
+	cmd.Flags().StringVar(&engine, "storage", server.BadgerStorage, fmt.Sprintf("Storage engine of the new database (%s), which must be the one of the backup", strings.Join(server.StorageEngines(), ", ")))
+
+	return cmd
+}
+
+// ensureEmptyDir returns an error if the directory exists and it is not
+// empty.
+func ensureEmptyDir(path string) error {
+	files, err := ioutil.ReadDir(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return fmt.Errorf("the data directory %s is not empty", path)
+	}
+	return nil
+}
@@ -19,6 +19,8 @@ package cmd
 import (
 	"github.com/bbva/qed/client"
 	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
 	"github.com/spf13/cobra"
 )
 
@@ -32,17 +34,39 @@ func newClientCommand(ctx *cmdContext) *cobra.Command {
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
 			log.SetLogger("QedClient", ctx.logLevel)
 
+			consistency, err := protocol.ParseReadConsistency(clientCtx.consistency)
+			if err != nil {
+				log.Fatalf("Invalid read consistency: %v", err)
+			}
+
+			keys := sign.NewKeySet()
+			for _, path := range clientCtx.publicKeyPaths {
+				verifier, err := sign.NewVerifierFromFile(path)
+				if err != nil {
+					log.Fatalf("Failed to load the public key of the server: %v", err)
+				}
+				keys.Add(verifier)
+			}
+
 			clientCtx.client = client.NewHTTPClient(client.Config{
-				Endpoint: clientCtx.endpoint,
-				APIKey:   ctx.apiKey,
-				Insecure: clientCtx.insecure,
+				Endpoints:       clientCtx.endpoints,
+				APIKey:          ctx.apiKey,
+				Insecure:        clientCtx.insecure,
+				CompactProofs:   clientCtx.compactProofs,
+				Namespace:       clientCtx.namespace,
+				ReadConsistency: consistency,
+				Keys:            keys,
 			})
 		},
 		TraverseChildren: true,
 	}
 
-	cmd.PersistentFlags().StringVarP(&clientCtx.endpoint, "endpoint", "e", "localhost:8080", "Endpoint for REST requests on (host:port)")
+	cmd.PersistentFlags().StringSliceVarP(&clientCtx.endpoints, "endpoint", "e", []string{"localhost:8080"}, "Comma-delimited list of endpoints of the servers for REST requests on (host:port), writes are sent to the leader among them")
 	cmd.PersistentFlags().BoolVar(&clientCtx.insecure, "insecure", false, "Disable TLS transport")
+	cmd.PersistentFlags().BoolVar(&clientCtx.compactProofs, "compact-proofs", false, "Request proofs in the compact binary encoding")
+	cmd.PersistentFlags().StringVar(&clientCtx.namespace, "namespace", "", "Namespace whose log is used instead of the default one")
+	cmd.PersistentFlags().StringVar(&clientCtx.consistency, "consistency", "stale", "Read consistency of the queries: stale, answered by any server; leader, answered by the leader; or linearizable, answered by the leader once it applied every committed write")
+	cmd.PersistentFlags().StringSliceVar(&clientCtx.publicKeyPaths, "public-key", []string{}, "Paths to the public keys of the server, either OpenSSH or PEM encoded PKIX, which verify the signatures of the snapshots by key ID")
 
 	cmd.AddCommand(newAddCommand(clientCtx))
 	cmd.AddCommand(newMembershipCommand(clientCtx))
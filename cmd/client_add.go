@@ -24,7 +24,7 @@ import (
 
 func newAddCommand(ctx *clientContext) *cobra.Command {
 
-	var key, value string
+	var key, value, receiptPath string
 
 	cmd := &cobra.Command{
 		Use:   "add",
@@ -33,10 +33,11 @@ func newAddCommand(ctx *clientContext) *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			log.Infof("Adding key [ %s ] with value [ %s ]\n", key, value)
 
-			snapshot, err := ctx.client.Add(key)
+			signed, err := ctx.client.AddSigned(key)
 			if err != nil {
 				return err
 			}
+			snapshot := signed.Snapshot
 
 			log.Infof(`
 Received snapshot with values:
@@ -50,12 +51,24 @@ Received snapshot with values:
 				snapshot.HistoryDigest,
 				snapshot.Version)
 
+			if receiptPath != "" {
+				receipt, err := ctx.client.Receipt([]byte(key), signed)
+				if err != nil {
+					return err
+				}
+				if err := writeReceipt(receiptPath, receipt); err != nil {
+					return err
+				}
+				log.Infof("Receipt written to %s", receiptPath)
+			}
+
 			return nil
 		},
 	}
 
 	cmd.Flags().StringVar(&key, "key", "", "Key to add")
 	cmd.Flags().StringVar(&value, "value", "", "Value to add")
+	cmd.Flags().StringVar(&receiptPath, "receipt", "", "Path of the file to write the receipt of the event to, which qed verify checks offline")
 	cmd.MarkFlagRequired("key")
 	cmd.MarkFlagRequired("value")
 
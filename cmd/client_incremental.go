@@ -62,12 +62,17 @@ func newIncrementalCommand(ctx *clientContext) *cobra.Command {
 			if verify {
 				sdBytes, _ := hex.DecodeString(startDigest)
 				edBytes, _ := hex.DecodeString(endDigest)
-				startSnapshot := &protocol.Snapshot{sdBytes, nil, start, nil}
-				endSnapshot := &protocol.Snapshot{edBytes, nil, end, nil}
+				startSnapshot := &protocol.Snapshot{HistoryDigest: sdBytes, Version: start, HashAlgorithm: proof.HashAlgorithm}
+				endSnapshot := &protocol.Snapshot{HistoryDigest: edBytes, Version: end, HashAlgorithm: proof.HashAlgorithm}
+
+				hasherF, err := hashing.NewHasherF(proof.HashAlgorithm)
+				if err != nil {
+					return err
+				}
 
 				log.Infof("Verifying with snapshots: \n\tStartDigest: %s\n\tEndDigest: %s\n",
 					startDigest, endDigest)
-				if ctx.client.VerifyIncremental(proof, startSnapshot, endSnapshot, hashing.NewSha256Hasher()) {
+				if ctx.client.VerifyIncremental(proof, startSnapshot, endSnapshot, hasherF()) {
 					log.Info("Verify: OK")
 				} else {
 					log.Info("Verify: KO")
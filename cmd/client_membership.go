@@ -18,6 +18,8 @@ package cmd
 
 import (
 	"encoding/hex"
+	"errors"
+	"fmt"
 
 	"github.com/spf13/cobra"
 
@@ -28,10 +30,10 @@ import (
 
 func newMembershipCommand(ctx *clientContext) *cobra.Command {
 
-	hasherF := hashing.NewSha256Hasher
 	var version uint64
 	var verify bool
 	var key, eventDigest, hyperDigest, historyDigest string
+	var receiptPath, snapshotPath string
 
 	cmd := &cobra.Command{
 		Use:   "membership",
@@ -50,6 +52,9 @@ func newMembershipCommand(ctx *clientContext) *cobra.Command {
 					log.Errorf("Error: trying to verify proof without history digest")
 				}
 			}
+			if receiptPath != "" && snapshotPath == "" {
+				return errors.New("a signed snapshot of the version is required to write a receipt")
+			}
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -58,17 +63,21 @@ func newMembershipCommand(ctx *clientContext) *cobra.Command {
 			var err error
 
 			if eventDigest == "" {
+				// the server hashes the key with its own hash algorithm
 				log.Infof("Querying key [ %s ] with version [ %d ]\n", key, version)
-				digest = hasherF().Do([]byte(key))
+				membershipResult, err = ctx.client.Membership([]byte(key), version)
+				if err != nil {
+					return err
+				}
+				digest = membershipResult.KeyDigest
 
 			} else {
 				log.Infof("Querying digest [ %s ] with version [ %d ]\n", eventDigest, version)
 				digest, _ = hex.DecodeString(eventDigest)
-			}
-
-			membershipResult, err = ctx.client.MembershipDigest(digest, version)
-			if err != nil {
-				return err
+				membershipResult, err = ctx.client.MembershipDigest(digest, version)
+				if err != nil {
+					return err
+				}
 			}
 			log.Debugf(`MembershipResult:
 	Exists: %b
@@ -89,10 +98,39 @@ func newMembershipCommand(ctx *clientContext) *cobra.Command {
 				membershipResult.Key,
 			)
 
+			if receiptPath != "" {
+				signed, err := readSignedSnapshot(snapshotPath)
+				if err != nil {
+					return err
+				}
+				if signed.Snapshot.Version != version {
+					return fmt.Errorf("the snapshot is of version %d instead of the queried one", signed.Snapshot.Version)
+				}
+				var event []byte
+				if eventDigest == "" {
+					event = []byte(key)
+				}
+				if err := writeReceipt(receiptPath, protocol.NewReceipt(event, membershipResult, signed)); err != nil {
+					return err
+				}
+				log.Infof("Receipt written to %s", receiptPath)
+			}
+
 			if verify {
 				hdBytes, _ := hex.DecodeString(hyperDigest)
 				htdBytes, _ := hex.DecodeString(historyDigest)
-				snapshot := &protocol.Snapshot{htdBytes, hdBytes, version, digest}
+				snapshot := &protocol.Snapshot{
+					HistoryDigest: htdBytes,
+					HyperDigest:   hdBytes,
+					Version:       version,
+					EventDigest:   digest,
+					HashAlgorithm: membershipResult.HashAlgorithm,
+				}
+
+				hasherF, err := hashing.NewHasherF(membershipResult.HashAlgorithm)
+				if err != nil {
+					return err
+				}
 
 				log.Infof("Verifying with Snapshot: \n\tEventDigest:%x\n\tHyperDigest: %s\n\tHistoryDigest: %s\n\tVersion: %d\n",
 					digest, hyperDigest, historyDigest, version)
@@ -115,6 +153,8 @@ func newMembershipCommand(ctx *clientContext) *cobra.Command {
 	cmd.Flags().StringVar(&eventDigest, "eventDigest", "", "Digest of the event")
 	cmd.Flags().StringVar(&hyperDigest, "hyperDigest", "", "Digest of the hyper tree")
 	cmd.Flags().StringVar(&historyDigest, "historyDigest", "", "Digest of the history tree")
+	cmd.Flags().StringVar(&receiptPath, "receipt", "", "Path of the file to write the receipt of the event to, which qed verify checks offline")
+	cmd.Flags().StringVar(&snapshotPath, "snapshot", "", "Path of the signed snapshot of the queried version, either as returned when adding an event or in a receipt")
 
 	cmd.MarkFlagRequired("version")
 
@@ -0,0 +1,109 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
+	"github.com/spf13/cobra"
+)
+
+type clusterContext struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newClusterCommand(ctx *cmdContext) *cobra.Command {
+	clusterCtx := &clusterContext{client: &http.Client{Timeout: 30 * time.Second}}
+
+	cmd := &cobra.Command{
+		Use:   "cluster",
+		Short: "Cluster management for qed",
+		Long:  `Manage the members of a running qed cluster through the management api of one of its servers`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			log.SetLogger("QedCluster", ctx.logLevel)
+		},
+		TraverseChildren: true,
+	}
+
+	cmd.PersistentFlags().StringVarP(&clusterCtx.endpoint, "endpoint", "e", "localhost:8090", "Endpoint of the management api of a server (host:port)")
+
+	cmd.AddCommand(newClusterMembersCommand(clusterCtx))
+	cmd.AddCommand(newClusterLeaderCommand(clusterCtx))
+	cmd.AddCommand(newClusterRemoveCommand(clusterCtx))
+	cmd.AddCommand(newClusterSnapshotCommand(clusterCtx))
+
+	return cmd
+}
+
+// do sends a request to the management api and decodes the response into
+// out, if it is not nil. If the server answers that only the leader can
+// serve the request, it is sent again to the leader it hints at.
+func (c *clusterContext) do(method, path string, in, out interface{}) error {
+	endpoint := c.endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+
+	var data []byte
+	if in != nil {
+		var err error
+		if data, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	for hinted := false; ; hinted = true {
+		req, err := http.NewRequest(method, endpoint+path, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		leader := resp.Header.Get(protocol.LeaderHeader)
+		if resp.StatusCode == http.StatusServiceUnavailable && leader != "" && !hinted {
+			log.Infof("Sending the request to the leader at %s", leader)
+			endpoint = leader
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(body, out)
+	}
+}
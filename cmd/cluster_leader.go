@@ -0,0 +1,47 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/bbva/qed/raftwal"
+	"github.com/spf13/cobra"
+)
+
+func newClusterLeaderCommand(ctx *clusterContext) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "leader",
+		Short: "Show the leader of the cluster",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var leader raftwal.Member
+			if err := ctx.do("GET", "/cluster/leader", nil, &leader); err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "ID: %s\n", leader.ID)
+			fmt.Fprintf(out, "Address: %s\n", leader.Address)
+			fmt.Fprintf(out, "API endpoint: %s\n", leader.APIEndpoint)
+			fmt.Fprintf(out, "Management endpoint: %s\n", leader.MgmtEndpoint)
+			return nil
+		},
+	}
+
+	return cmd
+}
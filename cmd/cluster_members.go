@@ -0,0 +1,54 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/bbva/qed/api/mgmthttp"
+	"github.com/spf13/cobra"
+)
+
+func newClusterMembersCommand(ctx *clusterContext) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "members",
+		Short: "List the members of the cluster",
+		Long: `List the members of the cluster with their voter status and the index of
+			the last Raft log they applied, which shows how far behind the leader they are.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var members []*mgmthttp.MemberStatus
+			if err := ctx.do("GET", "/cluster/members", nil, &members); err != nil {
+				return err
+			}
+
+			out := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(out, "ID\tADDRESS\tVOTER\tLEADER\tAPPLIED\tAPI\tMGMT")
+			for _, m := range members {
+				applied := fmt.Sprint(m.AppliedIndex)
+				if m.Unreachable {
+					applied = "unreachable"
+				}
+				fmt.Fprintf(out, "%s\t%s\t%t\t%t\t%s\t%s\t%s\n", m.ID, m.Address, m.Voter, m.Leader, applied, m.APIEndpoint, m.MgmtEndpoint)
+			}
+			return out.Flush()
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,43 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newClusterRemoveCommand(ctx *clusterContext) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "remove <node-id>",
+		Short: "Remove a node from the cluster",
+		Long: `Remove the node with the given ID from the cluster, along with its metadata.
+			The request is sent to the leader if the server is a follower.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := ctx.do("POST", "/cluster/remove", map[string]string{"id": args[0]}, nil); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Node %s removed\n", args[0])
+			return nil
+		},
+	}
+
+	return cmd
+}
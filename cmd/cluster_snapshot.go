@@ -0,0 +1,42 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func newClusterSnapshotCommand(ctx *clusterContext) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Take a Raft snapshot in a server",
+		Long: `Make the server take a Raft snapshot of its state right away, which
+			compacts its Raft log.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := ctx.do("POST", "/cluster/snapshot", nil, nil); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Snapshot taken")
+			return nil
+		},
+	}
+
+	return cmd
+}
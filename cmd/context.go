@@ -26,9 +26,13 @@ type cmdContext struct {
 }
 
 type clientContext struct {
-	endpoint string
-	insecure bool
-	client   *client.HTTPClient
+	endpoints      []string
+	insecure       bool
+	compactProofs  bool
+	namespace      string
+	consistency    string
+	publicKeyPaths []string
+	client         *client.HTTPClient
 }
 
 type agentContext struct {
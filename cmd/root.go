@@ -38,6 +38,9 @@ func NewRootCommand() *cobra.Command {
 	cmd.AddCommand(newStartCommand(ctx))
 	cmd.AddCommand(newClientCommand(ctx))
 	cmd.AddCommand(newAgentCommand(ctx))
+	cmd.AddCommand(newAdminCommand(ctx))
+	cmd.AddCommand(newClusterCommand(ctx))
+	cmd.AddCommand(newVerifyCommand(ctx))
 
 	return cmd
 }
@@ -20,10 +20,14 @@ import (
 	"fmt"
 	"os"
 	"os/user"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/raftwal"
 	"github.com/bbva/qed/server"
 )
 
@@ -64,14 +68,22 @@ func newStartCommand(ctx *cmdContext) *cobra.Command {
 	hostname, _ := os.Hostname()
 	cmd.Flags().StringVar(&conf.NodeID, "node-id", hostname, "Unique name for node. If not set, fallback to hostname")
 	cmd.Flags().StringVar(&conf.HTTPAddr, "http-addr", ":8080", "Endpoint for REST requests on (host:port)")
+	cmd.Flags().StringVar(&conf.APIEndpoint, "api-endpoint", "", "Endpoint of the REST api as clients and other nodes reach it (protocol://host:port). If not set, it is built from the http address")
+	cmd.Flags().StringVar(&conf.FollowerWrites, "follower-writes", "redirect", "How followers answer the writes only the leader can apply: redirect them or forward them to the leader")
 	cmd.Flags().StringVar(&conf.RaftAddr, "raft-addr", ":9000", "Raft bind address (host:port)")
 	cmd.Flags().StringVar(&conf.MgmtAddr, "mgmt-addr", ":8090", "Management endpoint bind address (host:port)")
 	cmd.Flags().StringSliceVar(&conf.RaftJoinAddr, "join-addr", []string{}, "Raft: Comma-delimited list of nodes ([host]:port), through which a cluster can be joined")
 	cmd.Flags().StringVar(&conf.GossipAddr, "gossip-addr", ":9100", "Gossip: management endpoint bind address (host:port)")
 	cmd.Flags().StringSliceVar(&conf.GossipJoinAddr, "gossip-join-addr", []string{}, "Gossip: Comma-delimited list of nodes ([host]:port), through which a cluster can be joined")
 	cmd.Flags().StringVarP(&conf.DBPath, "dbpath", "p", "/var/tmp/qed/data", "Set default storage path")
+	cmd.Flags().StringVar(&conf.Storage, "storage", server.BadgerStorage, fmt.Sprintf("Storage engine of the database (%s). Every node of a cluster must use the same one", strings.Join(server.StorageEngines(), ", ")))
 	cmd.Flags().StringVar(&conf.RaftPath, "raftpath", "/var/tmp/qed/raft", "Set raft storage path")
-	cmd.Flags().StringVarP(&conf.PrivateKeyPath, "keypath", "y", defaultKeyPath, "Path to the ed25519 key file")
+	cmd.Flags().StringVarP(&conf.PrivateKeyPath, "keypath", "y", defaultKeyPath, "Path to the private key file: ed25519 (OpenSSH or PKCS#8), ECDSA P-256 or RSA (PEM encoded PKCS#8, SEC 1 or PKCS#1)")
+	cmd.Flags().StringSliceVar(&conf.SigningKeyPaths, "signing-keys", []string{}, "Comma-delimited list of other private key files the node can sign with once the cluster rotates to them")
+	cmd.Flags().StringVar(&conf.HashAlgorithm, "hash-algorithm", hashing.SHA256, fmt.Sprintf("Hash algorithm used by the trees (%s). The %s suffix hashes their leaves and nodes in separate domains. It cannot be changed after the first boot", strings.Join(hashing.Algorithms(), ", "), hashing.DomainSeparation))
+	cmd.Flags().StringVar(&conf.Mode, "mode", balloon.EventMode.String(), fmt.Sprintf("Mode of the default namespace (%s). It cannot be changed after the first boot", strings.Join(balloon.Modes(), ", ")))
+	cmd.Flags().StringVar(&conf.DuplicatePolicy, "duplicate-policy", balloon.OverwriteDuplicates.String(), fmt.Sprintf("Policy for the events added more than once (%s). It cannot be changed after the first boot", strings.Join(balloon.DuplicatePolicies(), ", ")))
+	cmd.Flags().DurationVar(&conf.HyperCheckpointInterval, "hyper-checkpoint-interval", raftwal.DefaultHyperCheckpointInterval, "How often the hyper cache is saved to disk, so a restart only replays the versions added since then")
 	cmd.Flags().BoolVarP(&conf.EnableProfiling, "profiling", "f", false, "Allow a pprof url (localhost:6060) for profiling purposes")
 	cmd.Flags().BoolVar(&disableTLS, "insecure", false, "Disable TLS service")
 
@@ -0,0 +1,155 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
+)
+
+func newVerifyCommand(ctx *cmdContext) *cobra.Command {
+
+	var publicKeyPaths []string
+	var keysPath string
+
+	cmd := &cobra.Command{
+		Use:   "verify <receipt>",
+		Short: "Verify a receipt offline",
+		Long: `Verify a receipt written by the add or membership client commands without
+			any request to the server. It checks the signature of the snapshot with the
+			given public keys, that its key signs the version of the snapshot according
+			to the keys listed by the server, and the membership proof of the event
+			against it. The hyper proof is only checked when the receipt was written at
+			the version of the snapshot, otherwise the receipt is reported as partially
+			verified.`,
+		Args: cobra.ExactArgs(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			log.SetLogger("QedVerify", ctx.logLevel)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(publicKeyPaths) == 0 {
+				return errors.New("at least one public key is required to verify the signature")
+			}
+			keys := sign.NewKeySet()
+			for _, path := range publicKeyPaths {
+				verifier, err := sign.NewVerifierFromFile(path)
+				if err != nil {
+					return fmt.Errorf("can't load the public key %s: %v", path, err)
+				}
+				keys.Add(verifier)
+			}
+
+			if keysPath == "" {
+				return errors.New("the keys listed by the server are required to verify the signature")
+			}
+			ranges, err := readKeyRanges(keysPath)
+			if err != nil {
+				return err
+			}
+
+			receipt, err := readReceipt(args[0])
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			snapshot := receipt.SignedSnapshot.Snapshot
+			fmt.Fprintf(out, "Event digest: %x\n", receipt.EventDigest)
+			fmt.Fprintf(out, "Namespace: %s\n", snapshot.Namespace)
+			fmt.Fprintf(out, "Added at version: %d\n", receipt.Membership.ActualVersion)
+			fmt.Fprintf(out, "Snapshot version: %d\n", snapshot.Version)
+			fmt.Fprintf(out, "Signed by: %s (%s)\n", receipt.KeyID, receipt.SignedSnapshot.Algorithm)
+
+			switch err := receipt.Verify(keys, ranges); err {
+			case nil:
+				fmt.Fprintln(out, "Receipt OK")
+			case protocol.ErrHistoryOnly:
+				fmt.Fprintf(out, "Receipt partially verified: %v\n", err)
+			default:
+				return fmt.Errorf("the receipt is not valid: %v", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&publicKeyPaths, "public-key", []string{}, "Paths to the public keys of the server, either OpenSSH or PEM encoded PKIX, which verify the signature of the snapshot")
+	cmd.Flags().StringVar(&keysPath, "keys", "", "Path to the signing keys listed by the server at /keys, with the versions each one signs")
+
+	return cmd
+}
+
+func readReceipt(path string) (*protocol.Receipt, error) {
+	msg, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var receipt protocol.Receipt
+	if err := receipt.Decode(msg); err != nil {
+		return nil, fmt.Errorf("can't decode the receipt %s: %v", path, err)
+	}
+	if receipt.Membership == nil || receipt.SignedSnapshot == nil || receipt.SignedSnapshot.Snapshot == nil {
+		return nil, fmt.Errorf("%v: missing membership proof or snapshot", protocol.ErrInvalidReceipt)
+	}
+	return &receipt, nil
+}
+
+// readKeyRanges reads the signing keys listed by the server at /keys.
+func readKeyRanges(path string) (protocol.KeyRanges, error) {
+	msg, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*protocol.SigningKey
+	if err := json.Unmarshal(msg, &keys); err != nil {
+		return nil, fmt.Errorf("can't decode the signing keys %s: %v", path, err)
+	}
+	return protocol.NewKeyRanges(keys...), nil
+}
+
+func writeReceipt(path string, receipt *protocol.Receipt) error {
+	msg, err := receipt.Encode()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, msg, 0644)
+}
+
+// readSignedSnapshot reads a signed snapshot from a file, either the JSON
+// the server returns when adding an event or the receipt of one.
+func readSignedSnapshot(path string) (*protocol.SignedSnapshot, error) {
+	msg, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var receipt protocol.Receipt
+	if err := receipt.Decode(msg); err == nil && receipt.SignedSnapshot != nil {
+		return receipt.SignedSnapshot, nil
+	}
+	var signed protocol.SignedSnapshot
+	if err := signed.Decode(msg); err != nil || signed.Snapshot == nil {
+		return nil, fmt.Errorf("can't decode the signed snapshot %s", path)
+	}
+	return &signed, nil
+}
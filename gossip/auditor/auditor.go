@@ -22,12 +22,15 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/bbva/qed/client"
 	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/sign"
 )
 
 type Config struct {
@@ -57,7 +60,7 @@ type Auditor struct {
 func NewAuditor(conf Config) (*Auditor, error) {
 	auditor := Auditor{
 		qed: client.NewHTTPClient(client.Config{
-			Endpoint:  conf.QEDUrls[0],
+			Endpoints: conf.QEDUrls,
 			APIKey:    conf.APIKey,
 			Insecure: false,
 		}),
@@ -108,7 +111,12 @@ type Task interface {
 }
 
 func (t MembershipTask) getSnapshot(version uint64) (*protocol.SignedSnapshot, error) {
-	resp, err := http.Get(fmt.Sprintf("%s/snapshot?v=%d", t.pubUrl, version))
+	query := url.Values{}
+	query.Set("v", strconv.FormatUint(version, 10))
+	if t.s.Snapshot.Namespace != "" {
+		query.Set("ns", t.s.Snapshot.Namespace)
+	}
+	resp, err := http.Get(fmt.Sprintf("%s/snapshot?%s", t.pubUrl, query.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("Error getting snapshot from the store: %v", err)
 	}
@@ -135,8 +143,51 @@ type MembershipTask struct {
 	s      protocol.SignedSnapshot
 }
 
+// signingKey returns the public key of the key ID of the snapshot, as
+// listed by QED, along with whether it signs the version of the snapshot.
+func (t MembershipTask) signingKey() (*protocol.SigningKey, error) {
+	keys, err := t.qed.ForNamespace(t.s.Snapshot.Namespace).SigningKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if key.KeyID == t.s.KeyID {
+			return key, nil
+		}
+	}
+	return nil, nil
+}
+
+// verifySignature checks that the snapshot is signed with the key of its
+// key ID and that the key signs its version.
+func (t MembershipTask) verifySignature(key *protocol.SigningKey) error {
+	if key == nil {
+		return protocol.ErrUnknownKey
+	}
+	if !key.Signs(t.s.Snapshot.Version) {
+		return fmt.Errorf("the key %s does not sign version %d", key.KeyID, t.s.Snapshot.Version)
+	}
+	verifier, err := sign.NewVerifier(key.Algorithm, key.PublicKey)
+	if err != nil {
+		return err
+	}
+	return t.s.Verify(sign.NewKeySet(verifier), protocol.NewKeyRanges(key))
+}
+
 func (t MembershipTask) Do() {
-	proof, err := t.qed.MembershipDigest(t.s.Snapshot.EventDigest, t.s.Snapshot.Version)
+	key, err := t.signingKey()
+	if err != nil {
+		log.Infof("Unable to get the signing keys from QED, try later: %v", err)
+		t.taskCh <- t
+		return
+	}
+	if err := t.verifySignature(key); err != nil {
+		t.sendAlert(fmt.Sprintf("Invalid signature of snapshot %v: %v", t.s.Snapshot, err))
+		log.Infof("Invalid signature of snapshot %v: %v", t.s.Snapshot, err)
+		return
+	}
+
+	proof, err := t.qed.ForNamespace(t.s.Snapshot.Namespace).MembershipDigest(t.s.Snapshot.EventDigest, t.s.Snapshot.Version)
 	if err != nil {
 		// retry
 		t.sendAlert(fmt.Sprintf("Unable to verify snapshot %v", t.s.Snapshot))
@@ -144,6 +195,12 @@ func (t MembershipTask) Do() {
 		return
 	}
 
+	if !proof.Exists {
+		t.sendAlert(fmt.Sprintf("Event of snapshot %v is not a member", t.s.Snapshot))
+		log.Infof("Event of snapshot %v is not a member", t.s.Snapshot)
+		return
+	}
+
 	snap, err := t.getSnapshot(proof.CurrentVersion)
 	if err != nil {
 		log.Infof("Unable to get snapshot from storage, try later: %v", err)
@@ -155,8 +212,17 @@ func (t MembershipTask) Do() {
 		HyperDigest:   snap.Snapshot.HyperDigest,
 		Version:       t.s.Snapshot.Version,
 		EventDigest:   t.s.Snapshot.EventDigest,
+		HashAlgorithm: t.s.Snapshot.HashAlgorithm,
+		Timestamp:     t.s.Snapshot.Timestamp,
+		Namespace:     t.s.Snapshot.Namespace,
+	}
+	hasherF, err := hashing.NewHasherF(checkSnap.HashAlgorithm)
+	if err != nil {
+		t.sendAlert(fmt.Sprintf("Unable to verify snapshot %v: %v", t.s.Snapshot, err))
+		log.Infof("Unable to verify snapshot %v: %v", t.s.Snapshot, err)
+		return
 	}
-	ok := t.qed.DigestVerify(proof, checkSnap, hashing.NewSha256Hasher)
+	ok := t.qed.DigestVerify(proof, checkSnap, hasherF)
 	if !ok {
 		t.sendAlert(fmt.Sprintf("Unable to verify snapshot %v", t.s.Snapshot))
 		log.Infof("Unable to verify snapshot %v", t.s.Snapshot)
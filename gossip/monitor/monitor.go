@@ -58,7 +58,7 @@ func NewMonitor(conf Config) (*Monitor, error) {
 
 	monitor := Monitor{
 		client: client.NewHTTPClient(client.Config{
-			Endpoint:  conf.QedUrls[0],
+			Endpoints: conf.QedUrls,
 			APIKey:    conf.APIKey,
 			Insecure: false,
 		}),
@@ -76,6 +76,7 @@ func NewMonitor(conf Config) (*Monitor, error) {
 type QueryTask struct {
 	Start, End                 uint64
 	StartSnapshot, EndSnapshot protocol.Snapshot
+	Namespace                  string
 }
 
 func (m Monitor) Process(b protocol.BatchSnapshots) {
@@ -89,6 +90,7 @@ func (m Monitor) Process(b protocol.BatchSnapshots) {
 		End:           last.Version,
 		StartSnapshot: *first,
 		EndSnapshot:   *last,
+		Namespace:     b.Namespace,
 	}
 
 	m.taskCh <- task
@@ -152,14 +154,20 @@ func (m Monitor) sendAlert(msg string) {
 
 func (m Monitor) executeTask(task QueryTask) {
 	log.Debug("Executing task: %+v", task)
-	resp, err := m.client.Incremental(task.Start, task.End)
+	resp, err := m.client.ForNamespace(task.Namespace).Incremental(task.Start, task.End)
 	if err != nil {
 		// TODO: retry
 		m.sendAlert(fmt.Sprintf("Unable to verify incremental proof from %d to %d", task.Start, task.End))
 		log.Infof("Unable to verify incremental proof from %d to %d", task.Start, task.End)
 		return
 	}
-	ok := m.client.VerifyIncremental(resp, &task.StartSnapshot, &task.EndSnapshot, hashing.NewSha256Hasher())
+	hasherF, err := hashing.NewHasherF(task.EndSnapshot.HashAlgorithm)
+	if err != nil {
+		m.sendAlert(fmt.Sprintf("Unable to verify incremental proof from %d to %d: %v", task.Start, task.End, err))
+		log.Infof("Unable to verify incremental proof from %d to %d: %v", task.Start, task.End, err)
+		return
+	}
+	ok := m.client.VerifyIncremental(resp, &task.StartSnapshot, &task.EndSnapshot, hasherF())
 	if !ok {
 		m.sendAlert(fmt.Sprintf("Unable to verify incremental proof from %d to %d",
 			task.StartSnapshot.Version, task.EndSnapshot.Version))
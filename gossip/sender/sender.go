@@ -24,13 +24,12 @@ import (
 	"github.com/bbva/qed/gossip"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/protocol"
-	"github.com/bbva/qed/sign"
 )
 
 type Sender struct {
 	Agent  *gossip.Agent
 	Config *Config
-	signer sign.Signer
+	signer protocol.SnapshotSigner
 	quit   chan bool
 }
 
@@ -50,7 +49,7 @@ func DefaultConfig() *Config {
 	}
 }
 
-func NewSender(a *gossip.Agent, c *Config, s sign.Signer) *Sender {
+func NewSender(a *gossip.Agent, c *Config, s protocol.SnapshotSigner) *Sender {
 	return &Sender{
 		Agent:  a,
 		Config: c,
@@ -85,10 +84,15 @@ func (s Sender) batcherSender(id int, ch chan *protocol.Snapshot, quit chan bool
 			if len(batch.Snapshots) == 100 {
 				resetBatches()
 			}
+			// a batch only carries the snapshots of a namespace
+			if len(batch.Snapshots) > 0 && batch.Namespace != snap.Namespace {
+				resetBatches()
+			}
 			ss, err := s.doSign(snap)
 			if err != nil {
 				log.Errorf("Failed signing message: %v", err)
 			}
+			batch.Namespace = snap.Namespace
 			batch.Snapshots = append(batch.Snapshots, ss)
 
 		case <-ticker.C:
@@ -151,10 +155,10 @@ func (s Sender) Stop() {
 
 func (s *Sender) doSign(snapshot *protocol.Snapshot) (*protocol.SignedSnapshot, error) {
 
-	signature, err := s.signer.Sign([]byte(fmt.Sprintf("%v", snapshot)))
+	signed, err := s.signer.SignSnapshot(snapshot)
 	if err != nil {
 		fmt.Println("Publisher: error signing snapshot")
 		return nil, err
 	}
-	return &protocol.SignedSnapshot{Snapshot: snapshot, Signature: signature}, nil
+	return signed, nil
 }
@@ -0,0 +1,80 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hashing
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Identifiers of the hash algorithms a server can be configured with. They
+// are stored on first boot and sent along with snapshots and proofs so
+// clients can pick the matching hasher.
+const (
+	SHA256      = "sha256"
+	SHA512_256  = "sha512-256"
+	SHA3_256    = "sha3-256"
+	BLAKE2b_256 = "blake2b-256"
+)
+
+// DomainSeparation is the suffix of the identifiers of the hash algorithms
+// whose trees hash the leaves and the interior nodes in separate domains,
+// as RFC 6962 does, e.g. "sha256+rfc6962". The digests of the events are
+// the same with and without it.
+const DomainSeparation = "+rfc6962"
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown hash algorithm")
+
+	algorithms = map[string]func() Hasher{
+		SHA256:      NewSha256Hasher,
+		SHA512_256:  NewSha512_256Hasher,
+		SHA3_256:    NewSha3_256Hasher,
+		BLAKE2b_256: NewBlake2b256Hasher,
+	}
+)
+
+// NewHasherF returns the constructor of the hasher identified by the given
+// algorithm.
+func NewHasherF(algorithm string) (func() Hasher, error) {
+	hasherF, ok := algorithms[strings.TrimSuffix(algorithm, DomainSeparation)]
+	if !ok {
+		return nil, ErrUnknownAlgorithm
+	}
+	if HasDomainSeparation(algorithm) {
+		return NewDomainHasherF(hasherF), nil
+	}
+	return hasherF, nil
+}
+
+// HasDomainSeparation returns whether the given algorithm hashes the leaves
+// and the interior nodes of the trees in separate domains.
+func HasDomainSeparation(algorithm string) bool {
+	return strings.HasSuffix(algorithm, DomainSeparation)
+}
+
+// Algorithms returns the sorted list of supported hash algorithms, with
+// and without domain separation.
+func Algorithms() []string {
+	names := make([]string, 0, 2*len(algorithms))
+	for name := range algorithms {
+		names = append(names, name, name+DomainSeparation)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -18,7 +18,11 @@ package hashing
 
 import (
 	"crypto/sha256"
+	"crypto/sha512"
 	"hash"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
 )
 
 type Digest []byte
@@ -76,6 +80,41 @@ func (s *Sha256Hasher) Do(data ...[]byte) Digest {
 
 func (s Sha256Hasher) Len() uint16 { return uint16(256) }
 
+// DigestHasher implements the Hasher interface on top of any standard
+// hash.Hash with 256 bit digests.
+type DigestHasher struct {
+	underlying hash.Hash
+}
+
+func NewSha512_256Hasher() Hasher {
+	return &DigestHasher{underlying: sha512.New512_256()}
+}
+
+func NewSha3_256Hasher() Hasher {
+	return &DigestHasher{underlying: sha3.New256()}
+}
+
+func NewBlake2b256Hasher() Hasher {
+	// the error is only returned for keys longer than 64 bytes
+	underlying, _ := blake2b.New256(nil)
+	return &DigestHasher{underlying: underlying}
+}
+
+func (s *DigestHasher) Salted(salt []byte, data ...[]byte) Digest {
+	data = append(data, salt)
+	return s.Do(data...)
+}
+
+func (s *DigestHasher) Do(data ...[]byte) Digest {
+	s.underlying.Reset()
+	for i := 0; i < len(data); i++ {
+		s.underlying.Write(data[i])
+	}
+	return s.underlying.Sum(nil)[:]
+}
+
+func (s DigestHasher) Len() uint16 { return uint16(s.underlying.Size() * 8) }
+
 // PearsonHasher implements the Hasher interface and computes a 8 bit hash
 // function. Handy for testing hash tree implementations.
 type PearsonHasher struct{}
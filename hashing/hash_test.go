@@ -17,6 +17,7 @@
 package hashing
 
 import (
+	"encoding/hex"
 	"strconv"
 	"testing"
 
@@ -173,3 +174,53 @@ func TestFakePearsonHasher(t *testing.T) {
 		assert.Equal(t, hashDo, hashSalt, "Do and Salted hashes should NOT match in test: %s", testname)
 	}
 }
+
+func TestDigestHashers(t *testing.T) {
+	tests := map[string]struct {
+		hasherF        func() Hasher
+		expectedHashDo string
+	}{
+		SHA512_256:  {NewSha512_256Hasher, "53048e2681941ef99b2e29b76b4c7dabe4c2d0c634fc6d46e0e2f13107e7af23"},
+		SHA3_256:    {NewSha3_256Hasher, "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"},
+		BLAKE2b_256: {NewBlake2b256Hasher, "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+	}
+
+	for testname, test := range tests {
+		hasher := test.hasherF()
+		hashDo := hasher.Do([]byte("a"), []byte("bc"))
+		hashSalt := hasher.Salted([]byte("c"), []byte("a"), []byte("b"))
+		assert.Equalf(t, test.expectedHashDo, hex.EncodeToString(hashDo), "Hash Do don't match in test: %s", testname)
+		assert.Equalf(t, hashDo, hashSalt, "The salt should be appended to the data in test: %s", testname)
+		assert.Equalf(t, uint16(256), hasher.Len(), "Wrong length in test: %s", testname)
+	}
+}
+
+func TestNewHasherF(t *testing.T) {
+	for _, algorithm := range Algorithms() {
+		hasherF, err := NewHasherF(algorithm)
+		assert.NoErrorf(t, err, "The algorithm %s should be supported", algorithm)
+		assert.Equalf(t, uint16(256), hasherF().Len(), "Wrong length for algorithm %s", algorithm)
+	}
+
+	_, err := NewHasherF("md5")
+	assert.Equal(t, ErrUnknownAlgorithm, err, "Unknown algorithms should be rejected")
+}
+
+func TestDomainSeparation(t *testing.T) {
+	plainF, err := NewHasherF(SHA256)
+	assert.NoError(t, err)
+	domainF, err := NewHasherF(SHA256 + DomainSeparation)
+	assert.NoError(t, err)
+	assert.True(t, HasDomainSeparation(SHA256+DomainSeparation))
+	assert.False(t, HasDomainSeparation(SHA256))
+
+	plain, domain := plainF(), domainF()
+	salt, data := []byte{0x01}, []byte("data")
+
+	assert.Equal(t, plain.Do(data), domain.Do(data), "The digests of the events should not change")
+	assert.Equal(t, plain.Salted(salt, data), SaltedLeaf(plain, salt, data), "Plain hashers should not prefix leaves")
+	assert.Equal(t, plain.Salted(salt, data), SaltedNode(plain, salt, data), "Plain hashers should not prefix nodes")
+	assert.Equal(t, plain.Do([]byte{LeafPrefix}, data, salt), SaltedLeaf(domain, salt, data), "Leaves should be prefixed")
+	assert.Equal(t, plain.Do([]byte{NodePrefix}, data, salt), SaltedNode(domain, salt, data), "Nodes should be prefixed")
+	assert.NotEqual(t, DoLeaf(domain, data), DoNode(domain, data), "Leaves and nodes should be in separate domains")
+}
@@ -0,0 +1,89 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package hashing
+
+// Prefixes of the data hashed for the leaves and the interior nodes of a
+// tree by the hashers with domain separation, as RFC 6962 does, so a leaf
+// can never be taken for a node.
+const (
+	LeafPrefix byte = 0x00
+	NodePrefix byte = 0x01
+)
+
+// TreeHasher is implemented by the hashers that separate the domains of
+// the leaves and the interior nodes of a tree.
+type TreeHasher interface {
+	Hasher
+	Leaf(data ...[]byte) Digest
+	Node(data ...[]byte) Digest
+}
+
+type domainHasher struct {
+	Hasher
+}
+
+// NewDomainHasherF returns the constructor of a hasher that prefixes the
+// leaves with LeafPrefix and the interior nodes with NodePrefix, on top of
+// the hashers built by the given one.
+func NewDomainHasherF(hasherF func() Hasher) func() Hasher {
+	return func() Hasher {
+		return &domainHasher{hasherF()}
+	}
+}
+
+func (h *domainHasher) Leaf(data ...[]byte) Digest {
+	return h.Do(append([][]byte{{LeafPrefix}}, data...)...)
+}
+
+func (h *domainHasher) Node(data ...[]byte) Digest {
+	return h.Do(append([][]byte{{NodePrefix}}, data...)...)
+}
+
+// SaltedLeaf returns the digest of a leaf of a tree, whose position is
+// given by the salt. Only the hashers with domain separation prefix it.
+func SaltedLeaf(h Hasher, salt []byte, data ...[]byte) Digest {
+	if th, ok := h.(TreeHasher); ok {
+		return th.Leaf(append(data, salt)...)
+	}
+	return h.Salted(salt, data...)
+}
+
+// SaltedNode returns the digest of an interior node of a tree, whose
+// position is given by the salt. Only the hashers with domain separation
+// prefix it.
+func SaltedNode(h Hasher, salt []byte, data ...[]byte) Digest {
+	if th, ok := h.(TreeHasher); ok {
+		return th.Node(append(data, salt)...)
+	}
+	return h.Salted(salt, data...)
+}
+
+// DoLeaf returns the digest of a leaf without position.
+func DoLeaf(h Hasher, data ...[]byte) Digest {
+	if th, ok := h.(TreeHasher); ok {
+		return th.Leaf(data...)
+	}
+	return h.Do(data...)
+}
+
+// DoNode returns the digest of an interior node without position.
+func DoNode(h Hasher, data ...[]byte) Digest {
+	if th, ok := h.(TreeHasher); ok {
+		return th.Node(data...)
+	}
+	return h.Do(data...)
+}
@@ -0,0 +1,59 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import "fmt"
+
+// ReadConsistency is how up to date the answer to a query must be. It is
+// given in the ConsistencyParam parameter of the url of the query.
+type ReadConsistency string
+
+const (
+	// StaleReads are answered by any node with the versions it applied,
+	// which may be behind the ones of the leader.
+	StaleReads ReadConsistency = "stale"
+
+	// LeaderReads are only answered by the leader, which may have just
+	// lost its leadership without knowing it yet.
+	LeaderReads ReadConsistency = "leader"
+
+	// LinearizableReads are only answered by the leader once the rest of
+	// the cluster confirms it still is, and it applied every addition
+	// committed before the query.
+	LinearizableReads ReadConsistency = "linearizable"
+)
+
+// ConsistencyParam is the parameter of the url of a query with its read
+// consistency, which is stale if it is not given.
+const ConsistencyParam = "consistency"
+
+// VersionHeader is the header with the last version applied by the node
+// that answered a query, so clients know how stale the answer may be. It
+// is not set before the first addition.
+const VersionHeader = "Qed-Version"
+
+// ParseReadConsistency returns the read consistency with the given name,
+// stale if it is empty.
+func ParseReadConsistency(name string) (ReadConsistency, error) {
+	switch c := ReadConsistency(name); c {
+	case "":
+		return StaleReads, nil
+	case StaleReads, LeaderReads, LinearizableReads:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown read consistency %q", name)
+}
@@ -0,0 +1,369 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/bbva/qed/balloon/hyper"
+	"github.com/bbva/qed/balloon/navigator"
+	"github.com/bbva/qed/balloon/visitor"
+	"github.com/bbva/qed/hashing"
+)
+
+// ProofContentType is the media type of the compact binary encoding of
+// membership and incremental proofs.
+const ProofContentType = "application/x-qed-proof"
+
+// The binary encoding starts with the encoding version and the kind of
+// proof. Integers are encoded as uvarints and byte slices and strings are
+// prefixed with their length.
+//
+// Audit paths are encoded either as a generic list of positions and
+// digests or, for hyper audit paths that follow the path to the queried
+// key, as a bitmap with one bit per height of the tree. Only the siblings
+// whose bits are set are included; the rest are default hashes that the
+// decoder computes with the hash algorithm of the proof.
+const (
+	proofEncodingVersion = byte(1)
+
+	membershipProofKind  = byte(1)
+	incrementalProofKind = byte(2)
+
+	genericAuditPath = byte(0)
+	keyPathAuditPath = byte(1)
+)
+
+var (
+	ErrInvalidProofEncoding     = errors.New("invalid proof encoding")
+	ErrUnsupportedProofEncoding = errors.New("unsupported proof encoding version")
+)
+
+// MarshalBinary encodes a membership result with the compact binary
+// encoding.
+func (r *MembershipResult) MarshalBinary() ([]byte, error) {
+	var w proofWriter
+	w.writeHeader(membershipProofKind)
+
+	var flags byte
+	if r.Exists {
+		flags = 1
+	}
+	w.WriteByte(flags)
+	w.writeUvarint(r.CurrentVersion)
+	w.writeUvarint(r.QueryVersion)
+	w.writeUvarint(r.ActualVersion)
+	w.writeBytes(r.KeyDigest)
+	w.writeBytes(r.Key)
+	w.writeBytes([]byte(r.HashAlgorithm))
+	w.writeKeyPath(r.Hyper, r.KeyDigest, r.HashAlgorithm)
+	w.writeAuditPath(r.History)
+
+	return w.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a membership result from the compact binary
+// encoding.
+func (r *MembershipResult) UnmarshalBinary(data []byte) error {
+	rd := newProofReader(data)
+	rd.readHeader(membershipProofKind)
+
+	flags := rd.readByte()
+	r.Exists = flags&1 == 1
+	r.CurrentVersion = rd.readUvarint()
+	r.QueryVersion = rd.readUvarint()
+	r.ActualVersion = rd.readUvarint()
+	r.KeyDigest = rd.readBytes()
+	r.Key = rd.readBytes()
+	r.HashAlgorithm = string(rd.readBytes())
+	r.Hyper = rd.readKeyPath(r.KeyDigest, r.HashAlgorithm)
+	r.History = rd.readAuditPath()
+
+	return rd.done()
+}
+
+// MarshalBinary encodes an incremental response with the compact binary
+// encoding.
+func (r *IncrementalResponse) MarshalBinary() ([]byte, error) {
+	var w proofWriter
+	w.writeHeader(incrementalProofKind)
+	w.writeUvarint(r.Start)
+	w.writeUvarint(r.End)
+	w.writeBytes([]byte(r.HashAlgorithm))
+	w.writeAuditPath(r.AuditPath)
+	return w.Bytes(), nil
+}
+
+// UnmarshalBinary decodes an incremental response from the compact binary
+// encoding.
+func (r *IncrementalResponse) UnmarshalBinary(data []byte) error {
+	rd := newProofReader(data)
+	rd.readHeader(incrementalProofKind)
+	r.Start = rd.readUvarint()
+	r.End = rd.readUvarint()
+	r.HashAlgorithm = string(rd.readBytes())
+	r.AuditPath = rd.readAuditPath()
+	return rd.done()
+}
+
+// keyPath calls the given function for every height of the hyper tree
+// from the root to the leaves, with the positions of the node on the path
+// to the key and of its sibling.
+func keyPath(key []byte, numBits uint16, f func(height uint16, onPath, sibling navigator.Position) bool) {
+	nav := hyper.NewHyperTreeNavigator(numBits)
+	pos := nav.Root()
+	for height := int(numBits) - 1; height >= 0; height-- {
+		onPath, sibling := nav.GoToLeft(pos), nav.GoToRight(pos)
+		if key[(int(numBits)-1-height)/8]&(1<<uint(7-(int(numBits)-1-height)%8)) != 0 {
+			onPath, sibling = sibling, onPath
+		}
+		if !f(uint16(height), onPath, sibling) {
+			return
+		}
+		pos = onPath
+	}
+}
+
+func defaultHashes(key []byte, hashAlgorithm string) ([]hashing.Digest, bool) {
+	hasherF, err := hashing.NewHasherF(hashAlgorithm)
+	if err != nil {
+		return nil, false
+	}
+	hasher := hasherF()
+	if len(key) == 0 || int(hasher.Len()) != len(key)*8 {
+		return nil, false
+	}
+	return hyper.DefaultHashes(hasher), true
+}
+
+type proofWriter struct {
+	bytes.Buffer
+}
+
+func (w *proofWriter) writeHeader(kind byte) {
+	w.WriteByte(proofEncodingVersion)
+	w.WriteByte(kind)
+}
+
+func (w *proofWriter) writeUvarint(x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	w.Write(buf[:n])
+}
+
+func (w *proofWriter) writeBytes(b []byte) {
+	w.writeUvarint(uint64(len(b)))
+	w.Write(b)
+}
+
+func (w *proofWriter) writeAuditPath(path visitor.AuditPath) {
+	w.WriteByte(genericAuditPath)
+	w.writeUvarint(uint64(len(path)))
+	for id, digest := range path {
+		w.writeBytes([]byte(id))
+		w.writeBytes(digest)
+	}
+}
+
+// writeKeyPath encodes a hyper audit path as a bitmap of the siblings on
+// the path to the key. A proof of non-membership stops at the first empty
+// subtree on the path, which is also part of the audit path. Audit paths
+// that do not follow that layout are encoded as generic ones.
+func (w *proofWriter) writeKeyPath(path visitor.AuditPath, key []byte, hashAlgorithm string) {
+	defaults, ok := defaultHashes(key, hashAlgorithm)
+	if !ok || len(path) == 0 {
+		w.writeAuditPath(path)
+		return
+	}
+
+	numBits := uint16(len(key) * 8)
+	bitmap := make([]byte, len(key))
+	var digests []hashing.Digest
+	var stop uint64 // height of the empty subtree plus one, zero if none
+	used := 0
+	valid := true
+
+	keyPath(key, numBits, func(height uint16, onPath, sibling navigator.Position) bool {
+		digest, ok := path.Get(sibling)
+		if !ok {
+			valid = false
+			return false
+		}
+		used++
+		if !bytes.Equal(digest, defaults[height]) {
+			bitmap[height/8] |= 1 << (height % 8)
+			digests = append(digests, digest)
+		}
+		if empty, ok := path.Get(onPath); ok {
+			if !bytes.Equal(empty, defaults[height]) {
+				valid = false
+			}
+			used++
+			stop = uint64(height) + 1
+			return false
+		}
+		return true
+	})
+
+	if !valid || used != len(path) {
+		w.writeAuditPath(path)
+		return
+	}
+
+	w.WriteByte(keyPathAuditPath)
+	w.writeUvarint(stop)
+	w.Write(bitmap)
+	for _, digest := range digests {
+		w.Write(digest)
+	}
+}
+
+type proofReader struct {
+	*bytes.Reader
+	err error
+}
+
+func newProofReader(data []byte) *proofReader {
+	return &proofReader{Reader: bytes.NewReader(data)}
+}
+
+func (r *proofReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *proofReader) readHeader(kind byte) {
+	if r.readByte() != proofEncodingVersion {
+		r.fail(ErrUnsupportedProofEncoding)
+	}
+	if r.readByte() != kind {
+		r.fail(ErrInvalidProofEncoding)
+	}
+}
+
+func (r *proofReader) readByte() byte {
+	if r.err != nil {
+		return 0
+	}
+	b, err := r.ReadByte()
+	if err != nil {
+		r.fail(ErrInvalidProofEncoding)
+	}
+	return b
+}
+
+func (r *proofReader) readUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(r)
+	if err != nil {
+		r.fail(ErrInvalidProofEncoding)
+	}
+	return x
+}
+
+func (r *proofReader) readFull(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(r.Len()) {
+		r.fail(ErrInvalidProofEncoding)
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		r.fail(ErrInvalidProofEncoding)
+		return nil
+	}
+	return b
+}
+
+func (r *proofReader) readBytes() []byte {
+	return r.readFull(r.readUvarint())
+}
+
+func (r *proofReader) readAuditPath() visitor.AuditPath {
+	if r.readByte() != genericAuditPath {
+		r.fail(ErrInvalidProofEncoding)
+	}
+	return r.readGenericAuditPath()
+}
+
+func (r *proofReader) readGenericAuditPath() visitor.AuditPath {
+	size := r.readUvarint()
+	if size == 0 {
+		return nil
+	}
+	path := make(visitor.AuditPath)
+	for i := uint64(0); i < size && r.err == nil; i++ {
+		id := string(r.readBytes())
+		path[id] = r.readBytes()
+	}
+	return path
+}
+
+func (r *proofReader) readKeyPath(key []byte, hashAlgorithm string) visitor.AuditPath {
+	switch r.readByte() {
+	case genericAuditPath:
+		return r.readGenericAuditPath()
+	case keyPathAuditPath:
+	default:
+		r.fail(ErrInvalidProofEncoding)
+		return nil
+	}
+
+	defaults, ok := defaultHashes(key, hashAlgorithm)
+	if !ok {
+		r.fail(ErrInvalidProofEncoding)
+		return nil
+	}
+	numBits := uint16(len(key) * 8)
+	stop := r.readUvarint()
+	bitmap := r.readFull(uint64(len(key)))
+	if r.err != nil || stop > uint64(numBits) {
+		r.fail(ErrInvalidProofEncoding)
+		return nil
+	}
+
+	path := make(visitor.AuditPath)
+	keyPath(key, numBits, func(height uint16, onPath, sibling navigator.Position) bool {
+		digest := defaults[height]
+		if bitmap[height/8]&(1<<(height%8)) != 0 {
+			digest = r.readFull(uint64(len(key)))
+		}
+		path[sibling.StringId()] = digest
+		if stop > 0 && uint64(height) == stop-1 {
+			path[onPath.StringId()] = defaults[height]
+			return false
+		}
+		return true
+	})
+
+	return path
+}
+
+func (r *proofReader) done() error {
+	if r.err == nil && r.Len() != 0 {
+		r.fail(ErrInvalidProofEncoding)
+	}
+	return r.err
+}
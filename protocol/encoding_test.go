@@ -0,0 +1,130 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+)
+
+func newTestBalloon(t *testing.T, events int) (*balloon.Balloon, []*balloon.Snapshot, func()) {
+	store, closeF := storage_utils.OpenBPlusTreeStore()
+
+	b, err := balloon.NewBalloon(store, hashing.NewSha256Hasher)
+	require.NoError(t, err)
+
+	snapshots := make([]*balloon.Snapshot, events)
+	for i := 0; i < events; i++ {
+		snapshot, mutations, err := b.Add([]byte(fmt.Sprintf("event %d", i)))
+		require.NoError(t, err)
+		require.NoError(t, store.Mutate(mutations))
+		snapshots[i] = snapshot
+	}
+
+	return b, snapshots, closeF
+}
+
+func TestMembershipResultBinaryEncoding(t *testing.T) {
+	log.SetLogger("TestMembershipResultBinaryEncoding", log.SILENT)
+
+	b, snapshots, closeF := newTestBalloon(t, 10)
+	defer closeF()
+	last := snapshots[len(snapshots)-1]
+
+	testCases := []struct {
+		event  []byte
+		exists bool
+	}{
+		{[]byte("event 0"), true},
+		{[]byte("event 5"), true},
+		{[]byte("event 9"), true},
+		{[]byte("missing event"), false},
+	}
+
+	for i, c := range testCases {
+		proof, err := b.QueryMembership(c.event, last.Version)
+		require.NoError(t, err, "Error in test case %d", i)
+		require.Equal(t, c.exists, proof.Exists, "Wrong membership in test case %d", i)
+
+		result := ToMembershipResult(c.event, proof, hashing.SHA256)
+		encoded, err := result.MarshalBinary()
+		require.NoError(t, err, "Error encoding in test case %d", i)
+
+		jsonEncoded, err := json.Marshal(result)
+		require.NoError(t, err)
+		assert.True(t, len(encoded) < len(jsonEncoded)/4, "The binary encoding should be compact in test case %d", i)
+
+		var decoded MembershipResult
+		require.NoError(t, decoded.UnmarshalBinary(encoded), "Error decoding in test case %d", i)
+		assert.Equal(t, result, &decoded, "The decoded result should be equal to the original in test case %d", i)
+
+		balloonProof := ToBalloonProof(&decoded, hashing.NewSha256Hasher)
+		assert.True(t, balloonProof.Verify(c.event, last), "The decoded proof should verify in test case %d", i)
+	}
+}
+
+func TestIncrementalResponseBinaryEncoding(t *testing.T) {
+	log.SetLogger("TestIncrementalResponseBinaryEncoding", log.SILENT)
+
+	b, snapshots, closeF := newTestBalloon(t, 10)
+	defer closeF()
+
+	proof, err := b.QueryConsistency(2, 8)
+	require.NoError(t, err)
+
+	response := ToIncrementalResponse(proof, hashing.SHA256)
+	encoded, err := response.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded IncrementalResponse
+	require.NoError(t, decoded.UnmarshalBinary(encoded))
+	assert.Equal(t, response, &decoded, "The decoded response should be equal to the original")
+
+	incremental := ToIncrementalProof(&decoded, hashing.NewSha256Hasher())
+	assert.True(t, incremental.Verify(snapshots[2], snapshots[8]), "The decoded proof should verify")
+}
+
+func TestInvalidBinaryEncoding(t *testing.T) {
+	log.SetLogger("TestInvalidBinaryEncoding", log.SILENT)
+
+	b, _, closeF := newTestBalloon(t, 3)
+	defer closeF()
+
+	proof, err := b.QueryMembership([]byte("event 1"), 2)
+	require.NoError(t, err)
+	encoded, err := ToMembershipResult([]byte("event 1"), proof, hashing.SHA256).MarshalBinary()
+	require.NoError(t, err)
+
+	var result MembershipResult
+	assert.Equal(t, ErrInvalidProofEncoding, result.UnmarshalBinary(encoded[:len(encoded)-1]), "A truncated proof should fail")
+	assert.Equal(t, ErrInvalidProofEncoding, result.UnmarshalBinary(append(encoded, 0x0)), "Trailing bytes should fail")
+
+	var response IncrementalResponse
+	assert.Equal(t, ErrInvalidProofEncoding, response.UnmarshalBinary(encoded), "A membership proof is not an incremental one")
+
+	encoded[0] = 0x2
+	assert.Equal(t, ErrUnsupportedProofEncoding, result.UnmarshalBinary(encoded), "An unknown version should fail")
+}
@@ -29,12 +29,23 @@ import (
 	"github.com/bbva/qed/util"
 )
 
+// LeaderHeader is the header with the endpoint of the API of the leader
+// that a node answering a write it can not apply may add, so the client
+// sends it there instead of trying every node.
+const LeaderHeader = "Qed-Leader"
+
 // Event is the public struct that Add handler function uses to
 // parse the post params.
 type Event struct {
 	Event []byte
 }
 
+// EventsBulk is the public struct that AddBulk handler function uses to
+// parse the post params.
+type EventsBulk struct {
+	Events [][]byte
+}
+
 // MembershipQuery is the public struct that apihttp.Membership
 // Handler uses to parse the post params.
 type MembershipQuery struct {
@@ -49,19 +60,111 @@ type MembershipDigest struct {
 	Version   uint64
 }
 
+// BatchMembershipDigests is the public struct that
+// apihttp.BatchMembership Handler uses to parse the post params.
+type BatchMembershipDigests struct {
+	KeyDigests []hashing.Digest
+	Version    uint64
+}
+
+// RangeQuery is the public struct that apihttp.Range Handler uses to parse
+// the post params. Start and End are both included in the range.
+type RangeQuery struct {
+	Start   uint64
+	End     uint64
+	Version uint64
+}
+
+// VersionAtQuery is the public struct that apihttp.VersionAt Handler uses
+// to parse the post params. Timestamp is in nanoseconds since the Unix epoch.
+type VersionAtQuery struct {
+	Timestamp int64
+}
+
+// VersionAtResult is the public struct that apihttp.VersionAt Handler call
+// returns. Version is the last version committed at or before the queried
+// time, and Timestamp is the time it was committed.
+type VersionAtResult struct {
+	Version   uint64
+	Timestamp int64
+}
+
+// KeyValue is the public struct that apihttp.AddKeyValue Handler uses to
+// parse the post params.
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// KeyValueQuery is the public struct that apihttp.KeyValue Handler uses to
+// parse the post params.
+type KeyValueQuery struct {
+	Key []byte
+}
+
+// KeyHistoryQuery is the public struct that apihttp.KeyHistory Handler uses
+// to parse the post params.
+type KeyHistoryQuery struct {
+	Key   []byte
+	Start uint64
+	End   uint64
+}
+
 // Snapshot is the public struct that apihttp.Add Handler call returns.
+// Timestamp is the time the version was committed, in nanoseconds since the
+// Unix epoch. Namespace is the log the version belongs to, empty for the
+// default one.
 type Snapshot struct {
 	HistoryDigest hashing.Digest
 	HyperDigest   hashing.Digest
 	Version       uint64
 	EventDigest   hashing.Digest
+	HashAlgorithm string
+	Timestamp     int64
+	Namespace     string `json:",omitempty"`
+}
+
+// ToSnapshot translates internal api balloon.Snapshot to the public struct
+// protocol.Snapshot.
+func ToSnapshot(s *balloon.Snapshot, hashAlgorithm string) *Snapshot {
+	return &Snapshot{
+		HistoryDigest: s.HistoryDigest,
+		HyperDigest:   s.HyperDigest,
+		Version:       s.Version,
+		EventDigest:   s.EventDigest,
+		HashAlgorithm: hashAlgorithm,
+		Timestamp:     s.Timestamp,
+		Namespace:     s.Namespace,
+	}
 }
 
+// SignedSnapshot is a snapshot along with the signature of its canonical
+// encoding, made with the key of the given ID.
 type SignedSnapshot struct {
 	Snapshot  *Snapshot
+	KeyID     string
+	Algorithm string `json:",omitempty"`
 	Signature []byte
 }
 
+// SigningKey is the public struct that apihttp.Keys Handler call returns
+// for every key that signs the snapshots of a log. It signs the versions
+// from Start while it is active, and the ones from Start to End, not
+// included, once it is retired.
+type SigningKey struct {
+	KeyID     string
+	Algorithm string
+	PublicKey []byte
+	Start     uint64
+	End       uint64 `json:",omitempty"`
+	Active    bool
+}
+
+// Signs returns whether the key signs the given version.
+func (k *SigningKey) Signs(version uint64) bool {
+	return version >= k.Start && (k.Active || version < k.End)
+}
+
 func (b *SignedSnapshot) Encode() ([]byte, error) {
 	return json.Marshal(b)
 }
@@ -71,10 +174,13 @@ func (b *SignedSnapshot) Decode(msg []byte) error {
 	return err
 }
 
+// BatchSnapshots is the message the gossip agents share. All the snapshots
+// of a batch belong to the same namespace.
 type BatchSnapshots struct {
 	Snapshots []*SignedSnapshot
 	TTL       int
 	From      *member.Peer
+	Namespace string `json:",omitempty"`
 }
 
 type Source struct {
@@ -110,6 +216,71 @@ type MembershipResult struct {
 	ActualVersion  uint64
 	KeyDigest      hashing.Digest
 	Key            []byte
+	HashAlgorithm  string
+	Versions       []uint64 `json:",omitempty"`
+}
+
+// BatchMembershipResult is the public struct that apihttp.BatchMembership
+// Handler call returns. Exists and ActualVersions follow the order of the
+// key digests, and the audit paths are shared by all of them.
+type BatchMembershipResult struct {
+	KeyDigests     []hashing.Digest
+	Exists         []bool
+	ActualVersions []uint64
+	Hyper          visitor.AuditPath
+	History        visitor.AuditPath
+	CurrentVersion uint64
+	QueryVersion   uint64
+	HashAlgorithm  string
+	Versions       [][]uint64 `json:",omitempty"`
+}
+
+// RangeResult is the public struct that apihttp.Range Handler call returns.
+// EventDigests follow the order of the versions from Start to End.
+type RangeResult struct {
+	Start         uint64
+	End           uint64
+	Version       uint64
+	EventDigests  []hashing.Digest
+	History       visitor.AuditPath
+	HashAlgorithm string
+}
+
+// KeyValueEntry is an update of the value of a key along with the history
+// audit path to verify it.
+type KeyValueEntry struct {
+	Version  uint64
+	Previous uint64
+	Value    []byte
+	History  visitor.AuditPath
+}
+
+// KeyValueResult is the public struct that apihttp.KeyValue Handler call
+// returns.
+type KeyValueResult struct {
+	Exists         bool
+	Key            []byte
+	KeyDigest      hashing.Digest
+	Entry          *KeyValueEntry
+	Hyper          visitor.AuditPath
+	CurrentVersion uint64
+	HashAlgorithm  string
+}
+
+// KeyHistoryResult is the public struct that apihttp.KeyHistory Handler
+// call returns.
+type KeyHistoryResult struct {
+	Exists         bool
+	Key            []byte
+	KeyDigest      hashing.Digest
+	Start          uint64
+	End            uint64
+	LatestVersion  uint64
+	Entries        []*KeyValueEntry
+	Next           *KeyValueEntry
+	Hyper          visitor.AuditPath
+	CurrentVersion uint64
+	HashAlgorithm  string
 }
 
 type IncrementalRequest struct {
@@ -118,23 +289,31 @@ type IncrementalRequest struct {
 }
 
 type IncrementalResponse struct {
-	Start     uint64
-	End       uint64
-	AuditPath visitor.AuditPath
+	Start         uint64
+	End           uint64
+	AuditPath     visitor.AuditPath
+	HashAlgorithm string
 }
 
 // ToMembershipProof translates internal api balloon.MembershipProof to the
 // public struct protocol.MembershipResult.
-func ToMembershipResult(key []byte, mp *balloon.MembershipProof) *MembershipResult {
+func ToMembershipResult(key []byte, mp *balloon.MembershipProof, hashAlgorithm string) *MembershipResult {
+	// non-membership proofs have no history audit path
+	var historyAuditPath visitor.AuditPath
+	if mp.HistoryProof != nil {
+		historyAuditPath = mp.HistoryProof.AuditPath()
+	}
 	return &MembershipResult{
 		mp.Exists,
 		mp.HyperProof.AuditPath(),
-		mp.HistoryProof.AuditPath(),
+		historyAuditPath,
 		mp.CurrentVersion,
 		mp.QueryVersion,
 		mp.ActualVersion,
 		mp.KeyDigest,
 		key,
+		hashAlgorithm,
+		mp.Versions,
 	}
 }
 
@@ -142,6 +321,22 @@ func ToMembershipResult(key []byte, mp *balloon.MembershipProof) *MembershipResu
 // balloon.Proof.
 func ToBalloonProof(mr *MembershipResult, hasherF func() hashing.Hasher) *balloon.MembershipProof {
 
+	if !mr.Exists {
+		// a proof of non-membership only includes the hyper audit path
+		// to an empty subtree, so there is no value to verify
+		hyperProof := hyper.NewQueryProof(mr.KeyDigest, nil, mr.Hyper, hasherF())
+		return balloon.NewMembershipProof(
+			mr.Exists,
+			hyperProof,
+			nil,
+			mr.CurrentVersion,
+			mr.QueryVersion,
+			mr.ActualVersion,
+			mr.KeyDigest,
+			hasherF(),
+		)
+	}
+
 	historyProof := history.NewMembershipProof(
 		mr.ActualVersion,
 		mr.QueryVersion,
@@ -149,14 +344,19 @@ func ToBalloonProof(mr *MembershipResult, hasherF func() hashing.Hasher) *balloo
 		hasherF(),
 	)
 
+	// the hyper leaf of an event added several times has all its versions
+	value := util.Uint64AsBytes(mr.ActualVersion)
+	if len(mr.Versions) > 0 {
+		value = balloon.LeafValue(mr.Versions)
+	}
 	hyperProof := hyper.NewQueryProof(
 		mr.KeyDigest,
-		util.Uint64AsBytes(mr.ActualVersion),
+		value,
 		mr.Hyper,
 		hasherF(),
 	)
 
-	return balloon.NewMembershipProof(
+	proof := balloon.NewMembershipProof(
 		mr.Exists,
 		hyperProof,
 		historyProof,
@@ -166,14 +366,206 @@ func ToBalloonProof(mr *MembershipResult, hasherF func() hashing.Hasher) *balloo
 		mr.KeyDigest,
 		hasherF(),
 	)
+	proof.Versions = mr.Versions
+	return proof
+
+}
+
+// ToBatchMembershipResult translates internal api
+// balloon.BatchMembershipProof to the public struct
+// protocol.BatchMembershipResult.
+func ToBatchMembershipResult(p *balloon.BatchMembershipProof, hashAlgorithm string) *BatchMembershipResult {
+	// a batch without members has no history audit path
+	var historyAuditPath visitor.AuditPath
+	if p.HistoryProof != nil {
+		historyAuditPath = p.HistoryProof.AuditPath()
+	}
+	return &BatchMembershipResult{
+		KeyDigests:     p.KeyDigests,
+		Exists:         p.Exists,
+		ActualVersions: p.ActualVersions,
+		Hyper:          p.HyperProof.AuditPath(),
+		History:        historyAuditPath,
+		CurrentVersion: p.CurrentVersion,
+		QueryVersion:   p.QueryVersion,
+		HashAlgorithm:  hashAlgorithm,
+		Versions:       p.Versions,
+	}
+}
+
+// ToBatchMembershipProof translates public protocol.BatchMembershipResult
+// to internal balloon.BatchMembershipProof.
+func ToBatchMembershipProof(r *BatchMembershipResult, hasherF func() hashing.Hasher) *balloon.BatchMembershipProof {
+	keys := make([][]byte, len(r.KeyDigests))
+	values := make([][]byte, len(r.KeyDigests))
+	var indexes []uint64
+	for i, keyDigest := range r.KeyDigests {
+		keys[i] = keyDigest
+		if i < len(r.Exists) && i < len(r.ActualVersions) && r.Exists[i] {
+			values[i] = util.Uint64AsBytes(r.ActualVersions[i])
+			if i < len(r.Versions) && len(r.Versions[i]) > 0 {
+				values[i] = balloon.LeafValue(r.Versions[i])
+			}
+			indexes = append(indexes, r.ActualVersions[i])
+		}
+	}
+
+	var historyProof *history.BatchMembershipProof
+	if len(indexes) > 0 {
+		historyProof = history.NewBatchMembershipProof(indexes, r.QueryVersion, r.History, hasherF())
+	}
+
+	return &balloon.BatchMembershipProof{
+		KeyDigests:     r.KeyDigests,
+		Exists:         r.Exists,
+		ActualVersions: r.ActualVersions,
+		Versions:       r.Versions,
+		HyperProof:     hyper.NewBatchQueryProof(keys, values, r.Hyper, hasherF()),
+		HistoryProof:   historyProof,
+		CurrentVersion: r.CurrentVersion,
+		QueryVersion:   r.QueryVersion,
+		Hasher:         hasherF(),
+	}
+}
+
+// ToRangeResult translates internal api balloon.RangeProof to the public
+// struct protocol.RangeResult.
+func ToRangeResult(p *balloon.RangeProof, hashAlgorithm string) *RangeResult {
+	return &RangeResult{
+		Start:         p.Start,
+		End:           p.End,
+		Version:       p.Version,
+		EventDigests:  p.EventDigests,
+		History:       p.AuditPath,
+		HashAlgorithm: hashAlgorithm,
+	}
+}
+
+// ToRangeProof translates public protocol.RangeResult to internal
+// balloon.RangeProof.
+func ToRangeProof(r *RangeResult, hasherF func() hashing.Hasher) *balloon.RangeProof {
+	return balloon.NewRangeProof(
+		r.Start,
+		r.End,
+		r.Version,
+		r.EventDigests,
+		r.History,
+		hasherF(),
+	)
+}
+
+func toKeyValueEntry(e *balloon.KeyValueEntry) *KeyValueEntry {
+	if e == nil {
+		return nil
+	}
+	return &KeyValueEntry{
+		Version:  e.Version,
+		Previous: e.Previous,
+		Value:    e.Value,
+		History:  e.HistoryProof.AuditPath(),
+	}
+}
+
+func toBalloonEntry(e *KeyValueEntry, currentVersion uint64, hasherF func() hashing.Hasher) *balloon.KeyValueEntry {
+	if e == nil {
+		return nil
+	}
+	return &balloon.KeyValueEntry{
+		Version:      e.Version,
+		Previous:     e.Previous,
+		Value:        e.Value,
+		HistoryProof: history.NewMembershipProof(e.Version, currentVersion, e.History, hasherF()),
+	}
+}
+
+// ToKeyValueResult translates internal api balloon.KeyValueProof to the
+// public struct protocol.KeyValueResult.
+func ToKeyValueResult(p *balloon.KeyValueProof, hashAlgorithm string) *KeyValueResult {
+	return &KeyValueResult{
+		Exists:         p.Exists,
+		Key:            p.Key,
+		KeyDigest:      p.KeyDigest,
+		Entry:          toKeyValueEntry(p.Entry),
+		Hyper:          p.HyperProof.AuditPath(),
+		CurrentVersion: p.CurrentVersion,
+		HashAlgorithm:  hashAlgorithm,
+	}
+}
 
+// ToKeyValueProof translates public protocol.KeyValueResult to internal
+// balloon.KeyValueProof.
+func ToKeyValueProof(r *KeyValueResult, hasherF func() hashing.Hasher) *balloon.KeyValueProof {
+	// the hyper leaf of an existing key holds the version of its latest
+	// update
+	var value []byte
+	if r.Exists && r.Entry != nil {
+		value = util.Uint64AsBytes(r.Entry.Version)
+	}
+	return &balloon.KeyValueProof{
+		Exists:         r.Exists,
+		Key:            r.Key,
+		KeyDigest:      r.KeyDigest,
+		Entry:          toBalloonEntry(r.Entry, r.CurrentVersion, hasherF),
+		HyperProof:     hyper.NewQueryProof(r.KeyDigest, value, r.Hyper, hasherF()),
+		CurrentVersion: r.CurrentVersion,
+		Hasher:         hasherF(),
+	}
+}
+
+// ToKeyHistoryResult translates internal api balloon.KeyHistoryProof to
+// the public struct protocol.KeyHistoryResult.
+func ToKeyHistoryResult(p *balloon.KeyHistoryProof, hashAlgorithm string) *KeyHistoryResult {
+	entries := make([]*KeyValueEntry, len(p.Entries))
+	for i, e := range p.Entries {
+		entries[i] = toKeyValueEntry(e)
+	}
+	return &KeyHistoryResult{
+		Exists:         p.Exists,
+		Key:            p.Key,
+		KeyDigest:      p.KeyDigest,
+		Start:          p.Start,
+		End:            p.End,
+		LatestVersion:  p.LatestVersion,
+		Entries:        entries,
+		Next:           toKeyValueEntry(p.Next),
+		Hyper:          p.HyperProof.AuditPath(),
+		CurrentVersion: p.CurrentVersion,
+		HashAlgorithm:  hashAlgorithm,
+	}
+}
+
+// ToKeyHistoryProof translates public protocol.KeyHistoryResult to
+// internal balloon.KeyHistoryProof.
+func ToKeyHistoryProof(r *KeyHistoryResult, hasherF func() hashing.Hasher) *balloon.KeyHistoryProof {
+	var value []byte
+	if r.Exists {
+		value = util.Uint64AsBytes(r.LatestVersion)
+	}
+	entries := make([]*balloon.KeyValueEntry, len(r.Entries))
+	for i, e := range r.Entries {
+		entries[i] = toBalloonEntry(e, r.CurrentVersion, hasherF)
+	}
+	return &balloon.KeyHistoryProof{
+		Exists:         r.Exists,
+		Key:            r.Key,
+		KeyDigest:      r.KeyDigest,
+		Start:          r.Start,
+		End:            r.End,
+		LatestVersion:  r.LatestVersion,
+		Entries:        entries,
+		Next:           toBalloonEntry(r.Next, r.CurrentVersion, hasherF),
+		HyperProof:     hyper.NewQueryProof(r.KeyDigest, value, r.Hyper, hasherF()),
+		CurrentVersion: r.CurrentVersion,
+		Hasher:         hasherF(),
+	}
 }
 
-func ToIncrementalResponse(proof *balloon.IncrementalProof) *IncrementalResponse {
+func ToIncrementalResponse(proof *balloon.IncrementalProof, hashAlgorithm string) *IncrementalResponse {
 	return &IncrementalResponse{
 		proof.Start,
 		proof.End,
 		proof.AuditPath,
+		hashAlgorithm,
 	}
 }
 
@@ -0,0 +1,139 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/sign"
+)
+
+// ReceiptFormat identifies the encoding of the receipts, so verifiers can
+// reject the ones they do not understand.
+const ReceiptFormat = "qed-receipt/v1"
+
+var (
+	ErrInvalidReceipt = errors.New("invalid receipt")
+	// ErrHistoryOnly is returned by the receipts whose history proof is
+	// valid but whose hyper proof could not be checked.
+	ErrHistoryOnly = errors.New("only the history membership was verified, the hyper proof is of a later version than the snapshot")
+)
+
+// Receipt is a self-contained proof that an event is in a log. It bundles
+// the event or its digest, the membership proof of the digest and the
+// signed snapshot of the version the proof was queried at, so it can be
+// verified offline with the public key of the signer.
+type Receipt struct {
+	Format         string
+	Event          []byte `json:",omitempty"`
+	EventDigest    hashing.Digest
+	Membership     *MembershipResult
+	SignedSnapshot *SignedSnapshot
+	KeyID          string
+}
+
+// NewReceipt returns the receipt of the event digest proven by the
+// membership result against the signed snapshot. The event is optional.
+func NewReceipt(event []byte, result *MembershipResult, signed *SignedSnapshot) *Receipt {
+	return &Receipt{
+		Format:         ReceiptFormat,
+		Event:          event,
+		EventDigest:    result.KeyDigest,
+		Membership:     result,
+		SignedSnapshot: signed,
+		KeyID:          signed.KeyID,
+	}
+}
+
+// Encode encodes the receipt as JSON, the format of the receipt files.
+func (r *Receipt) Encode() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// Decode decodes a receipt encoded as JSON.
+func (r *Receipt) Decode(msg []byte) error {
+	return json.Unmarshal(msg, r)
+}
+
+func invalidReceipt(reason string) error {
+	return fmt.Errorf("%v: %s", ErrInvalidReceipt, reason)
+}
+
+// Verify checks the receipt without any request to the server: the
+// signature of the snapshot with the keys of the set, made by a key that
+// signs its version according to the ranges, that the event
+// hashes to the digest, and that the history tree of the snapshot version
+// has the digest at the version the event was added. The hyper proof is
+// also checked when the snapshot is of the current version of the proof.
+// The hyper digests of older versions are not kept, so otherwise it
+// returns ErrHistoryOnly once the history proof is verified.
+func (r *Receipt) Verify(keys sign.KeySet, ranges KeyRanges) error {
+	if r.Format != ReceiptFormat {
+		return invalidReceipt(fmt.Sprintf("unknown format %q", r.Format))
+	}
+	if r.Membership == nil || r.SignedSnapshot == nil || r.SignedSnapshot.Snapshot == nil {
+		return invalidReceipt("missing membership proof or snapshot")
+	}
+	if r.KeyID != r.SignedSnapshot.KeyID {
+		return invalidReceipt("the key ID is not the one of the snapshot signature")
+	}
+	if err := r.SignedSnapshot.Verify(keys, ranges); err != nil {
+		return err
+	}
+
+	snapshot, result := r.SignedSnapshot.Snapshot, r.Membership
+	if !result.Exists {
+		return invalidReceipt("the event is not a member of the log")
+	}
+	if result.QueryVersion != snapshot.Version {
+		return invalidReceipt("the proof was not queried at the version of the snapshot")
+	}
+	if result.ActualVersion > result.QueryVersion {
+		return invalidReceipt("the event was added after the version of the snapshot")
+	}
+	if result.HashAlgorithm != snapshot.HashAlgorithm {
+		return invalidReceipt("the proof and the snapshot use different hash algorithms")
+	}
+
+	hasherF, err := hashing.NewHasherF(snapshot.HashAlgorithm)
+	if err != nil {
+		return err
+	}
+	if r.Event != nil && !bytes.Equal(hasherF().Do(r.Event), r.EventDigest) {
+		return invalidReceipt("the event does not hash to the event digest")
+	}
+	if !bytes.Equal(r.EventDigest, result.KeyDigest) {
+		return invalidReceipt("the proof is not of the event digest")
+	}
+
+	proof := ToBalloonProof(result, hasherF)
+	if !proof.HistoryProof.Verify(r.EventDigest, snapshot.HistoryDigest) {
+		return invalidReceipt("the history proof does not match the snapshot")
+	}
+	if result.CurrentVersion != snapshot.Version {
+		return ErrHistoryOnly
+	}
+	if !proof.HyperProof.Verify(r.EventDigest, snapshot.HyperDigest) {
+		return invalidReceipt("the hyper proof does not match the snapshot")
+	}
+
+	return nil
+}
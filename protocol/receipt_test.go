@@ -0,0 +1,101 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/log"
+	"github.com/bbva/qed/sign"
+)
+
+func TestReceiptVerify(t *testing.T) {
+	log.SetLogger("TestReceiptVerify", log.SILENT)
+
+	b, snapshots, closeF := newTestBalloon(t, 10)
+	defer closeF()
+
+	signer := sign.NewEd25519Signer()
+	keys := sign.NewKeySet(signer)
+	ranges := NewKeyRanges(activeKey(signer))
+
+	newReceipt := func(event string, version uint64) *Receipt {
+		proof, err := b.QueryMembership([]byte(event), version)
+		require.NoError(t, err)
+		signed, err := ToSnapshot(snapshots[version], hashing.SHA256).Sign(signer)
+		require.NoError(t, err)
+		return NewReceipt([]byte(event), ToMembershipResult([]byte(event), proof, hashing.SHA256), signed)
+	}
+
+	testCases := []struct {
+		event    string
+		version  uint64
+		expected error
+	}{
+		{"event 9", 9, nil}, // the hyper proof is checked on the current version
+		{"event 3", 9, nil},
+		{"event 3", 3, ErrHistoryOnly}, // older versions only check the history proof
+		{"event 0", 5, ErrHistoryOnly},
+	}
+
+	for i, c := range testCases {
+		receipt := newReceipt(c.event, c.version)
+
+		encoded, err := receipt.Encode()
+		require.NoError(t, err)
+		var decoded Receipt
+		require.NoError(t, decoded.Decode(encoded))
+		assert.Equal(t, c.expected, decoded.Verify(keys, ranges), "The receipt of test case %d must be verified offline", i)
+
+		// the event is optional
+		decoded.Event = nil
+		assert.Equal(t, c.expected, decoded.Verify(keys, ranges), "The receipt of test case %d must be verified without the event", i)
+	}
+
+	retired := activeKey(signer)
+	retired.Active, retired.End = false, 9
+	assert.Equal(t, ErrKeyNotSigning, newReceipt("event 3", 9).Verify(keys, NewKeyRanges(retired)), "A receipt must not be verified with a key retired before its version")
+
+	receipt := newReceipt("event 3", 9)
+	assert.Equal(t, ErrUnknownKey, receipt.Verify(sign.NewKeySet(sign.NewEd25519Signer()), ranges), "A receipt must only be verified with the key of its signer")
+
+	tampered := *receipt
+	tampered.Event = []byte("event 4")
+	assert.Error(t, tampered.Verify(keys, ranges), "The event must hash to the event digest")
+
+	tampered = *receipt
+	tampered.EventDigest = hashing.NewSha256Hasher().Do([]byte("event 4"))
+	tampered.Event = nil
+	assert.Error(t, tampered.Verify(keys, ranges), "The proof must be of the event digest")
+
+	tampered = *receipt
+	tampered.SignedSnapshot = newReceipt("event 3", 8).SignedSnapshot
+	tampered.KeyID = tampered.SignedSnapshot.KeyID
+	assert.Error(t, tampered.Verify(keys, ranges), "The proof must be of the version of the snapshot")
+
+	tampered = *receipt
+	tampered.KeyID = "0000000000000000"
+	assert.Error(t, tampered.Verify(keys, ranges), "The key ID must be the one of the signature")
+
+	missing := newReceipt("missing event", 9)
+	assert.Error(t, missing.Verify(keys, ranges), "A receipt must prove the membership of its event")
+
+}
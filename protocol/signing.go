@@ -0,0 +1,166 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/bbva/qed/sign"
+)
+
+// snapshotContext starts the canonical encoding of every snapshot, so a
+// signature of a snapshot cannot be taken for the signature of any other
+// message signed with the same key.
+const snapshotContext = "qed snapshot v1\x00"
+
+var (
+	ErrInvalidSignature = errors.New("invalid snapshot signature")
+	ErrUnknownKey       = errors.New("unknown signing key")
+	ErrKeyNotSigning    = errors.New("the signing key does not sign the version of the snapshot")
+)
+
+// KeyRanges are the versions the signing keys of a log sign, by key ID, as
+// the server lists them. A retired key only signs the versions before the
+// one its successor started signing.
+type KeyRanges map[string]*SigningKey
+
+// NewKeyRanges returns the ranges of the given signing keys.
+func NewKeyRanges(keys ...*SigningKey) KeyRanges {
+	r := make(KeyRanges, len(keys))
+	for _, k := range keys {
+		r[k.KeyID] = k
+	}
+	return r
+}
+
+// SnapshotSigner signs snapshots with the key that signs their version.
+type SnapshotSigner interface {
+	SignSnapshot(s *Snapshot) (*SignedSnapshot, error)
+	// SignerFor returns the signer of the key that signs the given version
+	// of the namespace, which also signs what the server says about it.
+	SignerFor(namespace string, version uint64) (sign.Signer, error)
+}
+
+type fixedSigner struct {
+	signer sign.Signer
+}
+
+// NewSnapshotSigner returns a snapshot signer that signs every version with
+// the same key.
+func NewSnapshotSigner(signer sign.Signer) SnapshotSigner {
+	return fixedSigner{signer}
+}
+
+func (f fixedSigner) SignSnapshot(s *Snapshot) (*SignedSnapshot, error) {
+	return s.Sign(f.signer)
+}
+
+func (f fixedSigner) SignerFor(namespace string, version uint64) (sign.Signer, error) {
+	return f.signer, nil
+}
+
+// CanonicalBytes returns the encoding of the snapshot that servers sign.
+// It only depends on the values of the fields, so it can be rebuilt in any
+// language to verify a signature:
+//
+//	context        16 bytes, "qed snapshot v1" followed by a zero byte
+//	Version        8 bytes, unsigned big-endian
+//	Timestamp      8 bytes, two's complement big-endian
+//	EventDigest    length-prefixed bytes
+//	HistoryDigest  length-prefixed bytes
+//	HyperDigest    length-prefixed bytes
+//	HashAlgorithm  length-prefixed UTF-8 string
+//	Namespace      length-prefixed UTF-8 string, empty for the default one
+//
+// Lengths are 4 bytes, unsigned big-endian.
+func (s *Snapshot) CanonicalBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(snapshotContext)
+
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], s.Version)
+	buf.Write(n[:])
+	binary.BigEndian.PutUint64(n[:], uint64(s.Timestamp))
+	buf.Write(n[:])
+
+	for _, field := range [][]byte{
+		s.EventDigest,
+		s.HistoryDigest,
+		s.HyperDigest,
+		[]byte(s.HashAlgorithm),
+		[]byte(s.Namespace),
+	} {
+		binary.BigEndian.PutUint32(n[:4], uint32(len(field)))
+		buf.Write(n[:4])
+		buf.Write(field)
+	}
+
+	return buf.Bytes()
+}
+
+// Sign signs the canonical encoding of the snapshot under the key ID and
+// the algorithm of the signer.
+func (s *Snapshot) Sign(signer sign.Signer) (*SignedSnapshot, error) {
+	signature, err := signer.Sign(s.CanonicalBytes())
+	if err != nil {
+		return nil, err
+	}
+	return &SignedSnapshot{
+		Snapshot:  s,
+		KeyID:     sign.KeyID(signer.PublicKey()),
+		Algorithm: signer.Algorithm(),
+		Signature: signature,
+	}, nil
+}
+
+// Verify checks the signature of the canonical encoding of the snapshot
+// with the public key of its key ID, which must sign the version of the
+// snapshot according to the ranges. It returns ErrUnknownKey if the key
+// is not in the set, ErrKeyNotSigning if it is not in the ranges or it
+// does not sign the version, like a retired key, and ErrInvalidSignature
+// if the signature does not match or was made with another algorithm than
+// the one of the key. An empty algorithm stands for ed25519, the only one
+// before it was recorded.
+func (b *SignedSnapshot) Verify(keys sign.KeySet, ranges KeyRanges) error {
+	if b.Snapshot == nil {
+		return ErrInvalidSignature
+	}
+	verifier, ok := keys[b.KeyID]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if key, ok := ranges[b.KeyID]; !ok || !key.Signs(b.Snapshot.Version) {
+		return ErrKeyNotSigning
+	}
+	algorithm := b.Algorithm
+	if algorithm == "" {
+		algorithm = sign.Ed25519
+	}
+	if algorithm != verifier.Algorithm() {
+		return ErrInvalidSignature
+	}
+	ok, err := verifier.Verify(b.Snapshot.CanonicalBytes(), b.Signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
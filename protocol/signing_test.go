@@ -0,0 +1,143 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package protocol
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/sign"
+)
+
+func TestSnapshotCanonicalBytes(t *testing.T) {
+
+	snapshot := &Snapshot{
+		EventDigest:   []byte{0x01, 0x02},
+		HistoryDigest: []byte{0x03},
+		HyperDigest:   []byte{0x04},
+		Version:       1,
+		Timestamp:     -2,
+		HashAlgorithm: "sha256",
+		Namespace:     "ns",
+	}
+
+	// the same layout any other implementation must produce
+	expected := hex.EncodeToString([]byte(snapshotContext)) +
+		"0000000000000001" +
+		"fffffffffffffffe" +
+		"00000002" + "0102" +
+		"00000001" + "03" +
+		"00000001" + "04" +
+		"00000006" + hex.EncodeToString([]byte("sha256")) +
+		"00000002" + hex.EncodeToString([]byte("ns"))
+
+	assert.Equal(t, expected, hex.EncodeToString(snapshot.CanonicalBytes()))
+
+	// fields are not ambiguous even if their bytes are moved around
+	moved := *snapshot
+	moved.EventDigest = []byte{0x01}
+	moved.HistoryDigest = []byte{0x02, 0x03}
+	assert.NotEqual(t, snapshot.CanonicalBytes(), moved.CanonicalBytes())
+
+}
+
+func TestSignedSnapshotVerify(t *testing.T) {
+
+	signer := sign.NewEd25519Signer()
+	snapshot := &Snapshot{
+		EventDigest:   []byte{0x01},
+		HistoryDigest: []byte{0x02},
+		HyperDigest:   []byte{0x03},
+		Version:       7,
+		HashAlgorithm: "sha256",
+	}
+
+	keys := sign.NewKeySet(signer)
+	ranges := NewKeyRanges(activeKey(signer))
+
+	signed, err := snapshot.Sign(signer)
+	require.NoError(t, err)
+	assert.Equal(t, sign.KeyID(signer.PublicKey()), signed.KeyID)
+	assert.Equal(t, sign.Ed25519, signed.Algorithm)
+	require.NoError(t, signed.Verify(keys, ranges), "A signed snapshot must be verified")
+
+	msg, err := signed.Encode()
+	require.NoError(t, err)
+	var decoded SignedSnapshot
+	require.NoError(t, decoded.Decode(msg))
+	require.NoError(t, decoded.Verify(keys, ranges), "The signature must survive the JSON encoding")
+
+	decoded.Snapshot.Version = 8
+	assert.Equal(t, ErrInvalidSignature, decoded.Verify(keys, ranges), "A tampered snapshot must not be verified")
+
+	other := sign.NewEd25519Signer()
+	assert.Equal(t, ErrUnknownKey, signed.Verify(sign.NewKeySet(other), ranges), "A snapshot must only be verified with the key of its signer")
+
+	forged := *signed
+	forged.KeyID = sign.KeyID(other.PublicKey())
+	assert.Equal(t, ErrInvalidSignature, forged.Verify(sign.NewKeySet(signer, other), NewKeyRanges(activeKey(signer), activeKey(other))), "A snapshot must not be verified with a key that did not sign it")
+
+	legacy := *signed
+	legacy.Algorithm = ""
+	require.NoError(t, legacy.Verify(keys, ranges), "Signatures without an algorithm must be verified as ed25519")
+
+	retired := &SigningKey{KeyID: signed.KeyID, Start: 2, End: 7}
+	assert.Equal(t, ErrKeyNotSigning, signed.Verify(keys, NewKeyRanges(retired)), "A snapshot must not be verified with a key retired before its version")
+	assert.Equal(t, ErrKeyNotSigning, signed.Verify(keys, NewKeyRanges()), "A snapshot must not be verified with a key out of the ranges")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecdsaSigner, err := sign.NewECDSASigner(key)
+	require.NoError(t, err)
+	signed, err = snapshot.Sign(ecdsaSigner)
+	require.NoError(t, err)
+	assert.Equal(t, sign.ECDSAP256SHA256, signed.Algorithm)
+	require.NoError(t, signed.Verify(sign.NewKeySet(ecdsaSigner), NewKeyRanges(activeKey(ecdsaSigner))), "A snapshot signed with ECDSA must be verified")
+
+	signed.Algorithm = sign.RSAPSSSHA256
+	assert.Equal(t, ErrInvalidSignature, signed.Verify(sign.NewKeySet(ecdsaSigner), NewKeyRanges(activeKey(ecdsaSigner))), "A signature must only be verified with the algorithm of its key")
+
+}
+
+func TestSigningKeySigns(t *testing.T) {
+
+	retired := &SigningKey{Start: 2, End: 5}
+	active := &SigningKey{Start: 5, Active: true}
+
+	for version, expected := range map[uint64][2]bool{
+		1:   {false, false},
+		2:   {true, false},
+		4:   {true, false},
+		5:   {false, true},
+		100: {false, true},
+	} {
+		assert.Equal(t, expected[0], retired.Signs(version), "Wrong range of the retired key for version %d", version)
+		assert.Equal(t, expected[1], active.Signs(version), "Wrong range of the active key for version %d", version)
+	}
+
+}
+
+// activeKey returns the range of a key that signs every version.
+func activeKey(signer sign.Signer) *SigningKey {
+	return &SigningKey{KeyID: sign.KeyID(signer.PublicKey()), Start: 0, Active: true}
+}
@@ -0,0 +1,243 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raftwal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/storage"
+)
+
+// BackupFormat identifies the format of the backups of a node.
+const BackupFormat = "qed-backup/v1"
+
+var (
+	// ErrInvalidBackup is returned when restoring data that is not a
+	// backup, or that does not match its checksum.
+	ErrInvalidBackup = errors.New("invalid backup")
+
+	// ErrBackupMismatch is returned when the trees recomputed from a
+	// restored backup do not match the ones recorded by its header.
+	ErrBackupMismatch = errors.New("the restored trees do not match the backup")
+
+	// ErrStorageMismatch is returned when restoring a backup into a store
+	// of another storage engine than the one it was taken from.
+	ErrStorageMismatch = errors.New("the backup was taken from another storage engine")
+)
+
+// BackupDigests are the digests of the last version of a balloon, which
+// are nil if it is empty.
+type BackupDigests struct {
+	// Version is the one the next addition gets.
+	Version       uint64
+	HistoryDigest hashing.Digest `json:",omitempty"`
+	HyperDigest   hashing.Digest `json:",omitempty"`
+}
+
+func (d BackupDigests) matches(r *balloon.CheckReport) bool {
+	return d.Version == r.Version &&
+		bytes.Equal(d.HistoryDigest, r.HistoryDigest) &&
+		bytes.Equal(d.HyperDigest, r.HyperDigest)
+}
+
+// BackupHeader is the first line of a backup, encoded in JSON, which
+// records the balloons of the data that follows it.
+type BackupHeader struct {
+	Format        string
+	HashAlgorithm string
+	// Storage is the engine of the store the data was taken from, the
+	// only one that can load it.
+	Storage string `json:",omitempty"`
+	BackupDigests
+	Namespaces map[string]*BackupDigests `json:",omitempty"`
+	// Checksum is the SHA-256 digest of the data after the header.
+	Checksum hashing.Digest
+}
+
+// matches returns whether the report of the restored data is ok and
+// recomputed the same trees recorded by the header.
+func (h BackupHeader) matches(report *FsckReport) bool {
+	if !report.Ok() || report.HashAlgorithm != h.HashAlgorithm || !h.BackupDigests.matches(report.CheckReport) {
+		return false
+	}
+	if len(h.Namespaces) != len(report.Namespaces) {
+		return false
+	}
+	for name, digests := range h.Namespaces {
+		check, ok := report.Namespaces[name]
+		if !ok || !digests.matches(check) {
+			return false
+		}
+	}
+	return true
+}
+
+func digestsOf(b *balloon.Balloon) (BackupDigests, error) {
+	historyDigest, hyperDigest, err := b.Digests()
+	return BackupDigests{b.Version(), historyDigest, hyperDigest}, err
+}
+
+// Backup writes a consistent backup of the store to w, preceded by its
+// header. The data is spooled to a temporary file first, so additions are
+// only blocked while the store is copied and not while w reads it.
+func (fsm *BalloonFSM) Backup(w io.Writer) (*BackupHeader, error) {
+	spool, err := ioutil.TempFile("", "qed-backup")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	header, err := fsm.spoolBackup(spool)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	line, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(w, spool); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// spoolBackup writes the store to w and returns the header of the backup,
+// with no addition committed meanwhile.
+func (fsm *BalloonFSM) spoolBackup(w io.Writer) (*BackupHeader, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+
+	header := &BackupHeader{
+		Format:        BackupFormat,
+		HashAlgorithm: fsm.hashAlgorithm,
+		Storage:       storage.EngineOf(fsm.store),
+		Namespaces:    make(map[string]*BackupDigests, len(fsm.namespaces)),
+	}
+	var err error
+	if header.BackupDigests, err = digestsOf(fsm.balloon); err != nil {
+		return nil, err
+	}
+	for name, ns := range fsm.namespaces {
+		digests, err := digestsOf(ns.balloon)
+		if err != nil {
+			return nil, err
+		}
+		header.Namespaces[name] = &digests
+	}
+
+	checksum := sha256.New()
+	if err := fsm.store.Backup(io.MultiWriter(w, checksum), math.MaxUint64); err != nil {
+		return nil, err
+	}
+	header.Checksum = checksum.Sum(nil)
+
+	return header, nil
+}
+
+// Backup writes a consistent backup of the store of the node to w,
+// preceded by its header, and returns the header.
+func (b *RaftBalloon) Backup(w io.Writer) (*BackupHeader, error) {
+	return b.fsm.Backup(w)
+}
+
+// RestoreBackup loads a backup into an empty store and recomputes its
+// trees, returning the header of the backup and the report of the check
+// of the restored data. ErrInvalidBackup is returned if the data is not a
+// backup or it is corrupted, ErrStorageMismatch if it was taken from
+// another storage engine, and ErrBackupMismatch if the recomputed trees
+// do not match the ones recorded by the header, in which case the store
+// must be discarded.
+//
+// The Raft index of the restored state is reset, so the node can bootstrap
+// a new cluster from it, or join one and get its state from the leader.
+func RestoreBackup(r io.Reader, store storage.ManagedStore) (*BackupHeader, *FsckReport, error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, nil, ErrInvalidBackup
+	}
+	var header BackupHeader
+	if err := json.Unmarshal(line, &header); err != nil || header.Format != BackupFormat {
+		return nil, nil, ErrInvalidBackup
+	}
+	if engine := storage.EngineOf(store); header.Storage != "" && engine != "" && header.Storage != engine {
+		return &header, nil, ErrStorageMismatch
+	}
+
+	checksum := sha256.New()
+	data := io.TeeReader(br, checksum)
+	if err := store.Load(data); err != nil {
+		return &header, nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, data); err != nil {
+		return &header, nil, err
+	}
+	if !bytes.Equal(checksum.Sum(nil), header.Checksum) {
+		return &header, nil, ErrInvalidBackup
+	}
+
+	if err := resetState(store); err != nil {
+		return &header, nil, err
+	}
+
+	report, err := Fsck(store)
+	if err != nil {
+		return &header, nil, err
+	}
+	if !header.matches(report) {
+		return &header, report, ErrBackupMismatch
+	}
+	return &header, report, nil
+}
+
+// resetState resets the Raft index and term of the FSM state, if there is
+// one, keeping the last version and timestamp of the balloon.
+func resetState(store storage.ManagedStore) error {
+	if _, err := store.Get(storage.FSMStatePrefix, fsmStateKey); err == storage.ErrKeyNotFound {
+		return nil
+	}
+	state, err := loadState(store)
+	if err != nil {
+		return err
+	}
+	state.Index, state.Term = 0, 0
+	stateBuff, err := encodeMsgPack(state)
+	if err != nil {
+		return err
+	}
+	return store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStatePrefix, fsmStateKey, stateBuff.Bytes()),
+	})
+}
@@ -0,0 +1,144 @@
+package raftwal
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/storage/badger"
+	"github.com/bbva/qed/storage/bolt"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.SHA3_256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.NoError(t, err)
+	var last *fsmAddResponse
+	for i := uint64(1); i <= 5; i++ {
+		last = fsm.Apply(newRaftTimestampedLog(i, 1, int64(i))).(*fsmAddResponse)
+		assert.Nil(t, last.error)
+	}
+	c := fsm.Apply(newRaftCreateNamespaceLog(6, 1, "ns", "")).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	for i := uint64(0); i < 3; i++ {
+		r := fsm.Apply(newRaftNamespaceLog(7+i, 1, "ns", i)).(*fsmAddResponse)
+		assert.Nil(t, r.error)
+	}
+
+	var backup bytes.Buffer
+	header, err := fsm.Backup(&backup)
+	assert.NoError(t, err)
+	assert.Equal(t, BackupFormat, header.Format)
+	assert.Equal(t, hashing.SHA3_256, header.HashAlgorithm)
+	assert.Equal(t, badger.Engine, header.Storage, "The storage engine should be recorded")
+	assert.Equal(t, uint64(5), header.Version)
+	assert.Equal(t, last.snapshot.HistoryDigest, header.HistoryDigest, "The history digest should be the one of the last version")
+	assert.Equal(t, last.snapshot.HyperDigest, header.HyperDigest, "The hyper digest should be the one of the last version")
+	assert.Equal(t, uint64(3), header.Namespaces["ns"].Version, "The namespaces should be recorded")
+
+	restore := func(data []byte) (*BackupHeader, *FsckReport, error) {
+		restored, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.restore.test.db")
+		defer closeF()
+		return RestoreBackup(bytes.NewReader(data), restored)
+	}
+
+	restoredHeader, report, err := restore(backup.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, header, restoredHeader)
+	assert.True(t, report.Ok(), "The restored store should be consistent")
+	assert.Equal(t, header.HistoryDigest, report.HistoryDigest)
+	assert.Equal(t, header.HyperDigest, report.HyperDigest)
+
+	// the data does not match the checksum
+	corrupted := append([]byte(nil), backup.Bytes()...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	_, _, err = restore(corrupted)
+	assert.Error(t, err, "A corrupted backup should not be restored")
+
+	// the header does not match the data
+	forged := *header
+	forged.HyperDigest = hashing.Digest{0x01}
+	line, err := json.Marshal(&forged)
+	assert.NoError(t, err)
+	data := append(append(line, '\n'), bytes.SplitN(backup.Bytes(), []byte{'\n'}, 2)[1]...)
+	_, _, err = restore(data)
+	assert.Equal(t, ErrBackupMismatch, err, "A backup whose trees do not match its header should be rejected")
+
+	_, _, err = restore([]byte("not a backup\n"))
+	assert.Equal(t, ErrInvalidBackup, err)
+}
+
+func TestRestoreResetsRaftState(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.NoError(t, err)
+	r := fsm.Apply(newRaftTimestampedLog(42, 3, 1)).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+
+	var backup bytes.Buffer
+	_, err = fsm.Backup(&backup)
+	assert.NoError(t, err)
+
+	restored, closeRestored := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.restore.test.db")
+	defer closeRestored()
+	_, _, err = RestoreBackup(&backup, restored)
+	assert.NoError(t, err)
+
+	state, err := loadState(restored)
+	assert.NoError(t, err)
+	assert.Equal(t, &fsmState{0, 0, 0, 1}, state, "The Raft index should be reset and the balloon kept")
+}
+
+func TestBackupAndRestoreWithBolt(t *testing.T) {
+	store, closeF := storage_utils.OpenBoltStore(t, "/var/tmp/balloon.test.bolt")
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.NoError(t, err)
+	for i := uint64(1); i <= 5; i++ {
+		r := fsm.Apply(newRaftTimestampedLog(i, 1, int64(i))).(*fsmAddResponse)
+		assert.Nil(t, r.error)
+	}
+	c := fsm.Apply(newRaftCreateNamespaceLog(6, 1, "ns", "")).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	r := fsm.Apply(newRaftNamespaceLog(7, 1, "ns", 0)).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+
+	var backup bytes.Buffer
+	header, err := fsm.Backup(&backup)
+	assert.NoError(t, err)
+	assert.Equal(t, bolt.Engine, header.Storage, "The storage engine should be recorded")
+	data := append([]byte(nil), backup.Bytes()...)
+
+	restored, closeRestored := storage_utils.OpenBoltStore(t, "/var/tmp/balloon.restore.test.bolt")
+	defer closeRestored()
+	_, report, err := RestoreBackup(&backup, restored)
+	assert.NoError(t, err)
+	assert.True(t, report.Ok(), "The restored store should be consistent")
+
+	assert.Equal(t, header.Version, report.Version, "The restored balloon should have every version of the backup")
+	assert.Equal(t, header.HyperDigest, report.HyperDigest)
+	assert.Equal(t, uint64(1), report.Namespaces["ns"].Version, "The namespaces should be restored")
+
+	badgerStore, closeBadger := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.restore.test.db")
+	defer closeBadger()
+	_, _, err = RestoreBackup(bytes.NewReader(data), badgerStore)
+	assert.Equal(t, ErrStorageMismatch, err, "A backup should only be restored into a store of its storage engine")
+}
+
+func TestNamespaceBackup(t *testing.T) {
+	var backup bytes.Buffer
+	_, err := (&namespacedBalloon{namespace: "ns"}).Backup(&backup)
+	assert.Equal(t, ErrNamespaceBackup, err, "A namespace should not be backed up on its own")
+	assert.Zero(t, backup.Len())
+}
@@ -0,0 +1,96 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raftwal
+
+import (
+	"errors"
+
+	"github.com/hashicorp/raft"
+)
+
+// ErrNothingToSnapshot is returned when a snapshot is requested but no log
+// was applied yet.
+var ErrNothingToSnapshot = errors.New("nothing new to snapshot")
+
+// Member is a node of the cluster, as the Raft configuration and the
+// metadata replicated by the nodes know it.
+type Member struct {
+	ID           string
+	Address      string
+	Voter        bool
+	Leader       bool
+	APIEndpoint  string `json:",omitempty"`
+	MgmtEndpoint string `json:",omitempty"`
+}
+
+// Status is the Raft state of a node.
+type Status struct {
+	ID           string
+	State        string
+	LeaderID     string `json:",omitempty"`
+	LastIndex    uint64
+	AppliedIndex uint64
+}
+
+// Members returns the nodes of the cluster, in the order of the Raft
+// configuration.
+func (b *RaftBalloon) Members() ([]*Member, error) {
+	servers, err := b.Nodes()
+	if err != nil {
+		return nil, err
+	}
+	leader := b.LeaderAddr()
+
+	members := make([]*Member, 0, len(servers))
+	for _, srv := range servers {
+		id := string(srv.ID)
+		members = append(members, &Member{
+			ID:           id,
+			Address:      string(srv.Address),
+			Voter:        srv.Suffrage == raft.Voter,
+			Leader:       leader != "" && string(srv.Address) == leader,
+			APIEndpoint:  b.Metadata(id, APIEndpointKey),
+			MgmtEndpoint: b.Metadata(id, MgmtEndpointKey),
+		})
+	}
+	return members, nil
+}
+
+// Status returns the Raft state of the node.
+func (b *RaftBalloon) Status() (*Status, error) {
+	leaderID, err := b.LeaderID()
+	if err != nil {
+		return nil, err
+	}
+	return &Status{
+		ID:           b.id,
+		State:        b.raft.api.State().String(),
+		LeaderID:     leaderID,
+		LastIndex:    b.raft.api.LastIndex(),
+		AppliedIndex: b.raft.api.AppliedIndex(),
+	}, nil
+}
+
+// TakeSnapshot makes the node take a Raft snapshot of its state right
+// away, which lets Raft compact the logs applied before it.
+func (b *RaftBalloon) TakeSnapshot() error {
+	err := b.raft.api.Snapshot().Error()
+	if err == raft.ErrNothingNewToSnapshot {
+		return ErrNothingToSnapshot
+	}
+	return err
+}
@@ -27,12 +27,67 @@ import (
 type CommandType uint8
 
 const (
-	AddEventCommandType       CommandType = 0 // Commands which modify the database.
-	MetadataDeleteCommandType CommandType = 1
+	AddEventCommandType        CommandType = 0 // Commands which modify the database.
+	MetadataDeleteCommandType  CommandType = 1
+	AddEventsCommandType       CommandType = 2
+	AddKeyValueCommandType     CommandType = 3
+	CreateNamespaceCommandType CommandType = 4
+	RotateKeyCommandType       CommandType = 5
+	MetadataSetCommandType     CommandType = 6
 )
 
+// The timestamps of the commands are assigned by the leader, in nanoseconds
+// since the Unix epoch, so every replica records the same commit time.
+// The namespace is the log the command is applied to, empty for the
+// default one.
+
 type AddEventCommand struct {
-	Event []byte
+	Event     []byte
+	Timestamp int64
+	Namespace string
+}
+
+type AddEventsCommand struct {
+	Events    [][]byte
+	Timestamp int64
+	Namespace string
+}
+
+type AddKeyValueCommand struct {
+	Key, Value []byte
+	Timestamp  int64
+	Namespace  string
+}
+
+// The mode and the duplicate policy of a namespace are given by their
+// names. It adds events if the mode is empty, and it overwrites the
+// duplicates if the policy is empty.
+
+type CreateNamespaceCommand struct {
+	Name       string
+	Duplicates string
+	Mode       string
+}
+
+// The public key of a rotation signs the snapshots of every namespace from
+// the next version on. An initial rotation registers the key that signs
+// from the first version, and it is ignored once any key is registered.
+// The algorithm is one of the sign package, and it tells how the public
+// key is encoded.
+
+type RotateKeyCommand struct {
+	Algorithm string
+	PublicKey []byte
+	Initial   bool
+}
+
+// The metadata of a node, like the endpoint of its api, is replicated so
+// every node knows it. A set merges the given keys into the metadata of
+// the node, and a delete drops it when the node leaves the cluster.
+
+type MetadataSetCommand struct {
+	Id   string
+	Data map[string]string
 }
 
 type MetadataDeleteCommand struct {
@@ -0,0 +1,69 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raftwal
+
+import (
+	"fmt"
+
+	"github.com/bbva/qed/protocol"
+	"github.com/hashicorp/raft"
+)
+
+// ReadVersion makes sure the node can answer queries with the given
+// consistency and returns the version the next addition will get:
+//
+// Stale reads are answered by any node.
+//
+// Leader reads are only answered by the leader, otherwise ErrNotLeader is
+// returned.
+//
+// Linearizable reads are answered by the leader once a barrier is
+// committed by the cluster and applied, so the answer is not older than
+// any addition acknowledged before the query.
+func (b *RaftBalloon) ReadVersion(consistency protocol.ReadConsistency) (uint64, error) {
+	return b.readVersion("", consistency)
+}
+
+func (b *RaftBalloon) readVersion(namespace string, consistency protocol.ReadConsistency) (uint64, error) {
+	switch consistency {
+	case protocol.StaleReads:
+	case protocol.LeaderReads:
+		if !b.IsLeader() {
+			return 0, ErrNotLeader
+		}
+	case protocol.LinearizableReads:
+		if err := b.barrier(); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unknown read consistency %q", consistency)
+	}
+	return b.fsm.Version(namespace)
+}
+
+// barrier waits until every log committed before it is applied, which
+// only the leader can do.
+func (b *RaftBalloon) barrier() error {
+	if !b.IsLeader() {
+		return ErrNotLeader
+	}
+	err := b.raft.api.Barrier(b.raft.applyTimeout).Error()
+	if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+		return ErrNotLeader
+	}
+	return err
+}
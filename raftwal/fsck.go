@@ -0,0 +1,111 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raftwal
+
+import (
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/storage"
+)
+
+// FsckReport is the result of checking the integrity of the store of a
+// node, which can only rejoin the cluster if it is ok.
+type FsckReport struct {
+	*balloon.CheckReport
+	HashAlgorithm string
+	// StateVersion is the last version recorded by the FSM state, if
+	// there is a state.
+	StateVersion uint64
+	StateFound   bool
+	// Namespaces holds the checks of the trees of every namespace.
+	Namespaces map[string]*balloon.CheckReport
+}
+
+// StateMatches returns whether the FSM state records the last version of
+// the balloon, or there is no state if the balloon is empty.
+func (r FsckReport) StateMatches() bool {
+	if r.Version == 0 {
+		return !r.StateFound || r.StateVersion == 0
+	}
+	return r.StateFound && r.StateVersion == r.Version-1
+}
+
+// Ok returns whether the trees and the FSM state are consistent.
+func (r FsckReport) Ok() bool {
+	for _, ns := range r.Namespaces {
+		if !ns.Ok() {
+			return false
+		}
+	}
+	return r.CheckReport.Ok() && r.StateMatches()
+}
+
+// Fsck checks the integrity of the store of a stopped node. It recomputes
+// the trees of the balloon from the stored leaves, with the hash algorithm
+// recorded on first boot, and checks the FSM state against the last version.
+// The trees of the namespaces are checked the same way.
+func Fsck(store storage.ManagedStore) (*FsckReport, error) {
+	hashAlgorithm := hashing.SHA256
+	kv, err := store.Get(storage.FSMStatePrefix, hashAlgorithmKey)
+	if err != nil && err != storage.ErrKeyNotFound {
+		return nil, err
+	}
+	if err == nil {
+		hashAlgorithm = string(kv.Value)
+	}
+	hasherF, err := hashing.NewHasherF(hashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := balloon.Check(store, hasherF)
+	if err != nil {
+		return nil, err
+	}
+	report := &FsckReport{
+		CheckReport:   check,
+		HashAlgorithm: hashAlgorithm,
+		Namespaces:    make(map[string]*balloon.CheckReport),
+	}
+
+	registry, err := namespaceRegistry(store)
+	if err != nil {
+		return nil, err
+	}
+	for name := range registry {
+		check, err := balloon.Check(storage.NewNamespacedStore(store, name), hasherF)
+		if err != nil {
+			return nil, err
+		}
+		report.Namespaces[name] = check
+	}
+
+	_, err = store.Get(storage.FSMStatePrefix, fsmStateKey)
+	if err != nil && err != storage.ErrKeyNotFound {
+		return nil, err
+	}
+	if err == nil {
+		state, err := loadState(store)
+		if err != nil {
+			return nil, err
+		}
+		report.StateFound = true
+		report.StateVersion = state.BalloonVersion
+	}
+
+	return report, nil
+}
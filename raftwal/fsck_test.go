@@ -0,0 +1,54 @@
+package raftwal
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/storage"
+	storage_utils "github.com/bbva/qed/testutils/storage"
+)
+
+func TestFsck(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	report, err := Fsck(store)
+	assert.NoError(t, err)
+	assert.True(t, report.Ok(), "An empty store should be consistent")
+
+	fsm, err := NewBalloonFSM(store, hashing.SHA3_256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.NoError(t, err)
+	for i := uint64(1); i <= 5; i++ {
+		r := fsm.Apply(newRaftTimestampedLog(i, 1, int64(i))).(*fsmAddResponse)
+		assert.Nil(t, r.error)
+	}
+	c := fsm.Apply(newRaftCreateNamespaceLog(6, 1, "ns", "")).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	for i := uint64(0); i < 3; i++ {
+		r := fsm.Apply(newRaftNamespaceLog(7+i, 1, "ns", i)).(*fsmAddResponse)
+		assert.Nil(t, r.error)
+	}
+
+	report, err = Fsck(store)
+	assert.NoError(t, err)
+	assert.True(t, report.Ok(), "The store should be consistent")
+	assert.Equal(t, hashing.SHA3_256, report.HashAlgorithm, "The stored hash algorithm should be used")
+	assert.Equal(t, uint64(4), report.StateVersion, "Wrong version in the state")
+	assert.Equal(t, uint64(3), report.Namespaces["ns"].Version, "The namespace should be checked")
+
+	// a state behind the balloon
+	stateBuff, err := encodeMsgPack(&fsmState{3, 1, 2, 3})
+	assert.NoError(t, err)
+	assert.NoError(t, store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStatePrefix, fsmStateKey, stateBuff.Bytes()),
+	}))
+
+	report, err = Fsck(store)
+	assert.NoError(t, err)
+	assert.True(t, report.CheckReport.Ok(), "The trees should be consistent")
+	assert.False(t, report.StateMatches(), "The state should not match the balloon")
+}
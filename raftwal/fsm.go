@@ -25,6 +25,7 @@ import (
 	"github.com/bbva/qed/protocol"
 
 	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/balloon/hyper"
 	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/raftwal/commands"
@@ -37,29 +38,59 @@ type fsmGenericResponse struct {
 	error error
 }
 
+// fsmAddResponse has the snapshot of the addition. If the event had
+// already been added and the original snapshot is returned, duplicate is
+// set, as it must not be sent to the agents again.
 type fsmAddResponse struct {
-	snapshot *balloon.Snapshot
-	error    error
+	snapshot  *balloon.Snapshot
+	duplicate bool
+	error     error
+}
+
+// fsmAddBulkResponse has a snapshot per event in the bulk, and the ones of
+// the events added by it, without the duplicates, in added.
+type fsmAddBulkResponse struct {
+	snapshots []*balloon.Snapshot
+	added     []*balloon.Snapshot
+	error     error
 }
 
 type BalloonFSM struct {
-	hasherF func() hashing.Hasher
+	hasherF       func() hashing.Hasher
+	hashAlgorithm string
 
 	store   storage.ManagedStore
 	balloon *balloon.Balloon
 	state   *fsmState
 
+	// namespaces holds the logs created besides the default one, whose
+	// balloon is kept apart.
+	namespaces map[string]*namespace
+
+	// keys are the keys that sign the snapshots, in the order they were
+	// rotated.
+	keys []*signingKey
+
+	// meta is the metadata of the nodes of the cluster, by node ID.
+	meta map[string]map[string]string
+
 	agentsQueue chan *protocol.Snapshot
 
-	restoreMu sync.RWMutex // Restore needs exclusive access to database.
+	// mu guards the balloon and the store. Queries share it, so they run
+	// in parallel. Applies prepare their additions without it and only
+	// take it exclusively to store and publish them, so queries never see
+	// an addition whose mutations are not stored yet. Restores take it for
+	// their whole duration. Raft serializes the applies, so additions are
+	// never prepared in parallel.
+	mu sync.RWMutex
 }
 
 func loadState(s storage.ManagedStore) (*fsmState, error) {
 	var state fsmState
-	kvstate, err := s.Get(storage.FSMStatePrefix, []byte{0xab})
+	kvstate, err := s.Get(storage.FSMStatePrefix, fsmStateKey)
 	if err == storage.ErrKeyNotFound {
 		log.Infof("Unable to find previous state: assuming a clean instance")
-		return &fsmState{0, 0, 0}, nil
+		return &fsmState{0, 0, 0, 0}, nil
 	}
 	if err != nil {
 		return nil, err
@@ -69,48 +100,373 @@ func loadState(s storage.ManagedStore) (*fsmState, error) {
 	return &state, err
 }
 
-func NewBalloonFSM(store storage.ManagedStore, hasherF func() hashing.Hasher, agentsQueue chan *protocol.Snapshot) (*BalloonFSM, error) {
+// fsmStateKey is the key under the fsm state prefix where the state of
+// the last applied entry is stored.
+var fsmStateKey = []byte{0xab}
+
+// hashAlgorithmKey is the key under the fsm state prefix where the hash
+// algorithm is stored on first boot.
+var hashAlgorithmKey = []byte("hash-algorithm")
+
+// ensureHashAlgorithm stores the hash algorithm of a clean instance and
+// rejects a different one afterwards, as the trees could not be verified
+// anymore. Instances that were created before the hash algorithm was
+// stored always used SHA-256.
+func ensureHashAlgorithm(s storage.ManagedStore, hashAlgorithm string) error {
+	kv, err := s.Get(storage.FSMStatePrefix, hashAlgorithmKey)
+	if err == nil {
+		if string(kv.Value) != hashAlgorithm {
+			return fmt.Errorf("hash algorithm %s does not match the stored one %s", hashAlgorithm, kv.Value)
+		}
+		return nil
+	}
+	if err != storage.ErrKeyNotFound {
+		return err
+	}
+
+	_, err = s.Get(storage.FSMStatePrefix, fsmStateKey)
+	if err == nil && hashAlgorithm != hashing.SHA256 {
+		return fmt.Errorf("hash algorithm %s does not match the one of the existing data %s", hashAlgorithm, hashing.SHA256)
+	}
+	if err != nil && err != storage.ErrKeyNotFound {
+		return err
+	}
+
+	return s.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStatePrefix, hashAlgorithmKey, []byte(hashAlgorithm)),
+	})
+}
+
+// modeKey is the key under the fsm state prefix where the mode of the
+// default namespace is stored on first boot.
+var modeKey = []byte("balloon-mode")
+
+// ensureMode stores the mode of a clean instance and rejects a different
+// one afterwards, as the leaves of the hyper tree would be misread.
+// Instances that were created before the mode was stored added events.
+func ensureMode(s storage.ManagedStore, mode balloon.Mode) error {
+	kv, err := s.Get(storage.FSMStatePrefix, modeKey)
+	if err == nil {
+		if string(kv.Value) != mode.String() {
+			return fmt.Errorf("balloon mode %s does not match the stored one %s", mode, kv.Value)
+		}
+		return nil
+	}
+	if err != storage.ErrKeyNotFound {
+		return err
+	}
+
+	_, err = s.Get(storage.FSMStatePrefix, fsmStateKey)
+	if err == nil && mode != balloon.EventMode {
+		return fmt.Errorf("balloon mode %s does not match the one of the existing data %s", mode, balloon.EventMode)
+	}
+	if err != nil && err != storage.ErrKeyNotFound {
+		return err
+	}
+
+	return s.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStatePrefix, modeKey, []byte(mode.String())),
+	})
+}
+
+// duplicatePolicyKey is the key under the fsm state prefix where the
+// duplicate policy of the default namespace is stored on first boot.
+var duplicatePolicyKey = []byte("duplicate-policy")
+
+// ensureDuplicatePolicy stores the duplicate policy of a clean instance and
+// rejects a different one afterwards, as replaying the log with another
+// policy would build other trees. Instances that were created before the
+// policy was stored always overwrote the duplicates.
+func ensureDuplicatePolicy(s storage.ManagedStore, duplicates balloon.DuplicatePolicy) error {
+	kv, err := s.Get(storage.FSMStatePrefix, duplicatePolicyKey)
+	if err == nil {
+		if string(kv.Value) != duplicates.String() {
+			return fmt.Errorf("duplicate policy %s does not match the stored one %s", duplicates, kv.Value)
+		}
+		return nil
+	}
+	if err != storage.ErrKeyNotFound {
+		return err
+	}
+
+	_, err = s.Get(storage.FSMStatePrefix, fsmStateKey)
+	if err == nil && duplicates != balloon.OverwriteDuplicates {
+		return fmt.Errorf("duplicate policy %s does not match the one of the existing data %s", duplicates, balloon.OverwriteDuplicates)
+	}
+	if err != nil && err != storage.ErrKeyNotFound {
+		return err
+	}
+
+	return s.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStatePrefix, duplicatePolicyKey, []byte(duplicates.String())),
+	})
+}
+
+func NewBalloonFSM(store storage.ManagedStore, hashAlgorithm string, mode balloon.Mode, duplicates balloon.DuplicatePolicy, agentsQueue chan *protocol.Snapshot) (*BalloonFSM, error) {
+	return newBalloonFSM(store, hashAlgorithm, mode, duplicates, "", agentsQueue)
+}
+
+// NewBalloonFSMFromCheckpoint returns a FSM whose hyper cache is loaded from
+// the checkpoint saved to the given file, if it can be used, instead of
+// rebuilt from the store.
+func NewBalloonFSMFromCheckpoint(store storage.ManagedStore, hashAlgorithm string, mode balloon.Mode, duplicates balloon.DuplicatePolicy, checkpointPath string, agentsQueue chan *protocol.Snapshot) (*BalloonFSM, error) {
+	return newBalloonFSM(store, hashAlgorithm, mode, duplicates, checkpointPath, agentsQueue)
+}
+
+func newBalloonFSM(store storage.ManagedStore, hashAlgorithm string, mode balloon.Mode, duplicates balloon.DuplicatePolicy, checkpointPath string, agentsQueue chan *protocol.Snapshot) (*BalloonFSM, error) {
 
-	b, err := balloon.NewBalloon(store, hasherF)
+	hasherF, err := hashing.NewHasherF(hashAlgorithm)
 	if err != nil {
 		return nil, err
 	}
+	if err := ensureHashAlgorithm(store, hashAlgorithm); err != nil {
+		return nil, err
+	}
+	if err := ensureMode(store, mode); err != nil {
+		return nil, err
+	}
+	if err := ensureDuplicatePolicy(store, duplicates); err != nil {
+		return nil, err
+	}
+
+	var b *balloon.Balloon
+	if checkpointPath != "" {
+		b, err = balloon.NewBalloonFromCheckpoint(store, hasherF, checkpointPath)
+	} else {
+		b, err = balloon.NewBalloon(store, hasherF)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.SetMode(mode)
+	b.SetDuplicatePolicy(duplicates)
 	state, err := loadState(store)
 	if err != nil {
 		log.Infof("There was an error recovering the FSM state!!")
 		return nil, err
 	}
+	namespaces, err := openNamespaces(store, hasherF, checkpointPath)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := loadSigningKeys(store)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := loadMetadata(store)
+	if err != nil {
+		return nil, err
+	}
 
 	return &BalloonFSM{
-		hasherF:     hasherF,
-		store:       store,
-		balloon:     b,
-		state:       state,
-		agentsQueue: agentsQueue,
+		hasherF:       hasherF,
+		hashAlgorithm: hashAlgorithm,
+		store:         store,
+		balloon:       b,
+		state:         state,
+		namespaces:    namespaces,
+		keys:          keys,
+		meta:          meta,
+		agentsQueue:   agentsQueue,
 	}, nil
 }
 
-func (fsm *BalloonFSM) QueryDigestMembership(keyDigest hashing.Digest, version uint64) (*balloon.MembershipProof, error) {
-	return fsm.balloon.QueryDigestMembership(keyDigest, version)
+// namespace returns the log with the given name, the default one if it is
+// empty. The caller must hold the lock.
+func (fsm *BalloonFSM) namespace(name string) (*namespace, error) {
+	if name == "" {
+		return &namespace{balloon: fsm.balloon}, nil
+	}
+	ns, ok := fsm.namespaces[name]
+	if !ok {
+		return nil, ErrNamespaceNotFound
+	}
+	return ns, nil
+}
+
+// HasNamespace returns whether the namespace exists. The default one
+// always does.
+func (fsm *BalloonFSM) HasNamespace(name string) bool {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	_, err := fsm.namespace(name)
+	return err == nil
+}
+
+// Version returns the version the next addition to the namespace will
+// get.
+func (fsm *BalloonFSM) Version(namespace string) (uint64, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	ns, err := fsm.namespace(namespace)
+	if err != nil {
+		return 0, err
+	}
+	return ns.balloon.Version(), nil
+}
+
+// HashAlgorithm returns the identifier of the hash algorithm used by the
+// balloon.
+func (fsm *BalloonFSM) HashAlgorithm() string {
+	return fsm.hashAlgorithm
+}
+
+func (fsm *BalloonFSM) QueryDigestMembership(namespace string, keyDigest hashing.Digest, version uint64) (*balloon.MembershipProof, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	ns, err := fsm.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return ns.balloon.QueryDigestMembership(keyDigest, version)
+}
+
+func (fsm *BalloonFSM) QueryBatchMembership(namespace string, keyDigests []hashing.Digest, version uint64) (*balloon.BatchMembershipProof, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	ns, err := fsm.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return ns.balloon.QueryBatchMembership(keyDigests, version)
+}
+
+func (fsm *BalloonFSM) QueryRange(namespace string, start, end, version uint64) (*balloon.RangeProof, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	ns, err := fsm.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return ns.balloon.QueryRange(start, end, version)
+}
+
+func (fsm *BalloonFSM) QueryVersionAt(namespace string, timestamp int64) (uint64, int64, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	ns, err := fsm.namespace(namespace)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ns.balloon.QueryVersionAt(timestamp)
+}
+
+func (fsm *BalloonFSM) QueryMembership(namespace string, event []byte, version uint64) (*balloon.MembershipProof, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	ns, err := fsm.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return ns.balloon.QueryMembership(event, version)
+}
+
+func (fsm *BalloonFSM) QueryKeyValue(namespace string, key []byte) (*balloon.KeyValueProof, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	ns, err := fsm.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return ns.balloon.QueryKeyValue(key)
+}
+
+func (fsm *BalloonFSM) QueryKeyHistory(namespace string, key []byte, start, end uint64) (*balloon.KeyHistoryProof, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	ns, err := fsm.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return ns.balloon.QueryKeyHistory(key, start, end)
+}
+
+func (fsm *BalloonFSM) QueryConsistency(namespace string, start, end uint64) (*balloon.IncrementalProof, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	ns, err := fsm.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return ns.balloon.QueryConsistency(start, end)
+}
+
+// QueryCTTreeHead returns the head of the Certificate Transparency tree of
+// the namespace, which is given in it.
+func (fsm *BalloonFSM) QueryCTTreeHead(namespace string) (*balloon.TreeHead, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	ns, err := fsm.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	head, err := ns.balloon.QueryCTTreeHead()
+	if err != nil {
+		return nil, err
+	}
+	head.Namespace = namespace
+	return head, nil
+}
+
+func (fsm *BalloonFSM) QueryCTConsistency(namespace string, first, second uint64) ([]hashing.Digest, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	ns, err := fsm.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return ns.balloon.QueryCTConsistency(first, second)
 }
 
-func (fsm *BalloonFSM) QueryMembership(event []byte, version uint64) (*balloon.MembershipProof, error) {
-	return fsm.balloon.QueryMembership(event, version)
+func (fsm *BalloonFSM) QueryCTMembership(namespace string, leafHash hashing.Digest, size uint64) (uint64, []hashing.Digest, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	ns, err := fsm.namespace(namespace)
+	if err != nil {
+		return 0, nil, err
+	}
+	return ns.balloon.QueryCTMembership(leafHash, size)
 }
 
-func (fsm *BalloonFSM) QueryConsistency(start, end uint64) (*balloon.IncrementalProof, error) {
-	return fsm.balloon.QueryConsistency(start, end)
+func (fsm *BalloonFSM) QueryCTEntries(namespace string, start, end uint64) ([][]byte, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	ns, err := fsm.namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return ns.balloon.QueryCTEntries(start, end)
 }
 
+// fsmState keeps the last applied raft log entry along with the balloon
+// version and the time it was committed.
 type fsmState struct {
 	Index, Term, BalloonVersion uint64
+	Timestamp                   int64
 }
 
-func (s fsmState) shouldApply(f *fsmState) bool {
+// commitTimestamp returns the timestamp for the next version. The leader
+// clock could go backwards on a leadership change, so the timestamp is never
+// lower than the last one, keeping versions ordered by time. Every replica
+// gets the same result as it only depends on the log and the state.
+func (s fsmState) commitTimestamp(timestamp int64) int64 {
+	if timestamp < s.Timestamp {
+		return s.Timestamp
+	}
+	return timestamp
+}
+
+// isNewer returns whether the new state comes from a raft log entry after
+// the one of the current state.
+func (s fsmState) isNewer(f *fsmState) bool {
 	if f.Term < s.Term {
 		return false
 	}
-	if f.Term == s.Term && f.Index <= s.Index {
+	return f.Term > s.Term || f.Index > s.Index
+}
+
+func (s fsmState) shouldApply(f *fsmState) bool {
+	if !s.isNewer(f) {
 		return false
 	}
 
@@ -121,10 +477,21 @@ func (s fsmState) shouldApply(f *fsmState) bool {
 	return true
 }
 
+// newState returns the state after applying the raft log entry to the
+// given namespace and whether it should be applied. The balloon version of
+// the state is the one of the default namespace, as the others keep their
+// versions in their own trees.
+func (fsm *BalloonFSM) newState(l *raft.Log, namespace string) (*fsmState, bool) {
+	if namespace == "" {
+		state := &fsmState{l.Index, l.Term, fsm.balloon.Version(), fsm.state.Timestamp}
+		return state, fsm.state.shouldApply(state)
+	}
+	state := &fsmState{l.Index, l.Term, fsm.state.BalloonVersion, fsm.state.Timestamp}
+	return state, fsm.state.isNewer(state)
+}
+
 // Apply applies a Raft log entry to the database.
 func (fsm *BalloonFSM) Apply(l *raft.Log) interface{} {
-	// TODO should i use a restore mutex?
-
 	buf := l.Data
 	cmdType := commands.CommandType(buf[0])
 
@@ -134,11 +501,86 @@ func (fsm *BalloonFSM) Apply(l *raft.Log) interface{} {
 		if err := commands.Decode(buf[1:], &cmd); err != nil {
 			return &fsmAddResponse{error: err}
 		}
-		newState := &fsmState{l.Index, l.Term, fsm.balloon.Version()}
-		if fsm.state.shouldApply(newState) {
-			return fsm.applyAdd(cmd.Event, newState)
+		newState, ok := fsm.newState(l, cmd.Namespace)
+		if ok {
+			resp := fsm.applyAdd(cmd.Namespace, cmd.Event, cmd.Timestamp, newState)
+			if resp.error == nil && !resp.duplicate {
+				fsm.sendToAgents(resp.snapshot)
+			}
+			return resp
 		}
 		return &fsmAddResponse{error: fmt.Errorf("state already applied!: %+v -> %+v", fsm.state, newState)}
+	case commands.AddEventsCommandType:
+		var cmd commands.AddEventsCommand
+		if err := commands.Decode(buf[1:], &cmd); err != nil {
+			return &fsmAddBulkResponse{error: err}
+		}
+		newState, ok := fsm.newState(l, cmd.Namespace)
+		if ok {
+			resp := fsm.applyAddBulk(cmd.Namespace, cmd.Events, cmd.Timestamp, newState)
+			if resp.error == nil {
+				fsm.sendToAgents(resp.added...)
+			}
+			return resp
+		}
+		return &fsmAddBulkResponse{error: fmt.Errorf("state already applied!: %+v -> %+v", fsm.state, newState)}
+	case commands.AddKeyValueCommandType:
+		var cmd commands.AddKeyValueCommand
+		if err := commands.Decode(buf[1:], &cmd); err != nil {
+			return &fsmAddResponse{error: err}
+		}
+		newState, ok := fsm.newState(l, cmd.Namespace)
+		if ok {
+			resp := fsm.applyAddKeyValue(cmd.Namespace, cmd.Key, cmd.Value, cmd.Timestamp, newState)
+			if resp.error == nil {
+				fsm.sendToAgents(resp.snapshot)
+			}
+			return resp
+		}
+		return &fsmAddResponse{error: fmt.Errorf("state already applied!: %+v -> %+v", fsm.state, newState)}
+	case commands.CreateNamespaceCommandType:
+		var cmd commands.CreateNamespaceCommand
+		if err := commands.Decode(buf[1:], &cmd); err != nil {
+			return &fsmGenericResponse{error: err}
+		}
+		newState := &fsmState{l.Index, l.Term, fsm.state.BalloonVersion, fsm.state.Timestamp}
+		if fsm.state.isNewer(newState) {
+			return fsm.applyCreateNamespace(cmd.Name, cmd.Mode, cmd.Duplicates, newState)
+		}
+		return &fsmGenericResponse{error: fmt.Errorf("state already applied!: %+v -> %+v", fsm.state, newState)}
+	case commands.RotateKeyCommandType:
+		var cmd commands.RotateKeyCommand
+		if err := commands.Decode(buf[1:], &cmd); err != nil {
+			return &fsmGenericResponse{error: err}
+		}
+		newState := &fsmState{l.Index, l.Term, fsm.state.BalloonVersion, fsm.state.Timestamp}
+		if fsm.state.isNewer(newState) {
+			return fsm.applyRotateKey(cmd.Algorithm, cmd.PublicKey, cmd.Initial, newState)
+		}
+		return &fsmGenericResponse{error: fmt.Errorf("state already applied!: %+v -> %+v", fsm.state, newState)}
+	case commands.MetadataSetCommandType:
+		var cmd commands.MetadataSetCommand
+		if err := commands.Decode(buf[1:], &cmd); err != nil {
+			return &fsmGenericResponse{error: err}
+		}
+		newState := &fsmState{l.Index, l.Term, fsm.state.BalloonVersion, fsm.state.Timestamp}
+		if fsm.state.isNewer(newState) {
+			if cmd.Data == nil {
+				cmd.Data = map[string]string{}
+			}
+			return fsm.applyMetadata(cmd.Id, cmd.Data, newState)
+		}
+		return &fsmGenericResponse{error: fmt.Errorf("state already applied!: %+v -> %+v", fsm.state, newState)}
+	case commands.MetadataDeleteCommandType:
+		var cmd commands.MetadataDeleteCommand
+		if err := commands.Decode(buf[1:], &cmd); err != nil {
+			return &fsmGenericResponse{error: err}
+		}
+		newState := &fsmState{l.Index, l.Term, fsm.state.BalloonVersion, fsm.state.Timestamp}
+		if fsm.state.isNewer(newState) {
+			return fsm.applyMetadata(cmd.Id, nil, newState)
+		}
+		return &fsmGenericResponse{error: fmt.Errorf("state already applied!: %+v -> %+v", fsm.state, newState)}
 	default:
 		return &fsmGenericResponse{error: fmt.Errorf("unknown command: %v", cmdType)}
 
@@ -149,8 +591,8 @@ func (fsm *BalloonFSM) Apply(l *raft.Log) interface{} {
 // no Raft transaction is taking place during this call. Hashicorp Raft
 // guarantees that this function will not be called concurrently with Apply.
 func (fsm *BalloonFSM) Snapshot() (raft.FSMSnapshot, error) {
-	fsm.restoreMu.Lock()
-	defer fsm.restoreMu.Unlock()
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
 	version, err := fsm.store.GetLastVersion()
 	if err != nil {
 		return nil, err
@@ -165,48 +607,254 @@ func (fsm *BalloonFSM) Restore(rc io.ReadCloser) error {
 	log.Debug("Restoring Balloon...")
 
 	var err error
-	// Set the state from the snapshot, no lock required according to
-	// Hashicorp docs.
+	// Set the state from the snapshot. Hashicorp docs state that it is
+	// not called concurrently with Apply, but queries could be running.
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
 	if err = fsm.store.Load(rc); err != nil {
 		return err
 	}
+	// the state, including the last timestamp, comes with the snapshot
+	state, err := loadState(fsm.store)
+	if err != nil {
+		return err
+	}
+	fsm.state = state
+	// the namespaces could have been created or changed after the ones
+	// we had, so they are opened again from the restored store
+	namespaces, err := openNamespaces(fsm.store, fsm.hasherF, "")
+	if err != nil {
+		return err
+	}
+	fsm.namespaces = namespaces
+	if fsm.keys, err = loadSigningKeys(fsm.store); err != nil {
+		return err
+	}
+	if fsm.meta, err = loadMetadata(fsm.store); err != nil {
+		return err
+	}
 	return fsm.balloon.RefreshVersion()
 }
 
+// Checkpoint saves the hyper cache to the given file, so the FSM can be
+// reopened without rebuilding it. The cache of every namespace is saved to
+// its own file next to it. Once a checkpoint is saved, the journal of the
+// versions it covers is deleted. Applies are only blocked while the caches
+// are copied, not while they are written.
+func (fsm *BalloonFSM) Checkpoint(path string) error {
+	type checkpointed struct {
+		balloon    *balloon.Balloon
+		checkpoint *hyper.Checkpoint
+	}
+
+	fsm.mu.RLock()
+	checkpoints := map[string]checkpointed{
+		path: {fsm.balloon, fsm.balloon.Checkpoint()},
+	}
+	for name, ns := range fsm.namespaces {
+		checkpoints[namespaceCheckpointPath(path, name)] = checkpointed{ns.balloon, ns.balloon.Checkpoint()}
+	}
+	fsm.mu.RUnlock()
+
+	for path, c := range checkpoints {
+		if err := c.checkpoint.Save(path); err != nil {
+			return err
+		}
+		if err := c.balloon.PruneHyperJournal(c.checkpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (fsm *BalloonFSM) Close() error {
 	return fsm.store.Close()
 }
 
-func (fsm *BalloonFSM) applyAdd(event []byte, state *fsmState) *fsmAddResponse {
+// lookupNamespace returns the log with the given name for an apply, which
+// prepares its addition without holding the lock.
+func (fsm *BalloonFSM) lookupNamespace(name string) (*namespace, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	return fsm.namespace(name)
+}
 
-	snapshot, mutations, err := fsm.balloon.Add(event)
+func (fsm *BalloonFSM) applyAdd(namespace string, event []byte, timestamp int64, state *fsmState) *fsmAddResponse {
+	ns, err := fsm.lookupNamespace(namespace)
 	if err != nil {
 		return &fsmAddResponse{error: err}
 	}
+	addition, err := ns.balloon.PrepareAdd(event)
+	if err != nil {
+		return &fsmAddResponse{error: err}
+	}
+	snapshot := addition.Snapshots[0]
+	snapshot.Namespace = namespace
 
-	stateBuff, err := encodeMsgPack(state)
+	// the original snapshot of a duplicate has nothing to commit
+	if len(addition.Mutations) == 0 {
+		return &fsmAddResponse{snapshot: snapshot, duplicate: true}
+	}
+
+	return fsm.commitAdd(ns, addition, timestamp, state)
+}
+
+func (fsm *BalloonFSM) applyAddKeyValue(namespace string, key, value []byte, timestamp int64, state *fsmState) *fsmAddResponse {
+	ns, err := fsm.lookupNamespace(namespace)
 	if err != nil {
 		return &fsmAddResponse{error: err}
 	}
+	addition, err := ns.balloon.PrepareAddKeyValue(key, value)
+	if err != nil {
+		return &fsmAddResponse{error: err}
+	}
+	addition.Snapshots[0].Namespace = namespace
+
+	return fsm.commitAdd(ns, addition, timestamp, state)
+}
+
+// commitAdd stores the mutations of a single addition to the namespace
+// along with its timestamp and the new state.
+func (fsm *BalloonFSM) commitAdd(ns *namespace, addition *balloon.Addition, timestamp int64, state *fsmState) *fsmAddResponse {
+	snapshot := addition.Snapshots[0]
 
-	mutations = append(mutations, storage.NewMutation(storage.FSMStatePrefix, []byte{0xab}, stateBuff.Bytes()))
-	err = fsm.store.Mutate(mutations)
+	state.Timestamp = state.commitTimestamp(timestamp)
+	snapshot.Timestamp = state.Timestamp
+	mutations := append(addition.Mutations, balloon.NewTimestampMutation(snapshot.Version, snapshot.Timestamp))
+	ctMutations, err := ns.balloon.CTMutations(addition.Snapshots)
 	if err != nil {
 		return &fsmAddResponse{error: err}
 	}
-	fsm.state = state
+	mutations = ns.mutations(append(mutations, ctMutations...))
 
-	//Send snapshot to gossip agents
-	fsm.agentsQueue <- &protocol.Snapshot{
-		HistoryDigest: snapshot.HistoryDigest,
-		HyperDigest:   snapshot.HyperDigest,
-		Version:       snapshot.Version,
-		EventDigest:   snapshot.EventDigest,
+	if err := fsm.commit(addition, mutations, state); err != nil {
+		return &fsmAddResponse{error: err}
 	}
 
 	return &fsmAddResponse{snapshot: snapshot}
 }
 
+func (fsm *BalloonFSM) applyAddBulk(namespace string, events [][]byte, timestamp int64, state *fsmState) *fsmAddBulkResponse {
+	ns, err := fsm.lookupNamespace(namespace)
+	if err != nil {
+		return &fsmAddBulkResponse{error: err}
+	}
+	first := ns.balloon.Version()
+	addition, err := ns.balloon.PrepareAddBulk(events)
+	if err != nil {
+		return &fsmAddBulkResponse{error: err}
+	}
+	snapshots := addition.Snapshots
+
+	// the duplicates returned idempotently keep their original version and
+	// timestamp, while the snapshots repeated in the bulk are shared
+	added := make([]*balloon.Snapshot, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		snapshot.Namespace = namespace
+		if snapshot.Version >= first+uint64(len(added)) {
+			added = append(added, snapshot)
+		}
+	}
+	if len(added) == 0 {
+		return &fsmAddBulkResponse{snapshots: snapshots, added: added}
+	}
+
+	// all the events of a bulk are committed at the same time
+	state.Timestamp = state.commitTimestamp(timestamp)
+	mutations := addition.Mutations
+	for _, snapshot := range added {
+		snapshot.Timestamp = state.Timestamp
+		mutations = append(mutations, balloon.NewTimestampMutation(snapshot.Version, snapshot.Timestamp))
+	}
+	ctMutations, err := ns.balloon.CTMutations(added)
+	if err != nil {
+		return &fsmAddBulkResponse{error: err}
+	}
+	mutations = ns.mutations(append(mutations, ctMutations...))
+
+	// the state must reflect the version of the last event in the bulk
+	// to keep the balloon version check in shouldApply consistent
+	if namespace == "" {
+		state.BalloonVersion = added[len(added)-1].Version
+	}
+
+	if err := fsm.commit(addition, mutations, state); err != nil {
+		return &fsmAddBulkResponse{error: err}
+	}
+
+	return &fsmAddBulkResponse{snapshots: snapshots, added: added}
+}
+
+// commit stores the mutations of an addition along with the new state and
+// publishes its versions. The addition is prepared without the lock, which
+// is only taken here, so queries are not blocked while the trees are
+// updated but never see a version whose mutations are not stored yet.
+func (fsm *BalloonFSM) commit(addition *balloon.Addition, mutations []*storage.Mutation, state *fsmState) error {
+	stateBuff, err := encodeMsgPack(state)
+	if err != nil {
+		return err
+	}
+	mutations = append(mutations, storage.NewMutation(storage.FSMStatePrefix, fsmStateKey, stateBuff.Bytes()))
+
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	if err := fsm.store.Mutate(mutations); err != nil {
+		return err
+	}
+	addition.Commit()
+	fsm.state = state
+
+	return nil
+}
+
+// applyCreateNamespace registers a new empty namespace, with the mode and
+// the duplicate policy of the given names, along with the new state.
+func (fsm *BalloonFSM) applyCreateNamespace(name, mode, duplicates string, state *fsmState) *fsmGenericResponse {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	if !ValidNamespace(name) {
+		return &fsmGenericResponse{error: ErrInvalidNamespace}
+	}
+	if _, ok := fsm.namespaces[name]; ok {
+		return &fsmGenericResponse{error: ErrNamespaceExists}
+	}
+	nsMode, policy, err := parseNamespaceOptions(mode, duplicates)
+	if err != nil {
+		return &fsmGenericResponse{error: err}
+	}
+	ns, err := openNamespace(fsm.store, fsm.hasherF, name, nsMode, policy, "")
+	if err != nil {
+		return &fsmGenericResponse{error: err}
+	}
+
+	stateBuff, err := encodeMsgPack(state)
+	if err != nil {
+		return &fsmGenericResponse{error: err}
+	}
+	err = fsm.store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.NamespacePrefix, []byte(name), namespaceRegistryValue(nsMode, policy)),
+		storage.NewMutation(storage.FSMStatePrefix, fsmStateKey, stateBuff.Bytes()),
+	})
+	if err != nil {
+		return &fsmGenericResponse{error: err}
+	}
+	fsm.namespaces[name] = ns
+	fsm.state = state
+
+	return &fsmGenericResponse{}
+}
+
+// sendToAgents sends the snapshots to the gossip agents. It must be called
+// once the additions are committed and without holding the lock, as the
+// queue could block queries otherwise.
+func (fsm *BalloonFSM) sendToAgents(snapshots ...*balloon.Snapshot) {
+	for _, snapshot := range snapshots {
+		fsm.agentsQueue <- protocol.ToSnapshot(snapshot, fsm.hashAlgorithm)
+	}
+}
+
 // Decode reverses the encode operation on a byte slice input
 func decodeMsgPack(buf []byte, out interface{}) error {
 	r := bytes.NewBuffer(buf)
@@ -1,23 +1,35 @@
 package raftwal
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
 	"io"
+	"os"
+	"sync"
 	"testing"
 
 	"github.com/hashicorp/raft"
 	assert "github.com/stretchr/testify/require"
 
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/balloon/ct"
 	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/raftwal/commands"
+	"github.com/bbva/qed/sign"
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/bplus"
 	storage_utils "github.com/bbva/qed/testutils/storage"
+	"github.com/bbva/qed/util"
 )
 
 func TestApply(t *testing.T) {
 	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
 	defer closeF()
 
-	fsm, err := NewBalloonFSM(store, hashing.NewSha256Hasher, make(chan *protocol.Snapshot, 100))
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
 	assert.NoError(t, err)
 
 	// happy path
@@ -38,11 +50,601 @@ func TestApply(t *testing.T) {
 
 }
 
+func TestApplyBulk(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.NoError(t, err)
+
+	// happy path
+	r := fsm.Apply(newRaftBulkLog(1, 1, 10)).(*fsmAddBulkResponse)
+	assert.Nil(t, r.error)
+	assert.Len(t, r.snapshots, 10)
+	assert.Equal(t, uint64(9), r.snapshots[9].Version)
+
+	// Error: Command already applied
+	r = fsm.Apply(newRaftBulkLog(1, 1, 10)).(*fsmAddBulkResponse)
+	assert.Error(t, r.error)
+
+	// happy path: single events continue after the bulk
+	a := fsm.Apply(newRaftLog(2, 1)).(*fsmAddResponse)
+	assert.Nil(t, a.error)
+	assert.Equal(t, uint64(10), a.snapshot.Version)
+
+	// happy path
+	r = fsm.Apply(newRaftBulkLog(3, 1, 5)).(*fsmAddBulkResponse)
+	assert.Nil(t, r.error)
+	assert.Equal(t, uint64(11), r.snapshots[0].Version)
+}
+
+func TestApplyKeyValue(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.KeyValueMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.NoError(t, err)
+
+	// happy path
+	r := fsm.Apply(newRaftKeyValueLog(1, 1, "value 1")).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	r = fsm.Apply(newRaftKeyValueLog(2, 1, "value 2")).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	assert.Equal(t, uint64(1), r.snapshot.Version)
+
+	// Error: Command already applied
+	r = fsm.Apply(newRaftKeyValueLog(2, 1, "value 2")).(*fsmAddResponse)
+	assert.Error(t, r.error)
+
+	// Error: Events are not added in key/value mode
+	r = fsm.Apply(newRaftLog(3, 1)).(*fsmAddResponse)
+	assert.Equal(t, balloon.ErrWrongMode, r.error)
+
+	proof, err := fsm.QueryKeyValue("", []byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value 2"), proof.Entry.Value)
+	assert.Equal(t, uint64(0), proof.Entry.Previous)
+}
+
+func TestApplyTimestamps(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.NoError(t, err)
+
+	r := fsm.Apply(newRaftTimestampedLog(1, 1, 100)).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	assert.Equal(t, int64(100), r.snapshot.Timestamp)
+
+	// a leader with a clock behind the previous one
+	r = fsm.Apply(newRaftTimestampedLog(2, 2, 50)).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	assert.Equal(t, int64(100), r.snapshot.Timestamp, "Timestamps should never decrease")
+
+	r = fsm.Apply(newRaftTimestampedLog(3, 2, 300)).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	assert.Equal(t, int64(300), r.snapshot.Timestamp)
+
+	version, timestamp, err := fsm.QueryVersionAt("", 299)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), version, "Wrong version for the timestamp")
+	assert.Equal(t, int64(100), timestamp, "Wrong timestamp of the version")
+
+	version, _, err = fsm.QueryVersionAt("", 300)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), version, "Wrong version for the timestamp")
+
+	_, _, err = fsm.QueryVersionAt("", 99)
+	assert.Equal(t, balloon.ErrVersionNotFound, err, "No version was committed before the first one")
+}
+
+func TestQueriesDuringApply(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 1000))
+	assert.NoError(t, err)
+
+	numEvents := 200
+	snapshots := make([]*balloon.Snapshot, numEvents)
+
+	type query struct {
+		version uint64
+		proof   *balloon.MembershipProof
+	}
+	results := make(chan query, numEvents)
+
+	// readers query every event as soon as it is applied while the writer
+	// adds new ones, and the proofs are verified once all of them are applied
+	applied := make(chan uint64)
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for version := range applied {
+				proof, err := fsm.QueryMembership("", timestampedEvent(int64(version+1)), version)
+				assert.NoError(t, err)
+				results <- query{version, proof}
+			}
+		}()
+	}
+
+	for i := 0; i < numEvents; i++ {
+		r := fsm.Apply(newRaftTimestampedLog(uint64(i+1), 1, int64(i+1))).(*fsmAddResponse)
+		assert.Nil(t, r.error)
+		snapshots[i] = r.snapshot
+		applied <- uint64(i)
+	}
+	close(applied)
+	wg.Wait()
+	close(results)
+
+	for q := range results {
+		assert.True(t, q.proof.Exists, "Applied events should exist")
+		snapshot := &balloon.Snapshot{
+			EventDigest:   snapshots[q.version].EventDigest,
+			HistoryDigest: snapshots[q.version].HistoryDigest,
+			HyperDigest:   snapshots[q.proof.CurrentVersion].HyperDigest,
+			Version:       q.version,
+		}
+		assert.True(t, q.proof.Verify(timestampedEvent(int64(q.version+1)), snapshot), "The proof of version %d should be valid", q.version)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+	path := "/var/tmp/balloon.test.checkpoint"
+	defer os.Remove(path)
+
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.NoError(t, err)
+
+	var snapshot *balloon.Snapshot
+	for i := uint64(1); i <= 20; i++ {
+		r := fsm.Apply(newRaftTimestampedLog(i, 1, int64(i))).(*fsmAddResponse)
+		assert.Nil(t, r.error)
+		snapshot = r.snapshot
+
+		// the versions added after the checkpoint are replayed
+		if i == 10 {
+			assert.NoError(t, fsm.Checkpoint(path))
+		}
+	}
+
+	// only the journal of the versions after the checkpoint is kept
+	kvs, err := store.GetRange(storage.HyperLeafPrefix, util.Uint64AsBytes(0), util.Uint64AsBytes(snapshot.Version))
+	assert.NoError(t, err)
+	assert.Len(t, kvs, 10, "The journal of the checkpoint versions should be pruned")
+
+	restored, err := NewBalloonFSMFromCheckpoint(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, path, make(chan *protocol.Snapshot, 100))
+	assert.NoError(t, err)
+
+	for i := int64(1); i <= 20; i++ {
+		proof, err := restored.QueryMembership("", timestampedEvent(i), snapshot.Version)
+		assert.NoError(t, err)
+		assert.Truef(t, proof.Verify(timestampedEvent(i), snapshot), "The proof of event %d should verify", i)
+	}
+}
+
+func TestApplyNamespaces(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	agentsQueue := make(chan *protocol.Snapshot, 100)
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, agentsQueue)
+	assert.NoError(t, err)
+
+	c := fsm.Apply(newRaftCreateNamespaceLog(1, 1, "ns", "")).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	c = fsm.Apply(newRaftCreateNamespaceLog(2, 1, "ns", "")).(*fsmGenericResponse)
+	assert.Equal(t, ErrNamespaceExists, c.error)
+	c = fsm.Apply(newRaftCreateNamespaceLog(3, 1, "n/s", "")).(*fsmGenericResponse)
+	assert.Equal(t, ErrInvalidNamespace, c.error)
+
+	// the versions of each namespace are independent
+	index := uint64(4)
+	for i := uint64(0); i < 5; i++ {
+		r := fsm.Apply(newRaftNamespaceLog(index, 1, "", i)).(*fsmAddResponse)
+		assert.Nil(t, r.error)
+		assert.Equal(t, i, r.snapshot.Version)
+		assert.Equal(t, "", (<-agentsQueue).Namespace)
+		index++
+	}
+	for i := uint64(0); i < 3; i++ {
+		r := fsm.Apply(newRaftNamespaceLog(index, 1, "ns", i)).(*fsmAddResponse)
+		assert.Nil(t, r.error)
+		assert.Equal(t, i, r.snapshot.Version)
+		assert.Equal(t, "ns", r.snapshot.Namespace)
+		assert.Equal(t, "ns", (<-agentsQueue).Namespace)
+		index++
+	}
+	r := fsm.Apply(newRaftNamespaceLog(index, 1, "", 5)).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	assert.Equal(t, uint64(5), r.snapshot.Version)
+	<-agentsQueue
+	index++
+
+	r = fsm.Apply(newRaftNamespaceLog(index, 1, "unknown", 0)).(*fsmAddResponse)
+	assert.Equal(t, ErrNamespaceNotFound, r.error)
+
+	// the events of a namespace are not members of the others
+	proof, err := fsm.QueryMembership("ns", namespacedEvent(1), 2)
+	assert.NoError(t, err)
+	assert.True(t, proof.Exists)
+	proof, err = fsm.QueryMembership("", namespacedEvent(1), 5)
+	assert.NoError(t, err)
+	assert.True(t, proof.Exists)
+	proof, err = fsm.QueryMembership("ns", namespacedEvent(4), 2)
+	assert.NoError(t, err)
+	assert.False(t, proof.Exists, "Events of the default namespace should not be members of another")
+
+	// the namespaces are opened again with the store
+	reopened, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, agentsQueue)
+	assert.NoError(t, err)
+	assert.True(t, reopened.HasNamespace("ns"))
+	r = reopened.Apply(newRaftNamespaceLog(index, 1, "ns", 3)).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	assert.Equal(t, uint64(3), r.snapshot.Version)
+}
+
+func TestApplyKeyValueNamespace(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	agentsQueue := make(chan *protocol.Snapshot, 100)
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, agentsQueue)
+	assert.NoError(t, err)
+
+	data, _ := commands.Encode(commands.CreateNamespaceCommandType, &commands.CreateNamespaceCommand{Name: "keys", Mode: "keyvalue"})
+	c := fsm.Apply(&raft.Log{Index: 1, Term: 1, Type: raft.LogCommand, Data: data}).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	data, _ = commands.Encode(commands.CreateNamespaceCommandType, &commands.CreateNamespaceCommand{Name: "other", Mode: "mixed"})
+	c = fsm.Apply(&raft.Log{Index: 2, Term: 1, Type: raft.LogCommand, Data: data}).(*fsmGenericResponse)
+	assert.Error(t, c.error, "Unknown modes should be rejected")
+
+	// a key and an event with the same bytes live in different namespaces
+	data, _ = commands.Encode(commands.AddKeyValueCommandType, &commands.AddKeyValueCommand{Key: []byte("K"), Value: []byte("value"), Namespace: "keys"})
+	r := fsm.Apply(&raft.Log{Index: 3, Term: 1, Type: raft.LogCommand, Data: data}).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	<-agentsQueue
+	data, _ = commands.Encode(commands.AddEventCommandType, &commands.AddEventCommand{Event: []byte("K")})
+	r = fsm.Apply(&raft.Log{Index: 4, Term: 1, Type: raft.LogCommand, Data: data}).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	<-agentsQueue
+
+	// the mode of the namespace is opened again with the store
+	reopened, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, agentsQueue)
+	assert.NoError(t, err)
+	data, _ = commands.Encode(commands.AddEventCommandType, &commands.AddEventCommand{Event: []byte("K"), Namespace: "keys"})
+	r = reopened.Apply(&raft.Log{Index: 5, Term: 1, Type: raft.LogCommand, Data: data}).(*fsmAddResponse)
+	assert.Equal(t, balloon.ErrWrongMode, r.error, "Events should not be added to a key/value namespace")
+
+	proof, err := reopened.QueryKeyValue("keys", []byte("K"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), proof.Entry.Value)
+	membership, err := reopened.QueryMembership("", []byte("K"), 0)
+	assert.NoError(t, err)
+	assert.True(t, membership.Exists)
+}
+
+func TestApplyRotateKey(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	agentsQueue := make(chan *protocol.Snapshot, 100)
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, agentsQueue)
+	assert.NoError(t, err)
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	first, third := sign.NewEd25519Signer(), sign.NewEd25519Signer()
+	second, err := sign.NewECDSASigner(ecdsaKey)
+	assert.NoError(t, err)
+	keyring := sign.NewKeyring(first, second)
+	signer := &SnapshotSigner{fsm: fsm, keyring: keyring}
+
+	keys, err := fsm.SigningKeys("")
+	assert.NoError(t, err)
+	assert.Empty(t, keys, "No key should be registered on a clean instance")
+
+	// the initial key is only registered once
+	c := fsm.Apply(newRaftRotateKeyLog(1, 1, first, true)).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	c = fsm.Apply(newRaftRotateKeyLog(2, 1, third, true)).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	keys, err = fsm.SigningKeys("")
+	assert.NoError(t, err)
+	assert.Equal(t, []*SigningKey{
+		{ID: sign.KeyID(first.PublicKey()), Algorithm: sign.Ed25519, PublicKey: first.PublicKey(), Start: 0, Active: true},
+	}, keys)
+
+	index := uint64(3)
+	for i := uint64(0); i < 3; i++ {
+		r := fsm.Apply(newRaftNamespaceLog(index, 1, "", i)).(*fsmAddResponse)
+		assert.Nil(t, r.error)
+		<-agentsQueue
+		index++
+	}
+	c = fsm.Apply(newRaftCreateNamespaceLog(index, 1, "ns", "")).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	index++
+	r := fsm.Apply(newRaftNamespaceLog(index, 1, "ns", 0)).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	<-agentsQueue
+	index++
+
+	// the rotated key signs the next version of every namespace
+	c = fsm.Apply(newRaftRotateKeyLog(index, 1, second, false)).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	index++
+	keys, err = fsm.SigningKeys("")
+	assert.NoError(t, err)
+	assert.Equal(t, []*SigningKey{
+		{ID: sign.KeyID(first.PublicKey()), Algorithm: sign.Ed25519, PublicKey: first.PublicKey(), Start: 0, End: 3},
+		{ID: sign.KeyID(second.PublicKey()), Algorithm: sign.ECDSAP256SHA256, PublicKey: second.PublicKey(), Start: 3, Active: true},
+	}, keys)
+	keys, err = fsm.SigningKeys("ns")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), keys[0].End)
+	assert.Equal(t, uint64(1), keys[1].Start)
+
+	signed, err := signer.SignSnapshot(&protocol.Snapshot{Version: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, sign.KeyID(first.PublicKey()), signed.KeyID, "Versions before the rotation should be signed with the retired key")
+	signed, err = signer.SignSnapshot(&protocol.Snapshot{Version: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, sign.KeyID(second.PublicKey()), signed.KeyID, "Versions after the rotation should be signed with the active key")
+	signed, err = signer.SignSnapshot(&protocol.Snapshot{Version: 0, Namespace: "ns"})
+	assert.NoError(t, err)
+	assert.Equal(t, sign.KeyID(first.PublicKey()), signed.KeyID)
+
+	c = fsm.Apply(newRaftRotateKeyLog(index, 1, first, false)).(*fsmGenericResponse)
+	assert.Equal(t, ErrSigningKeyUsed, c.error)
+	index++
+
+	// the namespaces created after a rotation are signed by the active key
+	c = fsm.Apply(newRaftCreateNamespaceLog(index, 1, "late", "")).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	index++
+	signed, err = signer.SignSnapshot(&protocol.Snapshot{Version: 0, Namespace: "late"})
+	assert.NoError(t, err)
+	assert.Equal(t, sign.KeyID(second.PublicKey()), signed.KeyID)
+
+	// keys that are not loaded cannot sign
+	c = fsm.Apply(newRaftRotateKeyLog(index, 1, third, false)).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	_, err = signer.SignSnapshot(&protocol.Snapshot{Version: 2})
+	assert.NoError(t, err)
+	_, err = signer.SignSnapshot(&protocol.Snapshot{Version: 3})
+	assert.Error(t, err)
+
+	// the keys are loaded again with the store
+	reopened, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, agentsQueue)
+	assert.NoError(t, err)
+	reopenedKeys, err := reopened.SigningKeys("ns")
+	assert.NoError(t, err)
+	keys, err = fsm.SigningKeys("ns")
+	assert.NoError(t, err)
+	assert.Equal(t, keys, reopenedKeys)
+}
+
+func TestApplyMetadata(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	agentsQueue := make(chan *protocol.Snapshot, 100)
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, agentsQueue)
+	assert.NoError(t, err)
+
+	c := fsm.Apply(newRaftMetadataSetLog(1, 1, "node1", map[string]string{APIEndpointKey: "http://node1:8800"})).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	c = fsm.Apply(newRaftMetadataSetLog(2, 1, "node2", map[string]string{APIEndpointKey: "http://node2:8800"})).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	assert.Equal(t, "http://node1:8800", fsm.Metadata("node1", APIEndpointKey))
+	assert.Equal(t, "http://node2:8800", fsm.Metadata("node2", APIEndpointKey))
+
+	// new keys are merged with the ones already set
+	c = fsm.Apply(newRaftMetadataSetLog(3, 1, "node1", map[string]string{"zone": "a"})).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	assert.Equal(t, "http://node1:8800", fsm.Metadata("node1", APIEndpointKey))
+	assert.Equal(t, "a", fsm.Metadata("node1", "zone"))
+
+	// an old log is not applied twice
+	c = fsm.Apply(newRaftMetadataSetLog(3, 1, "node1", map[string]string{"zone": "b"})).(*fsmGenericResponse)
+	assert.Error(t, c.error)
+	assert.Equal(t, "a", fsm.Metadata("node1", "zone"))
+
+	c = fsm.Apply(newRaftMetadataDeleteLog(4, 1, "node1")).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	assert.Empty(t, fsm.Metadata("node1", APIEndpointKey))
+	assert.Equal(t, "http://node2:8800", fsm.Metadata("node2", APIEndpointKey))
+
+	// the metadata is persisted
+	fsm, err = NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, agentsQueue)
+	assert.NoError(t, err)
+	assert.Empty(t, fsm.Metadata("node1", APIEndpointKey))
+	assert.Equal(t, "http://node2:8800", fsm.Metadata("node2", APIEndpointKey))
+}
+
+func TestApplyDuplicates(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	agentsQueue := make(chan *protocol.Snapshot, 100)
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.RejectDuplicates, agentsQueue)
+	assert.NoError(t, err)
+
+	c := fsm.Apply(newRaftCreateNamespaceLog(1, 1, "ns", "idempotent")).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+	c = fsm.Apply(newRaftCreateNamespaceLog(2, 1, "other", "ignore")).(*fsmGenericResponse)
+	assert.Error(t, c.error, "Unknown duplicate policies should be rejected")
+
+	// the duplicates of the default namespace are rejected
+	r := fsm.Apply(newRaftNamespaceLog(3, 1, "", 0)).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	<-agentsQueue
+	r = fsm.Apply(newRaftNamespaceLog(4, 1, "", 0)).(*fsmAddResponse)
+	assert.Equal(t, balloon.ErrDuplicateEvent, r.error)
+	b := fsm.Apply(newRaftNamespaceBulkLog(5, 1, "", 1, 0)).(*fsmAddBulkResponse)
+	assert.Equal(t, balloon.ErrDuplicateEvent, b.error)
+	r = fsm.Apply(newRaftNamespaceLog(6, 1, "", 1)).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	assert.Equal(t, uint64(1), r.snapshot.Version, "Rejected events should not take a version")
+	<-agentsQueue
+
+	// the ones of the namespace get the original snapshot, which is not
+	// sent to the agents again
+	r = fsm.Apply(newRaftNamespaceLog(7, 1, "ns", 0)).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	<-agentsQueue
+	r = fsm.Apply(newRaftNamespaceLog(8, 1, "ns", 0)).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	assert.True(t, r.duplicate)
+	assert.Equal(t, uint64(0), r.snapshot.Version)
+	assert.Equal(t, "ns", r.snapshot.Namespace)
+	b = fsm.Apply(newRaftNamespaceBulkLog(9, 1, "ns", 0, 1)).(*fsmAddBulkResponse)
+	assert.Nil(t, b.error)
+	assert.Equal(t, uint64(0), b.snapshots[0].Version)
+	assert.Equal(t, uint64(1), b.snapshots[1].Version)
+	assert.Equal(t, uint64(1), (<-agentsQueue).Version)
+	assert.Empty(t, agentsQueue, "Only the new events should be sent to the agents")
+
+	// the policies are kept with the store
+	_, err = NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, agentsQueue)
+	assert.Error(t, err, "A duplicate policy different from the stored one should be rejected")
+	reopened, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.RejectDuplicates, agentsQueue)
+	assert.NoError(t, err)
+	r = reopened.Apply(newRaftNamespaceLog(10, 1, "ns", 1)).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+	assert.True(t, r.duplicate)
+}
+
+func TestApplyCT(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.NoError(t, err)
+
+	c := fsm.Apply(newRaftCreateNamespaceLog(1, 1, "ns", "")).(*fsmGenericResponse)
+	assert.Nil(t, c.error)
+
+	var snapshots []*balloon.Snapshot
+	for i := uint64(2); i < 5; i++ {
+		r := fsm.Apply(newRaftTimestampedLog(i, 1, int64(i)*1e6)).(*fsmAddResponse)
+		assert.Nil(t, r.error)
+		snapshots = append(snapshots, r.snapshot)
+	}
+	b := fsm.Apply(newRaftBulkLog(5, 1, 4)).(*fsmAddBulkResponse)
+	assert.Nil(t, b.error)
+	snapshots = append(snapshots, b.snapshots...)
+
+	b = fsm.Apply(newRaftNamespaceBulkLog(6, 1, "ns", 0, 1, 2)).(*fsmAddBulkResponse)
+	assert.Nil(t, b.error)
+	nsSnapshots := b.snapshots
+
+	for namespace, added := range map[string][]*balloon.Snapshot{"": snapshots, "ns": nsSnapshots} {
+		// the tree is the one of RFC 6962 of the leaf inputs of the versions
+		leafInputs := make([][]byte, len(added))
+		for i, s := range added {
+			leafInputs[i] = ct.LeafInput(s.EventDigest, s.Timestamp)
+		}
+		reference := bplus.NewBPlusTreeStore()
+		mutations, err := ct.NewTree(reference).Add(leafInputs, 0)
+		assert.NoError(t, err)
+		assert.NoError(t, reference.Mutate(mutations))
+		rootHash, err := ct.NewTree(reference).RootHash(uint64(len(added)))
+		assert.NoError(t, err)
+
+		head, err := fsm.QueryCTTreeHead(namespace)
+		assert.NoError(t, err)
+		assert.Equalf(t, uint64(len(added)), head.Size, "The size should be the number of versions of %q", namespace)
+		assert.Equalf(t, rootHash, head.RootHash, "Wrong root hash of %q", namespace)
+		assert.Equalf(t, added[len(added)-1].Timestamp, head.Timestamp, "The timestamp should be the one of the last version of %q", namespace)
+		assert.Equal(t, namespace, head.Namespace)
+
+		index, _, err := fsm.QueryCTMembership(namespace, ct.LeafHash(leafInputs[1]), head.Size)
+		assert.NoError(t, err)
+		assert.Equalf(t, uint64(1), index, "The leaf should be found by its hash in %q", namespace)
+
+		entries, err := fsm.QueryCTEntries(namespace, 0, head.Size)
+		assert.NoError(t, err)
+		assert.Equalf(t, leafInputs, entries, "Wrong entries of %q", namespace)
+	}
+}
+
+func TestDuplicatePolicyOfExistingData(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	// data stored before the duplicate policy was persisted overwrote them
+	store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStatePrefix, fsmStateKey, []byte{0x0}),
+	})
+
+	_, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.RecordDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.Error(t, err, "Existing data should only be accepted overwriting duplicates")
+}
+
+func TestModeIsPersisted(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.KeyValueMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.NoError(t, err)
+
+	r := fsm.Apply(newRaftKeyValueLog(1, 1, "value 1")).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+
+	_, err = NewBalloonFSM(store, hashing.SHA256, balloon.KeyValueMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.NoError(t, err, "The stored mode should be accepted")
+
+	_, err = NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.Error(t, err, "A mode different from the stored one should be rejected")
+}
+
+func TestHashAlgorithmIsPersisted(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	_, err := NewBalloonFSM(store, "md5", balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.Error(t, err, "Unknown hash algorithms should be rejected")
+
+	fsm, err := NewBalloonFSM(store, hashing.SHA3_256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.NoError(t, err)
+	assert.Equal(t, hashing.SHA3_256, fsm.HashAlgorithm())
+
+	r := fsm.Apply(newRaftLog(1, 1)).(*fsmAddResponse)
+	assert.Nil(t, r.error)
+
+	_, err = NewBalloonFSM(store, hashing.SHA3_256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.NoError(t, err, "The stored hash algorithm should be accepted")
+
+	_, err = NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.Error(t, err, "A hash algorithm different from the stored one should be rejected")
+}
+
+func TestHashAlgorithmOfExistingData(t *testing.T) {
+	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
+	defer closeF()
+
+	// data stored before the hash algorithm was persisted used SHA-256
+	store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStatePrefix, fsmStateKey, []byte{0x0}),
+	})
+
+	_, err := NewBalloonFSM(store, hashing.BLAKE2b_256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
+	assert.Error(t, err, "Existing data should only be accepted with SHA-256")
+}
+
 func TestSnapshot(t *testing.T) {
 	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
 	defer closeF()
 
-	fsm, err := NewBalloonFSM(store, hashing.NewSha256Hasher, make(chan *protocol.Snapshot, 100))
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
 	assert.NoError(t, err)
 
 	fsm.Apply(newRaftLog(0, 0))
@@ -66,7 +668,7 @@ func TestRestore(t *testing.T) {
 	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
 	defer closeF()
 
-	fsm, err := NewBalloonFSM(store, hashing.NewSha256Hasher, make(chan *protocol.Snapshot, 100))
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
 	assert.NoError(t, err)
 
 	assert.NoError(t, fsm.Restore(&fakeRC{}))
@@ -76,7 +678,7 @@ func TestAddAndRestoreSnapshot(t *testing.T) {
 	store, closeF := storage_utils.OpenBadgerStore(t, "/var/tmp/balloon.test.db")
 	defer closeF()
 
-	fsm, err := NewBalloonFSM(store, hashing.NewSha256Hasher, make(chan *protocol.Snapshot, 100))
+	fsm, err := NewBalloonFSM(store, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
 	assert.NoError(t, err)
 
 	fsm.Apply(newRaftLog(0, 0))
@@ -108,7 +710,7 @@ func TestAddAndRestoreSnapshot(t *testing.T) {
 	defer close2F()
 
 	// New FSMStore
-	fsm2, err := NewBalloonFSM(store2, hashing.NewSha256Hasher, make(chan *protocol.Snapshot, 100))
+	fsm2, err := NewBalloonFSM(store2, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, make(chan *protocol.Snapshot, 100))
 	assert.NoError(t, err)
 
 	err = fsm2.Restore(r)
@@ -124,3 +726,65 @@ func newRaftLog(index, term uint64) *raft.Log {
 	data, _ := commands.Encode(commands.AddEventCommandType, &commands.AddEventCommand{Event: event})
 	return &raft.Log{Index: index, Term: term, Type: raft.LogCommand, Data: data}
 }
+
+func timestampedEvent(timestamp int64) []byte {
+	return []byte(fmt.Sprintf("All's right with the world at %d", timestamp))
+}
+
+func newRaftTimestampedLog(index, term uint64, timestamp int64) *raft.Log {
+	event := timestampedEvent(timestamp)
+	data, _ := commands.Encode(commands.AddEventCommandType, &commands.AddEventCommand{Event: event, Timestamp: timestamp})
+	return &raft.Log{Index: index, Term: term, Type: raft.LogCommand, Data: data}
+}
+
+func newRaftBulkLog(index, term uint64, size int) *raft.Log {
+	events := make([][]byte, size)
+	for i := range events {
+		events[i] = []byte(fmt.Sprintf("All's right with the world %d", i))
+	}
+	data, _ := commands.Encode(commands.AddEventsCommandType, &commands.AddEventsCommand{Events: events})
+	return &raft.Log{Index: index, Term: term, Type: raft.LogCommand, Data: data}
+}
+
+func newRaftKeyValueLog(index, term uint64, value string) *raft.Log {
+	data, _ := commands.Encode(commands.AddKeyValueCommandType, &commands.AddKeyValueCommand{Key: []byte("key"), Value: []byte(value)})
+	return &raft.Log{Index: index, Term: term, Type: raft.LogCommand, Data: data}
+}
+
+func namespacedEvent(i uint64) []byte {
+	return []byte(fmt.Sprintf("All's right with the world %d", i))
+}
+
+func newRaftNamespaceLog(index, term uint64, namespace string, i uint64) *raft.Log {
+	data, _ := commands.Encode(commands.AddEventCommandType, &commands.AddEventCommand{Event: namespacedEvent(i), Namespace: namespace})
+	return &raft.Log{Index: index, Term: term, Type: raft.LogCommand, Data: data}
+}
+
+func newRaftNamespaceBulkLog(index, term uint64, namespace string, is ...uint64) *raft.Log {
+	events := make([][]byte, len(is))
+	for j, i := range is {
+		events[j] = namespacedEvent(i)
+	}
+	data, _ := commands.Encode(commands.AddEventsCommandType, &commands.AddEventsCommand{Events: events, Namespace: namespace})
+	return &raft.Log{Index: index, Term: term, Type: raft.LogCommand, Data: data}
+}
+
+func newRaftCreateNamespaceLog(index, term uint64, name, duplicates string) *raft.Log {
+	data, _ := commands.Encode(commands.CreateNamespaceCommandType, &commands.CreateNamespaceCommand{Name: name, Duplicates: duplicates})
+	return &raft.Log{Index: index, Term: term, Type: raft.LogCommand, Data: data}
+}
+
+func newRaftRotateKeyLog(index, term uint64, key sign.Signer, initial bool) *raft.Log {
+	data, _ := commands.Encode(commands.RotateKeyCommandType, &commands.RotateKeyCommand{Algorithm: key.Algorithm(), PublicKey: key.PublicKey(), Initial: initial})
+	return &raft.Log{Index: index, Term: term, Type: raft.LogCommand, Data: data}
+}
+
+func newRaftMetadataSetLog(index, term uint64, id string, data map[string]string) *raft.Log {
+	cmd, _ := commands.Encode(commands.MetadataSetCommandType, &commands.MetadataSetCommand{Id: id, Data: data})
+	return &raft.Log{Index: index, Term: term, Type: raft.LogCommand, Data: cmd}
+}
+
+func newRaftMetadataDeleteLog(index, term uint64, id string) *raft.Log {
+	data, _ := commands.Encode(commands.MetadataDeleteCommandType, &commands.MetadataDeleteCommand{Id: id})
+	return &raft.Log{Index: index, Term: term, Type: raft.LogCommand, Data: data}
+}
@@ -0,0 +1,232 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raftwal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/raftwal/commands"
+	"github.com/bbva/qed/sign"
+	"github.com/bbva/qed/storage"
+)
+
+var (
+	// ErrSigningKeyUsed is returned when rotating to a key that has
+	// already signed snapshots, as its versions would not be contiguous.
+	ErrSigningKeyUsed = errors.New("the signing key has already been used")
+)
+
+// signingKeysKey is the key under the fsm state prefix where the keys
+// that sign the snapshots are stored, in the order they were rotated.
+var signingKeysKey = []byte("signing-keys")
+
+// signingKey is a key the cluster agreed to sign snapshots with. It signs
+// the versions of every namespace from its start until the start of the
+// next key. The namespaces created after the rotation have no start, as
+// the key signs them from their first version.
+type signingKey struct {
+	Algorithm string
+	PublicKey []byte
+	Starts    map[string]uint64
+}
+
+// SigningKey is a key that signs the snapshots of a namespace from Start
+// on while it is Active, and up to End, not included, once it is retired.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	PublicKey []byte
+	Start     uint64
+	End       uint64
+	Active    bool
+}
+
+// signingKeyFor returns the key that signs the given version, if any.
+func signingKeyFor(keys []*SigningKey, version uint64) (*SigningKey, bool) {
+	for _, key := range keys {
+		if version >= key.Start && (key.Active || version < key.End) {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func loadSigningKeys(s storage.ManagedStore) ([]*signingKey, error) {
+	kv, err := s.Get(storage.FSMStatePrefix, signingKeysKey)
+	if err == storage.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []*signingKey
+	err = decodeMsgPack(kv.Value, &keys)
+	return keys, err
+}
+
+// SigningKeys returns the keys that signed the snapshots of the namespace
+// with their ranges of versions, in the order they were rotated. It is
+// empty until the first key is registered.
+func (fsm *BalloonFSM) SigningKeys(namespace string) ([]*SigningKey, error) {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	if _, err := fsm.namespace(namespace); err != nil {
+		return nil, err
+	}
+	keys := make([]*SigningKey, len(fsm.keys))
+	for i, k := range fsm.keys {
+		// the keys rotated before the algorithm was recorded are ed25519
+		algorithm := k.Algorithm
+		if algorithm == "" {
+			algorithm = sign.Ed25519
+		}
+		keys[i] = &SigningKey{
+			ID:        sign.KeyID(k.PublicKey),
+			Algorithm: algorithm,
+			PublicKey: k.PublicKey,
+			Start:     k.Starts[namespace],
+		}
+		if i > 0 {
+			keys[i-1].End = keys[i].Start
+		}
+	}
+	if len(keys) > 0 {
+		keys[len(keys)-1].Active = true
+	}
+	return keys, nil
+}
+
+// applyRotateKey registers the key that signs the next version of every
+// namespace, along with the new state.
+func (fsm *BalloonFSM) applyRotateKey(algorithm string, publicKey []byte, initial bool, state *fsmState) *fsmGenericResponse {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	if initial && len(fsm.keys) > 0 {
+		return &fsmGenericResponse{}
+	}
+	for _, k := range fsm.keys {
+		if bytes.Equal(k.PublicKey, publicKey) {
+			return &fsmGenericResponse{error: ErrSigningKeyUsed}
+		}
+	}
+
+	key := &signingKey{Algorithm: algorithm, PublicKey: publicKey, Starts: make(map[string]uint64)}
+	if !initial {
+		key.Starts[""] = fsm.balloon.Version()
+		for name, ns := range fsm.namespaces {
+			key.Starts[name] = ns.balloon.Version()
+		}
+	}
+	keys := append(fsm.keys[:len(fsm.keys):len(fsm.keys)], key)
+
+	keysBuff, err := encodeMsgPack(keys)
+	if err != nil {
+		return &fsmGenericResponse{error: err}
+	}
+	stateBuff, err := encodeMsgPack(state)
+	if err != nil {
+		return &fsmGenericResponse{error: err}
+	}
+	err = fsm.store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStatePrefix, signingKeysKey, keysBuff.Bytes()),
+		storage.NewMutation(storage.FSMStatePrefix, fsmStateKey, stateBuff.Bytes()),
+	})
+	if err != nil {
+		return &fsmGenericResponse{error: err}
+	}
+	fsm.keys = keys
+	fsm.state = state
+
+	return &fsmGenericResponse{}
+}
+
+// SigningKeys returns the keys that signed the snapshots of the default
+// namespace with their ranges of versions.
+func (b *RaftBalloon) SigningKeys() ([]*SigningKey, error) {
+	return b.fsm.SigningKeys("")
+}
+
+// RotateSigningKey makes the given public key sign the snapshots of every
+// namespace from their next version on, through Raft consensus, so every
+// node switches at the same versions. The private key must be loaded by
+// every node beforehand.
+func (b *RaftBalloon) RotateSigningKey(algorithm string, publicKey []byte) error {
+	return b.rotateSigningKey(algorithm, publicKey, false)
+}
+
+// InitSigningKey registers the public key that signs the snapshots from
+// the first version of every namespace, unless a key is already registered.
+func (b *RaftBalloon) InitSigningKey(algorithm string, publicKey []byte) error {
+	return b.rotateSigningKey(algorithm, publicKey, true)
+}
+
+func (b *RaftBalloon) rotateSigningKey(algorithm string, publicKey []byte, initial bool) error {
+	verifier, err := sign.NewVerifier(algorithm, publicKey)
+	if err != nil {
+		return err
+	}
+	cmd := &commands.RotateKeyCommand{
+		Algorithm: verifier.Algorithm(),
+		PublicKey: publicKey,
+		Initial:   initial,
+	}
+	resp, err := b.raftApply(commands.RotateKeyCommandType, cmd)
+	if err != nil {
+		return err
+	}
+	return resp.(*fsmGenericResponse).error
+}
+
+// SnapshotSigner signs the snapshots of a RaftBalloon with the key the
+// cluster agreed on for their versions. Until a key is registered, it signs
+// with the default key of the keyring.
+type SnapshotSigner struct {
+	fsm     *BalloonFSM
+	keyring *sign.Keyring
+}
+
+// NewSnapshotSigner returns a signer of the snapshots of the balloon with
+// the keys of the keyring.
+func NewSnapshotSigner(b *RaftBalloon, keyring *sign.Keyring) *SnapshotSigner {
+	return &SnapshotSigner{fsm: b.fsm, keyring: keyring}
+}
+
+func (s *SnapshotSigner) SignSnapshot(snapshot *protocol.Snapshot) (*protocol.SignedSnapshot, error) {
+	signer, err := s.SignerFor(snapshot.Namespace, snapshot.Version)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.Sign(signer)
+}
+
+func (s *SnapshotSigner) SignerFor(namespace string, version uint64) (sign.Signer, error) {
+	keys, err := s.fsm.SigningKeys(namespace)
+	if err != nil {
+		return nil, err
+	}
+	signer := s.keyring.Default()
+	if key, ok := signingKeyFor(keys, version); ok {
+		if signer, ok = s.keyring.Get(key.ID); !ok {
+			return nil, fmt.Errorf("the signing key %s of version %d is not loaded", key.ID, version)
+		}
+	}
+	return signer, nil
+}
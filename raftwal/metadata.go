@@ -0,0 +1,127 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raftwal
+
+import (
+	"github.com/bbva/qed/raftwal/commands"
+	"github.com/bbva/qed/storage"
+)
+
+// APIEndpointKey is the metadata key of the endpoint of the HTTP api of a
+// node, as clients reach it, like http://10.0.0.1:8800.
+const APIEndpointKey = "api_endpoint"
+
+// MgmtEndpointKey is the metadata key of the endpoint of the management
+// HTTP api of a node, like http://10.0.0.1:8700.
+const MgmtEndpointKey = "mgmt_endpoint"
+
+// metadataKey is the key under the fsm state prefix where the metadata of
+// the nodes is stored, by node ID.
+var metadataKey = []byte("metadata")
+
+func loadMetadata(s storage.ManagedStore) (map[string]map[string]string, error) {
+	meta := make(map[string]map[string]string)
+	kv, err := s.Get(storage.FSMStatePrefix, metadataKey)
+	if err == storage.ErrKeyNotFound {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = decodeMsgPack(kv.Value, &meta)
+	return meta, err
+}
+
+// Metadata returns the value of the key in the metadata of the node with
+// the given ID, empty if it is not set.
+func (fsm *BalloonFSM) Metadata(id, key string) string {
+	fsm.mu.RLock()
+	defer fsm.mu.RUnlock()
+	return fsm.meta[id][key]
+}
+
+// applyMetadata sets the metadata of the node with the given ID, or deletes
+// it if data is nil, along with the new state.
+func (fsm *BalloonFSM) applyMetadata(id string, data map[string]string, state *fsmState) *fsmGenericResponse {
+	fsm.mu.Lock()
+	defer fsm.mu.Unlock()
+
+	meta := make(map[string]map[string]string, len(fsm.meta))
+	for k, v := range fsm.meta {
+		meta[k] = v
+	}
+	if data == nil {
+		delete(meta, id)
+	} else {
+		merged := make(map[string]string, len(meta[id])+len(data))
+		for k, v := range meta[id] {
+			merged[k] = v
+		}
+		for k, v := range data {
+			merged[k] = v
+		}
+		meta[id] = merged
+	}
+
+	metaBuff, err := encodeMsgPack(meta)
+	if err != nil {
+		return &fsmGenericResponse{error: err}
+	}
+	stateBuff, err := encodeMsgPack(state)
+	if err != nil {
+		return &fsmGenericResponse{error: err}
+	}
+	err = fsm.store.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.FSMStatePrefix, metadataKey, metaBuff.Bytes()),
+		storage.NewMutation(storage.FSMStatePrefix, fsmStateKey, stateBuff.Bytes()),
+	})
+	if err != nil {
+		return &fsmGenericResponse{error: err}
+	}
+	fsm.meta = meta
+	fsm.state = state
+
+	return &fsmGenericResponse{}
+}
+
+// SetMetadata sets the given keys of the metadata of the node with the
+// given ID through Raft consensus, so every node knows them. This must be
+// called from the leader or it will fail.
+func (b *RaftBalloon) SetMetadata(id string, data map[string]string) error {
+	cmd := &commands.MetadataSetCommand{Id: id, Data: data}
+	resp, err := b.raftApply(commands.MetadataSetCommandType, cmd)
+	if err != nil {
+		return err
+	}
+	return resp.(*fsmGenericResponse).error
+}
+
+// Metadata returns the value of the key in the metadata of the node with
+// the given ID, empty if it is not set.
+func (b *RaftBalloon) Metadata(id, key string) string {
+	return b.fsm.Metadata(id, key)
+}
+
+// LeaderEndpoint returns the endpoint of the HTTP api of the leader, empty
+// if there is no leader or it did not register its endpoint.
+func (b *RaftBalloon) LeaderEndpoint() string {
+	id, err := b.LeaderID()
+	if err != nil || id == "" {
+		return ""
+	}
+	return b.Metadata(id, APIEndpointKey)
+}
@@ -0,0 +1,301 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package raftwal
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/protocol"
+	"github.com/bbva/qed/storage"
+)
+
+var (
+	// ErrInvalidNamespace is returned when a namespace name has characters
+	// other than letters, digits, hyphens and underscores, or it is longer
+	// than 64 characters.
+	ErrInvalidNamespace = errors.New("invalid namespace name")
+
+	// ErrNamespaceExists is returned when creating a namespace that already
+	// exists.
+	ErrNamespaceExists = errors.New("namespace already exists")
+
+	// ErrNamespaceNotFound is returned when using a namespace that has not
+	// been created.
+	ErrNamespaceNotFound = errors.New("namespace not found")
+
+	// ErrNamespaceBackup is returned when backing up a namespace, as only
+	// the whole store, with every namespace, can be backed up.
+	ErrNamespaceBackup = errors.New("a namespace can not be backed up on its own")
+)
+
+var namespaceName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ValidNamespace returns whether the name can be used for a namespace.
+func ValidNamespace(name string) bool {
+	return namespaceName.MatchString(name)
+}
+
+// namespace is an independent log with its own trees, stored in the same
+// store as the default one.
+type namespace struct {
+	store   *storage.NamespacedStore
+	balloon *balloon.Balloon
+}
+
+// mutations translates the mutations of the balloon of the namespace to
+// mutations of the underlying store.
+func (n *namespace) mutations(mutations []*storage.Mutation) []*storage.Mutation {
+	if n.store == nil {
+		return mutations
+	}
+	return n.store.Mutations(mutations)
+}
+
+// namespaceCheckpointPath returns the file where the hyper cache of a
+// namespace is saved, next to the one of the default namespace.
+func namespaceCheckpointPath(checkpointPath, name string) string {
+	if checkpointPath == "" || name == "" {
+		return checkpointPath
+	}
+	return checkpointPath + "." + name
+}
+
+// namespaceOptions returns the mode and the duplicate policy stored in the
+// registry of a namespace, which are the name of the policy optionally
+// followed by a comma and the name of the mode. Namespaces created before
+// they were stored add events and overwrite the duplicates.
+func namespaceOptions(value []byte) (balloon.Mode, balloon.DuplicatePolicy, error) {
+	parts := strings.SplitN(string(value), ",", 2)
+	var mode string
+	if len(parts) == 2 {
+		mode = parts[1]
+	}
+	return parseNamespaceOptions(mode, parts[0])
+}
+
+// parseNamespaceOptions returns the mode and the duplicate policy of the
+// given names, which add events and overwrite the duplicates if empty.
+func parseNamespaceOptions(mode, duplicates string) (balloon.Mode, balloon.DuplicatePolicy, error) {
+	m, p := balloon.EventMode, balloon.OverwriteDuplicates
+	var err error
+	if mode != "" {
+		if m, err = balloon.ParseMode(mode); err != nil {
+			return 0, 0, err
+		}
+	}
+	if duplicates != "" {
+		if p, err = balloon.ParseDuplicatePolicy(duplicates); err != nil {
+			return 0, 0, err
+		}
+	}
+	return m, p, nil
+}
+
+// namespaceRegistryValue reverses namespaceOptions.
+func namespaceRegistryValue(mode balloon.Mode, duplicates balloon.DuplicatePolicy) []byte {
+	return []byte(duplicates.String() + "," + mode.String())
+}
+
+// namespaceRegistry returns the registry values of the namespaces created
+// in the store, by name.
+func namespaceRegistry(store storage.Store) (map[string][]byte, error) {
+	registry := make(map[string][]byte)
+	reader := store.GetAll(storage.NamespacePrefix)
+	defer reader.Close()
+	for {
+		entries := make([]*storage.KVPair, 100)
+		n, err := reader.Read(entries)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		for _, entry := range entries[:n] {
+			registry[string(entry.Key)] = entry.Value
+		}
+	}
+	return registry, nil
+}
+
+// openNamespaces opens the balloons of every namespace created in the store.
+func openNamespaces(store storage.KeyPrefixStore, hasherF func() hashing.Hasher, checkpointPath string) (map[string]*namespace, error) {
+	registry, err := namespaceRegistry(store)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := make(map[string]*namespace, len(registry))
+	for name, value := range registry {
+		mode, duplicates, err := namespaceOptions(value)
+		if err != nil {
+			return nil, err
+		}
+		ns, err := openNamespace(store, hasherF, name, mode, duplicates, namespaceCheckpointPath(checkpointPath, name))
+		if err != nil {
+			return nil, err
+		}
+		namespaces[name] = ns
+	}
+	return namespaces, nil
+}
+
+func openNamespace(store storage.KeyPrefixStore, hasherF func() hashing.Hasher, name string, mode balloon.Mode, duplicates balloon.DuplicatePolicy, checkpointPath string) (*namespace, error) {
+	nsStore := storage.NewNamespacedStore(store, name)
+	var b *balloon.Balloon
+	var err error
+	if checkpointPath != "" {
+		b, err = balloon.NewBalloonFromCheckpoint(nsStore, hasherF, checkpointPath)
+	} else {
+		b, err = balloon.NewBalloon(nsStore, hasherF)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.SetMode(mode)
+	b.SetDuplicatePolicy(duplicates)
+	return &namespace{store: nsStore, balloon: b}, nil
+}
+
+// namespacedBalloon is the api of a namespace of a RaftBalloon. Additions
+// go through Raft consensus like the ones of the default namespace.
+type namespacedBalloon struct {
+	b         *RaftBalloon
+	namespace string
+}
+
+func (n *namespacedBalloon) Add(event []byte) (*balloon.Snapshot, error) {
+	return n.b.add(n.namespace, event)
+}
+
+func (n *namespacedBalloon) AddBulk(events [][]byte) ([]*balloon.Snapshot, error) {
+	return n.b.addBulk(n.namespace, events)
+}
+
+func (n *namespacedBalloon) AddKeyValue(key, value []byte) (*balloon.Snapshot, error) {
+	return n.b.addKeyValue(n.namespace, key, value)
+}
+
+func (n *namespacedBalloon) QueryDigestMembership(keyDigest hashing.Digest, version uint64) (*balloon.MembershipProof, error) {
+	return n.b.fsm.QueryDigestMembership(n.namespace, keyDigest, version)
+}
+
+func (n *namespacedBalloon) QueryMembership(event []byte, version uint64) (*balloon.MembershipProof, error) {
+	return n.b.fsm.QueryMembership(n.namespace, event, version)
+}
+
+func (n *namespacedBalloon) QueryBatchMembership(keyDigests []hashing.Digest, version uint64) (*balloon.BatchMembershipProof, error) {
+	return n.b.fsm.QueryBatchMembership(n.namespace, keyDigests, version)
+}
+
+func (n *namespacedBalloon) QueryConsistency(start, end uint64) (*balloon.IncrementalProof, error) {
+	return n.b.fsm.QueryConsistency(n.namespace, start, end)
+}
+
+func (n *namespacedBalloon) QueryRange(start, end, version uint64) (*balloon.RangeProof, error) {
+	return n.b.fsm.QueryRange(n.namespace, start, end, version)
+}
+
+func (n *namespacedBalloon) QueryVersionAt(timestamp int64) (uint64, int64, error) {
+	return n.b.fsm.QueryVersionAt(n.namespace, timestamp)
+}
+
+func (n *namespacedBalloon) QueryKeyValue(key []byte) (*balloon.KeyValueProof, error) {
+	return n.b.fsm.QueryKeyValue(n.namespace, key)
+}
+
+func (n *namespacedBalloon) QueryKeyHistory(key []byte, start, end uint64) (*balloon.KeyHistoryProof, error) {
+	return n.b.fsm.QueryKeyHistory(n.namespace, key, start, end)
+}
+
+func (n *namespacedBalloon) QueryCTTreeHead() (*balloon.TreeHead, error) {
+	return n.b.fsm.QueryCTTreeHead(n.namespace)
+}
+
+func (n *namespacedBalloon) QueryCTConsistency(first, second uint64) ([]hashing.Digest, error) {
+	return n.b.fsm.QueryCTConsistency(n.namespace, first, second)
+}
+
+func (n *namespacedBalloon) QueryCTMembership(leafHash hashing.Digest, size uint64) (uint64, []hashing.Digest, error) {
+	return n.b.fsm.QueryCTMembership(n.namespace, leafHash, size)
+}
+
+func (n *namespacedBalloon) QueryCTEntries(start, end uint64) ([][]byte, error) {
+	return n.b.fsm.QueryCTEntries(n.namespace, start, end)
+}
+
+func (n *namespacedBalloon) ReadVersion(consistency protocol.ReadConsistency) (uint64, error) {
+	return n.b.readVersion(n.namespace, consistency)
+}
+
+func (n *namespacedBalloon) HashAlgorithm() string {
+	return n.b.HashAlgorithm()
+}
+
+func (n *namespacedBalloon) Join(nodeID, addr string, metadata map[string]string) error {
+	return n.b.Join(nodeID, addr, metadata)
+}
+
+func (n *namespacedBalloon) IsLeader() bool {
+	return n.b.IsLeader()
+}
+
+func (n *namespacedBalloon) LeaderEndpoint() string {
+	return n.b.LeaderEndpoint()
+}
+
+func (n *namespacedBalloon) Members() ([]*Member, error) {
+	return n.b.Members()
+}
+
+func (n *namespacedBalloon) Remove(id string) error {
+	return n.b.Remove(id)
+}
+
+func (n *namespacedBalloon) Status() (*Status, error) {
+	return n.b.Status()
+}
+
+func (n *namespacedBalloon) TakeSnapshot() error {
+	return n.b.TakeSnapshot()
+}
+
+// Backup fails, as a backup holds the whole store and its header records
+// every namespace.
+func (n *namespacedBalloon) Backup(w io.Writer) (*BackupHeader, error) {
+	return nil, ErrNamespaceBackup
+}
+
+func (n *namespacedBalloon) Namespace(name string) (RaftBalloonApi, error) {
+	return n.b.Namespace(name)
+}
+
+func (n *namespacedBalloon) CreateNamespace(name string, mode balloon.Mode, duplicates balloon.DuplicatePolicy) error {
+	return n.b.CreateNamespace(name, mode, duplicates)
+}
+
+func (n *namespacedBalloon) SigningKeys() ([]*SigningKey, error) {
+	return n.b.fsm.SigningKeys(n.namespace)
+}
+
+func (n *namespacedBalloon) RotateSigningKey(algorithm string, publicKey []byte) error {
+	return n.b.RotateSigningKey(algorithm, publicKey)
+}
@@ -19,6 +19,7 @@ package raftwal
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"time"
@@ -37,6 +38,11 @@ const (
 	retainSnapshotCount = 2
 	leaderWaitDelay     = 100 * time.Millisecond
 	raftLogCacheSize    = 512
+
+	// DefaultHyperCheckpointInterval is how often the hyper cache is saved
+	// by default, so a restart only has to replay the versions added since
+	// then.
+	DefaultHyperCheckpointInterval = 5 * time.Minute
 )
 
 var (
@@ -47,16 +53,70 @@ var (
 	// ErrNotLeader is returned when a node attempts to execute a leader-only
 	// operation.
 	ErrNotLeader = errors.New("not leader")
+
+	// ErrEmptyBulk is returned when a bulk insertion has no events.
+	ErrEmptyBulk = errors.New("bulk without events")
 )
 
 // RaftBalloon is the interface Raft-backed balloons must implement.
 type RaftBalloonApi interface {
 	Add(event []byte) (*balloon.Snapshot, error)
+	AddBulk(events [][]byte) ([]*balloon.Snapshot, error)
+	AddKeyValue(key, value []byte) (*balloon.Snapshot, error)
 	QueryDigestMembership(keyDigest hashing.Digest, version uint64) (*balloon.MembershipProof, error)
 	QueryMembership(event []byte, version uint64) (*balloon.MembershipProof, error)
+	QueryBatchMembership(keyDigests []hashing.Digest, version uint64) (*balloon.BatchMembershipProof, error)
 	QueryConsistency(start, end uint64) (*balloon.IncrementalProof, error)
-	// Join joins the node, identified by nodeID and reachable at addr, to the cluster
-	Join(nodeID, addr string) error
+	QueryRange(start, end, version uint64) (*balloon.RangeProof, error)
+	QueryVersionAt(timestamp int64) (uint64, int64, error)
+	QueryKeyValue(key []byte) (*balloon.KeyValueProof, error)
+	QueryKeyHistory(key []byte, start, end uint64) (*balloon.KeyHistoryProof, error)
+	// QueryCTTreeHead returns the head of the Certificate Transparency tree
+	// of the last version
+	QueryCTTreeHead() (*balloon.TreeHead, error)
+	// QueryCTConsistency returns the proof of consistency between the
+	// Certificate Transparency trees of the given sizes
+	QueryCTConsistency(first, second uint64) ([]hashing.Digest, error)
+	// QueryCTMembership returns the index and the audit path of the leaf
+	// with the given hash in the Certificate Transparency tree of the size
+	QueryCTMembership(leafHash hashing.Digest, size uint64) (uint64, []hashing.Digest, error)
+	// QueryCTEntries returns the inputs of the leaves of the Certificate
+	// Transparency tree from the start to the end version
+	QueryCTEntries(start, end uint64) ([][]byte, error)
+	// ReadVersion makes sure the node can answer queries with the given
+	// consistency and returns the version the next addition will get
+	ReadVersion(consistency protocol.ReadConsistency) (uint64, error)
+	// HashAlgorithm returns the identifier of the hash algorithm used by the balloon
+	HashAlgorithm() string
+	// Join joins the node, identified by nodeID and reachable at addr, to the
+	// cluster, along with its metadata
+	Join(nodeID, addr string, metadata map[string]string) error
+	// IsLeader returns whether the node is the leader of the cluster
+	IsLeader() bool
+	// LeaderEndpoint returns the endpoint of the api of the leader, empty if
+	// it is not known
+	LeaderEndpoint() string
+	// Members returns the nodes of the cluster
+	Members() ([]*Member, error)
+	// Remove removes the node with the given ID from the cluster
+	Remove(id string) error
+	// Status returns the Raft state of the node
+	Status() (*Status, error)
+	// TakeSnapshot makes the node take a Raft snapshot right away
+	TakeSnapshot() error
+	// Backup writes a consistent backup of the store of the node to w
+	Backup(w io.Writer) (*BackupHeader, error)
+	// Namespace returns the api of the namespace with the given name, which
+	// must have been created before
+	Namespace(name string) (RaftBalloonApi, error)
+	// CreateNamespace creates a new empty namespace
+	CreateNamespace(name string, mode balloon.Mode, duplicates balloon.DuplicatePolicy) error
+	// SigningKeys returns the keys that signed the snapshots of the balloon
+	// with their ranges of versions
+	SigningKeys() ([]*SigningKey, error)
+	// RotateSigningKey makes the given public key sign the next version of
+	// every namespace
+	RotateSigningKey(algorithm string, publicKey []byte) error
 }
 
 // RaftBalloon is a replicated verifiable key-value store, where changes are made via Raft consensus.
@@ -65,6 +125,8 @@ type RaftBalloon struct {
 	addr string // Node addr
 	id   string // Node ID
 
+	checkpointInterval time.Duration // How often the hyper cache is saved
+
 	raft struct {
 		api          *raft.Raft             // The consensus mechanism
 		transport    *raft.NetworkTransport // Raft network transport
@@ -90,8 +152,13 @@ type RaftBalloon struct {
 
 }
 
-// New returns a new RaftBalloon.
-func NewRaftBalloon(path, addr, id string, store storage.ManagedStore, agentsQueue chan *protocol.Snapshot) (*RaftBalloon, error) {
+// New returns a new RaftBalloon. The mode and the duplicate policy are the
+// ones of the default namespace, and the hyper cache is saved every
+// checkpoint interval.
+func NewRaftBalloon(path, addr, id string, store storage.ManagedStore, hashAlgorithm string, mode balloon.Mode, duplicates balloon.DuplicatePolicy, checkpointInterval time.Duration, agentsQueue chan *protocol.Snapshot) (*RaftBalloon, error) {
+	if checkpointInterval <= 0 {
+		return nil, fmt.Errorf("invalid hyper checkpoint interval %v", checkpointInterval)
+	}
 
 	// Create the log store and stable store
 	badgerLogStore, err := raftbadger.New(raftbadger.Options{Path: path + "/logs", NoSync: true, ValueLogGC: true}) // raftbadger.NewBadgerStore(path + "/logs")
@@ -109,17 +176,18 @@ func NewRaftBalloon(path, addr, id string, store storage.ManagedStore, agentsQue
 	}
 
 	// Instantiate balloon FSM
-	fsm, err := NewBalloonFSM(store, hashing.NewSha256Hasher, agentsQueue)
+	fsm, err := NewBalloonFSMFromCheckpoint(store, hashAlgorithm, mode, duplicates, hyperCheckpointPath(path), agentsQueue)
 	if err != nil {
 		return nil, fmt.Errorf("new balloon fsm: %s", err)
 	}
 
 	rb := &RaftBalloon{
-		path: path,
-		addr: addr,
-		id:   id,
-		done: make(chan struct{}),
-		fsm:  fsm,
+		path:               path,
+		addr:               addr,
+		id:                 id,
+		checkpointInterval: checkpointInterval,
+		done:               make(chan struct{}),
+		fsm:                fsm,
 	}
 
 	rb.store.db = store
@@ -172,6 +240,9 @@ func (b *RaftBalloon) Open(bootstrap bool) error {
 		return fmt.Errorf("new raft: %s", err)
 	}
 
+	b.wg.Add(1)
+	go b.checkpointHyperCache()
+
 	if bootstrap {
 		log.Info("bootstrap needed")
 		b.raft.nodes = &raft.Configuration{
@@ -190,10 +261,39 @@ func (b *RaftBalloon) Open(bootstrap bool) error {
 	return nil
 }
 
-// Join joins a node, identified by id and located at addr, to this store.
+func hyperCheckpointPath(path string) string {
+	return path + "/hyper-cache.checkpoint"
+}
+
+// checkpointHyperCache saves the hyper cache periodically and once more
+// when the balloon is closed, before the database is.
+func (b *RaftBalloon) checkpointHyperCache() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.saveHyperCheckpoint()
+		case <-b.done:
+			b.saveHyperCheckpoint()
+			return
+		}
+	}
+}
+
+func (b *RaftBalloon) saveHyperCheckpoint() {
+	if err := b.fsm.Checkpoint(hyperCheckpointPath(b.path)); err != nil {
+		log.Infof("Unable to save hyper cache checkpoint: %v", err)
+	}
+}
+
+// Join joins a node, identified by id and located at addr, to this store,
+// and sets its metadata, if any.
 // The node must be ready to respond to Raft communications at that address.
 // This must be called from the Leader or it will fail.
-func (b *RaftBalloon) Join(nodeID, addr string) error {
+func (b *RaftBalloon) Join(nodeID, addr string, metadata map[string]string) error {
 
 	log.Infof("received join request for remote node %s at %s", nodeID, addr)
 
@@ -211,7 +311,7 @@ func (b *RaftBalloon) Join(nodeID, addr string) error {
 			// a join operation -- is needed.
 			if srv.Address == raft.ServerAddress(addr) && srv.ID == raft.ServerID(nodeID) {
 				log.Infof("node %s at %s already member of cluster, ignoring join request", nodeID, addr)
-				return nil
+				return b.setJoinMetadata(nodeID, metadata)
 			}
 
 			future := b.raft.api.RemoveServer(srv.ID, 0, 0)
@@ -230,7 +330,14 @@ func (b *RaftBalloon) Join(nodeID, addr string) error {
 	}
 
 	log.Infof("node %s at %s joined successfully", nodeID, addr)
-	return nil
+	return b.setJoinMetadata(nodeID, metadata)
+}
+
+func (b *RaftBalloon) setJoinMetadata(nodeID string, metadata map[string]string) error {
+	if len(metadata) == 0 {
+		return nil
+	}
+	return b.SetMetadata(nodeID, metadata)
 }
 
 // Close closes the RaftBalloon. If wait is true, waits for a graceful shutdown.
@@ -248,13 +355,8 @@ func (b *RaftBalloon) Close(wait bool) error {
 	close(b.done)
 	b.wg.Wait()
 
-	// close database
-	if err := b.store.db.Close(); err != nil {
-		return err
-	}
-	b.store.db = nil
-
-	// shutdown raft
+	// shutdown raft before the database, as the fsm may still be applying
+	// logs to it
 	if b.raft.api != nil {
 		f := b.raft.api.Shutdown()
 		if wait {
@@ -265,6 +367,12 @@ func (b *RaftBalloon) Close(wait bool) error {
 		b.raft.api = nil
 	}
 
+	// close database
+	if err := b.store.db.Close(); err != nil {
+		return err
+	}
+	b.store.db = nil
+
 	// close raft store
 	if err := b.store.badgerLog.Close(); err != nil {
 		return err
@@ -386,6 +494,9 @@ func (b *RaftBalloon) raftApply(t commands.CommandType, cmd interface{}) (interf
 	}
 	future := b.raft.api.Apply(buf, b.raft.applyTimeout)
 	if err := future.Error(); err != nil {
+		if err == raft.ErrNotLeader {
+			return nil, ErrNotLeader
+		}
 		return nil, err
 	}
 	return future.Response(), nil
@@ -397,22 +508,124 @@ func (b *RaftBalloon) raftApply(t commands.CommandType, cmd interface{}) (interf
 */
 
 func (b *RaftBalloon) Add(event []byte) (*balloon.Snapshot, error) {
-	cmd := &commands.AddEventCommand{Event: event}
+	return b.add("", event)
+}
+
+func (b *RaftBalloon) AddBulk(events [][]byte) ([]*balloon.Snapshot, error) {
+	return b.addBulk("", events)
+}
+
+func (b *RaftBalloon) AddKeyValue(key, value []byte) (*balloon.Snapshot, error) {
+	return b.addKeyValue("", key, value)
+}
+
+func (b *RaftBalloon) add(namespace string, event []byte) (*balloon.Snapshot, error) {
+	cmd := &commands.AddEventCommand{Event: event, Timestamp: time.Now().UnixNano(), Namespace: namespace}
 	resp, err := b.raftApply(commands.AddEventCommandType, cmd)
 	if err != nil {
 		return nil, err
 	}
-	return resp.(*fsmAddResponse).snapshot, nil
+	addResp := resp.(*fsmAddResponse)
+	return addResp.snapshot, addResp.error
+}
+
+func (b *RaftBalloon) addBulk(namespace string, events [][]byte) ([]*balloon.Snapshot, error) {
+	if len(events) == 0 {
+		return nil, ErrEmptyBulk
+	}
+	cmd := &commands.AddEventsCommand{Events: events, Timestamp: time.Now().UnixNano(), Namespace: namespace}
+	resp, err := b.raftApply(commands.AddEventsCommandType, cmd)
+	if err != nil {
+		return nil, err
+	}
+	bulkResp := resp.(*fsmAddBulkResponse)
+	return bulkResp.snapshots, bulkResp.error
+}
+
+func (b *RaftBalloon) addKeyValue(namespace string, key, value []byte) (*balloon.Snapshot, error) {
+	cmd := &commands.AddKeyValueCommand{Key: key, Value: value, Timestamp: time.Now().UnixNano(), Namespace: namespace}
+	resp, err := b.raftApply(commands.AddKeyValueCommandType, cmd)
+	if err != nil {
+		return nil, err
+	}
+	addResp := resp.(*fsmAddResponse)
+	return addResp.snapshot, addResp.error
 }
 
 func (b *RaftBalloon) QueryDigestMembership(keyDigest hashing.Digest, version uint64) (*balloon.MembershipProof, error) {
-	return b.fsm.QueryDigestMembership(keyDigest, version)
+	return b.fsm.QueryDigestMembership("", keyDigest, version)
+}
+
+func (b *RaftBalloon) QueryBatchMembership(keyDigests []hashing.Digest, version uint64) (*balloon.BatchMembershipProof, error) {
+	return b.fsm.QueryBatchMembership("", keyDigests, version)
+}
+
+func (b *RaftBalloon) QueryRange(start, end, version uint64) (*balloon.RangeProof, error) {
+	return b.fsm.QueryRange("", start, end, version)
+}
+
+func (b *RaftBalloon) QueryVersionAt(timestamp int64) (uint64, int64, error) {
+	return b.fsm.QueryVersionAt("", timestamp)
 }
 
 func (b *RaftBalloon) QueryMembership(event []byte, version uint64) (*balloon.MembershipProof, error) {
-	return b.fsm.QueryMembership(event, version)
+	return b.fsm.QueryMembership("", event, version)
 }
 
 func (b *RaftBalloon) QueryConsistency(start, end uint64) (*balloon.IncrementalProof, error) {
-	return b.fsm.QueryConsistency(start, end)
+	return b.fsm.QueryConsistency("", start, end)
+}
+
+func (b *RaftBalloon) QueryKeyValue(key []byte) (*balloon.KeyValueProof, error) {
+	return b.fsm.QueryKeyValue("", key)
+}
+
+func (b *RaftBalloon) QueryKeyHistory(key []byte, start, end uint64) (*balloon.KeyHistoryProof, error) {
+	return b.fsm.QueryKeyHistory("", key, start, end)
+}
+
+func (b *RaftBalloon) QueryCTTreeHead() (*balloon.TreeHead, error) {
+	return b.fsm.QueryCTTreeHead("")
+}
+
+func (b *RaftBalloon) QueryCTConsistency(first, second uint64) ([]hashing.Digest, error) {
+	return b.fsm.QueryCTConsistency("", first, second)
+}
+
+func (b *RaftBalloon) QueryCTMembership(leafHash hashing.Digest, size uint64) (uint64, []hashing.Digest, error) {
+	return b.fsm.QueryCTMembership("", leafHash, size)
+}
+
+func (b *RaftBalloon) QueryCTEntries(start, end uint64) ([][]byte, error) {
+	return b.fsm.QueryCTEntries("", start, end)
+}
+
+func (b *RaftBalloon) HashAlgorithm() string {
+	return b.fsm.HashAlgorithm()
+}
+
+// Namespace returns the api of the namespace with the given name, or the
+// balloon itself if the name is empty.
+func (b *RaftBalloon) Namespace(name string) (RaftBalloonApi, error) {
+	if name == "" {
+		return b, nil
+	}
+	if !b.fsm.HasNamespace(name) {
+		return nil, ErrNamespaceNotFound
+	}
+	return &namespacedBalloon{b: b, namespace: name}, nil
+}
+
+// CreateNamespace creates a new empty namespace through Raft consensus,
+// with the given mode and policy for the events added more than once.
+func (b *RaftBalloon) CreateNamespace(name string, mode balloon.Mode, duplicates balloon.DuplicatePolicy) error {
+	if !ValidNamespace(name) {
+		return ErrInvalidNamespace
+	}
+	cmd := &commands.CreateNamespaceCommand{Name: name, Duplicates: duplicates.String(), Mode: mode.String()}
+	resp, err := b.raftApply(commands.CreateNamespaceCommandType, cmd)
+	if err != nil {
+		return err
+	}
+	return resp.(*fsmGenericResponse).error
 }
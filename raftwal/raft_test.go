@@ -29,6 +29,8 @@ import (
 
 	"github.com/bbva/qed/protocol"
 
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/hashing"
 	"github.com/bbva/qed/log"
 	"github.com/bbva/qed/storage/badger"
 	utilrand "github.com/bbva/qed/testutils/rand"
@@ -56,7 +58,7 @@ func newNode(t *testing.T, id int) (*RaftBalloon, func()) {
 	raftPath := fmt.Sprintf("/var/tmp/raft-test/node%d/raft", id)
 	err = os.MkdirAll(raftPath, os.FileMode(0755))
 	require.NoError(t, err)
-	r, err := NewRaftBalloon(raftPath, raftAddr(id), fmt.Sprintf("%d", id), badger, make(chan *protocol.Snapshot, 25000))
+	r, err := NewRaftBalloon(raftPath, raftAddr(id), fmt.Sprintf("%d", id), badger, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, DefaultHyperCheckpointInterval, make(chan *protocol.Snapshot, 25000))
 	require.NoError(t, err)
 
 	return r, func() {
@@ -87,6 +89,40 @@ func Test_Raft_IsLeader(t *testing.T) {
 
 }
 
+func Test_Raft_ReadVersion(t *testing.T) {
+
+	log.SetLogger("Test_Raft_ReadVersion", log.SILENT)
+
+	r, clean := newNode(t, 1)
+	defer clean()
+
+	err := r.Open(true)
+	require.NoError(t, err)
+
+	defer func() {
+		err = r.Close(true)
+		require.NoError(t, err)
+	}()
+
+	_, err = r.WaitForLeader(10 * time.Second)
+	require.NoError(t, err)
+
+	_, err = r.Add([]byte("Test Event"))
+	require.NoError(t, err)
+
+	for _, consistency := range []protocol.ReadConsistency{protocol.StaleReads, protocol.LeaderReads, protocol.LinearizableReads} {
+		version, err := r.ReadVersion(consistency)
+		require.NoError(t, err, "the leader should answer %s reads", consistency)
+		require.Equal(t, uint64(1), version, "wrong version for %s reads", consistency)
+	}
+
+	_, err = r.ReadVersion("eventual")
+	require.Error(t, err, "an unknown read consistency should be rejected")
+
+	require.NoError(t, r.TakeSnapshot())
+
+}
+
 func Test_Raft_OpenStoreCloseSingleNode(t *testing.T) {
 
 	r, clean := newNode(t, 2)
@@ -133,7 +169,7 @@ func Test_Raft_MultiNodeJoin(t *testing.T) {
 	err = r1.Open(false)
 	require.NoError(t, err)
 
-	err = r0.Join("1", string(r1.raft.transport.LocalAddr()))
+	err = r0.Join("1", string(r1.raft.transport.LocalAddr()), nil)
 	require.NoError(t, err)
 
 }
@@ -163,7 +199,7 @@ func Test_Raft_MultiNodeJoinRemove(t *testing.T) {
 	err = r1.Open(false)
 	require.NoError(t, err)
 
-	err = r0.Join("6", string(r1.raft.transport.LocalAddr()))
+	err = r0.Join("6", string(r1.raft.transport.LocalAddr()), map[string]string{APIEndpointKey: "http://127.0.0.1:8806"})
 	require.NoError(t, err)
 
 	_, err = r0.WaitForLeader(10 * time.Second)
@@ -177,6 +213,29 @@ func Test_Raft_MultiNodeJoinRemove(t *testing.T) {
 
 	require.Equal(t, id, r0.ID(), "wrong leader ID returned")
 
+	require.Equal(t, "http://127.0.0.1:8806", r0.Metadata("6", APIEndpointKey), "wrong api endpoint of the joined node")
+
+	members, err := r0.Members()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(members), "wrong number of members")
+	for _, m := range members {
+		require.True(t, m.Voter, "every member should be a voter")
+		require.Equal(t, m.ID == r0.ID(), m.Leader, "wrong leader flag of member %s", m.ID)
+	}
+
+	status, err := r1.Status()
+	require.NoError(t, err)
+	require.Equal(t, "Follower", status.State)
+	require.Equal(t, r0.ID(), status.LeaderID)
+
+		// Only stale reads are answered by a follower.
+	_, err = r1.ReadVersion(protocol.StaleReads)
+	require.NoError(t, err)
+	_, err = r1.ReadVersion(protocol.LeaderReads)
+	require.Equal(t, ErrNotLeader, err)
+	_, err = r1.ReadVersion(protocol.LinearizableReads)
+	require.Equal(t, ErrNotLeader, err)
+
 	storeNodes := []string{r0.id, r1.id}
 	sort.StringSlice(storeNodes).Sort()
 
@@ -363,7 +422,7 @@ func newNodeBench(b *testing.B, id int) (*RaftBalloon, func()) {
 	raftPath := fmt.Sprintf("/var/tmp/raft-test/node%d/raft", id)
 	err = os.MkdirAll(raftPath, os.FileMode(0755))
 	require.NoError(b, err)
-	r, err := NewRaftBalloon(raftPath, raftAddr(id), fmt.Sprintf("%d", id), badger, make(chan *protocol.Snapshot, 100))
+	r, err := NewRaftBalloon(raftPath, raftAddr(id), fmt.Sprintf("%d", id), badger, hashing.SHA256, balloon.EventMode, balloon.OverwriteDuplicates, DefaultHyperCheckpointInterval, make(chan *protocol.Snapshot, 100))
 	require.NoError(b, err)
 
 	return r, func() {
@@ -22,6 +22,11 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"time"
+
+	"github.com/bbva/qed/balloon"
+	"github.com/bbva/qed/hashing"
+	"github.com/bbva/qed/raftwal"
 )
 
 type Config struct {
@@ -32,6 +37,15 @@ type Config struct {
 	// TLS server bind address/port.
 	HTTPAddr string
 
+	// Endpoint of the HTTP api as clients and the other nodes reach it
+	// (protocol://host:port). Followers send the writes to the one of the
+	// leader. If not set, it is built from HTTPAddr.
+	APIEndpoint string
+
+	// How followers answer the writes only the leader can apply: redirect
+	// or forward.
+	FollowerWrites string
+
 	// Raft communication bind address/port.
 	RaftAddr string
 
@@ -46,6 +60,10 @@ type Config struct {
 	// Path to storage directory.
 	DBPath string
 
+	// Storage engine the database is kept in: badger or bolt. Every node
+	// of a cluster must use the same one.
+	Storage string
+
 	// Path to Raft storage directory.
 	RaftPath string
 
@@ -55,9 +73,32 @@ type Config struct {
 	// List of nodes, through which a gossip cluster can be joined (protocol://host:port).
 	GossipJoinAddr []string
 
-	// Path to the private key file used to sign snapshots.
+	// Path to the private key file used to sign snapshots until the
+	// cluster agrees on a key.
 	PrivateKeyPath string
 
+	// Paths to the private key files of the other keys the node can sign
+	// with, such as the ones the cluster rotates to. A retired key must be
+	// kept to sign the snapshots of its versions again.
+	SigningKeyPaths []string
+
+	// Hash algorithm used by the trees. It is stored on first boot and
+	// cannot be changed afterwards.
+	HashAlgorithm string
+
+	// Mode of the default namespace, which either adds events or sets the
+	// values of keys. It is stored on first boot and cannot be changed
+	// afterwards.
+	Mode string
+
+	// Policy for the events added more than once to the default namespace.
+	// It is stored on first boot and cannot be changed afterwards.
+	DuplicatePolicy string
+
+	// How often the hyper cache is saved to disk, so a restart only has to
+	// replay the versions added since then.
+	HyperCheckpointInterval time.Duration
+
 	// Enables profiling endpoint.
 	EnableProfiling bool
 
@@ -82,20 +123,26 @@ func DefaultConfig() *Config {
 	homeDir := usr.HomeDir
 
 	return &Config{
-		NodeID:            hostname,
-		HTTPAddr:          "127.0.0.1:8080",
-		RaftAddr:          "127.0.0.1:9000",
-		MgmtAddr:          "127.0.0.1:8090",
-		RaftJoinAddr:      []string{},
-		GossipAddr:        "127.0.0.1:9100",
-		GossipJoinAddr:    []string{},
-		DBPath:            currentDir + "/data",
-		RaftPath:          currentDir + "/raft",
-		EnableProfiling:   false,
-		EnableTampering:   false,
-		EnableTLS:         true,
-		SSLCertificate:    fmt.Sprintf("%s/.ssh/server.crt", homeDir),
-		SSLCertificateKey: fmt.Sprintf("%s/.ssh/server.key", homeDir),
+		NodeID:                  hostname,
+		HTTPAddr:                "127.0.0.1:8080",
+		FollowerWrites:          "redirect",
+		RaftAddr:                "127.0.0.1:9000",
+		MgmtAddr:                "127.0.0.1:8090",
+		RaftJoinAddr:            []string{},
+		GossipAddr:              "127.0.0.1:9100",
+		GossipJoinAddr:          []string{},
+		DBPath:                  currentDir + "/data",
+		Storage:                 BadgerStorage,
+		RaftPath:                currentDir + "/raft",
+		HashAlgorithm:           hashing.SHA256,
+		Mode:                    balloon.EventMode.String(),
+		DuplicatePolicy:         balloon.OverwriteDuplicates.String(),
+		HyperCheckpointInterval: raftwal.DefaultHyperCheckpointInterval,
+		EnableProfiling:         false,
+		EnableTampering:         false,
+		EnableTLS:               true,
+		SSLCertificate:          fmt.Sprintf("%s/.ssh/server.crt", homeDir),
+		SSLCertificateKey:       fmt.Sprintf("%s/.ssh/server.key", homeDir),
 	}
 }
 
@@ -29,10 +29,12 @@ import (
 	"net/http"
 	_ "net/http/pprof" // this will enable the default profiling capabilities
 	"os"
+	"time"
 
 	"github.com/bbva/qed/api/apihttp"
 	"github.com/bbva/qed/api/mgmthttp"
 	"github.com/bbva/qed/api/tampering"
+	"github.com/bbva/qed/balloon"
 	"github.com/bbva/qed/gossip"
 	"github.com/bbva/qed/gossip/member"
 	"github.com/bbva/qed/gossip/sender"
@@ -41,7 +43,6 @@ import (
 	"github.com/bbva/qed/protocol"
 	"github.com/bbva/qed/raftwal"
 	"github.com/bbva/qed/sign"
-	"github.com/bbva/qed/storage/badger"
 	"github.com/bbva/qed/util"
 )
 
@@ -55,7 +56,7 @@ type Server struct {
 	raftBalloon     *raftwal.RaftBalloon
 	tamperingServer *http.Server
 	profilingServer *http.Server
-	signer          sign.Signer
+	keyring         *sign.Keyring
 	sender          *sender.Sender
 	agent           *gossip.Agent
 	agentsQueue     chan *protocol.Snapshot
@@ -84,17 +85,24 @@ func NewServer(conf *Config) (*Server, error) {
 		return nil, err
 	}
 
-	// Open badger store
-	store, err := badger.NewBadgerStoreOpts(&badger.Options{Path: conf.DBPath, ValueLogGC: true})
+	// Open the store of the storage engine
+	store, err := OpenStore(conf.Storage, conf.DBPath, false)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create signer
-	server.signer, err = sign.NewEd25519SignerFromFile(conf.PrivateKeyPath)
+	// Create keyring
+	signer, err := sign.NewSignerFromFile(conf.PrivateKeyPath)
 	if err != nil {
 		return nil, err
 	}
+	others := make([]sign.Signer, len(conf.SigningKeyPaths))
+	for i, path := range conf.SigningKeyPaths {
+		if others[i], err = sign.NewSignerFromFile(path); err != nil {
+			return nil, err
+		}
+	}
+	server.keyring = sign.NewKeyring(signer, others...)
 
 	// Create gossip agent
 	config := gossip.DefaultConfig()
@@ -112,17 +120,30 @@ func NewServer(conf *Config) (*Server, error) {
 	// TODO: add queue size to config
 	server.agentsQueue = make(chan *protocol.Snapshot, 10000)
 
-	// Create sender
-	server.sender = sender.NewSender(server.agent, sender.DefaultConfig(), server.signer)
-
 	// Create RaftBalloon
-	server.raftBalloon, err = raftwal.NewRaftBalloon(conf.RaftPath, conf.RaftAddr, conf.NodeID, store, server.agentsQueue)
+	mode, err := balloon.ParseMode(conf.Mode)
 	if err != nil {
 		return nil, err
 	}
+	duplicates, err := balloon.ParseDuplicatePolicy(conf.DuplicatePolicy)
+	if err != nil {
+		return nil, err
+	}
+	server.raftBalloon, err = raftwal.NewRaftBalloon(conf.RaftPath, conf.RaftAddr, conf.NodeID, store, conf.HashAlgorithm, mode, duplicates, conf.HyperCheckpointInterval, server.agentsQueue)
+	if err != nil {
+		return nil, err
+	}
+	snapshotSigner := raftwal.NewSnapshotSigner(server.raftBalloon, server.keyring)
+
+	// Create sender
+	server.sender = sender.NewSender(server.agent, sender.DefaultConfig(), snapshotSigner)
 
 	// Create http endpoints
-	httpMux := apihttp.NewApiHttp(server.raftBalloon)
+	followerWrites, err := apihttp.ParseFollowerWrites(conf.FollowerWrites)
+	if err != nil {
+		return nil, err
+	}
+	httpMux := apihttp.NewApiHttp(server.raftBalloon, snapshotSigner, followerWrites)
 	if conf.EnableTLS {
 		server.httpServer = newTLSServer(conf.HTTPAddr, httpMux)
 	} else {
@@ -130,11 +151,15 @@ func NewServer(conf *Config) (*Server, error) {
 	}
 
 	// Create management endpoints
-	mgmtMux := mgmthttp.NewMgmtHttp(server.raftBalloon)
+	mgmtMux := mgmthttp.NewMgmtHttp(server.raftBalloon, server.keyring)
 	server.mgmtServer = newHTTPServer(conf.MgmtAddr, mgmtMux)
 
 	if conf.EnableTampering {
-		tamperMux := tampering.NewTamperingApi(store, hashing.NewSha256Hasher())
+		hasherF, err := hashing.NewHasherF(conf.HashAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		tamperMux := tampering.NewTamperingApi(store, hasherF())
 		server.tamperingServer = newHTTPServer("localhost:8081", tamperMux)
 	}
 	if conf.EnableProfiling {
@@ -144,8 +169,34 @@ func NewServer(conf *Config) (*Server, error) {
 	return server, nil
 }
 
-func join(joinAddr, raftAddr, nodeID string) error {
-	b, err := json.Marshal(map[string]string{"addr": raftAddr, "id": nodeID})
+// apiEndpoint returns the endpoint of the HTTP api of the node, as the other
+// nodes and the clients reach it.
+func (s *Server) apiEndpoint() string {
+	if s.conf.APIEndpoint != "" {
+		return s.conf.APIEndpoint
+	}
+	if s.conf.EnableTLS {
+		return "https://" + s.conf.HTTPAddr
+	}
+	return "http://" + s.conf.HTTPAddr
+}
+
+// mgmtEndpoint returns the endpoint of the management HTTP api of the
+// node, as the other nodes reach it.
+func (s *Server) mgmtEndpoint() string {
+	return "http://" + s.conf.MgmtAddr
+}
+
+// metadata returns the metadata the node registers in the cluster.
+func (s *Server) metadata() map[string]string {
+	return map[string]string{
+		raftwal.APIEndpointKey:  s.apiEndpoint(),
+		raftwal.MgmtEndpointKey: s.mgmtEndpoint(),
+	}
+}
+
+func join(joinAddr, raftAddr, nodeID string, metadata map[string]string) error {
+	b, err := json.Marshal(map[string]interface{}{"addr": raftAddr, "id": nodeID, "metadata": metadata})
 	if err != nil {
 		return err
 	}
@@ -170,6 +221,10 @@ func (s *Server) Start() error {
 		return err
 	}
 
+	if s.bootstrap {
+		go s.initCluster()
+	}
+
 	if s.profilingServer != nil {
 		go func() {
 			log.Debugf("	* Starting profiling HTTP server in addr: localhost:6060")
@@ -221,7 +276,7 @@ func (s *Server) Start() error {
 	if !s.bootstrap {
 		for _, addr := range s.conf.RaftJoinAddr {
 			log.Debug("	* Joining existent cluster QED MGMT HTTP server in addr: ", s.conf.MgmtAddr)
-			if err := join(addr, s.conf.RaftAddr, s.conf.NodeID); err != nil {
+			if err := join(addr, s.conf.RaftAddr, s.conf.NodeID, s.metadata()); err != nil {
 				log.Fatalf("failed to join node at %s: %s", addr, err.Error())
 			}
 		}
@@ -239,6 +294,24 @@ func (s *Server) Start() error {
 	return nil
 }
 
+// initCluster registers the default key of the node as the one that signs
+// the snapshots from the first version, once the node is elected as the
+// leader of the new cluster, which has no effect if a key is already
+// registered. It also registers the endpoints of its apis, which the nodes
+// that join send along with their join request instead.
+func (s *Server) initCluster() {
+	if _, err := s.raftBalloon.WaitForLeader(10 * time.Second); err != nil {
+		log.Errorf("Can't initialize the cluster: %v", err)
+		return
+	}
+	if err := s.raftBalloon.InitSigningKey(s.keyring.Default().Algorithm(), s.keyring.Default().PublicKey()); err != nil {
+		log.Errorf("Can't register the signing key: %v", err)
+	}
+	if err := s.raftBalloon.SetMetadata(s.conf.NodeID, s.metadata()); err != nil {
+		log.Errorf("Can't register the endpoints of the node: %v", err)
+	}
+}
+
 // Stop will close all the channels from the mux servers.
 func (s *Server) Stop() error {
 	fmt.Printf("\nShutting down QED server %s", s.conf.NodeID)
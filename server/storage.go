@@ -0,0 +1,76 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/badger"
+	"github.com/bbva/qed/storage/bolt"
+)
+
+// Storage engines the database of a server can be kept in. Every node of a
+// cluster must use the same one, as their snapshots are backups of it.
+const (
+	BadgerStorage = badger.Engine
+	BoltStorage   = bolt.Engine
+)
+
+// boltFile is the file of the bolt database in the data directory.
+const boltFile = "qed.db"
+
+// Store is the database of a server, which the tampering api also deletes
+// keys from.
+type Store interface {
+	storage.ManagedStore
+	Delete(prefix byte, key []byte) error
+}
+
+// StorageEngines returns the names of the supported storage engines.
+func StorageEngines() []string {
+	return []string{BadgerStorage, BoltStorage}
+}
+
+// OpenStore opens the database kept by the given storage engine in the
+// data directory path. A read-only database is opened to inspect it
+// offline, so it is never written to nor created.
+func OpenStore(engine, path string, readOnly bool) (Store, error) {
+	switch engine {
+	case BadgerStorage:
+		store, err := badger.NewBadgerStoreOpts(&badger.Options{Path: path, ValueLogGC: !readOnly, ReadOnly: readOnly})
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case BoltStorage:
+		if !readOnly {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return nil, err
+			}
+		}
+		store, err := bolt.NewBoltStoreOpts(&bolt.Options{Path: filepath.Join(path, boltFile), ReadOnly: readOnly})
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown storage engine %q", engine)
+	}
+}
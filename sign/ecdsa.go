@@ -0,0 +1,78 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sign
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+)
+
+// ECDSAVerifier verifies ECDSA P-256 signatures of the SHA-256 digest of
+// the messages.
+type ECDSAVerifier struct {
+	publicKey *ecdsa.PublicKey
+	encoded   []byte
+}
+
+// NewECDSAVerifier returns a verifier of the given P-256 public key.
+func NewECDSAVerifier(publicKey *ecdsa.PublicKey) (*ECDSAVerifier, error) {
+	if publicKey.Curve != elliptic.P256() {
+		return nil, ErrInvalidPublicKey
+	}
+	encoded, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &ECDSAVerifier{publicKey, encoded}, nil
+}
+
+func (v *ECDSAVerifier) Verify(message, sig []byte) (bool, error) {
+	digest := sha256.Sum256(message)
+	return ecdsa.VerifyASN1(v.publicKey, digest[:], sig), nil
+}
+
+func (v *ECDSAVerifier) PublicKey() []byte {
+	return v.encoded
+}
+
+func (v *ECDSAVerifier) Algorithm() string {
+	return ECDSAP256SHA256
+}
+
+// ECDSASigner signs the SHA-256 digest of the messages with an ECDSA P-256
+// private key.
+type ECDSASigner struct {
+	*ECDSAVerifier
+	privateKey *ecdsa.PrivateKey
+}
+
+// NewECDSASigner returns a signer of the given P-256 private key.
+func NewECDSASigner(privateKey *ecdsa.PrivateKey) (Signer, error) {
+	verifier, err := NewECDSAVerifier(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &ECDSASigner{verifier, privateKey}, nil
+}
+
+func (s *ECDSASigner) Sign(message []byte) ([]byte, error) {
+	digest := sha256.Sum256(message)
+	return ecdsa.SignASN1(rand.Reader, s.privateKey, digest[:])
+}
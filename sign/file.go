@@ -0,0 +1,138 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	stded25519 "crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// NewSignerFromFile returns a signer of the private key stored in the
+// given file, either in the OpenSSH format or PEM encoded as PKCS#8, SEC 1
+// (EC PRIVATE KEY) or PKCS#1 (RSA PRIVATE KEY). The algorithm follows the
+// type of the key: ed25519 keys sign with Ed25519, P-256 keys with ECDSA
+// and RSA keys with RSA-PSS.
+func NewSignerFromFile(privateKeyPath string) (Signer, error) {
+
+	privateKeyBytes, err := ioutil.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, err := ssh.ParseRawPrivateKey(privateKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := pk.(type) {
+	case *ed25519.PrivateKey:
+		return newEd25519Signer(*key), nil
+	case stded25519.PrivateKey:
+		return newEd25519Signer(ed25519.PrivateKey(key)), nil
+	case *ecdsa.PrivateKey:
+		return NewECDSASigner(key)
+	case *rsa.PrivateKey:
+		return NewRSAPSSSigner(key)
+	default:
+		return nil, fmt.Errorf("%v: private key of type %T", ErrUnsupportedAlgorithm, pk)
+	}
+
+}
+
+// NewVerifier returns a verifier of the public key encoded as the given
+// algorithm expects. Ed25519 is assumed if the algorithm is empty, as it was
+// the only one before the algorithm was recorded.
+func NewVerifier(algorithm string, publicKey []byte) (Verifier, error) {
+	switch algorithm {
+	case Ed25519, "":
+		return NewEd25519Verifier(publicKey)
+	case ECDSAP256SHA256, RSAPSSSHA256:
+		pk, err := x509.ParsePKIXPublicKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		verifier, err := newVerifier(pk)
+		if err != nil {
+			return nil, err
+		}
+		if verifier.Algorithm() != algorithm {
+			return nil, ErrInvalidPublicKey
+		}
+		return verifier, nil
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// NewVerifierFromFile returns a verifier of the public key stored in the
+// given file, either PEM encoded as PKIX (PUBLIC KEY) or in the OpenSSH
+// format of the .pub files.
+func NewVerifierFromFile(publicKeyPath string) (Verifier, error) {
+
+	publicKeyBytes, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if block, _ := pem.Decode(publicKeyBytes); block != nil {
+		if block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("%v: unsupported PEM type %s", ErrInvalidPublicKey, block.Type)
+		}
+		pk, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newVerifier(pk)
+	}
+
+	pk, _, _, _, err := ssh.ParseAuthorizedKey(publicKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	cpk, ok := pk.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%v: unsupported key type %s", ErrInvalidPublicKey, pk.Type())
+	}
+	return newVerifier(cpk.CryptoPublicKey())
+
+}
+
+// newVerifier returns a verifier of the public key, with the algorithm
+// that follows its type.
+func newVerifier(pk crypto.PublicKey) (Verifier, error) {
+	switch key := pk.(type) {
+	case ed25519.PublicKey:
+		return NewEd25519Verifier(key)
+	case stded25519.PublicKey:
+		return NewEd25519Verifier(key)
+	case *ecdsa.PublicKey:
+		return NewECDSAVerifier(key)
+	case *rsa.PublicKey:
+		return NewRSAPSSVerifier(key)
+	default:
+		return nil, fmt.Errorf("%v: public key of type %T", ErrUnsupportedAlgorithm, pk)
+	}
+}
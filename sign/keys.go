@@ -0,0 +1,77 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sign
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// KeyID returns the identifier of a public key, which is the hex encoding
+// of the first 8 bytes of its SHA-256 digest.
+func KeyID(publicKey []byte) string {
+	digest := sha256.Sum256(publicKey)
+	return hex.EncodeToString(digest[:8])
+}
+
+// Keyring holds the signers a server can sign with, by key ID. The first
+// one is the default, which signs until the cluster agrees on a key.
+type Keyring struct {
+	signers   map[string]Signer
+	defaultID string
+}
+
+// NewKeyring returns a keyring with the given signers, the first of which
+// is the default one.
+func NewKeyring(signer Signer, others ...Signer) *Keyring {
+	k := &Keyring{
+		signers:   make(map[string]Signer, len(others)+1),
+		defaultID: KeyID(signer.PublicKey()),
+	}
+	for _, s := range append([]Signer{signer}, others...) {
+		k.signers[KeyID(s.PublicKey())] = s
+	}
+	return k
+}
+
+// Get returns the signer of the key with the given ID, if it is loaded.
+func (k *Keyring) Get(id string) (Signer, bool) {
+	s, ok := k.signers[id]
+	return s, ok
+}
+
+// Default returns the default signer of the keyring.
+func (k *Keyring) Default() Signer {
+	return k.signers[k.defaultID]
+}
+
+// KeySet holds the public keys that verify signatures, by key ID.
+type KeySet map[string]Verifier
+
+// NewKeySet returns a key set with the given public keys.
+func NewKeySet(verifiers ...Verifier) KeySet {
+	s := make(KeySet, len(verifiers))
+	for _, v := range verifiers {
+		s.Add(v)
+	}
+	return s
+}
+
+// Add adds a public key to the set.
+func (s KeySet) Add(v Verifier) {
+	s[KeyID(v.PublicKey())] = v
+}
@@ -0,0 +1,87 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package sign
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+)
+
+// minRSABits is the minimum size of the RSA keys.
+const minRSABits = 2048
+
+// pssOptions salt the signatures with as many bytes as the digest has,
+// which every RSA-PSS implementation is able to verify.
+var pssOptions = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+
+// RSAPSSVerifier verifies RSA-PSS signatures of the SHA-256 digest of the
+// messages.
+type RSAPSSVerifier struct {
+	publicKey *rsa.PublicKey
+	encoded   []byte
+}
+
+// NewRSAPSSVerifier returns a verifier of the given RSA public key, which
+// must have at least 2048 bits.
+func NewRSAPSSVerifier(publicKey *rsa.PublicKey) (*RSAPSSVerifier, error) {
+	if publicKey.N.BitLen() < minRSABits {
+		return nil, ErrInvalidPublicKey
+	}
+	encoded, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &RSAPSSVerifier{publicKey, encoded}, nil
+}
+
+func (v *RSAPSSVerifier) Verify(message, sig []byte) (bool, error) {
+	digest := sha256.Sum256(message)
+	return rsa.VerifyPSS(v.publicKey, crypto.SHA256, digest[:], sig, pssOptions) == nil, nil
+}
+
+func (v *RSAPSSVerifier) PublicKey() []byte {
+	return v.encoded
+}
+
+func (v *RSAPSSVerifier) Algorithm() string {
+	return RSAPSSSHA256
+}
+
+// RSAPSSSigner signs the SHA-256 digest of the messages with an RSA
+// private key and the PSS padding.
+type RSAPSSSigner struct {
+	*RSAPSSVerifier
+	privateKey *rsa.PrivateKey
+}
+
+// NewRSAPSSSigner returns a signer of the given RSA private key, which
+// must have at least 2048 bits.
+func NewRSAPSSSigner(privateKey *rsa.PrivateKey) (Signer, error) {
+	verifier, err := NewRSAPSSVerifier(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &RSAPSSSigner{verifier, privateKey}, nil
+}
+
+func (s *RSAPSSSigner) Sign(message []byte) ([]byte, error) {
+	digest := sha256.Sum256(message)
+	return rsa.SignPSS(rand.Reader, s.privateKey, crypto.SHA256, digest[:], pssOptions)
+}
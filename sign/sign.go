@@ -19,17 +19,44 @@ package sign
 import (
 	"crypto/rand"
 	"errors"
+	"fmt"
 	"io/ioutil"
 
 	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/ssh"
 )
 
+// The signature algorithms. Ed25519 public keys are their raw 32 bytes,
+// and the rest are encoded as DER PKIX public keys. ECDSA signatures are
+// ASN.1 DER encoded, and RSA-PSS ones salt as many bytes as the SHA-256
+// digest has.
+const (
+	Ed25519         = "ed25519"
+	ECDSAP256SHA256 = "ecdsa-p256-sha256"
+	RSAPSSSHA256    = "rsa-pss-sha256"
+)
+
 type Signer interface {
 	Sign(message []byte) ([]byte, error)
 	Verify(message, sig []byte) (bool, error)
+	PublicKey() []byte
+	Algorithm() string
+}
+
+// Verifier checks signatures with a public key only, so clients can verify
+// what servers sign without holding their private keys. Every Signer is
+// also a Verifier.
+type Verifier interface {
+	Verify(message, sig []byte) (bool, error)
+	PublicKey() []byte
+	Algorithm() string
 }
 
+var (
+	ErrInvalidPublicKey     = errors.New("invalid public key")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signature algorithm")
+)
+
 type Ed25519Signer struct {
 	privateKey ed25519.PrivateKey
 	publicKey  ed25519.PublicKey
@@ -78,6 +105,13 @@ func NewEd25519SignerFromFile(privateKeyPath string) (Signer, error) {
 
 }
 
+func newEd25519Signer(privateKey ed25519.PrivateKey) Signer {
+	return &Ed25519Signer{
+		privateKey,
+		privateKey.Public().(ed25519.PublicKey),
+	}
+}
+
 func (s *Ed25519Signer) Sign(message []byte) ([]byte, error) {
 	return ed25519.Sign(s.privateKey, message), nil
 }
@@ -85,3 +119,64 @@ func (s *Ed25519Signer) Sign(message []byte) ([]byte, error) {
 func (s *Ed25519Signer) Verify(message, sig []byte) (bool, error) {
 	return ed25519.Verify(s.publicKey, message, sig), nil
 }
+
+func (s *Ed25519Signer) PublicKey() []byte {
+	return s.publicKey
+}
+
+func (s *Ed25519Signer) Algorithm() string {
+	return Ed25519
+}
+
+type Ed25519Verifier struct {
+	publicKey ed25519.PublicKey
+}
+
+// NewEd25519Verifier returns a verifier of the raw 32 bytes ed25519 public
+// key.
+func NewEd25519Verifier(publicKey []byte) (Verifier, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, ErrInvalidPublicKey
+	}
+	return &Ed25519Verifier{ed25519.PublicKey(publicKey)}, nil
+}
+
+// NewEd25519VerifierFromFile returns a verifier of the public key stored
+// in the OpenSSH format, as in the .pub file that ssh-keygen writes next
+// to the private key of the server.
+func NewEd25519VerifierFromFile(publicKeyPath string) (Verifier, error) {
+
+	publicKeyBytes, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, _, _, _, err := ssh.ParseAuthorizedKey(publicKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	cpk, ok := pk.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%v: unsupported key type %s", ErrInvalidPublicKey, pk.Type())
+	}
+	publicKey, ok := cpk.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%v: unsupported key type %s", ErrInvalidPublicKey, pk.Type())
+	}
+
+	return &Ed25519Verifier{publicKey}, nil
+
+}
+
+func (v *Ed25519Verifier) Verify(message, sig []byte) (bool, error) {
+	return ed25519.Verify(v.publicKey, message, sig), nil
+}
+
+func (v *Ed25519Verifier) PublicKey() []byte {
+	return v.publicKey
+}
+
+func (v *Ed25519Verifier) Algorithm() string {
+	return Ed25519
+}
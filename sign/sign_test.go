@@ -17,10 +17,22 @@
 package sign
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	stded25519 "crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 
 	assert "github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
 )
 
 func testSign(t *testing.T, signer Signer) {
@@ -35,6 +47,32 @@ func testSign(t *testing.T, signer Signer) {
 }
 func TestEdSign(t *testing.T) { testSign(t, NewEd25519Signer()) }
 
+func TestECDSASign(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	signer, err := NewECDSASigner(key)
+	assert.NoError(t, err)
+	testSign(t, signer)
+
+	key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(t, err)
+	_, err = NewECDSASigner(key)
+	assert.Equal(t, ErrInvalidPublicKey, err, "Only P-256 keys must be accepted")
+}
+
+func TestRSAPSSSign(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	signer, err := NewRSAPSSSigner(key)
+	assert.NoError(t, err)
+	testSign(t, signer)
+
+	key, err = rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(t, err)
+	_, err = NewRSAPSSSigner(key)
+	assert.Equal(t, ErrInvalidPublicKey, err, "Keys shorter than 2048 bits must not be accepted")
+}
+
 func syncBenchmark(b *testing.B, signer Signer, iterations int) {
 
 	b.N = iterations
@@ -87,3 +125,124 @@ func BenchmarkEd(b *testing.B) {
 	}
 
 }
+
+func TestEd25519VerifierFromFile(t *testing.T) {
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	sshKey, err := ssh.NewPublicKey(publicKey)
+	assert.NoError(t, err)
+
+	f, err := ioutil.TempFile("", "qed-sign-test")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.Write(ssh.MarshalAuthorizedKey(sshKey))
+	assert.NoError(t, err)
+	f.Close()
+
+	verifier, err := NewEd25519VerifierFromFile(f.Name())
+	assert.NoError(t, err)
+
+	message := []byte("send reinforcements, we're going to advance")
+	result, _ := verifier.Verify(message, ed25519.Sign(privateKey, message))
+	assert.True(t, result, "Must be verified with the public key")
+
+	result, _ = verifier.Verify(message, ed25519.Sign(privateKey, []byte("send three and fourpence")))
+	assert.False(t, result, "Must not verify the signature of another message")
+
+	_, err = NewEd25519Verifier(publicKey[1:])
+	assert.Equal(t, ErrInvalidPublicKey, err)
+
+}
+
+func TestKeyring(t *testing.T) {
+
+	first, second := NewEd25519Signer(), NewEd25519Signer()
+	keyring := NewKeyring(first, second)
+
+	assert.Equal(t, first, keyring.Default(), "The first signer must be the default one")
+
+	signer, ok := keyring.Get(KeyID(second.PublicKey()))
+	assert.True(t, ok, "Every signer must be found by its key ID")
+	assert.Equal(t, second, signer)
+
+	_, ok = keyring.Get(KeyID(NewEd25519Signer().PublicKey()))
+	assert.False(t, ok, "Unknown keys must not be found")
+
+	keys := NewKeySet(first)
+	_, ok = keys[KeyID(first.PublicKey())]
+	assert.True(t, ok, "Public keys must be found by their key ID")
+	assert.Len(t, KeyID(first.PublicKey()), 16)
+
+}
+
+func writeTempFile(t *testing.T, data []byte) string {
+	f, err := ioutil.TempFile("", "qed-sign-test")
+	assert.NoError(t, err)
+	_, err = f.Write(data)
+	assert.NoError(t, err)
+	f.Close()
+	return f.Name()
+}
+
+func TestSignerFromFile(t *testing.T) {
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	_, edKey, err := stded25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	for algorithm, key := range map[string]crypto.Signer{
+		Ed25519:         edKey,
+		ECDSAP256SHA256: ecdsaKey,
+		RSAPSSSHA256:    rsaKey,
+	} {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		assert.NoError(t, err)
+		privatePath := writeTempFile(t, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		defer os.Remove(privatePath)
+
+		signer, err := NewSignerFromFile(privatePath)
+		assert.NoError(t, err, "The %s key must be loaded from PKCS#8", algorithm)
+		assert.Equal(t, algorithm, signer.Algorithm())
+		testSign(t, signer)
+
+		verifier, err := NewVerifier(algorithm, signer.PublicKey())
+		assert.NoError(t, err)
+		assert.Equal(t, KeyID(signer.PublicKey()), KeyID(verifier.PublicKey()))
+
+		der, err = x509.MarshalPKIXPublicKey(key.Public())
+		assert.NoError(t, err)
+		publicPath := writeTempFile(t, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+		defer os.Remove(publicPath)
+
+		verifier, err = NewVerifierFromFile(publicPath)
+		assert.NoError(t, err, "The %s key must be loaded from PKIX", algorithm)
+		assert.Equal(t, algorithm, verifier.Algorithm())
+		assert.Equal(t, signer.PublicKey(), verifier.PublicKey())
+
+		message := []byte("send reinforcements, we're going to advance")
+		sig, err := signer.Sign(message)
+		assert.NoError(t, err)
+		result, _ := verifier.Verify(message, sig)
+		assert.True(t, result, "Must be verified with the public key of the file")
+	}
+
+	der, err := x509.MarshalECPrivateKey(ecdsaKey)
+	assert.NoError(t, err)
+	path := writeTempFile(t, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	defer os.Remove(path)
+	signer, err := NewSignerFromFile(path)
+	assert.NoError(t, err, "SEC 1 keys must be loaded")
+	assert.Equal(t, ECDSAP256SHA256, signer.Algorithm())
+
+	ecdsaSigner, err := NewECDSASigner(ecdsaKey)
+	assert.NoError(t, err)
+	_, err = NewVerifier(RSAPSSSHA256, ecdsaSigner.PublicKey())
+	assert.Equal(t, ErrInvalidPublicKey, err, "A key must not be verified with another algorithm")
+	_, err = NewVerifier("dsa", ecdsaSigner.PublicKey())
+	assert.Equal(t, ErrUnsupportedAlgorithm, err)
+
+}
@@ -32,6 +32,9 @@ import (
 	"github.com/bbva/qed/storage"
 )
 
+// Engine is the name of the storage engine of the Badger stores.
+const Engine = "badger"
+
 type BadgerStore struct {
 	db                  *b.DB
 	vlogTicker          *time.Ticker // runs every 1m, check size of vlog and run GC conditionally.
@@ -67,6 +70,10 @@ type Options struct {
 	// GCThreshold sets threshold in bytes for the vlog size to be included in the
 	// garbage collection cycle. By default, 1GB.
 	GCThreshold int64
+
+	// ReadOnly opens the database without writing to it, so it can be
+	// inspected offline. It fails if the database was not closed cleanly.
+	ReadOnly bool
 }
 
 func NewBadgerStore(path string) (*BadgerStore, error) {
@@ -85,6 +92,7 @@ func NewBadgerStoreOpts(opts *Options) (*BadgerStore, error) {
 	bOpts.Dir = opts.Path
 	bOpts.ValueDir = opts.Path
 	bOpts.SyncWrites = false
+	bOpts.ReadOnly = opts.ReadOnly
 
 	db, err := b.Open(bOpts)
 	if err != nil {
@@ -219,22 +227,64 @@ func (s BadgerStore) GetLast(prefix byte) (*storage.KVPair, error) {
 	}
 }
 
+func (s BadgerStore) GetLastByKeyPrefix(prefix byte, keyPrefix []byte) (*storage.KVPair, error) {
+	result := new(storage.KVPair)
+	start := append([]byte{prefix}, keyPrefix...)
+	err := s.db.View(func(txn *b.Txn) error {
+		var err error
+		opts := b.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Reverse = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		// a reversed iterator seeks for the greatest key lower or equal
+		// than the given one, so we seek for the first key after the
+		// prefix and skip it if it exists
+		end := storage.PrefixEnd(start)
+		if end == nil {
+			it.Rewind()
+		} else {
+			it.Seek(end)
+			if it.Valid() && bytes.Equal(it.Item().Key(), end) {
+				it.Next()
+			}
+		}
+		if it.ValidForPrefix(start) {
+			item := it.Item()
+			key := item.KeyCopy(nil)
+			result.Key = key[len(start):]
+			result.Value, err = item.ValueCopy(nil)
+		} else {
+			err = b.ErrKeyNotFound
+		}
+		return err
+	})
+	switch err {
+	case nil:
+		return result, nil
+	case b.ErrKeyNotFound:
+		return nil, storage.ErrKeyNotFound
+	default:
+		return nil, err
+	}
+}
+
 type BadgerKVPairReader struct {
-	prefix byte
+	prefix []byte
 	txn    *b.Txn
 	it     *b.Iterator
 }
 
-func NewBadgerKVPairReader(prefix byte, txn *b.Txn) *BadgerKVPairReader {
+func NewBadgerKVPairReader(prefix []byte, txn *b.Txn) *BadgerKVPairReader {
 	opts := b.DefaultIteratorOptions
 	opts.PrefetchSize = 10
 	it := txn.NewIterator(opts)
-	it.Seek([]byte{prefix})
+	it.Seek(prefix)
 	return &BadgerKVPairReader{prefix, txn, it}
 }
 
 func (r *BadgerKVPairReader) Read(buffer []*storage.KVPair) (n int, err error) {
-	for n = 0; r.it.ValidForPrefix([]byte{r.prefix}) && n < len(buffer); r.it.Next() {
+	for n = 0; r.it.ValidForPrefix(r.prefix) && n < len(buffer); r.it.Next() {
 		item := r.it.Item()
 		var key, value []byte
 		key = item.KeyCopy(key)
@@ -242,7 +292,7 @@ func (r *BadgerKVPairReader) Read(buffer []*storage.KVPair) (n int, err error) {
 		if err != nil {
 			break
 		}
-		buffer[n] = &storage.KVPair{key[1:], value}
+		buffer[n] = &storage.KVPair{key[len(r.prefix):], value}
 		n++
 	}
 
@@ -256,7 +306,15 @@ func (r *BadgerKVPairReader) Close() {
 }
 
 func (s BadgerStore) GetAll(prefix byte) storage.KVPairReader {
-	return NewBadgerKVPairReader(prefix, s.db.NewTransaction(false))
+	return NewBadgerKVPairReader([]byte{prefix}, s.db.NewTransaction(false))
+}
+
+func (s BadgerStore) GetAllByKeyPrefix(prefix byte, keyPrefix []byte) storage.KVPairReader {
+	return NewBadgerKVPairReader(append([]byte{prefix}, keyPrefix...), s.db.NewTransaction(false))
+}
+
+func (s BadgerStore) Engine() string {
+	return Engine
 }
 
 func (s BadgerStore) Close() error {
@@ -341,9 +399,11 @@ func (s *BadgerStore) GetLastVersion() (uint64, error) {
 		opts.Reverse = true
 		it := txn.NewIterator(opts)
 		defer it.Close()
-		// we are using a reversed iterator so we need to seek for
-		// the last possible key
-		it.Rewind()
+		// the fsm state is written along with every mutation, so the
+		// last key under its prefix always holds the last version.
+		// We are using a reversed iterator so we need to seek for the
+		// last possible key of that prefix
+		it.Seek([]byte{storage.FSMStatePrefix, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
 		if it.Valid() {
 			item := it.Item()
 			version = item.Version()
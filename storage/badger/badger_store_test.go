@@ -257,6 +257,67 @@ func TestBackupLoad(t *testing.T) {
 	require.Equal(t, reversion, version, "Error in restored version")
 }
 
+func TestGetLastVersion(t *testing.T) {
+	store, closeF := openBadgerStore(t)
+	defer closeF()
+
+	// keys stored after the fsm state prefix must not hide its version
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		{storage.KeyValuePrefix, []byte{0xff}, []byte{0x0}},
+	}))
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		{storage.FSMStatePrefix, []byte{0xab}, []byte{0x0}},
+	}))
+	last, err := store.GetLastVersion()
+	require.NoError(t, err)
+
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		{storage.IndexPrefix, []byte{0x1}, []byte{0x1}},
+		{storage.FSMStatePrefix, []byte{0xab}, []byte{0x1}},
+	}))
+	version, err := store.GetLastVersion()
+	require.NoError(t, err)
+	require.True(t, version > last, "The version should increase with every mutation of the fsm state")
+}
+
+func TestGetByKeyPrefix(t *testing.T) {
+	store, closeF := openBadgerStore(t)
+	defer closeF()
+
+	// keys of other key prefixes surround the ones being read, including
+	// the first key after them
+	prefix := storage.NamespaceDataPrefix
+	keyPrefix := []byte{0x01, 0xff}
+	store.Mutate([]*storage.Mutation{
+		{prefix, []byte{0x01, 0xfe, 0xff}, nil},
+		{prefix, []byte{0x02}, nil},
+		{prefix + 1, []byte{0x01, 0xff, 0x00}, nil},
+	})
+	for i := byte(0); i < 10; i++ {
+		store.Mutate([]*storage.Mutation{
+			{prefix, append(keyPrefix, i), []byte{i}},
+		})
+	}
+
+	reader := store.GetAllByKeyPrefix(prefix, keyPrefix)
+	entries := make([]*storage.KVPair, 20)
+	n, err := reader.Read(entries)
+	reader.Close()
+	require.NoError(t, err)
+	require.Equal(t, 10, n, "Only the keys with the key prefix should be read")
+	for i := 0; i < n; i++ {
+		require.Equalf(t, []byte{byte(i)}, entries[i].Key, "The key prefix should be removed from the key")
+	}
+
+	kv, err := store.GetLastByKeyPrefix(prefix, keyPrefix)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x09}, kv.Key, "The key should match the last inserted element")
+	require.Equal(t, []byte{0x09}, kv.Value, "The value should match the last inserted element")
+
+	_, err = store.GetLastByKeyPrefix(prefix, []byte{0x03})
+	require.Equal(t, storage.ErrKeyNotFound, err)
+}
+
 func BenchmarkMutate(b *testing.B) {
 	store, closeF := openBadgerStore(b)
 	defer closeF()
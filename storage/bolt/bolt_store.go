@@ -0,0 +1,360 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package bolt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	b "github.com/coreos/bbolt"
+
+	"github.com/bbva/qed/storage"
+)
+
+// Engine is the name of the storage engine of the bolt stores.
+const Engine = "bolt"
+
+const (
+	// openTimeout is how long to wait for the lock of a database that is
+	// already open, by a running server for example.
+	openTimeout = time.Second
+
+	// loadBatchSize is the number of entries written by every transaction
+	// of a load.
+	loadBatchSize = 1000
+)
+
+var ErrInvalidEntry = errors.New("invalid entry in bolt backup")
+
+// BoltStore keeps every prefix in its own bucket of a single-file bolt
+// database.
+type BoltStore struct {
+	db *b.DB
+}
+
+// Options contains all the configuration used to open the bolt db
+type Options struct {
+	// Path is the file of the bolt db to use.
+	Path string
+
+	// NoSync causes the database to skip fsync calls after each
+	// commit. This is unsafe, so it should be used with caution.
+	NoSync bool
+
+	// ReadOnly opens the database without writing to it, so it can be
+	// inspected offline.
+	ReadOnly bool
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	return NewBoltStoreOpts(&Options{Path: path})
+}
+
+func NewBoltStoreOpts(opts *Options) (*BoltStore, error) {
+	db, err := b.Open(opts.Path, 0600, &b.Options{Timeout: openTimeout, ReadOnly: opts.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	db.NoSync = opts.NoSync
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Mutate(mutations []*storage.Mutation) error {
+	return s.db.Update(func(tx *b.Tx) error {
+		return mutate(tx, mutations)
+	})
+}
+
+func mutate(tx *b.Tx, mutations []*storage.Mutation) error {
+	for _, m := range mutations {
+		bucket, err := tx.CreateBucketIfNotExists([]byte{m.Prefix})
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(m.Key, m.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BoltStore) GetRange(prefix byte, start, end []byte) (storage.KVRange, error) {
+	result := make(storage.KVRange, 0)
+	err := s.db.View(func(tx *b.Tx) error {
+		bucket := tx.Bucket([]byte{prefix})
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.Seek(start); k != nil && bytes.Compare(k, end) <= 0; k, v = c.Next() {
+			result = append(result, storage.KVPair{Key: copyBytes(k), Value: copyBytes(v)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *BoltStore) Get(prefix byte, key []byte) (*storage.KVPair, error) {
+	result := new(storage.KVPair)
+	result.Key = key
+	err := s.db.View(func(tx *b.Tx) error {
+		bucket := tx.Bucket([]byte{prefix})
+		if bucket == nil {
+			return storage.ErrKeyNotFound
+		}
+		// keys stored with an empty value are told apart from the
+		// missing ones by the cursor
+		k, v := bucket.Cursor().Seek(key)
+		if k == nil || !bytes.Equal(k, key) {
+			return storage.ErrKeyNotFound
+		}
+		result.Value = copyBytes(v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *BoltStore) GetLast(prefix byte) (*storage.KVPair, error) {
+	return s.GetLastByKeyPrefix(prefix, nil)
+}
+
+func (s *BoltStore) GetLastByKeyPrefix(prefix byte, keyPrefix []byte) (*storage.KVPair, error) {
+	result := new(storage.KVPair)
+	err := s.db.View(func(tx *b.Tx) error {
+		bucket := tx.Bucket([]byte{prefix})
+		if bucket == nil {
+			return storage.ErrKeyNotFound
+		}
+		// the last key of the key prefix is the one before the first
+		// key after it
+		c := bucket.Cursor()
+		var k, v []byte
+		if end := storage.PrefixEnd(keyPrefix); end == nil {
+			k, v = c.Last()
+		} else if k, _ = c.Seek(end); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		if k == nil || !bytes.HasPrefix(k, keyPrefix) {
+			return storage.ErrKeyNotFound
+		}
+		result.Key = copyBytes(k[len(keyPrefix):])
+		result.Value = copyBytes(v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// BoltKVPairReader reads the pairs of a prefix in batches. Every read runs
+// in its own transaction and starts from the first key not read yet, so
+// the reader does not block the writes while it is open, but a read sees
+// the pairs committed before it.
+type BoltKVPairReader struct {
+	db        *b.DB
+	prefix    []byte
+	keyPrefix []byte
+	next      []byte
+	done      bool
+}
+
+func NewBoltKVPairReader(db *b.DB, prefix byte, keyPrefix []byte) *BoltKVPairReader {
+	return &BoltKVPairReader{
+		db:        db,
+		prefix:    []byte{prefix},
+		keyPrefix: keyPrefix,
+		next:      keyPrefix,
+	}
+}
+
+func (r *BoltKVPairReader) Read(buffer []*storage.KVPair) (n int, err error) {
+	if r.done {
+		return 0, nil
+	}
+	err = r.db.View(func(tx *b.Tx) error {
+		bucket := tx.Bucket(r.prefix)
+		if bucket == nil {
+			r.done = true
+			return nil
+		}
+		c := bucket.Cursor()
+		k, v := c.Seek(r.next)
+		for ; k != nil && bytes.HasPrefix(k, r.keyPrefix) && n < len(buffer); k, v = c.Next() {
+			buffer[n] = &storage.KVPair{Key: copyBytes(k[len(r.keyPrefix):]), Value: copyBytes(v)}
+			n++
+		}
+		if k != nil && bytes.HasPrefix(k, r.keyPrefix) {
+			r.next = copyBytes(k)
+		} else {
+			r.done = true
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (r *BoltKVPairReader) Close() {
+	r.done = true
+}
+
+func (s *BoltStore) GetAll(prefix byte) storage.KVPairReader {
+	return NewBoltKVPairReader(s.db, prefix, nil)
+}
+
+func (s *BoltStore) GetAllByKeyPrefix(prefix byte, keyPrefix []byte) storage.KVPairReader {
+	return NewBoltKVPairReader(s.db, prefix, keyPrefix)
+}
+
+func (s *BoltStore) Engine() string {
+	return Engine
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) Delete(prefix byte, key []byte) error {
+	return s.db.Update(func(tx *b.Tx) error {
+		bucket := tx.Bucket([]byte{prefix})
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete(key)
+	})
+}
+
+// Backup writes every entry of the database into the given writer, as
+// its prefix followed by the length-prefixed key and value. Bolt keeps a
+// single version of every key, so the backup holds the last committed
+// transaction, which can be newer than until but never older.
+func (s *BoltStore) Backup(w io.Writer, until uint64) error {
+	return s.db.View(func(tx *b.Tx) error {
+		return tx.ForEach(func(name []byte, bucket *b.Bucket) error {
+			return bucket.ForEach(func(k, v []byte) error {
+				return writeTo(w, name[0], k, v)
+			})
+		})
+	})
+}
+
+func writeTo(w io.Writer, prefix byte, key, value []byte) error {
+	buf := make([]byte, 1+2*binary.MaxVarintLen64, 1+2*binary.MaxVarintLen64+len(key)+len(value))
+	buf[0] = prefix
+	n := 1 + binary.PutUvarint(buf[1:], uint64(len(key)))
+	n += binary.PutUvarint(buf[n:], uint64(len(value)))
+	buf = append(buf[:n], key...)
+	buf = append(buf, value...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// Load writes the entries of a backup into the database, in batches of
+// transactions.
+func (s *BoltStore) Load(r io.Reader) error {
+	br := bufio.NewReader(r)
+	mutations := make([]*storage.Mutation, 0, loadBatchSize)
+	for {
+		m, err := readFrom(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		mutations = append(mutations, m)
+		if len(mutations) == loadBatchSize {
+			if err := s.Mutate(mutations); err != nil {
+				return err
+			}
+			mutations = mutations[:0]
+		}
+	}
+	if len(mutations) == 0 {
+		return nil
+	}
+	return s.Mutate(mutations)
+}
+
+func readFrom(r *bufio.Reader) (*storage.Mutation, error) {
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	keyLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	valueLen, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if keyLen == 0 || keyLen > b.MaxKeySize || valueLen > b.MaxValueSize {
+		return nil, ErrInvalidEntry
+	}
+	key := make([]byte, keyLen)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	value := make([]byte, valueLen)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return storage.NewMutation(prefix, key, value), nil
+}
+
+// unexpectedEOF reports the end of the backup in the middle of an entry.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// GetLastVersion returns the id of the last committed transaction, which
+// increases with every mutation.
+func (s *BoltStore) GetLastVersion() (uint64, error) {
+	var version uint64
+	err := s.db.View(func(tx *b.Tx) error {
+		version = uint64(tx.ID())
+		return nil
+	})
+	return version, err
+}
+
+// copyBytes copies a slice returned by bolt, which is only valid while its
+// transaction is open.
+func copyBytes(s []byte) []byte {
+	if s == nil {
+		return nil
+	}
+	c := make([]byte, len(s))
+	copy(c, s)
+	return c
+}
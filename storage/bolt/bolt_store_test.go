@@ -0,0 +1,443 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package bolt
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/testutils/rand"
+	"github.com/bbva/qed/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutate(t *testing.T) {
+	store, closeF := openBoltStore(t)
+	defer closeF()
+	prefix := byte(0x0)
+
+	tests := []struct {
+		testname      string
+		key, value    []byte
+		expectedError error
+	}{
+		{"Mutate Key=Value", []byte("Key"), []byte("Value"), nil},
+		{"Mutate empty value", []byte("Empty"), nil, nil},
+	}
+
+	for _, test := range tests {
+		err := store.Mutate([]*storage.Mutation{
+			{Prefix: prefix, Key: test.key, Value: test.value},
+		})
+		require.Equalf(t, test.expectedError, err, "Error mutating in test: %s", test.testname)
+		_, err = store.Get(prefix, test.key)
+		require.Equalf(t, test.expectedError, err, "Error getting key in test: %s", test.testname)
+	}
+}
+
+func TestGetExistentKey(t *testing.T) {
+
+	store, closeF := openBoltStore(t)
+	defer closeF()
+
+	testCases := []struct {
+		prefix        byte
+		key, value    []byte
+		expectedError error
+	}{
+		{byte(0x0), []byte("Key1"), []byte("Value1"), nil},
+		{byte(0x0), []byte("Key2"), []byte("Value2"), nil},
+		{byte(0x1), []byte("Key3"), []byte("Value3"), nil},
+		{byte(0x1), []byte("Key4"), []byte("Value4"), storage.ErrKeyNotFound},
+		{byte(0x2), []byte("Key5"), []byte("Value5"), storage.ErrKeyNotFound},
+	}
+
+	for _, test := range testCases {
+		if test.expectedError == nil {
+			err := store.Mutate([]*storage.Mutation{
+				{Prefix: test.prefix, Key: test.key, Value: test.value},
+			})
+			require.NoError(t, err)
+		}
+
+		stored, err := store.Get(test.prefix, test.key)
+		if test.expectedError == nil {
+			require.NoError(t, err)
+			require.Equalf(t, stored.Key, test.key, "The stored key does not match the original: expected %d, actual %d", test.key, stored.Key)
+			require.Equalf(t, stored.Value, test.value, "The stored value does not match the original: expected %d, actual %d", test.value, stored.Value)
+		} else {
+			require.Equal(t, test.expectedError, err)
+		}
+	}
+
+}
+
+func TestGetRange(t *testing.T) {
+	store, closeF := openBoltStore(t)
+	defer closeF()
+
+	var testCases = []struct {
+		size       int
+		start, end byte
+	}{
+		{40, 10, 50},
+		{0, 1, 9},
+		{11, 1, 20},
+		{10, 40, 60},
+		{0, 60, 100},
+		{0, 20, 10},
+	}
+
+	prefix := byte(0x0)
+	for i := 10; i < 50; i++ {
+		store.Mutate([]*storage.Mutation{
+			{Prefix: prefix, Key: []byte{byte(i)}, Value: []byte("Value")},
+		})
+	}
+
+	for _, test := range testCases {
+		slice, err := store.GetRange(prefix, []byte{test.start}, []byte{test.end})
+		require.NoError(t, err)
+		require.Equalf(t, len(slice), test.size, "Slice length invalid: expected %d, actual %d", test.size, len(slice))
+	}
+
+	slice, err := store.GetRange(prefix+1, []byte{10}, []byte{50})
+	require.NoError(t, err)
+	require.Empty(t, slice, "A prefix without keys should return an empty range")
+}
+
+func TestDelete(t *testing.T) {
+	store, closeF := openBoltStore(t)
+	defer closeF()
+
+	prefix := byte(0x0)
+	key, value := []byte("Key"), []byte("Value")
+
+	err := store.Mutate([]*storage.Mutation{
+		{Prefix: prefix, Key: key, Value: value},
+	})
+	require.NoError(t, err)
+
+	_, err = store.Get(prefix, key)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Delete(prefix, key))
+	_, err = store.Get(prefix, key)
+	require.Equal(t, storage.ErrKeyNotFound, err, "The deleted key should not be found")
+
+	require.NoError(t, store.Delete(prefix+1, key), "Deleting from a prefix without keys should not fail")
+}
+
+func TestGetAll(t *testing.T) {
+
+	prefix := storage.HyperCachePrefix
+	numElems := uint16(1000)
+	testCases := []struct {
+		batchSize    int
+		numBatches   int
+		lastBatchLen int
+	}{
+		{10, 100, 10},
+		{20, 50, 20},
+		{17, 59, 14},
+	}
+
+	store, closeF := openBoltStore(t)
+	defer closeF()
+
+	// insert
+	for i := uint16(0); i < numElems; i++ {
+		key := util.Uint16AsBytes(i)
+		store.Mutate([]*storage.Mutation{
+			{Prefix: prefix, Key: key, Value: key},
+		})
+	}
+
+	for i, c := range testCases {
+		reader := store.GetAll(storage.HyperCachePrefix)
+		numBatches := 0
+		var lastBatchLen int
+		for {
+			entries := make([]*storage.KVPair, c.batchSize)
+			n, _ := reader.Read(entries)
+			if n == 0 {
+				break
+			}
+			numBatches++
+			lastBatchLen = n
+		}
+		reader.Close()
+		assert.Equalf(t, c.numBatches, numBatches, "The number of batches should match for test case %d", i)
+		assert.Equal(t, c.lastBatchLen, lastBatchLen, "The size of the last batch len should match for test case %d", i)
+	}
+
+}
+
+func TestGetAllWhileMutating(t *testing.T) {
+	store, closeF := openBoltStore(t)
+	defer closeF()
+
+	prefix := storage.HyperCachePrefix
+	for i := uint16(0); i < 100; i++ {
+		key := util.Uint16AsBytes(i)
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			{Prefix: prefix, Key: key, Value: key},
+		}))
+	}
+
+	// the reader must not block the writes between its reads, even the
+	// ones that grow the database
+	reader := store.GetAll(prefix)
+	defer reader.Close()
+	entries := make([]*storage.KVPair, 10)
+	read := 0
+	for i := uint16(100); ; i++ {
+		n, err := reader.Read(entries)
+		require.NoError(t, err)
+		if n == 0 {
+			break
+		}
+		for j := 0; j < n; j++ {
+			require.Equal(t, util.Uint16AsBytes(uint16(read+j)), entries[j].Key, "The keys should be read in order")
+		}
+		read += n
+		require.NoError(t, store.Mutate([]*storage.Mutation{
+			{Prefix: prefix, Key: util.Uint16AsBytes(i), Value: rand.Bytes(4096)},
+		}))
+	}
+	require.True(t, read > 100, "The keys committed after a read should be read by the next ones")
+}
+
+func TestGetLast(t *testing.T) {
+	store, closeF := openBoltStore(t)
+	defer closeF()
+
+	// insert
+	numElems := uint64(20)
+	prefixes := [][]byte{{storage.IndexPrefix}, {storage.HistoryCachePrefix}, {storage.HyperCachePrefix}}
+	for _, prefix := range prefixes {
+		for i := uint64(0); i < numElems; i++ {
+			key := util.Uint64AsBytes(i)
+			store.Mutate([]*storage.Mutation{
+				{Prefix: prefix[0], Key: key, Value: key},
+			})
+		}
+	}
+
+	// get last element for history prefix
+	kv, err := store.GetLast(storage.HistoryCachePrefix)
+	require.NoError(t, err)
+	require.Equalf(t, util.Uint64AsBytes(numElems-1), kv.Key, "The key should match the last inserted element")
+	require.Equalf(t, util.Uint64AsBytes(numElems-1), kv.Value, "The value should match the last inserted element")
+
+	_, err = store.GetLast(storage.KeyValuePrefix)
+	require.Equal(t, storage.ErrKeyNotFound, err)
+}
+
+func TestBackupLoad(t *testing.T) {
+	store, closeF := openBoltStore(t)
+	defer closeF()
+
+	// insert
+	numElems := uint64(2500)
+	prefixes := [][]byte{{storage.IndexPrefix}, {storage.HistoryCachePrefix}, {storage.HyperCachePrefix}}
+	for _, prefix := range prefixes {
+		mutations := make([]*storage.Mutation, 0, numElems)
+		for i := uint64(0); i < numElems; i++ {
+			key := util.Uint64AsBytes(i)
+			mutations = append(mutations, storage.NewMutation(prefix[0], key, key))
+		}
+		require.NoError(t, store.Mutate(mutations))
+	}
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		{Prefix: storage.FSMStatePrefix, Key: []byte{0xab}, Value: nil},
+	}))
+
+	version, err := store.GetLastVersion()
+	require.NoError(t, err)
+
+	var backup bytes.Buffer
+	require.NoError(t, store.Backup(&backup, version))
+
+	restore, recloseF := openBoltStore(t)
+	defer recloseF()
+	require.NoError(t, restore.Load(bytes.NewReader(backup.Bytes())))
+
+	for _, prefix := range prefixes {
+		kvs, err := restore.GetRange(prefix[0], util.Uint64AsBytes(0), util.Uint64AsBytes(numElems))
+		require.NoError(t, err)
+		require.Equalf(t, int(numElems), len(kvs), "Every key of prefix %d should be restored", prefix[0])
+	}
+	_, err = restore.Get(storage.FSMStatePrefix, []byte{0xab})
+	require.NoError(t, err, "The keys with an empty value should be restored")
+
+	truncated := bytes.NewReader(backup.Bytes()[:backup.Len()-1])
+	require.Equal(t, io.ErrUnexpectedEOF, restore.Load(truncated), "A truncated backup should not be loaded")
+}
+
+func TestGetLastVersion(t *testing.T) {
+	store, closeF := openBoltStore(t)
+	defer closeF()
+
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		{Prefix: storage.FSMStatePrefix, Key: []byte{0xab}, Value: []byte{0x0}},
+	}))
+	last, err := store.GetLastVersion()
+	require.NoError(t, err)
+
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		{Prefix: storage.IndexPrefix, Key: []byte{0x1}, Value: []byte{0x1}},
+		{Prefix: storage.FSMStatePrefix, Key: []byte{0xab}, Value: []byte{0x1}},
+	}))
+	version, err := store.GetLastVersion()
+	require.NoError(t, err)
+	require.True(t, version > last, "The version should increase with every mutation of the fsm state")
+}
+
+func TestGetByKeyPrefix(t *testing.T) {
+	store, closeF := openBoltStore(t)
+	defer closeF()
+
+	// keys of other key prefixes surround the ones being read, including
+	// the first key after them
+	prefix := storage.NamespaceDataPrefix
+	keyPrefix := []byte{0x01, 0xff}
+	store.Mutate([]*storage.Mutation{
+		{Prefix: prefix, Key: []byte{0x01, 0xfe, 0xff}, Value: nil},
+		{Prefix: prefix, Key: []byte{0x02}, Value: nil},
+		{Prefix: prefix + 1, Key: []byte{0x01, 0xff, 0x00}, Value: nil},
+	})
+	for i := byte(0); i < 10; i++ {
+		store.Mutate([]*storage.Mutation{
+			{Prefix: prefix, Key: append(keyPrefix, i), Value: []byte{i}},
+		})
+	}
+
+	reader := store.GetAllByKeyPrefix(prefix, keyPrefix)
+	entries := make([]*storage.KVPair, 20)
+	n, err := reader.Read(entries)
+	reader.Close()
+	require.NoError(t, err)
+	require.Equal(t, 10, n, "Only the keys with the key prefix should be read")
+	for i := 0; i < n; i++ {
+		require.Equalf(t, []byte{byte(i)}, entries[i].Key, "The key prefix should be removed from the key")
+	}
+
+	kv, err := store.GetLastByKeyPrefix(prefix, keyPrefix)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x09}, kv.Key, "The key should match the last inserted element")
+	require.Equal(t, []byte{0x09}, kv.Value, "The value should match the last inserted element")
+
+	_, err = store.GetLastByKeyPrefix(prefix, []byte{0x03})
+	require.Equal(t, storage.ErrKeyNotFound, err)
+}
+
+func TestReopen(t *testing.T) {
+	path := mustTempDir()
+	defer os.RemoveAll(path)
+	file := filepath.Join(path, "bolt_store_test.db")
+
+	store, err := NewBoltStore(file)
+	require.NoError(t, err)
+	require.NoError(t, store.Mutate([]*storage.Mutation{
+		{Prefix: storage.IndexPrefix, Key: []byte("Key"), Value: []byte("Value")},
+	}))
+
+	_, err = NewBoltStoreOpts(&Options{Path: file, ReadOnly: true})
+	require.Error(t, err, "A database open by another store should not be opened")
+	require.NoError(t, store.Close())
+
+	store, err = NewBoltStoreOpts(&Options{Path: file, ReadOnly: true})
+	require.NoError(t, err)
+	defer store.Close()
+	kv, err := store.Get(storage.IndexPrefix, []byte("Key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("Value"), kv.Value, "The value should be kept once the database is closed")
+}
+
+func BenchmarkMutate(b *testing.B) {
+	store, closeF := openBoltStore(b)
+	defer closeF()
+	prefix := byte(0x0)
+	b.N = 10000
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.Mutate([]*storage.Mutation{
+			{Prefix: prefix, Key: rand.Bytes(128), Value: []byte("Value")},
+		})
+	}
+
+}
+
+func BenchmarkGet(b *testing.B) {
+	store, closeF := openBoltStore(b)
+	defer closeF()
+	prefix := byte(0x0)
+	N := 10000
+	b.N = N
+	var key []byte
+
+	// populate storage
+	for i := 0; i < N; i++ {
+		if i == 10 {
+			key = rand.Bytes(128)
+			store.Mutate([]*storage.Mutation{
+				{Prefix: prefix, Key: key, Value: []byte("Value")},
+			})
+		} else {
+			store.Mutate([]*storage.Mutation{
+				{Prefix: prefix, Key: rand.Bytes(128), Value: []byte("Value")},
+			})
+		}
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		store.Get(prefix, key)
+	}
+
+}
+
+func openBoltStore(t require.TestingT) (*BoltStore, func()) {
+	path := mustTempDir()
+
+	store, err := NewBoltStoreOpts(&Options{Path: filepath.Join(path, "bolt_store_test.db"), NoSync: true})
+	if err != nil {
+		t.Errorf("Error opening bolt store: %v", err)
+		t.FailNow()
+	}
+	return store, func() {
+		store.Close()
+		defer os.RemoveAll(path)
+	}
+}
+
+func mustTempDir() string {
+	var err error
+	path, err := ioutil.TempDir("", "bolt-test-")
+	if err != nil {
+		panic("failed to create temp dir")
+	}
+	return path
+}
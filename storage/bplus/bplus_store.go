@@ -47,6 +47,11 @@ func (s *BPlusTreeStore) Mutate(mutations []*storage.Mutation) error {
 	return nil
 }
 
+func (s *BPlusTreeStore) Delete(prefix byte, key []byte) error {
+	s.db.Delete(KVItem{append([]byte{prefix}, key...), nil})
+	return nil
+}
+
 func (s BPlusTreeStore) GetRange(prefix byte, start, end []byte) (storage.KVRange, error) {
 	result := make(storage.KVRange, 0)
 	startKey := append([]byte{prefix}, start...)
@@ -77,10 +82,13 @@ func (s BPlusTreeStore) Get(prefix byte, key []byte) (*storage.KVPair, error) {
 
 func (s BPlusTreeStore) GetLast(prefix byte) (*storage.KVPair, error) {
 	result := new(storage.KVPair)
-	s.db.DescendGreaterThan(KVItem{[]byte{prefix}, nil}, func(i btree.Item) bool {
+	// every key with the prefix is lower than the next prefix alone
+	s.db.DescendLessOrEqual(KVItem{[]byte{prefix + 1}, nil}, func(i btree.Item) bool {
 		item := i.(KVItem)
-		result.Key = item.Key[1:]
-		result.Value = item.Value
+		if item.Key[0] == prefix {
+			result.Key = item.Key[1:]
+			result.Value = item.Value
+		}
 		return false
 	})
 	if result.Key == nil {
@@ -89,21 +97,51 @@ func (s BPlusTreeStore) GetLast(prefix byte) (*storage.KVPair, error) {
 	return result, nil
 }
 
+func (s BPlusTreeStore) GetLastByKeyPrefix(prefix byte, keyPrefix []byte) (*storage.KVPair, error) {
+	result := new(storage.KVPair)
+	start := append([]byte{prefix}, keyPrefix...)
+	end := storage.PrefixEnd(start)
+	last := func(i btree.Item) bool {
+		item := i.(KVItem)
+		if bytes.Equal(item.Key, end) {
+			return true
+		}
+		if bytes.HasPrefix(item.Key, start) {
+			result.Key = item.Key[len(start):]
+			result.Value = item.Value
+		}
+		return false
+	}
+	if end == nil {
+		s.db.Descend(last)
+	} else {
+		s.db.DescendLessOrEqual(KVItem{end, nil}, last)
+	}
+	if result.Key == nil {
+		return nil, storage.ErrKeyNotFound
+	}
+	return result, nil
+}
+
 func (s BPlusTreeStore) GetAll(prefix byte) storage.KVPairReader {
-	return NewBPlusKVPairReader(prefix, s.db)
+	return NewBPlusKVPairReader([]byte{prefix}, s.db)
+}
+
+func (s BPlusTreeStore) GetAllByKeyPrefix(prefix byte, keyPrefix []byte) storage.KVPairReader {
+	return NewBPlusKVPairReader(append([]byte{prefix}, keyPrefix...), s.db)
 }
 
 type BPlusKVPairReader struct {
-	prefix  byte
+	prefix  []byte
 	db      *btree.BTree
 	lastKey []byte
 }
 
-func NewBPlusKVPairReader(prefix byte, db *btree.BTree) *BPlusKVPairReader {
+func NewBPlusKVPairReader(prefix []byte, db *btree.BTree) *BPlusKVPairReader {
 	return &BPlusKVPairReader{
 		prefix:  prefix,
 		db:      db,
-		lastKey: []byte{prefix},
+		lastKey: prefix,
 	}
 }
 
@@ -114,8 +152,11 @@ func (r *BPlusKVPairReader) Read(buffer []*storage.KVPair) (n int, err error) {
 			return false
 		}
 		key := i.(KVItem).Key
+		if !bytes.HasPrefix(key, r.prefix) {
+			return false
+		}
 		if bytes.Compare(key, r.lastKey) != 0 {
-			buffer[n] = &storage.KVPair{key[1:], i.(KVItem).Value}
+			buffer[n] = &storage.KVPair{key[len(r.prefix):], i.(KVItem).Value}
 			n++
 		}
 		r.lastKey = key
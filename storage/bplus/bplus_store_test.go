@@ -132,11 +132,12 @@ func TestGetAll(t *testing.T) {
 	store, closeF := openBPlusTreeStore()
 	defer closeF()
 
-	// insert
+	// insert, with elements under the next prefix that must not be read
 	for i := uint16(0); i < numElems; i++ {
 		key := util.Uint16AsBytes(i)
 		store.Mutate([]*storage.Mutation{
 			{prefix, key, key},
+			{prefix + 1, key, key},
 		})
 	}
 
@@ -176,6 +177,11 @@ func TestGetLast(t *testing.T) {
 		}
 	}
 
+	// and a greater element for a later prefix
+	store.Mutate([]*storage.Mutation{
+		{storage.TimestampPrefix, util.Uint64AsBytes(numElems), nil},
+	})
+
 	// get last element for history prefix
 	kv, err := store.GetLast(storage.HistoryCachePrefix)
 	require.NoError(t, err)
@@ -183,6 +189,44 @@ func TestGetLast(t *testing.T) {
 	require.Equalf(t, util.Uint64AsBytes(numElems-1), kv.Value, "The value should match the last inserted element")
 }
 
+func TestGetByKeyPrefix(t *testing.T) {
+	store, closeF := openBPlusTreeStore()
+	defer closeF()
+
+	// keys of other key prefixes surround the ones being read, including
+	// the first key after them
+	prefix := storage.NamespaceDataPrefix
+	keyPrefix := []byte{0x01, 0xff}
+	store.Mutate([]*storage.Mutation{
+		{prefix, []byte{0x01, 0xfe, 0xff}, nil},
+		{prefix, []byte{0x02}, nil},
+		{prefix + 1, []byte{0x01, 0xff, 0x00}, nil},
+	})
+	for i := byte(0); i < 10; i++ {
+		store.Mutate([]*storage.Mutation{
+			{prefix, append(keyPrefix, i), []byte{i}},
+		})
+	}
+
+	reader := store.GetAllByKeyPrefix(prefix, keyPrefix)
+	entries := make([]*storage.KVPair, 20)
+	n, err := reader.Read(entries)
+	reader.Close()
+	require.NoError(t, err)
+	require.Equal(t, 10, n, "Only the keys with the key prefix should be read")
+	for i := 0; i < n; i++ {
+		require.Equalf(t, []byte{byte(i)}, entries[i].Key, "The key prefix should be removed from the key")
+	}
+
+	kv, err := store.GetLastByKeyPrefix(prefix, keyPrefix)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x09}, kv.Key, "The key should match the last inserted element")
+	require.Equal(t, []byte{0x09}, kv.Value, "The value should match the last inserted element")
+
+	_, err = store.GetLastByKeyPrefix(prefix, []byte{0x03})
+	require.Equal(t, storage.ErrKeyNotFound, err)
+}
+
 func BenchmarkMutate(b *testing.B) {
 	store, closeF := openBPlusTreeStore()
 	defer closeF()
@@ -0,0 +1,105 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package storage
+
+// NamespacedStore keeps the data of a namespace inside another store. Every
+// prefix of the namespace is mapped to a range of keys under the namespace
+// data prefix, so the trees of several namespaces can share the same
+// underlying store without colliding.
+type NamespacedStore struct {
+	store  KeyPrefixStore
+	header []byte
+}
+
+// NewNamespacedStore returns a view of the store restricted to the given
+// namespace. Names must not be longer than 255 bytes.
+func NewNamespacedStore(store KeyPrefixStore, namespace string) *NamespacedStore {
+	header := make([]byte, 0, len(namespace)+1)
+	header = append(header, byte(len(namespace)))
+	header = append(header, namespace...)
+	return &NamespacedStore{store: store, header: header}
+}
+
+// keyPrefix returns the key prefix under which the given prefix of the
+// namespace is stored.
+func (s NamespacedStore) keyPrefix(prefix byte) []byte {
+	keyPrefix := make([]byte, 0, len(s.header)+1)
+	keyPrefix = append(keyPrefix, s.header...)
+	return append(keyPrefix, prefix)
+}
+
+func (s NamespacedStore) key(prefix byte, key []byte) []byte {
+	return append(s.keyPrefix(prefix), key...)
+}
+
+// Mutations translates the mutations of the namespace to mutations of the
+// underlying store, so they can be applied along with others.
+func (s NamespacedStore) Mutations(mutations []*Mutation) []*Mutation {
+	translated := make([]*Mutation, len(mutations))
+	for i, m := range mutations {
+		translated[i] = NewMutation(NamespaceDataPrefix, s.key(m.Prefix, m.Key), m.Value)
+	}
+	return translated
+}
+
+func (s NamespacedStore) Mutate(mutations []*Mutation) error {
+	return s.store.Mutate(s.Mutations(mutations))
+}
+
+func (s NamespacedStore) GetRange(prefix byte, start, end []byte) (KVRange, error) {
+	result, err := s.store.GetRange(NamespaceDataPrefix, s.key(prefix, start), s.key(prefix, end))
+	if err != nil {
+		return nil, err
+	}
+	offset := len(s.header) + 1
+	for i := range result {
+		result[i].Key = result[i].Key[offset:]
+	}
+	return result, nil
+}
+
+func (s NamespacedStore) Get(prefix byte, key []byte) (*KVPair, error) {
+	pair, err := s.store.Get(NamespaceDataPrefix, s.key(prefix, key))
+	if err != nil {
+		return nil, err
+	}
+	return &KVPair{Key: key, Value: pair.Value}, nil
+}
+
+func (s NamespacedStore) GetAll(prefix byte) KVPairReader {
+	return s.store.GetAllByKeyPrefix(NamespaceDataPrefix, s.keyPrefix(prefix))
+}
+
+func (s NamespacedStore) GetLast(prefix byte) (*KVPair, error) {
+	return s.store.GetLastByKeyPrefix(NamespaceDataPrefix, s.keyPrefix(prefix))
+}
+
+// Delete removes a key of the namespace, if the underlying store can
+// delete keys.
+func (s NamespacedStore) Delete(prefix byte, key []byte) error {
+	store, ok := s.store.(DeletableStore)
+	if !ok {
+		return ErrDeleteNotSupported
+	}
+	return store.Delete(NamespaceDataPrefix, s.key(prefix, key))
+}
+
+// Close does nothing, as the underlying store is shared with other
+// namespaces and must be closed by its owner.
+func (s NamespacedStore) Close() error {
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+   Copyright 2018 Banco Bilbao Vizcaya Argentaria, S.A.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/bbva/qed/storage"
+	"github.com/bbva/qed/storage/bplus"
+	"github.com/bbva/qed/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespacedStore(t *testing.T) {
+	store := bplus.NewBPlusTreeStore()
+	defer store.Close()
+
+	// the same keys are stored in the default keyspace and in two
+	// namespaces whose names share a prefix
+	ns := storage.NewNamespacedStore(store, "ns")
+	other := storage.NewNamespacedStore(store, "ns2")
+	for i := uint64(0); i < 10; i++ {
+		key := util.Uint64AsBytes(i)
+		m := []*storage.Mutation{storage.NewMutation(storage.HistoryCachePrefix, key, []byte("ns"))}
+		require.NoError(t, ns.Mutate(m))
+		m[0].Value = []byte("other")
+		require.NoError(t, other.Mutate(m))
+		m[0].Value = []byte("default")
+		require.NoError(t, store.Mutate(m))
+	}
+	require.NoError(t, ns.Mutate([]*storage.Mutation{
+		storage.NewMutation(storage.HistoryCachePrefix+1, util.Uint64AsBytes(20), nil),
+	}))
+
+	kv, err := ns.Get(storage.HistoryCachePrefix, util.Uint64AsBytes(3))
+	require.NoError(t, err)
+	require.Equal(t, util.Uint64AsBytes(3), kv.Key)
+	require.Equal(t, []byte("ns"), kv.Value, "The value should be the one of the namespace")
+
+	_, err = ns.Get(storage.HistoryCachePrefix, util.Uint64AsBytes(10))
+	require.Equal(t, storage.ErrKeyNotFound, err)
+
+	kvs, err := ns.GetRange(storage.HistoryCachePrefix, util.Uint64AsBytes(2), util.Uint64AsBytes(4))
+	require.NoError(t, err)
+	require.Len(t, kvs, 3)
+	for i, kv := range kvs {
+		require.Equal(t, util.Uint64AsBytes(uint64(i+2)), kv.Key)
+		require.Equal(t, []byte("ns"), kv.Value)
+	}
+
+	last, err := ns.GetLast(storage.HistoryCachePrefix)
+	require.NoError(t, err)
+	require.Equal(t, util.Uint64AsBytes(9), last.Key, "The last key should not come from another prefix")
+
+	reader := ns.GetAll(storage.HistoryCachePrefix)
+	entries := make([]*storage.KVPair, 20)
+	n, _ := reader.Read(entries)
+	reader.Close()
+	require.Equal(t, 10, n, "Only the keys of the namespace prefix should be read")
+	for i := 0; i < n; i++ {
+		require.Equal(t, util.Uint64AsBytes(uint64(i)), entries[i].Key)
+		require.Equal(t, []byte("ns"), entries[i].Value)
+	}
+}
@@ -24,14 +24,25 @@ import (
 )
 
 const (
-	IndexPrefix        = byte(0x0)
-	HyperCachePrefix   = byte(0x1)
-	HistoryCachePrefix = byte(0x2)
-	FSMStatePrefix     = byte(0x3)
+	IndexPrefix         = byte(0x0)
+	HyperCachePrefix    = byte(0x1)
+	HistoryCachePrefix  = byte(0x2)
+	FSMStatePrefix      = byte(0x3)
+	KeyValuePrefix      = byte(0x4)
+	HistoryLeafPrefix   = byte(0x5)
+	TimestampPrefix     = byte(0x6)
+	HyperLeafPrefix     = byte(0x7)
+	NamespacePrefix     = byte(0x8)
+	NamespaceDataPrefix = byte(0x9)
+	HyperDigestPrefix   = byte(0xa)
+	CTNodePrefix        = byte(0xb)
+	CTLeafPrefix        = byte(0xc)
+	CTIndexPrefix       = byte(0xd)
 )
 
 var (
-	ErrKeyNotFound = errors.New("key not found")
+	ErrKeyNotFound        = errors.New("key not found")
+	ErrDeleteNotSupported = errors.New("the store cannot delete keys")
 )
 
 type Store interface {
@@ -47,13 +58,55 @@ type DeletableStore interface {
 	Store
 	Delete(prefix byte, key []byte) error
 }
-type ManagedStore interface {
+
+// KeyPrefixStore is a store that can also iterate over the keys of a
+// prefix that start with a given sequence of bytes.
+type KeyPrefixStore interface {
 	Store
+	// GetAllByKeyPrefix returns the pairs of the prefix whose keys start with
+	// keyPrefix. Their keys are returned without it.
+	GetAllByKeyPrefix(prefix byte, keyPrefix []byte) KVPairReader
+	// GetLastByKeyPrefix returns the last pair of the prefix whose key starts
+	// with keyPrefix. Its key is returned without it.
+	GetLastByKeyPrefix(prefix byte, keyPrefix []byte) (*KVPair, error)
+}
+
+type ManagedStore interface {
+	KeyPrefixStore
 	Backup(w io.Writer, until uint64) error
 	Load(r io.Reader) error
 	GetLastVersion() (uint64, error)
 }
 
+// EngineStore is a store that tells the storage engine it keeps its data
+// in. Its backups can only be loaded by stores of the same engine.
+type EngineStore interface {
+	Engine() string
+}
+
+// EngineOf returns the storage engine of a store, or an empty string if it
+// does not tell it.
+func EngineOf(s Store) string {
+	if e, ok := s.(EngineStore); ok {
+		return e.Engine()
+	}
+	return ""
+}
+
+// PrefixEnd returns the first key greater than every key that starts with
+// the given prefix, or nil if there is none.
+func PrefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
 type Mutation struct {
 	Prefix     byte
 	Key, Value []byte
@@ -82,6 +135,8 @@ func NewKVRange() KVRange {
 	return make(KVRange, 0)
 }
 
+// InsertSorted inserts a pair keeping the range sorted by key. If the key
+// already exists, its value is replaced.
 func (r KVRange) InsertSorted(p KVPair) KVRange {
 
 	if len(r) == 0 {
@@ -94,6 +149,7 @@ func (r KVRange) InsertSorted(p KVPair) KVRange {
 	})
 
 	if index > 0 && bytes.Equal(r[index-1].Key, p.Key) {
+		r[index-1].Value = p.Value
 		return r
 	}
 
@@ -75,6 +75,18 @@ func TestInsertSorted(t *testing.T) {
 			},
 		},
 
+		{
+			originalRange: KVRange{
+				NewKVPair([]byte{0x01}, []byte{0x01}),
+				NewKVPair([]byte{0x08}, []byte{0x08}),
+			},
+			item: NewKVPair([]byte{0x08}, []byte{0x09}),
+			expectedRange: KVRange{
+				NewKVPair([]byte{0x01}, []byte{0x01}),
+				NewKVPair([]byte{0x08}, []byte{0x09}),
+			},
+		},
+
 		{
 			originalRange: KVRange{
 				NewKVPair([]byte{0x00}, []byte{0x00}),
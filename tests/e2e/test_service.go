@@ -47,9 +47,14 @@ func (a *alertStore) GetAll() []string {
 	return n
 }
 
+type snapKey struct {
+	namespace string
+	version   uint64
+}
+
 type snapStore struct {
 	sync.Mutex
-	d map[uint64]*protocol.SignedSnapshot
+	d map[snapKey]*protocol.SignedSnapshot
 }
 
 func (s *snapStore) Put(b *protocol.BatchSnapshots) {
@@ -57,14 +62,14 @@ func (s *snapStore) Put(b *protocol.BatchSnapshots) {
 	defer s.Unlock()
 
 	for _, snap := range b.Snapshots {
-		s.d[snap.Snapshot.Version] = snap
+		s.d[snapKey{snap.Snapshot.Namespace, snap.Snapshot.Version}] = snap
 	}
 }
 
-func (s *snapStore) Get(version uint64) (v *protocol.SignedSnapshot, ok bool) {
+func (s *snapStore) Get(namespace string, version uint64) (v *protocol.SignedSnapshot, ok bool) {
 	s.Lock()
 	defer s.Unlock()
-	v, ok = s.d[version]
+	v, ok = s.d[snapKey{namespace, version}]
 	return v, ok
 }
 
@@ -152,7 +157,7 @@ func (s *Service) getSnapshotHandler() func(http.ResponseWriter, *http.Request)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			b, ok := s.snaps.Get(uint64(version))
+			b, ok := s.snaps.Get(q.Get("ns"), uint64(version))
 			if !ok {
 				http.Error(w, fmt.Sprintf("Version not found: %v", version), http.StatusUnprocessableEntity)
 				return
@@ -211,7 +216,7 @@ func NewService() *Service {
 	var snaps snapStore
 	var alerts alertStore
 	var stats statStore
-	snaps.d = make(map[uint64]*protocol.SignedSnapshot)
+	snaps.d = make(map[snapKey]*protocol.SignedSnapshot)
 	stats.batch = make(map[string][]int)
 	alerts.d = make([]string, 0)
 
@@ -23,6 +23,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	bd "github.com/bbva/qed/storage/badger"
+	bt "github.com/bbva/qed/storage/bolt"
 	bp "github.com/bbva/qed/storage/bplus"
 )
 
@@ -45,6 +46,18 @@ func OpenBadgerStore(t require.TestingT, path string) (*bd.BadgerStore, func())
 	}
 }
 
+func OpenBoltStore(t require.TestingT, path string) (*bt.BoltStore, func()) {
+	store, err := bt.NewBoltStore(path)
+	if err != nil {
+		t.Errorf("Error opening bolt store: %v", err)
+		t.FailNow()
+	}
+	return store, func() {
+		store.Close()
+		deleteFile(path)
+	}
+}
+
 func deleteFile(path string) {
 	err := os.RemoveAll(path)
 	if err != nil {
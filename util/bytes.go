@@ -33,3 +33,7 @@ func Uint16AsBytes(i uint16) []byte {
 func BytesAsUint64(b []byte) uint64 {
 	return binary.BigEndian.Uint64(b)
 }
+
+func BytesAsUint16(b []byte) uint16 {
+	return binary.BigEndian.Uint16(b)
+}